@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/ozone"
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/service"
+)
+
+// options are the command's flags
+type options struct {
+	definitionPath string
+	dryRun         bool
+}
+
+func run(ctx context.Context, opts options) error {
+	def, err := service.LoadDefinition(opts.definitionPath)
+	if err != nil {
+		return err
+	}
+
+	repo, err := login(ctx)
+	if err != nil {
+		return err
+	}
+	did := repo.Auth.Did
+
+	current, err := service.Fetch(ctx, repo, did)
+	if err != nil {
+		return err
+	}
+
+	diff := current.Diff(def)
+	if len(diff) == 0 {
+		fmt.Printf("✓ The service record already declares the %d labels in %s\n", len(def.Labels), opts.definitionPath)
+		return nil
+	}
+	fmt.Printf("Changes to the service record of %s:\n", repo.Auth.Handle)
+	for _, line := range diff {
+		fmt.Printf("  %s\n", line)
+	}
+
+	if opts.dryRun {
+		fmt.Println("Dry run, not updating the service record")
+		return nil
+	}
+
+	if err := service.Put(ctx, repo, did, current, current.Declare(def, time.Now())); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Service record updated with %d labels\n", len(def.Labels))
+	return nil
+}
+
+// login logs in as the labeler account with LABELER_PASSWORD, or failing
+// that as the Ozone service account, which is the labeler account when
+// labels go through Ozone
+func login(ctx context.Context) (*xrpc.Client, error) {
+	account, ok, err := service.LoadAccount()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return service.Login(ctx, identity.DefaultDirectory(), util.RobustHTTPClient(), account)
+	}
+
+	config, err := ozone.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("LABELER_DID and LABELER_PASSWORD must be set to log in as the labeler account, or else the Ozone service account's: %w", err)
+	}
+	client := ozone.NewClient(config)
+	if err := client.Login(ctx); err != nil {
+		return nil, err
+	}
+	return client.Repo(), nil
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.definitionPath, "definition", "labels.toml", "TOML file declaring the label values")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "show the changes without updating the record")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, opts); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
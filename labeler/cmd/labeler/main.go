@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/decisions"
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/labels"
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/server"
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/store"
+)
+
+// options are the daemon's flags
+type options struct {
+	listen         string
+	databaseURL    string
+	decisionsPath  string
+	categoriesPath string
+	poll           time.Duration
+	sync           labels.Options
+}
+
+// resyncInterval is how often expiring labels are checked for refresh when
+// the decisions haven't changed. Labels are refreshed with half their
+// lifetime left, so --expire must be at least twice this.
+const resyncInterval = time.Hour
+
+// labeler labels the accounts list-pusher decides to list and serves the labels
+type labeler struct {
+	opts   options
+	log    *store.Postgres
+	signer *labels.Signer
+	server *server.Server
+
+	// applied and categorised are the modification times of the decisions
+	// and categories last applied, at synced
+	applied     time.Time
+	categorised time.Time
+	synced      time.Time
+}
+
+// loadSigner reads the labeler's DID and signing key from the environment
+func loadSigner() (*labels.Signer, error) {
+	did := os.Getenv("LABELER_DID")
+	if did == "" {
+		return nil, fmt.Errorf("LABELER_DID must be set to the labeler account's DID")
+	}
+	encoded := os.Getenv("LABELER_SIGNING_KEY")
+	if encoded == "" {
+		return nil, fmt.Errorf("LABELER_SIGNING_KEY must be set to the labeler's signing key")
+	}
+
+	key, err := labels.ParseSigningKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return labels.NewSigner(did, key)
+}
+
+// modTime returns when the file at path last changed, or the zero time if
+// there is none
+func modTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return info.ModTime(), nil
+}
+
+// apply brings the labels in line with the decisions file if it or the
+// categories changed since last time, or if expiring labels may be due for
+// refresh
+func (l *labeler) apply(ctx context.Context) error {
+	applied, err := modTime(l.opts.decisionsPath)
+	if err != nil || applied.IsZero() {
+		return err
+	}
+	categorised, err := modTime(l.opts.categoriesPath)
+	if err != nil {
+		return err
+	}
+	due := l.opts.sync.Expiry > 0 && time.Since(l.synced) >= resyncInterval
+	if applied.Equal(l.applied) && categorised.Equal(l.categorised) && !due {
+		return nil
+	}
+
+	ds, err := decisions.Load(l.opts.decisionsPath)
+	if err != nil {
+		return err
+	}
+	categories, err := labels.LoadCategories(l.opts.categoriesPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Applying %d decisions from %s with %d categories\n", len(ds), l.opts.decisionsPath, len(categories.Categories))
+
+	results, err := labels.SyncAll(ctx, l.log, l.signer, ds, categories, l.opts.sync)
+	changed := false
+	for _, val := range slices.Sorted(maps.Keys(results)) {
+		result := results[val]
+		changed = changed || result.Applied+result.Refreshed+result.Negated > 0
+		fmt.Printf("✓ %s: %d labels applied, %d refreshed, %d negated, %d already in place\n", val, result.Applied, result.Refreshed, result.Negated, result.Unchanged)
+	}
+	if changed {
+		l.server.Notify()
+	}
+	if err != nil {
+		return err
+	}
+
+	l.applied, l.categorised, l.synced = applied, categorised, time.Now()
+	return nil
+}
+
+// watch applies the decisions file whenever it changes, until ctx is done
+func (l *labeler) watch(ctx context.Context) {
+	ticker := time.NewTicker(l.opts.poll)
+	defer ticker.Stop()
+
+	for {
+		if err := l.apply(ctx); err != nil && ctx.Err() == nil {
+			fmt.Printf("✗ Failed to apply decisions: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func run(ctx context.Context, opts options) error {
+	signer, err := loadSigner()
+	if err != nil {
+		return err
+	}
+
+	st, err := store.Open(ctx, opts.databaseURL)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	l := &labeler{opts: opts, log: st, signer: signer, server: server.New(st)}
+	fmt.Printf("Labeling as %s with %q\n", signer.DID(), opts.sync.Value)
+	if opts.sync.Expiry > 0 {
+		fmt.Printf("Labels expire after %v unless refreshed\n", opts.sync.Expiry)
+	}
+
+	httpServer := &http.Server{Addr: opts.listen, Handler: l.server}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdown)
+	}()
+	go l.watch(ctx)
+
+	fmt.Printf("Listening on %s\n", opts.listen)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.listen, "listen", ":8080", "address to serve queryLabels and subscribeLabels on")
+	flag.StringVar(&opts.databaseURL, "db", "", "Postgres URL of the label log (default $LABELER_DB)")
+	flag.StringVar(&opts.decisionsPath, "decisions", "../list-pusher/policy-decisions.jsonl", "decisions written by list-pusher's decide command")
+	flag.StringVar(&opts.categoriesPath, "categories", "categories.toml", "TOML file mapping source lists to extra labels")
+	flag.DurationVar(&opts.poll, "poll", time.Minute, "how often to check the decisions file for changes")
+	flag.StringVar(&opts.sync.Value, "label", labels.DefaultValue, "label value to apply")
+	flag.DurationVar(&opts.sync.Expiry, "expire", 0, "let labels lapse this long after they were last signed, refreshing them while the account still qualifies (0 for never)")
+	flag.Float64Var(&opts.sync.MaxNegatePercent, "max-negate-percent", 10, "refuse to negate more than this percentage of the labels in one go")
+	flag.BoolVar(&opts.sync.Force, "force", false, "negate labels even beyond --max-negate-percent")
+	flag.Parse()
+	if opts.poll <= 0 {
+		log.Fatalf("Error: --poll must be positive")
+	}
+	if opts.sync.Expiry > 0 && opts.sync.Expiry < 2*resyncInterval {
+		log.Fatalf("Error: --expire must be at least %v, or labels lapse between checks", 2*resyncInterval)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, opts); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
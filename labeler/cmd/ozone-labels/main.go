@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/decisions"
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/labels"
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/ozone"
+)
+
+// options are the command's flags
+type options struct {
+	decisionsPath  string
+	categoriesPath string
+	batchSize      int
+	managedPrefix  string
+	dryRun         bool
+	sync           labels.Options
+}
+
+func run(ctx context.Context, opts options) error {
+	if opts.sync.Expiry > 0 && opts.sync.Expiry < time.Hour {
+		return fmt.Errorf("--expire must be at least an hour, Ozone sets label durations in hours")
+	}
+
+	config, err := ozone.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	ds, err := decisions.Load(opts.decisionsPath)
+	if err != nil {
+		return err
+	}
+	categories, err := labels.LoadCategories(opts.categoriesPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Applying %d decisions from %s with %d categories through Ozone as %s\n", len(ds), opts.decisionsPath, len(categories.Categories), config.Handle)
+
+	client := ozone.NewClient(config)
+	client.SetBatchSize(opts.batchSize)
+	client.SetManagedPrefix(opts.managedPrefix)
+	if err := client.Login(ctx); err != nil {
+		return err
+	}
+
+	results, err := client.Sync(ctx, ds, categories, opts.sync, opts.dryRun)
+	failed := 0
+	for _, val := range slices.Sorted(maps.Keys(results)) {
+		result := results[val]
+		failed += result.Failed
+		if opts.dryRun {
+			fmt.Printf("✓ %s: would apply %d labels, refresh %d and negate %d; %d already in place\n", val, result.Applied, result.Refreshed, result.Negated, result.Unchanged)
+			continue
+		}
+		fmt.Printf("✓ %s: %d labels applied, %d refreshed, %d negated, %d already in place\n", val, result.Applied, result.Refreshed, result.Negated, result.Unchanged)
+	}
+	if err != nil {
+		return err
+	}
+	if opts.dryRun {
+		fmt.Println("Dry run, no label events emitted")
+		return nil
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d labels could not be changed, rerun to retry them", failed)
+	}
+	return nil
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.decisionsPath, "decisions", "../list-pusher/policy-decisions.jsonl", "decisions written by list-pusher's decide command")
+	flag.StringVar(&opts.categoriesPath, "categories", "categories.toml", "TOML file mapping source lists to extra labels")
+	flag.IntVar(&opts.batchSize, "batch-size", ozone.DefaultBatchSize, "accounts to label between progress reports")
+	flag.StringVar(&opts.managedPrefix, "managed-prefix", "", "negate labels Ozone has issued whose value starts with this and that no category uses any more (empty to leave values not in categories.toml alone)")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "show what would change without emitting label events")
+	flag.StringVar(&opts.sync.Value, "label", labels.DefaultValue, "label value to apply")
+	flag.DurationVar(&opts.sync.Expiry, "expire", 0, "let labels lapse this long after they were last applied, refreshing them while the account still qualifies (0 for never)")
+	flag.Float64Var(&opts.sync.MaxNegatePercent, "max-negate-percent", 10, "refuse to negate more than this percentage of the labels in one go")
+	flag.BoolVar(&opts.sync.Force, "force", false, "negate labels even beyond --max-negate-percent")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, opts); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+// Package decisions reads the listing decisions list-pusher's decide command
+// writes, so the labeler labels exactly the accounts the list-pusher lists
+package decisions
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Decision is list-pusher's policy decision on one DID. Only the fields the
+// labeler needs are read.
+type Decision struct {
+	DID     string   `json:"did"`
+	Include bool     `json:"include"`
+	Rule    string   `json:"rule"`
+	Lists   []string `json:"lists"`
+}
+
+// Load reads a policy-decisions.jsonl file. Lines with an invalid DID are an
+// error, since labelling from a damaged file could label the wrong accounts.
+func Load(path string) ([]Decision, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read decisions %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var decisions []Decision
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var decision Decision
+		if err := json.Unmarshal(scanner.Bytes(), &decision); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		if _, err := syntax.ParseDID(decision.DID); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		decisions = append(decisions, decision)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read decisions %s: %w", path, err)
+	}
+
+	return decisions, nil
+}
@@ -0,0 +1,135 @@
+package labels
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/decisions"
+)
+
+// valuePattern is what a custom label value may look like
+var valuePattern = regexp.MustCompile(`^[a-z-]+$`)
+
+// Category is a group of source lists whose subscribers get their own label
+type Category struct {
+	Name  string   `toml:"name"`
+	Label string   `toml:"label"`
+	Lists []string `toml:"lists"`
+}
+
+// Categories maps source lists to label values. Every account the decisions
+// include gets the main label, plus the label of each category with a list
+// it subscribes to.
+type Categories struct {
+	Categories []Category `toml:"categories"`
+}
+
+// LoadCategories reads the categories from a TOML file. A missing file means
+// no categories, so only the main label is applied.
+func LoadCategories(path string) (*Categories, error) {
+	categories := &Categories{}
+	if _, err := toml.DecodeFile(path, categories); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &Categories{}, nil
+		}
+		return nil, fmt.Errorf("failed to parse TOML file %s: %w", path, err)
+	}
+	if err := categories.validate(); err != nil {
+		return nil, fmt.Errorf("invalid categories %s: %w", path, err)
+	}
+	return categories, nil
+}
+
+func (c *Categories) validate() error {
+	names := make(map[string]bool)
+	for _, category := range c.Categories {
+		if category.Name == "" {
+			return fmt.Errorf("every category needs a name")
+		}
+		if names[category.Name] {
+			return fmt.Errorf("category %q is defined twice", category.Name)
+		}
+		names[category.Name] = true
+
+		if !valuePattern.MatchString(category.Label) {
+			return fmt.Errorf("category %q: labels are lowercase letters and dashes", category.Name)
+		}
+		for _, uri := range category.Lists {
+			if _, err := syntax.ParseATURI(uri); err != nil {
+				return fmt.Errorf("category %q: lists are given by AT-URI: %w", category.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Targets returns the decisions to sync each label value with: value, the
+// main label, gets ds as they are and each category's label gets ds
+// including only the accounts subscribed to one of its lists. Any value in
+// existing that is neither gets no one, so its labels are negated.
+func (c *Categories) Targets(value string, ds []decisions.Decision, existing []string) (map[string][]decisions.Decision, error) {
+	lists := make(map[string]map[string]bool)
+	for _, category := range c.Categories {
+		if category.Label == value {
+			return nil, fmt.Errorf("category %q uses the main label %q", category.Name, value)
+		}
+		if lists[category.Label] == nil {
+			lists[category.Label] = make(map[string]bool)
+		}
+		for _, uri := range category.Lists {
+			lists[category.Label][uri] = true
+		}
+	}
+
+	targets := map[string][]decisions.Decision{value: ds}
+	for val, uris := range lists {
+		var categorised []decisions.Decision
+		for _, decision := range ds {
+			subscribed := false
+			for _, uri := range decision.Lists {
+				subscribed = subscribed || uris[uri]
+			}
+			decision.Include = decision.Include && subscribed
+			categorised = append(categorised, decision)
+		}
+		targets[val] = categorised
+	}
+	for _, val := range existing {
+		if _, ok := targets[val]; !ok {
+			targets[val] = nil
+		}
+	}
+	return targets, nil
+}
+
+// ValueOptions returns opts for syncing val. A value that is neither
+// opts.Value nor any category's label is retired and every label of it is
+// negated, so the negation guardrail doesn't apply to it.
+func (c *Categories) ValueOptions(opts Options, val string) Options {
+	retired := val != opts.Value
+	for _, category := range c.Categories {
+		retired = retired && category.Label != val
+	}
+
+	opts.Value = val
+	if retired {
+		opts.MaxNegatePercent = 100
+	}
+	return opts
+}
+
+// Values returns the label values in targets in order
+func Values(targets map[string][]decisions.Decision) []string {
+	values := make([]string, 0, len(targets))
+	for val := range targets {
+		values = append(values, val)
+	}
+	sort.Strings(values)
+	return values
+}
@@ -0,0 +1,115 @@
+// Package labels makes the labels we publish: it signs them with the
+// labeler's atproto signing key and works out which labels a set of listing
+// decisions calls for.
+package labels
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/label"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// DefaultValue is the label for subscribers of the AI blocklists
+const DefaultValue = "ai-blocklist-subscriber"
+
+// ParseSigningKey reads the labeler's private key: the hex secp256k1 key that
+// ozone-setup.sh generates as OZONE_SIGNING_KEY_HEX, or a multibase key
+func ParseSigningKey(encoded string) (crypto.PrivateKey, error) {
+	encoded = strings.TrimSpace(encoded)
+	if strings.HasPrefix(encoded, "z") {
+		key, err := crypto.ParsePrivateMultibase(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multibase signing key: %w", err)
+		}
+		return key, nil
+	}
+
+	raw, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("signing key is neither hex nor multibase: %w", err)
+	}
+	key, err := crypto.ParsePrivateBytesK256(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secp256k1 signing key: %w", err)
+	}
+	return key, nil
+}
+
+// Signer creates labels from the labeler's DID, signed with its key
+type Signer struct {
+	did syntax.DID
+	key crypto.PrivateKey
+}
+
+// NewSigner creates a signer for the labeler account did
+func NewSigner(did string, key crypto.PrivateKey) (*Signer, error) {
+	parsed, err := syntax.ParseDID(did)
+	if err != nil {
+		return nil, fmt.Errorf("invalid labeler DID: %w", err)
+	}
+	return &Signer{did: parsed, key: key}, nil
+}
+
+// DID returns the labeler's DID, the src of every label it signs
+func (s *Signer) DID() string {
+	return s.did.String()
+}
+
+// PublicKey returns the key labels can be verified with
+func (s *Signer) PublicKey() (crypto.PublicKey, error) {
+	return s.key.PublicKey()
+}
+
+// Sign creates a label of val on uri, created at cts, or now if that is
+// zero. If exp is not zero the label lapses then unless it is signed again.
+func (s *Signer) Sign(uri, val string, cts, exp time.Time) (label.Label, error) {
+	l := s.label(uri, val, cts)
+	if !exp.IsZero() {
+		expires := datetime(exp)
+		l.ExpiresAt = &expires
+	}
+	return s.sign(l)
+}
+
+// Negate creates a negation of val on uri, created at cts or now, which
+// withdraws the label from it
+func (s *Signer) Negate(uri, val string, cts time.Time) (label.Label, error) {
+	l := s.label(uri, val, cts)
+	neg := true
+	l.Negated = &neg
+	return s.sign(l)
+}
+
+// label starts a label of val on uri from the labeler
+func (s *Signer) label(uri, val string, cts time.Time) label.Label {
+	if cts.IsZero() {
+		cts = time.Now()
+	}
+	return label.Label{
+		CreatedAt: datetime(cts),
+		SourceDID: s.did.String(),
+		URI:       uri,
+		Val:       val,
+		Version:   label.ATPROTO_LABEL_VERSION,
+	}
+}
+
+func (s *Signer) sign(l label.Label) (label.Label, error) {
+	if err := l.VerifySyntax(); err != nil {
+		return l, err
+	}
+	if err := l.Sign(s.key); err != nil {
+		return l, fmt.Errorf("failed to sign label %s on %s: %w", l.Val, l.URI, err)
+	}
+	return l, nil
+}
+
+// datetime formats t as an atproto datetime
+func datetime(t time.Time) string {
+	return t.UTC().Format(syntax.AtprotoDatetimeLayout)
+}
@@ -0,0 +1,278 @@
+package labels
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/label"
+
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/decisions"
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/store"
+)
+
+const labelerDID = "did:plc:labeler"
+
+var t0 = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// newSigner returns a signer with a fresh key
+func newSigner(t *testing.T) *Signer {
+	key, err := crypto.GeneratePrivateKeyK256()
+	must(t, err)
+	signer, err := NewSigner(labelerDID, key)
+	must(t, err)
+	return signer
+}
+
+func TestParseSigningKey(t *testing.T) {
+	key, err := crypto.GeneratePrivateKeyK256()
+	must(t, err)
+	public, err := key.PublicKey()
+	must(t, err)
+
+	for name, encoded := range map[string]string{
+		"hex":       hex.EncodeToString(key.Bytes()) + "\n",
+		"multibase": key.Multibase(),
+	} {
+		parsed, err := ParseSigningKey(encoded)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		parsedPublic, err := parsed.PublicKey()
+		must(t, err)
+		if !parsedPublic.Equal(public) {
+			t.Errorf("%s: parsed a different key", name)
+		}
+	}
+
+	if _, err := ParseSigningKey("not a key"); err == nil {
+		t.Error("expected an error for garbage")
+	}
+}
+
+func TestSign(t *testing.T) {
+	signer := newSigner(t)
+	l, err := signer.Sign("did:plc:someone", DefaultValue, t0, t0.Add(24*time.Hour))
+	must(t, err)
+	if l.SourceDID != labelerDID || l.URI != "did:plc:someone" || l.Val != DefaultValue || l.CreatedAt != "2025-03-01T12:00:00Z" ||
+		l.ExpiresAt == nil || *l.ExpiresAt != "2025-03-02T12:00:00Z" || l.Negated != nil {
+		t.Errorf("unexpected label %+v", l)
+	}
+
+	public, err := signer.PublicKey()
+	must(t, err)
+	must(t, l.VerifySignature(public))
+
+	l.Val = "something-else"
+	if err := l.VerifySignature(public); err == nil {
+		t.Error("a tampered label verified")
+	}
+
+	neg, err := signer.Negate("did:plc:someone", DefaultValue, t0)
+	must(t, err)
+	if neg.Negated == nil || !*neg.Negated || neg.ExpiresAt != nil {
+		t.Errorf("unexpected negation %+v", neg)
+	}
+	must(t, neg.VerifySignature(public))
+}
+
+// memoryLog is a label log in memory
+type memoryLog struct {
+	entries []store.Entry
+}
+
+func (m *memoryLog) Current(ctx context.Context, src, val string) (map[string]store.Entry, error) {
+	current := make(map[string]store.Entry)
+	for _, entry := range m.entries {
+		if entry.Label.SourceDID == src && entry.Label.Val == val {
+			current[entry.Label.URI] = entry
+		}
+	}
+	return current, nil
+}
+
+func (m *memoryLog) Append(ctx context.Context, labels []label.Label) ([]store.Entry, error) {
+	var appended []store.Entry
+	for _, l := range labels {
+		entry := store.Entry{Seq: int64(len(m.entries) + 1), Label: l}
+		m.entries = append(m.entries, entry)
+		appended = append(appended, entry)
+	}
+	return appended, nil
+}
+
+func (m *memoryLog) Values(ctx context.Context, src string) ([]string, error) {
+	seen := make(map[string]bool)
+	var values []string
+	for _, entry := range m.entries {
+		if entry.Label.SourceDID == src && !seen[entry.Label.Val] {
+			seen[entry.Label.Val] = true
+			values = append(values, entry.Label.Val)
+		}
+	}
+	return values, nil
+}
+
+// labels returns the latest label on each DID in the log, as "val" or "-val"
+// for a negation, with "~" appended if it expires
+func (m *memoryLog) labels() map[string]string {
+	labels := make(map[string]string)
+	for _, entry := range m.entries {
+		l := entry.Label
+		value := l.Val
+		if l.Negated != nil && *l.Negated {
+			value = "-" + value
+		}
+		if l.ExpiresAt != nil {
+			value += "~"
+		}
+		labels[l.URI] = value
+	}
+	return labels
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	signer := newSigner(t)
+	log := &memoryLog{}
+	opts := Options{Value: DefaultValue, MaxNegatePercent: 50}
+
+	ds := []decisions.Decision{
+		{DID: "did:plc:one", Include: true},
+		{DID: "did:plc:two", Include: true},
+		{DID: "did:plc:three", Include: true},
+		{DID: "did:plc:out", Include: false},
+	}
+	result, err := Sync(ctx, log, signer, ds, opts)
+	must(t, err)
+	if result != (Result{Applied: 3}) || len(log.entries) != 3 {
+		t.Fatalf("got %+v with log %+v", result, log.entries)
+	}
+
+	// Running again changes nothing
+	result, err = Sync(ctx, log, signer, ds, opts)
+	must(t, err)
+	if result != (Result{Unchanged: 3}) || len(log.entries) != 3 {
+		t.Errorf("got %+v with log %+v", result, log.entries)
+	}
+
+	// Dropping one negates its label; dropping two is over the limit
+	ds[1].Include = false
+	result, err = Sync(ctx, log, signer, ds[:2], opts)
+	if err == nil {
+		t.Errorf("negated 2 of 3 labels with a 50%% limit: %+v", result)
+	}
+	result, err = Sync(ctx, log, signer, ds, opts)
+	must(t, err)
+	if result != (Result{Negated: 1, Unchanged: 2}) {
+		t.Errorf("got %+v", result)
+	}
+
+	// Once labels expire, the ones in place are refreshed and a negated one
+	// is applied again
+	ds[1].Include = true
+	opts.Expiry = 24 * time.Hour
+	result, err = Sync(ctx, log, signer, ds, opts)
+	must(t, err)
+	if result != (Result{Applied: 1, Refreshed: 2}) {
+		t.Errorf("got %+v", result)
+	}
+	want := map[string]string{"did:plc:one": DefaultValue + "~", "did:plc:two": DefaultValue + "~", "did:plc:three": DefaultValue + "~"}
+	if !reflect.DeepEqual(log.labels(), want) {
+		t.Errorf("got labels %v", log.labels())
+	}
+
+	// A label that has lapsed is not negated, but is applied again if wanted
+	lapsed := "2000-01-01T00:00:00.000Z"
+	for i := range log.entries {
+		if log.entries[i].Label.URI == "did:plc:one" || log.entries[i].Label.URI == "did:plc:two" {
+			log.entries[i].Label.ExpiresAt = &lapsed
+		}
+	}
+	ds[0].Include = false
+	result, err = Sync(ctx, log, signer, ds, opts)
+	must(t, err)
+	if result != (Result{Applied: 1, Unchanged: 1}) {
+		t.Errorf("got %+v", result)
+	}
+}
+
+func TestSyncAll(t *testing.T) {
+	ctx := context.Background()
+	signer := newSigner(t)
+	log := &memoryLog{}
+	opts := Options{Value: DefaultValue, MaxNegatePercent: 50}
+
+	const art, code = "at://did:plc:a/app.bsky.graph.list/art", "at://did:plc:a/app.bsky.graph.list/code"
+	categories := &Categories{Categories: []Category{
+		{Name: "art", Label: "ai-art-blocklist", Lists: []string{art}},
+		{Name: "code", Label: "ai-code-blocklist", Lists: []string{code}},
+	}}
+	ds := []decisions.Decision{
+		{DID: "did:plc:artist", Include: true, Lists: []string{art}},
+		{DID: "did:plc:both", Include: true, Lists: []string{art, code}},
+		{DID: "did:plc:coder", Include: true, Lists: []string{code}},
+		{DID: "did:plc:override", Include: true},
+		{DID: "did:plc:out", Include: false, Lists: []string{art}},
+	}
+	results, err := SyncAll(ctx, log, signer, ds, categories, opts)
+	must(t, err)
+	want := map[string]Result{DefaultValue: {Applied: 4}, "ai-art-blocklist": {Applied: 2}, "ai-code-blocklist": {Applied: 2}}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %+v, want %+v", results, want)
+	}
+
+	// Regrouping the lists labels the new category and negates every label of
+	// the old ones, which the guardrail leaves to go
+	categories.Categories = []Category{{Name: "tech", Label: "ai-tech-blocklist", Lists: []string{art, code}}}
+	results, err = SyncAll(ctx, log, signer, ds, categories, opts)
+	must(t, err)
+	want = map[string]Result{DefaultValue: {Unchanged: 4}, "ai-tech-blocklist": {Applied: 3}, "ai-art-blocklist": {Negated: 2}, "ai-code-blocklist": {Negated: 2}}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got %+v, want %+v", results, want)
+	}
+
+	// The guardrail still holds for categories in use
+	categories.Categories[0].Lists = nil
+	_, err = SyncAll(ctx, log, signer, ds, categories, opts)
+	if err == nil || !strings.Contains(err.Error(), "refusing to negate 3 of 3") {
+		t.Fatalf("got %v, want the negation guardrail for the category in use", err)
+	}
+
+	categories.Categories = []Category{{Name: "main", Label: DefaultValue}}
+	if _, err := SyncAll(ctx, log, signer, ds, categories, opts); err == nil {
+		t.Error("a category reusing the main label was accepted")
+	}
+}
+
+func TestLoadCategories(t *testing.T) {
+	categories, err := LoadCategories(filepath.Join(t.TempDir(), "missing.toml"))
+	must(t, err)
+	if len(categories.Categories) != 0 {
+		t.Errorf("a missing file got %+v", categories)
+	}
+
+	// The sample shipped with the labeler
+	_, err = LoadCategories("../../categories.toml")
+	must(t, err)
+
+	path := filepath.Join(t.TempDir(), "categories.toml")
+	must(t, os.WriteFile(path, []byte("[[categories]]\nname = \"art\"\nlabel = \"ai-art\"\nlists = [\"https://bsky.app/profile/a/lists/b\"]\n"), 0o644))
+	if _, err := LoadCategories(path); err == nil || !strings.Contains(err.Error(), "AT-URI") {
+		t.Errorf("got %v, want an error about the list URI", err)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,237 @@
+package labels
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/label"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/decisions"
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/store"
+)
+
+// appendBatchSize is how many labels are appended to the log at once
+const appendBatchSize = 1000
+
+// Log is the part of the label log Sync reads and writes
+type Log interface {
+	Current(ctx context.Context, src, val string) (map[string]store.Entry, error)
+	Append(ctx context.Context, labels []label.Label) ([]store.Entry, error)
+	Values(ctx context.Context, src string) ([]string, error)
+}
+
+// Options controls Sync
+type Options struct {
+	// Value is the label to apply; SyncAll applies it to every account
+	// included and category labels besides
+	Value string
+
+	// Expiry, if set, is how long each label lasts unless refreshed. Labels
+	// are signed again once less than half of it remains.
+	Expiry time.Duration
+
+	// MaxNegatePercent stops Sync negating more than this share of the labels
+	// in place, which usually means the decisions are incomplete, unless Force
+	MaxNegatePercent float64
+	Force            bool
+}
+
+// Result counts what Sync did
+type Result struct {
+	Applied   int
+	Refreshed int
+	Negated   int
+	Unchanged int
+}
+
+// Plan is what it takes to bring a labeler's labels in line with the
+// decisions: the DIDs to label, to label again before their labels lapse and
+// to negate
+type Plan struct {
+	Apply     []string
+	Refresh   []string
+	Negate    []string
+	Unchanged int
+}
+
+// NewPlan works out the Plan for ds given current, the latest label of
+// opts.Value on each subject. It refuses to negate more than
+// opts.MaxNegatePercent of the labels in place unless opts.Force.
+func NewPlan(current map[string]label.Label, ds []decisions.Decision, now time.Time, opts Options) (Plan, error) {
+	var plan Plan
+
+	included := make(map[string]bool)
+	for _, decision := range ds {
+		if !decision.Include || included[decision.DID] {
+			continue
+		}
+		included[decision.DID] = true
+
+		l, ok := current[decision.DID]
+		switch {
+		case !ok || !active(l, now):
+			plan.Apply = append(plan.Apply, decision.DID)
+		case needsRefresh(l, now, opts.Expiry):
+			plan.Refresh = append(plan.Refresh, decision.DID)
+		default:
+			plan.Unchanged++
+		}
+	}
+
+	var labelled int
+	for uri, l := range current {
+		if !active(l, now) {
+			continue
+		}
+		labelled++
+		if !included[uri] {
+			plan.Negate = append(plan.Negate, uri)
+		}
+	}
+	sort.Strings(plan.Negate)
+
+	if len(plan.Negate) > 0 {
+		percent := 100 * float64(len(plan.Negate)) / float64(labelled)
+		if percent > opts.MaxNegatePercent {
+			if !opts.Force {
+				return plan, fmt.Errorf("refusing to negate %d of %d labels (%.1f%%, limit %.1f%%); rerun with --force if this is intended",
+					len(plan.Negate), labelled, percent, opts.MaxNegatePercent)
+			}
+			fmt.Printf("⚠️  Negating %.1f%% of the labels (limit %.1f%%), continuing because --force was given\n", percent, opts.MaxNegatePercent)
+		}
+	}
+
+	return plan, nil
+}
+
+// Sync brings the labels in the log in line with the decisions: every DID
+// they include carries the label, with a fresh expiry if labels expire, and
+// the label is negated on every other DID that still carries it
+func Sync(ctx context.Context, log Log, signer *Signer, ds []decisions.Decision, opts Options) (Result, error) {
+	var result Result
+
+	entries, err := log.Current(ctx, signer.DID(), opts.Value)
+	if err != nil {
+		return result, err
+	}
+	current := make(map[string]label.Label, len(entries))
+	for uri, entry := range entries {
+		current[uri] = entry.Label
+	}
+
+	now := time.Now()
+	plan, err := NewPlan(current, ds, now, opts)
+	if err != nil {
+		return result, err
+	}
+	result.Unchanged = plan.Unchanged
+
+	var exp time.Time
+	if opts.Expiry > 0 {
+		exp = now.Add(opts.Expiry)
+	}
+
+	var pending []label.Label
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		if _, err := log.Append(ctx, pending); err != nil {
+			return err
+		}
+		pending = pending[:0]
+		return nil
+	}
+	add := func(l label.Label, err error) error {
+		if err != nil {
+			return err
+		}
+		pending = append(pending, l)
+		if len(pending) < appendBatchSize {
+			return nil
+		}
+		return flush()
+	}
+
+	for _, did := range plan.Apply {
+		if err := add(signer.Sign(did, opts.Value, now, exp)); err != nil {
+			return result, err
+		}
+		result.Applied++
+	}
+	for _, did := range plan.Refresh {
+		if err := add(signer.Sign(did, opts.Value, now, exp)); err != nil {
+			return result, err
+		}
+		result.Refreshed++
+	}
+	for _, did := range plan.Negate {
+		if err := add(signer.Negate(did, opts.Value, now)); err != nil {
+			return result, err
+		}
+		result.Negated++
+	}
+	return result, flush()
+}
+
+// SyncAll syncs opts.Value and the label of each category, and negates the
+// labels of any value the log holds that is no longer in use, however many
+// there are. A value that fails to sync doesn't stop the others.
+func SyncAll(ctx context.Context, log Log, signer *Signer, ds []decisions.Decision, categories *Categories, opts Options) (map[string]Result, error) {
+	existing, err := log.Values(ctx, signer.DID())
+	if err != nil {
+		return nil, err
+	}
+	targets, err := categories.Targets(opts.Value, ds, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]Result, len(targets))
+	var errs []error
+	for _, val := range Values(targets) {
+		result, err := Sync(ctx, log, signer, targets[val], categories.ValueOptions(opts, val))
+		results[val] = result
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", val, err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// active reports whether l applies its value at now: it isn't a negation and
+// hasn't expired
+func active(l label.Label, now time.Time) bool {
+	if l.Negated != nil && *l.Negated {
+		return false
+	}
+	exp, ok := expiry(l)
+	return !ok || now.Before(exp)
+}
+
+// needsRefresh reports whether an active label should be signed again: it
+// has less than half of expiry left, or it expires though labels no longer do
+func needsRefresh(l label.Label, now time.Time, expiresAfter time.Duration) bool {
+	exp, ok := expiry(l)
+	if expiresAfter == 0 {
+		return ok
+	}
+	return !ok || exp.Sub(now) < expiresAfter/2
+}
+
+// expiry returns when l lapses, if it does
+func expiry(l label.Label) (time.Time, bool) {
+	if l.ExpiresAt == nil {
+		return time.Time{}, false
+	}
+	exp, err := syntax.ParseDatetime(*l.ExpiresAt)
+	if err != nil {
+		// An unreadable expiry is treated as already lapsed
+		return time.Time{}, true
+	}
+	return exp.Time(), true
+}
@@ -0,0 +1,252 @@
+// Package ozone applies and removes labels through an Ozone instance's
+// tools.ozone.moderation.emitEvent, logged in as Ozone's service account.
+// Requests go to the service account's PDS, which proxies them to Ozone.
+package ozone
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	toolsozone "github.com/bluesky-social/indigo/api/ozone"
+	"github.com/bluesky-social/indigo/atproto/label"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/retry"
+)
+
+// DefaultPDSHost is the service account's PDS when OZONE_PDS_HOST is unset
+const DefaultPDSHost = "https://bsky.social"
+
+// DefaultBatchSize is how many accounts are labelled between progress reports
+const DefaultBatchSize = 100
+
+// queryLimit is the most labels Ozone returns per queryLabels page
+const queryLimit = 250
+
+// Config is how to reach Ozone
+type Config struct {
+	// PDSHost is the service account's PDS
+	PDSHost string
+	// Handle and Password log in as the service account; Password may be an
+	// app password
+	Handle   string
+	Password string
+	// ServerDID is Ozone's DID, the service account's, which the PDS
+	// proxies moderation requests to
+	ServerDID string
+}
+
+// LoadConfig reads the Ozone configuration from the environment, using the
+// names ozone-setup.sh gives them
+func LoadConfig() (Config, error) {
+	config := Config{
+		PDSHost:   os.Getenv("OZONE_PDS_HOST"),
+		Handle:    os.Getenv("OZONE_SERVICE_ACCOUNT_HANDLE"),
+		Password:  os.Getenv("OZONE_SERVICE_ACCOUNT_PASSWORD"),
+		ServerDID: os.Getenv("OZONE_SERVER_DID"),
+	}
+	if config.PDSHost == "" {
+		config.PDSHost = DefaultPDSHost
+	}
+
+	var missing []string
+	if config.Handle == "" {
+		missing = append(missing, "OZONE_SERVICE_ACCOUNT_HANDLE")
+	}
+	if config.Password == "" {
+		missing = append(missing, "OZONE_SERVICE_ACCOUNT_PASSWORD")
+	}
+	if config.ServerDID == "" {
+		missing = append(missing, "OZONE_SERVER_DID")
+	}
+	if len(missing) > 0 {
+		return config, fmt.Errorf("%s must be set", strings.Join(missing, ", "))
+	}
+	return config, nil
+}
+
+// Client talks to Ozone as its service account
+type Client struct {
+	config    Config
+	retry     *retry.Retrier
+	budget    *budget
+	batchSize int
+	prefix    string
+
+	// pds logs in; ozone carries the session to Ozone through the PDS
+	mu    sync.Mutex
+	pds   *xrpc.Client
+	ozone *xrpc.Client
+}
+
+// NewClient creates a client for config. Call Login before making requests.
+func NewClient(config Config) *Client {
+	httpClient := util.RobustHTTPClient()
+	budget := &budget{next: httpClient.Transport}
+	httpClient.Transport = budget
+
+	c := &Client{
+		config:    config,
+		budget:    budget,
+		batchSize: DefaultBatchSize,
+		pds:       &xrpc.Client{Client: httpClient, Host: config.PDSHost},
+		ozone: &xrpc.Client{
+			Client:  httpClient,
+			Host:    config.PDSHost,
+			Headers: map[string]string{"atproto-proxy": config.ServerDID + "#atproto_labeler"},
+		},
+	}
+	c.retry = retry.New(retry.DefaultConfig, c.Login)
+	return c
+}
+
+// SetBatchSize sets how many accounts are labelled between progress reports
+func (c *Client) SetBatchSize(size int) {
+	if size > 0 {
+		c.batchSize = size
+	}
+}
+
+// SetManagedPrefix makes Sync treat every label value starting with prefix
+// as its own, negating those no category uses any more. Without it Sync
+// leaves values it wasn't given alone.
+func (c *Client) SetManagedPrefix(prefix string) {
+	c.prefix = prefix
+}
+
+// Login creates a session for the service account
+func (c *Client) Login(ctx context.Context) error {
+	out, err := comatproto.ServerCreateSession(ctx, c.pds, &comatproto.ServerCreateSession_Input{
+		Identifier: c.config.Handle,
+		Password:   c.config.Password,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	if out.Did != c.config.ServerDID {
+		return fmt.Errorf("logged in as %s, but Ozone's service account is %s", out.Did, c.config.ServerDID)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ozone.Auth = &xrpc.AuthInfo{
+		AccessJwt:  out.AccessJwt,
+		RefreshJwt: out.RefreshJwt,
+		Handle:     out.Handle,
+		Did:        out.Did,
+	}
+	return nil
+}
+
+// session returns the client carrying the current session to Ozone
+func (c *Client) session() *xrpc.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	client := *c.ozone
+	return &client
+}
+
+// Repo returns a client for the service account's own PDS, without the
+// proxying to Ozone, for writing records such as the labeler service record
+func (c *Client) Repo() *xrpc.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	client := *c.pds
+	client.Auth = c.ozone.Auth
+	return &client
+}
+
+// DID returns the service account's DID
+func (c *Client) DID() string {
+	return c.config.ServerDID
+}
+
+// Labels returns the latest label Ozone has put on each account, negated or
+// not, by value and then by account. It reads every label once, however many
+// values there are.
+func (c *Client) Labels(ctx context.Context) (map[string]map[string]label.Label, error) {
+	index := make(map[string]map[string]label.Label)
+	err := c.eachLabel(ctx, func(l label.Label) {
+		current, ok := index[l.Val]
+		if !ok {
+			current = make(map[string]label.Label)
+			index[l.Val] = current
+		}
+		if latest, ok := current[l.URI]; ok && latest.CreatedAt > l.CreatedAt {
+			return
+		}
+		current[l.URI] = l
+	})
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// eachLabel pages through the labels Ozone has put on accounts, calling fn on each
+func (c *Client) eachLabel(ctx context.Context, fn func(label.Label)) error {
+	var cursor string
+	for {
+		var out *comatproto.LabelQueryLabels_Output
+		err := c.retry.Do(ctx, func() error {
+			var err error
+			out, err = comatproto.LabelQueryLabels(ctx, c.session(), cursor, queryLimit, []string{c.config.ServerDID}, []string{"did:*"})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to query Ozone's labels: %w", err)
+		}
+
+		for _, lex := range out.Labels {
+			l := label.FromLexicon(lex)
+			if l.SourceDID != c.config.ServerDID || !strings.HasPrefix(l.URI, "did:") {
+				continue
+			}
+			fn(l)
+		}
+
+		if out.Cursor == nil || *out.Cursor == "" || *out.Cursor == cursor || len(out.Labels) == 0 {
+			return nil
+		}
+		cursor = *out.Cursor
+	}
+}
+
+// Label emits a label event on did, creating the create values and negating
+// the negate values. Created labels lapse after duration, rounded up to the
+// hour, if it is set.
+func (c *Client) Label(ctx context.Context, did string, create, negate []string, duration time.Duration, comment string) error {
+	event := &toolsozone.ModerationDefs_ModEventLabel{
+		CreateLabelVals: append([]string{}, create...),
+		NegateLabelVals: append([]string{}, negate...),
+	}
+	if comment != "" {
+		event.Comment = &comment
+	}
+	if duration > 0 && len(create) > 0 {
+		hours := int64(math.Ceil(duration.Hours()))
+		event.DurationInHours = &hours
+	}
+
+	return c.retry.Do(ctx, func() error {
+		if err := c.pace(ctx); err != nil {
+			return err
+		}
+		client := c.session()
+		_, err := toolsozone.ModerationEmitEvent(ctx, client, &toolsozone.ModerationEmitEvent_Input{
+			CreatedBy: client.Auth.Did,
+			Event:     &toolsozone.ModerationEmitEvent_Input_Event{ModerationDefs_ModEventLabel: event},
+			Subject: &toolsozone.ModerationEmitEvent_Input_Subject{
+				AdminDefs_RepoRef: &comatproto.AdminDefs_RepoRef{Did: did},
+			},
+		})
+		return err
+	})
+}
@@ -0,0 +1,460 @@
+package ozone
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/decisions"
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/labels"
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/retry"
+)
+
+const serverDID = "did:plc:ozone"
+
+// fakeOzone stands in for a PDS proxying to Ozone: it logs the service
+// account in, answers queryLabels from the labels it holds and applies the
+// label events emitted to it
+type fakeOzone struct {
+	t *testing.T
+
+	mu     sync.Mutex
+	logins int
+	labels map[string]comatproto.LabelDefs_Label // by "did val"
+	events []string
+
+	// throttle answers this many emitEvent calls with 429s, expire answers
+	// the next one with an expired token and reject refuses to label DIDs.
+	// limit, if set, is how many emitEvent calls the rate limit allows,
+	// reported on each response; calls beyond it get 429s, counted in over.
+	throttle int
+	expire   bool
+	reject   map[string]bool
+	limit    int
+	emitted  int
+	over     int
+}
+
+func newFakeOzone(t *testing.T) (*fakeOzone, *httptest.Server) {
+	f := &fakeOzone{t: t, labels: make(map[string]comatproto.LabelDefs_Label), reject: make(map[string]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /xrpc/com.atproto.server.createSession", f.createSession)
+	mux.HandleFunc("GET /xrpc/com.atproto.label.queryLabels", f.queryLabels)
+	mux.HandleFunc("POST /xrpc/tools.ozone.moderation.emitEvent", f.emitEvent)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+// label gives did the label val as if Ozone had issued it at cts
+func (f *fakeOzone) label(did, val string, cts time.Time, neg bool) {
+	l := comatproto.LabelDefs_Label{Src: serverDID, Uri: did, Val: val, Cts: cts.UTC().Format(syntax.AtprotoDatetimeLayout)}
+	if neg {
+		l.Neg = &neg
+	}
+	f.labels[did+" "+val] = l
+}
+
+func (f *fakeOzone) token() string {
+	return fmt.Sprintf("access-%d", f.logins)
+}
+
+func (f *fakeOzone) createSession(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("atproto-proxy") != "" {
+		f.t.Errorf("createSession was proxied to %s", r.Header.Get("atproto-proxy"))
+	}
+	var input comatproto.ServerCreateSession_Input
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Identifier != "ozone.test" || input.Password != "secret" {
+		writeError(w, http.StatusUnauthorized, "AuthenticationRequired")
+		return
+	}
+	f.logins++
+	json.NewEncoder(w).Encode(comatproto.ServerCreateSession_Output{Did: serverDID, Handle: "ozone.test", AccessJwt: f.token(), RefreshJwt: "refresh"})
+}
+
+// authorized checks a request carries the current session and is proxied to Ozone
+func (f *fakeOzone) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if proxy := r.Header.Get("atproto-proxy"); proxy != serverDID+"#atproto_labeler" {
+		f.t.Errorf("%s proxied to %q", r.URL.Path, proxy)
+	}
+	if r.Header.Get("Authorization") != "Bearer "+f.token() {
+		writeError(w, http.StatusUnauthorized, "InvalidToken")
+		return false
+	}
+	return true
+}
+
+func (f *fakeOzone) queryLabels(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.authorized(w, r) {
+		return
+	}
+
+	// Pages of two, to exercise the cursor
+	var keys []string
+	for key := range f.labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	start, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+	end := min(start+2, len(keys))
+
+	out := comatproto.LabelQueryLabels_Output{Labels: []*comatproto.LabelDefs_Label{}}
+	for _, key := range keys[start:end] {
+		l := f.labels[key]
+		out.Labels = append(out.Labels, &l)
+	}
+	if end < len(keys) {
+		cursor := strconv.Itoa(end)
+		out.Cursor = &cursor
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+func (f *fakeOzone) emitEvent(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.limit > 0 {
+		f.emitted++
+		w.Header().Set("ratelimit-limit", strconv.Itoa(f.limit))
+		w.Header().Set("ratelimit-remaining", strconv.Itoa(max(f.limit-f.emitted, 0)))
+		w.Header().Set("ratelimit-reset", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+		if f.emitted > f.limit {
+			f.over++
+			writeError(w, http.StatusTooManyRequests, "RateLimitExceeded")
+			return
+		}
+	}
+	if f.throttle > 0 {
+		f.throttle--
+		w.Header().Set("ratelimit-limit", "3000")
+		w.Header().Set("ratelimit-remaining", "0")
+		w.Header().Set("ratelimit-reset", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+		writeError(w, http.StatusTooManyRequests, "RateLimitExceeded")
+		return
+	}
+	if f.expire {
+		f.expire = false
+		writeError(w, http.StatusBadRequest, "ExpiredToken")
+		return
+	}
+	if !f.authorized(w, r) {
+		return
+	}
+
+	var input struct {
+		CreatedBy string `json:"createdBy"`
+		Event     struct {
+			Type            string   `json:"$type"`
+			CreateLabelVals []string `json:"createLabelVals"`
+			NegateLabelVals []string `json:"negateLabelVals"`
+			DurationInHours *int64   `json:"durationInHours"`
+		} `json:"event"`
+		Subject struct {
+			Type string `json:"$type"`
+			DID  string `json:"did"`
+		} `json:"subject"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+	if input.CreatedBy != serverDID || input.Event.Type != "tools.ozone.moderation.defs#modEventLabel" || input.Subject.Type != "com.atproto.admin.defs#repoRef" {
+		f.t.Errorf("unexpected event %+v", input)
+	}
+	if input.Event.CreateLabelVals == nil || input.Event.NegateLabelVals == nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+	if f.reject[input.Subject.DID] {
+		writeError(w, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+
+	now := time.Now()
+	for _, val := range input.Event.CreateLabelVals {
+		f.label(input.Subject.DID, val, now, false)
+		event := fmt.Sprintf("create %s %s", val, input.Subject.DID)
+		if input.Event.DurationInHours != nil {
+			exp := now.Add(time.Duration(*input.Event.DurationInHours) * time.Hour).UTC().Format(syntax.AtprotoDatetimeLayout)
+			l := f.labels[input.Subject.DID+" "+val]
+			l.Exp = &exp
+			f.labels[input.Subject.DID+" "+val] = l
+			event += fmt.Sprintf(" %dh", *input.Event.DurationInHours)
+		}
+		f.events = append(f.events, event)
+	}
+	for _, val := range input.Event.NegateLabelVals {
+		f.label(input.Subject.DID, val, now, true)
+		f.events = append(f.events, fmt.Sprintf("negate %s %s", val, input.Subject.DID))
+	}
+	json.NewEncoder(w).Encode(map[string]any{"id": len(f.events)})
+}
+
+func writeError(w http.ResponseWriter, status int, name string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": name, "message": name})
+}
+
+func newTestClient(t *testing.T, url string) (*Client, *[]time.Duration) {
+	client := NewClient(Config{PDSHost: url, Handle: "ozone.test", Password: "secret", ServerDID: serverDID})
+	client.SetBatchSize(2)
+	var sleeps []time.Duration
+	client.retry.Sleep = func(_ context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return nil
+	}
+	must(t, client.Login(t.Context()))
+	return client, &sleeps
+}
+
+const value = labels.DefaultValue
+
+// noCategories applies only the main label
+var noCategories = &labels.Categories{}
+
+func TestSync(t *testing.T) {
+	ozone, server := newFakeOzone(t)
+	earlier := time.Now().Add(-time.Hour)
+	ozone.label("did:plc:kept", value, earlier, false)
+	ozone.label("did:plc:dropped", value, earlier, false)
+	ozone.label("did:plc:negated", value, earlier, true)
+	ozone.label("did:plc:other", value, earlier, false)
+	ozone.label("did:plc:dropped", "spam", earlier, false)
+	ozone.throttle = 1
+	ozone.expire = true
+	ozone.reject["did:plc:refused"] = true
+
+	client, sleeps := newTestClient(t, server.URL)
+	ds := []decisions.Decision{
+		{DID: "did:plc:kept", Include: true},
+		{DID: "did:plc:other", Include: true},
+		{DID: "did:plc:new", Include: true},
+		{DID: "did:plc:refused", Include: true},
+		{DID: "did:plc:negated", Include: false},
+		{DID: "did:plc:dropped", Include: false},
+	}
+	opts := labels.Options{Value: value, MaxNegatePercent: 50}
+
+	results, err := client.Sync(t.Context(), ds, noCategories, opts, false)
+	must(t, err)
+	if want := map[string]Result{value: {Applied: 1, Negated: 1, Unchanged: 2, Failed: 1}}; !reflect.DeepEqual(results, want) {
+		t.Errorf("got %+v, want %+v", results, want)
+	}
+	// The label moderators applied by hand is left alone
+	if want := []string{"create " + value + " did:plc:new", "negate " + value + " did:plc:dropped"}; !reflect.DeepEqual(ozone.events, want) {
+		t.Errorf("got events %v, want %v", ozone.events, want)
+	}
+	if ozone.logins != 2 {
+		t.Errorf("logged in %d times, want 2 after the token expired", ozone.logins)
+	}
+	if len(*sleeps) != 1 || (*sleeps)[0] < 30*time.Second {
+		t.Errorf("slept %v, want one wait for the rate limit to reset", *sleeps)
+	}
+
+	// Everything in place: a second run changes nothing
+	results, err = client.Sync(t.Context(), ds, noCategories, opts, false)
+	must(t, err)
+	if want := map[string]Result{value: {Unchanged: 3, Failed: 1}}; !reflect.DeepEqual(results, want) {
+		t.Errorf("second run got %+v, want %+v", results, want)
+	}
+}
+
+func TestSyncCategories(t *testing.T) {
+	ozone, server := newFakeOzone(t)
+	client, _ := newTestClient(t, server.URL)
+
+	const art = "at://did:plc:a/app.bsky.graph.list/art"
+	categories := &labels.Categories{Categories: []labels.Category{{Name: "art", Label: "ai-art", Lists: []string{art}}}}
+	ds := []decisions.Decision{
+		{DID: "did:plc:artist", Include: true, Lists: []string{art}},
+		{DID: "did:plc:other", Include: true},
+	}
+	results, err := client.Sync(t.Context(), ds, categories, labels.Options{Value: value}, false)
+	must(t, err)
+	if want := map[string]Result{value: {Applied: 2}, "ai-art": {Applied: 1}}; !reflect.DeepEqual(results, want) {
+		t.Errorf("got %+v, want %+v", results, want)
+	}
+	want := []string{"create ai-art did:plc:artist", "create " + value + " did:plc:artist", "create " + value + " did:plc:other"}
+	if !reflect.DeepEqual(ozone.events, want) {
+		t.Errorf("got events %v, want %v", ozone.events, want)
+	}
+}
+
+func TestSyncManagedPrefix(t *testing.T) {
+	ozone, server := newFakeOzone(t)
+	earlier := time.Now().Add(-time.Hour)
+	ozone.label("did:plc:artist", value, earlier, false)
+	ozone.label("did:plc:artist", "ai-art", earlier, false)
+	ozone.label("did:plc:artist", "spam", earlier, false)
+	client, _ := newTestClient(t, server.URL)
+
+	// The art category was dropped from the file; negating all of its labels
+	// doesn't need --force
+	ds := []decisions.Decision{{DID: "did:plc:artist", Include: true}}
+	opts := labels.Options{Value: value, MaxNegatePercent: 10}
+	_, err := client.Sync(t.Context(), ds, noCategories, opts, false)
+	must(t, err)
+	if len(ozone.events) != 0 {
+		t.Fatalf("emitted %v without a managed prefix, want nothing", ozone.events)
+	}
+
+	client.SetManagedPrefix("ai-")
+	results, err := client.Sync(t.Context(), ds, noCategories, opts, false)
+	must(t, err)
+	if want := map[string]Result{value: {Unchanged: 1}, "ai-art": {Negated: 1}}; !reflect.DeepEqual(results, want) {
+		t.Errorf("got %+v, want %+v", results, want)
+	}
+	if want := []string{"negate ai-art did:plc:artist"}; !reflect.DeepEqual(ozone.events, want) {
+		t.Errorf("got events %v, want %v", ozone.events, want)
+	}
+}
+
+func TestSyncExpiry(t *testing.T) {
+	ozone, server := newFakeOzone(t)
+	client, _ := newTestClient(t, server.URL)
+
+	ds := []decisions.Decision{{DID: "did:plc:one", Include: true}}
+	opts := labels.Options{Value: value, Expiry: 90 * time.Minute}
+	results, err := client.Sync(t.Context(), ds, noCategories, opts, false)
+	must(t, err)
+	if results[value].Applied != 1 {
+		t.Errorf("got %+v, want one label applied", results)
+	}
+	if want := []string{"create " + value + " did:plc:one 2h"}; !reflect.DeepEqual(ozone.events, want) {
+		t.Errorf("got events %v, want %v", ozone.events, want)
+	}
+}
+
+func TestSyncGuardrail(t *testing.T) {
+	ozone, server := newFakeOzone(t)
+	for i := range 4 {
+		ozone.label(fmt.Sprintf("did:plc:%d", i), value, time.Now(), false)
+	}
+	client, _ := newTestClient(t, server.URL)
+
+	ds := []decisions.Decision{{DID: "did:plc:0", Include: true}}
+	opts := labels.Options{Value: value, MaxNegatePercent: 10}
+	if _, err := client.Sync(t.Context(), ds, noCategories, opts, false); err == nil || !strings.Contains(err.Error(), "refusing to negate 3 of 4") {
+		t.Fatalf("got %v, want the negation guardrail", err)
+	}
+
+	results, err := client.Sync(t.Context(), ds, noCategories, labels.Options{Value: value, Force: true}, true)
+	must(t, err)
+	if results[value].Negated != 3 {
+		t.Errorf("dry run got %+v, want 3 to negate", results)
+	}
+	if len(ozone.events) != 0 {
+		t.Errorf("emitted %v, want nothing", ozone.events)
+	}
+}
+
+func TestSyncWaitsForRateLimitReset(t *testing.T) {
+	ozone, server := newFakeOzone(t)
+	ozone.limit = 2
+	client, sleeps := newTestClient(t, server.URL)
+	client.retry.Sleep = func(_ context.Context, d time.Duration) error {
+		*sleeps = append(*sleeps, d)
+		// The budget is back once the wait is over
+		ozone.mu.Lock()
+		ozone.emitted = 0
+		ozone.mu.Unlock()
+		return nil
+	}
+
+	ds := []decisions.Decision{{DID: "did:plc:a", Include: true}, {DID: "did:plc:b", Include: true}, {DID: "did:plc:c", Include: true}}
+	results, err := client.Sync(t.Context(), ds, noCategories, labels.Options{Value: value}, false)
+	must(t, err)
+	if results[value].Applied != 3 {
+		t.Errorf("got %+v, want 3 labels applied", results)
+	}
+	// The third event waits for the reset rather than being refused
+	if len(*sleeps) != 1 || (*sleeps)[0] < 30*time.Second {
+		t.Errorf("slept %v, want one wait for the rate limit to reset", *sleeps)
+	}
+	if ozone.over != 0 || ozone.emitted != 1 {
+		t.Errorf("got %d 429s and %d events after the reset, want none and 1", ozone.over, ozone.emitted)
+	}
+}
+
+func TestLabelsIndexesEveryValue(t *testing.T) {
+	ozone, server := newFakeOzone(t)
+	earlier := time.Now().Add(-time.Hour)
+	ozone.label("did:plc:a", value, earlier, false)
+	ozone.label("did:plc:a", "ai-art", earlier, true)
+	ozone.label("did:plc:b", value, earlier, false)
+	ozone.labels["did:plc:c "+value] = comatproto.LabelDefs_Label{Src: "did:plc:someone-else", Uri: "did:plc:c", Val: value, Cts: earlier.UTC().Format(syntax.AtprotoDatetimeLayout)}
+	client, _ := newTestClient(t, server.URL)
+
+	index, err := client.Labels(t.Context())
+	must(t, err)
+	got := make(map[string][]string)
+	for val, current := range index {
+		for did := range current {
+			got[val] = append(got[val], did)
+		}
+		sort.Strings(got[val])
+	}
+	if want := map[string][]string{value: {"did:plc:a", "did:plc:b"}, "ai-art": {"did:plc:a"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRejectedIsNotRetried(t *testing.T) {
+	ozone, server := newFakeOzone(t)
+	ozone.reject["did:plc:one"] = true
+	client, sleeps := newTestClient(t, server.URL)
+
+	err := client.Label(t.Context(), "did:plc:one", []string{value}, nil, 0, "")
+	if err == nil || !strings.Contains(err.Error(), "request rejected") {
+		t.Fatalf("got %v, want a rejection", err)
+	}
+	if len(*sleeps) != 0 {
+		t.Errorf("slept %v before giving up, want no retries", *sleeps)
+	}
+}
+
+func TestRetryStopsWhenCancelled(t *testing.T) {
+	ozone, server := newFakeOzone(t)
+	ozone.throttle = 1
+	client, _ := newTestClient(t, server.URL)
+	client.retry.Sleep = retry.Sleep
+
+	// The rate limit resets in a minute, but the wait ends with the context
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := client.Label(ctx, "did:plc:one", []string{value}, nil, 0, "")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the context's error", err)
+	}
+	if waited := time.Since(start); waited > 10*time.Second {
+		t.Errorf("waited %v after the context was done", waited)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,68 @@
+package ozone
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// budget reads the rate limit the PDS reports on every response, so label
+// events pause once it is spent instead of running into 429s
+type budget struct {
+	next http.RoundTripper
+
+	mu        sync.Mutex
+	remaining int
+	reset     time.Time
+}
+
+func (b *budget) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := b.next.RoundTrip(req)
+	// A 429 is waited out by the retry, which reads the reset from the error
+	if err != nil || resp.StatusCode == http.StatusTooManyRequests {
+		return resp, err
+	}
+
+	remaining, err := strconv.Atoi(resp.Header.Get("ratelimit-remaining"))
+	if err != nil {
+		return resp, nil
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("ratelimit-reset"), 10, 64)
+	if err != nil {
+		return resp, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remaining = remaining
+	b.reset = time.Unix(reset, 0)
+	return resp, nil
+}
+
+// wait returns how long to hold off before the next request: until the reset
+// if the budget is spent, otherwise not at all. It assumes the wait is taken
+// and forgets the spent budget, since the next response reports the new one.
+func (b *budget) wait(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.remaining > 0 || b.reset.IsZero() || !b.reset.After(now) {
+		return 0
+	}
+	wait := b.reset.Sub(now) + time.Second
+	b.reset = time.Time{}
+	return wait
+}
+
+// pace waits for the rate limit to reset if the last response said it was spent
+func (c *Client) pace(ctx context.Context) error {
+	wait := c.budget.wait(time.Now())
+	if wait == 0 {
+		return nil
+	}
+	fmt.Printf("Rate limit spent, waiting %v for it to reset\n", wait.Round(time.Second))
+	return c.retry.Sleep(ctx, wait)
+}
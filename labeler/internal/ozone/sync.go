@@ -0,0 +1,124 @@
+package ozone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/label"
+
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/decisions"
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/labels"
+)
+
+// Result counts what Sync did. Failed counts accounts Ozone would not label
+// or unlabel; they are retried on the next run.
+type Result struct {
+	Applied   int
+	Refreshed int
+	Negated   int
+	Unchanged int
+	Failed    int
+}
+
+// Sync brings the labels Ozone has issued in line with the decisions, the
+// same way labels.SyncAll does for the Go labeler's own log: opts.Value goes
+// on every account included and each category's label on those subscribed
+// to its lists. Labels of values starting with the managed prefix that no
+// category uses any more are negated. Other values are left alone, since
+// moderators may apply them through Ozone by hand. With dryRun it only
+// reports the plan. A value that fails to sync doesn't stop the others.
+func (c *Client) Sync(ctx context.Context, ds []decisions.Decision, categories *labels.Categories, opts labels.Options, dryRun bool) (map[string]Result, error) {
+	issued, err := c.Labels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing []string
+	if c.prefix != "" {
+		for val := range issued {
+			if strings.HasPrefix(val, c.prefix) {
+				existing = append(existing, val)
+			}
+		}
+	}
+
+	targets, err := categories.Targets(opts.Value, ds, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]Result, len(targets))
+	var errs []error
+	for _, val := range labels.Values(targets) {
+		result, err := c.sync(ctx, issued[val], targets[val], categories.ValueOptions(opts, val), dryRun)
+		results[val] = result
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", val, err))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// sync brings the labels of opts.Value in line with ds, given current, the
+// latest label of it on each account
+func (c *Client) sync(ctx context.Context, current map[string]label.Label, ds []decisions.Decision, opts labels.Options, dryRun bool) (Result, error) {
+	var result Result
+
+	plan, err := labels.NewPlan(current, ds, time.Now(), opts)
+	if err != nil {
+		return result, err
+	}
+	result.Unchanged = plan.Unchanged
+
+	fmt.Printf("%s: %d to label, %d to refresh, %d to negate, %d already in place\n", opts.Value, len(plan.Apply), len(plan.Refresh), len(plan.Negate), plan.Unchanged)
+	if dryRun {
+		result.Applied, result.Refreshed, result.Negated = len(plan.Apply), len(plan.Refresh), len(plan.Negate)
+		return result, nil
+	}
+
+	create := func(did string) error {
+		return c.Label(ctx, did, []string{opts.Value}, nil, opts.Expiry, "Subscribes to a tracked AI blocklist")
+	}
+	negate := func(did string) error {
+		return c.Label(ctx, did, nil, []string{opts.Value}, 0, "No longer meets the listing policy")
+	}
+
+	var failed int
+	result.Applied, failed = c.inBatches(ctx, "Labelling", plan.Apply, create)
+	result.Failed += failed
+	result.Refreshed, failed = c.inBatches(ctx, "Refreshing", plan.Refresh, create)
+	result.Failed += failed
+	result.Negated, failed = c.inBatches(ctx, "Negating", plan.Negate, negate)
+	result.Failed += failed
+
+	return result, ctx.Err()
+}
+
+// inBatches runs apply on each DID, reporting progress a batch at a time
+func (c *Client) inBatches(ctx context.Context, verb string, dids []string, apply func(did string) error) (successful, failed int) {
+	for start := 0; start < len(dids) && ctx.Err() == nil; start += c.batchSize {
+		end := min(start+c.batchSize, len(dids))
+		batch := dids[start:end]
+
+		fmt.Printf("%s users %d-%d/%d\n", verb, start+1, end, len(dids))
+		var batchFailed int
+		for _, did := range batch {
+			if err := apply(did); err != nil {
+				fmt.Printf("✗ Failed on %s: %v\n", did, err)
+				batchFailed++
+				continue
+			}
+			successful++
+		}
+		failed += batchFailed
+		fmt.Printf("✓ %d/%d in batch succeeded\n", len(batch)-batchFailed, len(batch))
+	}
+
+	return successful, failed
+}
@@ -0,0 +1,132 @@
+// Package retry runs XRPC requests again when they fail for reasons that
+// pass: a rate limit waits for its reset, an expired session is renewed and
+// other failures back off exponentially. Requests the server refused are
+// not retried, since sending them unchanged will not help.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// Config is how many times to try and how long to back off at first
+type Config struct {
+	MaxRetries int
+	BaseWait   time.Duration
+}
+
+// DefaultConfig tries five times, backing off from a minute
+var DefaultConfig = Config{MaxRetries: 5, BaseWait: 60 * time.Second}
+
+// Retrier runs requests under a Config
+type Retrier struct {
+	Config
+
+	// Renew logs in again after the session expired. Without it an expired
+	// session is retried like any other failure.
+	Renew func(ctx context.Context) error
+
+	// Sleep waits between attempts; it is Sleep unless replaced, in tests
+	Sleep func(ctx context.Context, d time.Duration) error
+}
+
+// New creates a Retrier for config that renews sessions with renew
+func New(config Config, renew func(ctx context.Context) error) *Retrier {
+	return &Retrier{Config: config, Renew: renew, Sleep: Sleep}
+}
+
+// Do runs op until it succeeds, is rejected, runs out of attempts or ctx is
+// done. Retrying with a renewed session doesn't use up an attempt.
+func (r *Retrier) Do(ctx context.Context, op func() error) error {
+	renewed := false
+	for attempt := 1; attempt <= r.MaxRetries; attempt++ {
+		err := op()
+		if err == nil {
+			return nil // Success
+		}
+
+		// Check if the session expired and log in again
+		if r.Renew != nil && IsTokenExpired(err) && !renewed {
+			fmt.Println("Token expired, logging in again...")
+			if loginErr := r.Renew(ctx); loginErr != nil {
+				return fmt.Errorf("failed to renew session: %w", loginErr)
+			}
+			// Retry immediately with the new session instead of waiting
+			renewed = true
+			attempt--
+			continue
+		}
+		renewed = false
+
+		if IsRejected(err) {
+			return fmt.Errorf("request rejected: %w", err)
+		}
+
+		if attempt == r.MaxRetries {
+			return fmt.Errorf("final attempt failed after %d tries: %w", r.MaxRetries, err)
+		}
+
+		waitTime := WaitTime(err, attempt, r.BaseWait)
+		fmt.Printf("Attempt %d/%d failed: %v\n", attempt, r.MaxRetries, err)
+		fmt.Printf("Waiting %v before retry...\n", waitTime)
+		if err := r.Sleep(ctx, waitTime); err != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("maximum retries exceeded")
+}
+
+// WaitTime determines how long to wait before retrying after the given
+// attempt failed with err
+func WaitTime(err error, attempt int, baseWait time.Duration) time.Duration {
+	// If we were told when the rate limit resets, wait exactly that long
+	var xrpcErr *xrpc.Error
+	if errors.As(err, &xrpcErr) && xrpcErr.Ratelimit != nil && !xrpcErr.Ratelimit.Reset.IsZero() {
+		waitUntilReset := time.Until(xrpcErr.Ratelimit.Reset) + time.Second
+
+		if waitUntilReset > 0 && waitUntilReset < 48*time.Hour {
+			fmt.Printf("Rate limit exceeded (remaining: %d), waiting until reset + 1s\n", xrpcErr.Ratelimit.Remaining)
+			return waitUntilReset
+		}
+	}
+
+	// Exponential backoff: baseWait * (2 ^ (attempt - 1))
+	exponentialWait := time.Duration(float64(baseWait) * math.Pow(2, float64(attempt-1)))
+	fmt.Printf("Using exponential backoff for attempt #%d: %v\n", attempt, exponentialWait)
+	return exponentialWait
+}
+
+// IsRejected reports whether the server refused the request itself, as
+// opposed to a transient failure
+func IsRejected(err error) bool {
+	var xrpcErr *xrpc.Error
+	if !errors.As(err, &xrpcErr) {
+		return false
+	}
+	return xrpcErr.StatusCode >= 400 && xrpcErr.StatusCode < 500 && !xrpcErr.IsThrottled()
+}
+
+// IsTokenExpired reports whether err means the session needs renewing
+func IsTokenExpired(err error) bool {
+	return strings.Contains(err.Error(), "ExpiredToken") || strings.Contains(err.Error(), "Token has expired")
+}
+
+// Sleep waits for d, or until ctx is done
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,70 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+func xrpcError(status int, name string) error {
+	return &xrpc.Error{StatusCode: status, Wrapped: &xrpc.XRPCError{ErrStr: name, Message: name}}
+}
+
+func TestDo(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantSleep []time.Duration
+		wantLogin int
+		wantErr   string
+	}{
+		{name: "success", errs: []error{nil}, wantCalls: 1},
+		{name: "backs off", errs: []error{errors.New("connection reset"), xrpcError(502, "BadGateway"), nil}, wantCalls: 3, wantSleep: []time.Duration{time.Second, 2 * time.Second}},
+		{name: "renews session", errs: []error{xrpcError(400, "ExpiredToken"), nil}, wantCalls: 2, wantLogin: 1},
+		{name: "renewing is not an attempt", errs: []error{xrpcError(502, "A"), xrpcError(400, "ExpiredToken"), xrpcError(502, "B"), nil}, wantCalls: 4, wantSleep: []time.Duration{time.Second, 2 * time.Second}, wantLogin: 1},
+		{name: "expires again", errs: []error{xrpcError(400, "ExpiredToken"), xrpcError(400, "ExpiredToken")}, wantCalls: 2, wantLogin: 1, wantErr: "request rejected"},
+		{name: "rejected", errs: []error{xrpcError(400, "InvalidRequest")}, wantCalls: 1, wantErr: "request rejected"},
+		{name: "gives up", errs: []error{xrpcError(500, "A"), xrpcError(500, "B"), xrpcError(500, "C")}, wantCalls: 3, wantSleep: []time.Duration{time.Second, 2 * time.Second}, wantErr: "final attempt failed after 3 tries"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logins int
+			r := New(Config{MaxRetries: 3, BaseWait: time.Second}, func(context.Context) error {
+				logins++
+				return nil
+			})
+			var sleeps []time.Duration
+			r.Sleep = func(_ context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return nil
+			}
+
+			calls := 0
+			err := r.Do(t.Context(), func() error {
+				calls++
+				return tt.errs[calls-1]
+			})
+
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls || logins != tt.wantLogin || !reflect.DeepEqual(sleeps, tt.wantSleep) {
+				t.Errorf("got %d calls, %d logins and sleeps %v", calls, logins, sleeps)
+			}
+		})
+	}
+}
+
+func TestWaitTimeUsesReset(t *testing.T) {
+	err := &xrpc.Error{StatusCode: 429, Wrapped: errors.New("RateLimitExceeded"), Ratelimit: &xrpc.RatelimitInfo{Reset: time.Now().Add(10 * time.Minute)}}
+	if wait := WaitTime(err, 1, time.Second); wait < 9*time.Minute || wait > 11*time.Minute {
+		t.Errorf("waited %v, want until the reset", wait)
+	}
+}
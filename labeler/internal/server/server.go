@@ -0,0 +1,235 @@
+// Package server serves the label log over XRPC: com.atproto.label.queryLabels
+// for lookups and com.atproto.label.subscribeLabels for the event stream that
+// the AppView and other consumers follow.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/gorilla/websocket"
+
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/store"
+)
+
+// Limits on queryLabels pages and subscribeLabels replay batches
+const (
+	defaultQueryLimit = 50
+	maxQueryLimit     = 250
+	replayBatchSize   = 500
+)
+
+// Log is the part of the label log the server reads
+type Log interface {
+	Query(ctx context.Context, q store.Query) ([]store.Entry, error)
+	Since(ctx context.Context, cursor int64, limit int) ([]store.Entry, error)
+	LatestSeq(ctx context.Context) (int64, error)
+}
+
+// Server serves the label log
+type Server struct {
+	log Log
+	mux *http.ServeMux
+
+	// PollInterval is how often subscribers check the log for labels appended
+	// by other processes; labels appended here wake them at once via Notify
+	PollInterval time.Duration
+
+	upgrader websocket.Upgrader
+
+	mu   sync.Mutex
+	wake chan struct{}
+}
+
+// New creates a server for the label log
+func New(log Log) *Server {
+	s := &Server{
+		log:          log,
+		mux:          http.NewServeMux(),
+		PollInterval: 5 * time.Second,
+		wake:         make(chan struct{}),
+	}
+	s.mux.HandleFunc("GET /xrpc/_health", s.health)
+	s.mux.HandleFunc("GET /xrpc/com.atproto.label.queryLabels", s.queryLabels)
+	s.mux.HandleFunc("GET /xrpc/com.atproto.label.subscribeLabels", s.subscribeLabels)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Notify tells subscribers that labels have been appended
+func (s *Server) Notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+// woken returns a channel that is closed at the next Notify
+func (s *Server) woken() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.wake
+}
+
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"version": "labeler"})
+}
+
+// xrpcError writes an XRPC error response
+func xrpcError(w http.ResponseWriter, status int, name, message string) {
+	writeJSON(w, status, map[string]string{"error": name, "message": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// queryLabels serves com.atproto.label.queryLabels
+func (s *Server) queryLabels(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := store.Query{URIPatterns: params["uriPatterns"], Sources: params["sources"], Limit: defaultQueryLimit}
+	if len(q.URIPatterns) == 0 {
+		xrpcError(w, http.StatusBadRequest, "InvalidRequest", "uriPatterns is required")
+		return
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxQueryLimit {
+			xrpcError(w, http.StatusBadRequest, "InvalidRequest", fmt.Sprintf("limit must be between 1 and %d", maxQueryLimit))
+			return
+		}
+		q.Limit = n
+	}
+	if cursor := params.Get("cursor"); cursor != "" {
+		n, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || n < 0 {
+			xrpcError(w, http.StatusBadRequest, "InvalidRequest", "invalid cursor")
+			return
+		}
+		q.Cursor = n
+	}
+
+	entries, err := s.log.Query(r.Context(), q)
+	if err != nil {
+		log.Printf("queryLabels failed: %v", err)
+		xrpcError(w, http.StatusInternalServerError, "InternalServerError", "failed to read labels")
+		return
+	}
+
+	out := comatproto.LabelQueryLabels_Output{Labels: make([]*comatproto.LabelDefs_Label, 0, len(entries))}
+	for _, entry := range entries {
+		lex := entry.Label.ToLexicon()
+		out.Labels = append(out.Labels, &lex)
+	}
+	if len(entries) == q.Limit {
+		cursor := strconv.FormatInt(entries[len(entries)-1].Seq, 10)
+		out.Cursor = &cursor
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// subscribeLabels serves com.atproto.label.subscribeLabels. With a cursor,
+// the stream replays every label after it before going live; without one it
+// starts with the next label appended.
+func (s *Server) subscribeLabels(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	latest, err := s.log.LatestSeq(ctx)
+	if err != nil {
+		log.Printf("subscribeLabels failed: %v", err)
+		xrpcError(w, http.StatusInternalServerError, "InternalServerError", "failed to read labels")
+		return
+	}
+	cursor := latest
+	var future bool
+	if raw := r.URL.Query().Get("cursor"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			xrpcError(w, http.StatusBadRequest, "InvalidRequest", "invalid cursor")
+			return
+		}
+		cursor, future = n, n > latest
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if future {
+		frame, err := errorFrame("FutureCursor", "cursor is ahead of the label log")
+		if err == nil {
+			conn.WriteMessage(websocket.BinaryMessage, frame)
+		}
+		return
+	}
+
+	// The client sends nothing but control frames; reading notices it leaving
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := s.stream(ctx, conn, cursor); err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("subscribeLabels stream from %s ended: %v", r.RemoteAddr, err)
+	}
+}
+
+// stream sends every label after cursor, then each new one as it is appended
+func (s *Server) stream(ctx context.Context, conn *websocket.Conn, cursor int64) error {
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Fetch the wake channel first so a Notify during the read isn't missed
+		wake := s.woken()
+		entries, err := s.log.Since(ctx, cursor, replayBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			lex := entry.Label.ToLexicon()
+			frame, err := labelsFrame(&comatproto.LabelSubscribeLabels_Labels{Seq: entry.Seq, Labels: []*comatproto.LabelDefs_Label{&lex}})
+			if err != nil {
+				return err
+			}
+			conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+			if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+				return err
+			}
+			cursor = entry.Seq
+		}
+		if len(entries) == replayBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/label"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/gorilla/websocket"
+
+	"github.com/segyges/bsky-ai-tribalism-utils/labeler/internal/store"
+)
+
+// fakeLog is a label log in memory that records the last query
+type fakeLog struct {
+	mu      sync.Mutex
+	entries []store.Entry
+	query   store.Query
+}
+
+func (f *fakeLog) append(uri string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l := label.Label{SourceDID: "did:plc:labeler", URI: uri, Val: "ai-blocklist-subscriber", CreatedAt: "2025-03-01T12:00:00Z", Version: 1, Sig: []byte{1, 2, 3}}
+	f.entries = append(f.entries, store.Entry{Seq: int64(len(f.entries) + 1), Label: l})
+}
+
+func (f *fakeLog) Query(ctx context.Context, q store.Query) ([]store.Entry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.query = q
+	return f.since(q.Cursor, q.Limit), nil
+}
+
+func (f *fakeLog) Since(ctx context.Context, cursor int64, limit int) ([]store.Entry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.since(cursor, limit), nil
+}
+
+func (f *fakeLog) since(cursor int64, limit int) []store.Entry {
+	var entries []store.Entry
+	for _, entry := range f.entries {
+		if entry.Seq > cursor && len(entries) < limit {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func (f *fakeLog) LatestSeq(ctx context.Context) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return int64(len(f.entries)), nil
+}
+
+func TestQueryLabels(t *testing.T) {
+	log := &fakeLog{}
+	log.append("did:plc:one")
+	log.append("did:plc:two")
+	log.append("did:plc:three")
+	server := httptest.NewServer(New(log))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/xrpc/com.atproto.label.queryLabels?uriPatterns=did:plc:*&uriPatterns=at://x&sources=did:plc:labeler&limit=2&cursor=0")
+	must(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d", resp.StatusCode)
+	}
+
+	var out comatproto.LabelQueryLabels_Output
+	must(t, json.NewDecoder(resp.Body).Decode(&out))
+	want := store.Query{URIPatterns: []string{"did:plc:*", "at://x"}, Sources: []string{"did:plc:labeler"}, Limit: 2}
+	if !reflect.DeepEqual(log.query, want) {
+		t.Errorf("got query %+v", log.query)
+	}
+	if len(out.Labels) != 2 || out.Labels[1].Uri != "did:plc:two" || !bytes.Equal(out.Labels[0].Sig, []byte{1, 2, 3}) {
+		t.Errorf("unexpected labels %+v", out.Labels)
+	}
+	if out.Cursor == nil || *out.Cursor != "2" {
+		t.Errorf("got cursor %v", out.Cursor)
+	}
+
+	for _, query := range []string{"", "?uriPatterns=x&limit=500", "?uriPatterns=x&cursor=abc"} {
+		resp, err := http.Get(server.URL + "/xrpc/com.atproto.label.queryLabels" + query)
+		must(t, err)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q: got status %d", query, resp.StatusCode)
+		}
+	}
+}
+
+// readFrame reads one event stream frame, returning its header and body
+func readFrame(t *testing.T, conn *websocket.Conn) (events.EventHeader, *bytes.Reader) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	must(t, err)
+
+	r := bytes.NewReader(data)
+	var header events.EventHeader
+	must(t, header.UnmarshalCBOR(r))
+	return header, r
+}
+
+// readLabels reads a #labels frame and returns its seq and label subject
+func readLabels(t *testing.T, conn *websocket.Conn) (int64, string) {
+	t.Helper()
+	header, r := readFrame(t, conn)
+	if header.Op != opMessage || header.MsgType != "#labels" {
+		t.Fatalf("unexpected header %+v", header)
+	}
+	var msg comatproto.LabelSubscribeLabels_Labels
+	must(t, msg.UnmarshalCBOR(r))
+	if len(msg.Labels) != 1 {
+		t.Fatalf("expected one label, got %+v", msg)
+	}
+	return msg.Seq, msg.Labels[0].Uri
+}
+
+func TestSubscribeLabels(t *testing.T) {
+	log := &fakeLog{}
+	log.append("did:plc:one")
+	log.append("did:plc:two")
+	s := New(log)
+	s.PollInterval = time.Hour
+	server := httptest.NewServer(s)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/xrpc/com.atproto.label.subscribeLabels"
+
+	// Replay from a cursor, then a live label
+	replay, _, err := websocket.DefaultDialer.Dial(url+"?cursor=1", nil)
+	must(t, err)
+	defer replay.Close()
+	if seq, uri := readLabels(t, replay); seq != 2 || uri != "did:plc:two" {
+		t.Errorf("replayed %d %s", seq, uri)
+	}
+
+	// Without a cursor, only labels appended after connecting
+	live, _, err := websocket.DefaultDialer.Dial(url, nil)
+	must(t, err)
+	defer live.Close()
+
+	log.append("did:plc:three")
+	s.Notify()
+	for name, conn := range map[string]*websocket.Conn{"replay": replay, "live": live} {
+		if seq, uri := readLabels(t, conn); seq != 3 || uri != "did:plc:three" {
+			t.Errorf("%s: got %d %s", name, seq, uri)
+		}
+	}
+
+	// A cursor past the end is an error frame
+	future, _, err := websocket.DefaultDialer.Dial(url+"?cursor=10", nil)
+	must(t, err)
+	defer future.Close()
+	header, r := readFrame(t, future)
+	var frame events.ErrorFrame
+	must(t, frame.UnmarshalCBOR(r))
+	if header.Op != opError || frame.Error != "FutureCursor" {
+		t.Errorf("got %+v %+v", header, frame)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
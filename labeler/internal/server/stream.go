@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// Frame header ops of an atproto event stream
+const (
+	opMessage = 1
+	opError   = -1
+)
+
+// frameHeader writes the {op, t} map that precedes every event stream frame
+func frameHeader(w *cbg.CborWriter, op int64, msgType string) error {
+	fields := uint64(1)
+	if msgType != "" {
+		fields++
+	}
+	if err := w.WriteMajorTypeHeader(cbg.MajMap, fields); err != nil {
+		return err
+	}
+
+	// DAG-CBOR orders keys by length first, so "t" comes before "op"
+	if msgType != "" {
+		if err := writeString(w, "t"); err != nil {
+			return err
+		}
+		if err := writeString(w, msgType); err != nil {
+			return err
+		}
+	}
+	if err := writeString(w, "op"); err != nil {
+		return err
+	}
+	if op < 0 {
+		return w.WriteMajorTypeHeader(cbg.MajNegativeInt, uint64(-op-1))
+	}
+	return w.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(op))
+}
+
+func writeString(w *cbg.CborWriter, s string) error {
+	if err := w.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(s))); err != nil {
+		return err
+	}
+	_, err := w.WriteString(s)
+	return err
+}
+
+// labelsFrame encodes a #labels message
+func labelsFrame(msg *comatproto.LabelSubscribeLabels_Labels) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := cbg.NewCborWriter(buf)
+	if err := frameHeader(w, opMessage, "#labels"); err != nil {
+		return nil, err
+	}
+	if err := msg.MarshalCBOR(w); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// errorFrame encodes an error frame, which ends the stream
+func errorFrame(name, message string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := cbg.NewCborWriter(buf)
+	if err := frameHeader(w, opError, ""); err != nil {
+		return nil, err
+	}
+
+	if err := w.WriteMajorTypeHeader(cbg.MajMap, 2); err != nil {
+		return nil, err
+	}
+	for _, s := range []string{"error", name, "message", message} {
+		if err := writeString(w, s); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
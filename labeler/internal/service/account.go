@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// Account is how to log in as the labeler account, which owns the service
+// record
+type Account struct {
+	// Identifier is the account's DID or handle
+	Identifier string
+	// Password may be an app password
+	Password string
+	// PDSHost is the account's PDS. If empty it is read from the account's
+	// DID document.
+	PDSHost string
+}
+
+// LoadAccount reads the labeler account's login from the environment:
+// LABELER_DID (or LABELER_HANDLE), LABELER_PASSWORD and LABELER_PDS_HOST. ok
+// is false if LABELER_PASSWORD isn't set.
+func LoadAccount() (account Account, ok bool, err error) {
+	account = Account{
+		Identifier: os.Getenv("LABELER_DID"),
+		Password:   os.Getenv("LABELER_PASSWORD"),
+		PDSHost:    os.Getenv("LABELER_PDS_HOST"),
+	}
+	if account.Identifier == "" {
+		account.Identifier = os.Getenv("LABELER_HANDLE")
+	}
+	if account.Password == "" {
+		return account, false, nil
+	}
+	if account.Identifier == "" {
+		return account, true, fmt.Errorf("LABELER_DID or LABELER_HANDLE must be set with LABELER_PASSWORD")
+	}
+	return account, true, nil
+}
+
+// Login logs in as the account on its PDS, finding the PDS through dir if
+// account doesn't name one, and returns a client for the account's repo
+func Login(ctx context.Context, dir identity.Directory, httpClient *http.Client, account Account) (*xrpc.Client, error) {
+	host := account.PDSHost
+	if host == "" {
+		atid, err := syntax.ParseAtIdentifier(account.Identifier)
+		if err != nil {
+			return nil, fmt.Errorf("invalid handle or DID %s: %w", account.Identifier, err)
+		}
+		ident, err := dir.Lookup(ctx, *atid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", account.Identifier, err)
+		}
+		if host = ident.PDSEndpoint(); host == "" {
+			return nil, fmt.Errorf("DID document for %s has no #atproto_pds service endpoint", ident.DID)
+		}
+	}
+
+	client := &xrpc.Client{Client: httpClient, Host: strings.TrimSuffix(host, "/")}
+	out, err := comatproto.ServerCreateSession(ctx, client, &comatproto.ServerCreateSession_Input{
+		Identifier: account.Identifier,
+		Password:   account.Password,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to log in as %s: %w", account.Identifier, err)
+	}
+	client.Auth = &xrpc.AuthInfo{
+		AccessJwt:  out.AccessJwt,
+		RefreshJwt: out.RefreshJwt,
+		Handle:     out.Handle,
+		Did:        out.Did,
+	}
+	return client, nil
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+const labelerDID = "did:plc:labeler"
+
+// newPDS is a PDS that logs the labeler account in with the password "secret"
+func newPDS(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input comatproto.ServerCreateSession_Input
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/xrpc/com.atproto.server.createSession" || json.NewDecoder(r.Body).Decode(&input) != nil || input.Password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "AuthenticationRequired", "message": "Invalid identifier or password"})
+			return
+		}
+		json.NewEncoder(w).Encode(comatproto.ServerCreateSession_Output{Did: labelerDID, Handle: "labeler.test", AccessJwt: "access", RefreshJwt: "refresh"})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// directory knows the labeler account, hosted on pds if it is set
+func directory(pds string) identity.Directory {
+	dir := identity.NewMockDirectory()
+	ident := identity.Identity{DID: syntax.DID(labelerDID), Handle: syntax.Handle("labeler.test")}
+	if pds != "" {
+		ident.Services = map[string]identity.ServiceEndpoint{
+			"atproto_pds": {Type: "AtprotoPersonalDataServer", URL: pds + "/"},
+		}
+	}
+	dir.Insert(ident)
+	return &dir
+}
+
+func TestLogin(t *testing.T) {
+	pds := newPDS(t)
+
+	for _, tc := range []struct {
+		name    string
+		account Account
+		dir     identity.Directory
+		wantErr string
+	}{
+		{name: "PDS given", account: Account{Identifier: "labeler.test", Password: "secret", PDSHost: pds.URL}, dir: directory("")},
+		{name: "PDS from DID document", account: Account{Identifier: labelerDID, Password: "secret"}, dir: directory(pds.URL)},
+		{name: "no PDS in DID document", account: Account{Identifier: labelerDID, Password: "secret"}, dir: directory(""), wantErr: "no #atproto_pds"},
+		{name: "unknown account", account: Account{Identifier: "someone.test", Password: "secret"}, dir: directory(pds.URL), wantErr: "failed to resolve"},
+		{name: "wrong password", account: Account{Identifier: labelerDID, Password: "guess", PDSHost: pds.URL}, dir: directory(""), wantErr: "failed to log in"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := Login(t.Context(), tc.dir, &http.Client{}, tc.account)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if client.Host != pds.URL || client.Auth == nil || client.Auth.Did != labelerDID || client.Auth.AccessJwt != "access" {
+				t.Errorf("got client for %s with auth %+v", client.Host, client.Auth)
+			}
+		})
+	}
+}
+
+func TestLoadAccount(t *testing.T) {
+	t.Setenv("LABELER_DID", "")
+	t.Setenv("LABELER_HANDLE", "labeler.test")
+	t.Setenv("LABELER_PDS_HOST", "")
+	t.Setenv("LABELER_PASSWORD", "")
+	if _, ok, err := LoadAccount(); ok || err != nil {
+		t.Errorf("got ok %v, error %v without a password, want neither", ok, err)
+	}
+
+	t.Setenv("LABELER_PASSWORD", "secret")
+	account, ok, err := LoadAccount()
+	if !ok || err != nil || account.Identifier != "labeler.test" {
+		t.Errorf("got %+v, ok %v, error %v", account, ok, err)
+	}
+
+	t.Setenv("LABELER_DID", labelerDID)
+	if account, _, _ := LoadAccount(); account.Identifier != labelerDID {
+		t.Errorf("got identifier %s, want the DID over the handle", account.Identifier)
+	}
+
+	t.Setenv("LABELER_DID", "")
+	t.Setenv("LABELER_HANDLE", "")
+	if _, _, err := LoadAccount(); err == nil {
+		t.Error("got no error without an identifier")
+	}
+}
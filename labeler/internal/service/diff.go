@@ -0,0 +1,108 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// Diff describes what publishing def would change in the current record, one
+// line per change: "+" for additions, "-" for removals and "~" for changes.
+// It is empty when the record already declares def.
+func (c Current) Diff(def *Definition) []string {
+	var diff []string
+	before := &bsky.LabelerDefs_LabelerPolicies{}
+	if c.Record == nil {
+		diff = append(diff, "+ service record")
+	} else if c.Record.Policies != nil {
+		before = c.Record.Policies
+	}
+	after := def.Policies()
+
+	definitions := make(map[string]*comatproto.LabelDefs_LabelValueDefinition)
+	for _, definition := range before.LabelValueDefinitions {
+		definitions[definition.Identifier] = definition
+	}
+	defined := make(map[string]bool)
+	for _, definition := range after.LabelValueDefinitions {
+		defined[definition.Identifier] = true
+		old, ok := definitions[definition.Identifier]
+		if !ok {
+			diff = append(diff, "+ label "+definition.Identifier)
+			continue
+		}
+		diff = append(diff, diffLabel(old, definition)...)
+	}
+	for _, definition := range before.LabelValueDefinitions {
+		if !defined[definition.Identifier] {
+			diff = append(diff, "- label "+definition.Identifier)
+		}
+	}
+
+	if oldValues, newValues := values(before), values(after); !slices.Equal(oldValues, newValues) {
+		diff = append(diff, fmt.Sprintf("~ label values: %v → %v", oldValues, newValues))
+	}
+	return diff
+}
+
+// diffLabel describes the changes from one definition of a label value to another
+func diffLabel(old, new *comatproto.LabelDefs_LabelValueDefinition) []string {
+	var diff []string
+	changed := func(field, before, after string) {
+		if before != after {
+			diff = append(diff, fmt.Sprintf("~ %s %s: %q → %q", new.Identifier, field, before, after))
+		}
+	}
+	changed("severity", old.Severity, new.Severity)
+	changed("blurs", old.Blurs, new.Blurs)
+	changed("default setting", deref(old.DefaultSetting), deref(new.DefaultSetting))
+	if adultOnly(old) != adultOnly(new) {
+		diff = append(diff, fmt.Sprintf("~ %s adult only: %v → %v", new.Identifier, adultOnly(old), adultOnly(new)))
+	}
+
+	locales := make(map[string]*comatproto.LabelDefs_LabelValueDefinitionStrings)
+	for _, locale := range old.Locales {
+		locales[locale.Lang] = locale
+	}
+	langs := make(map[string]bool)
+	for _, locale := range new.Locales {
+		langs[locale.Lang] = true
+		before, ok := locales[locale.Lang]
+		if !ok {
+			diff = append(diff, fmt.Sprintf("+ %s %s locale", new.Identifier, locale.Lang))
+			continue
+		}
+		changed(locale.Lang+" name", before.Name, locale.Name)
+		changed(locale.Lang+" description", before.Description, locale.Description)
+	}
+	for _, locale := range old.Locales {
+		if !langs[locale.Lang] {
+			diff = append(diff, fmt.Sprintf("- %s %s locale", new.Identifier, locale.Lang))
+		}
+	}
+	return diff
+}
+
+// values returns the label values policies declare
+func values(policies *bsky.LabelerDefs_LabelerPolicies) []string {
+	var values []string
+	for _, value := range policies.LabelValues {
+		if value != nil {
+			values = append(values, *value)
+		}
+	}
+	return values
+}
+
+func adultOnly(definition *comatproto.LabelDefs_LabelValueDefinition) bool {
+	return definition.AdultOnly != nil && *definition.AdultOnly
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,211 @@
+// Package service manages the labeler account's app.bsky.labeler.service
+// record, which tells clients the label values we publish and how to show
+// them. The values are declared in a TOML file and the record is diffed
+// against it before being updated.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// The service record's collection and record key
+const (
+	Collection = "app.bsky.labeler.service"
+	RecordKey  = "self"
+)
+
+// identifierPattern is what a custom label value may look like
+var identifierPattern = regexp.MustCompile(`^[a-z-]+$`)
+
+// The values the lexicon allows for each setting
+var (
+	severities      = []string{"inform", "alert", "none"}
+	blurs           = []string{"content", "media", "none"}
+	defaultSettings = []string{"ignore", "warn", "hide"}
+)
+
+// Locale is a label value's name and description in one language
+type Locale struct {
+	Lang        string `toml:"lang"`
+	Name        string `toml:"name"`
+	Description string `toml:"description"`
+}
+
+// Label declares one label value
+type Label struct {
+	Identifier     string   `toml:"identifier"`
+	Severity       string   `toml:"severity"`
+	Blurs          string   `toml:"blurs"`
+	DefaultSetting string   `toml:"default_setting"`
+	AdultOnly      bool     `toml:"adult_only"`
+	Locales        []Locale `toml:"locales"`
+}
+
+// Definition is the label values the labeler publishes, in the order
+// clients should list them
+type Definition struct {
+	Labels []Label `toml:"labels"`
+}
+
+// LoadDefinition reads a definition from a TOML file
+func LoadDefinition(path string) (*Definition, error) {
+	var def Definition
+	if _, err := toml.DecodeFile(path, &def); err != nil {
+		return nil, fmt.Errorf("failed to parse TOML file %s: %w", path, err)
+	}
+	if err := def.validate(); err != nil {
+		return nil, fmt.Errorf("invalid label definition %s: %w", path, err)
+	}
+	return &def, nil
+}
+
+func (d *Definition) validate() error {
+	if len(d.Labels) == 0 {
+		return fmt.Errorf("no labels defined")
+	}
+
+	seen := make(map[string]bool)
+	for _, l := range d.Labels {
+		if !identifierPattern.MatchString(l.Identifier) || len(l.Identifier) > 100 {
+			return fmt.Errorf("label %q: identifiers are up to 100 lowercase letters and dashes", l.Identifier)
+		}
+		if seen[l.Identifier] {
+			return fmt.Errorf("label %q is defined twice", l.Identifier)
+		}
+		seen[l.Identifier] = true
+
+		if !slices.Contains(severities, l.Severity) {
+			return fmt.Errorf("label %q: severity must be one of %s", l.Identifier, strings.Join(severities, ", "))
+		}
+		if !slices.Contains(blurs, l.Blurs) {
+			return fmt.Errorf("label %q: blurs must be one of %s", l.Identifier, strings.Join(blurs, ", "))
+		}
+		if l.DefaultSetting != "" && !slices.Contains(defaultSettings, l.DefaultSetting) {
+			return fmt.Errorf("label %q: default_setting must be one of %s", l.Identifier, strings.Join(defaultSettings, ", "))
+		}
+
+		if len(l.Locales) == 0 {
+			return fmt.Errorf("label %q has no locales", l.Identifier)
+		}
+		langs := make(map[string]bool)
+		for _, locale := range l.Locales {
+			if _, err := syntax.ParseLanguage(locale.Lang); err != nil {
+				return fmt.Errorf("label %q: %w", l.Identifier, err)
+			}
+			if langs[locale.Lang] {
+				return fmt.Errorf("label %q has two %s locales", l.Identifier, locale.Lang)
+			}
+			langs[locale.Lang] = true
+			if locale.Name == "" || locale.Description == "" {
+				return fmt.Errorf("label %q: the %s locale needs a name and a description", l.Identifier, locale.Lang)
+			}
+		}
+	}
+	return nil
+}
+
+// Policies returns the definition as the service record's policies
+func (d *Definition) Policies() *bsky.LabelerDefs_LabelerPolicies {
+	policies := &bsky.LabelerDefs_LabelerPolicies{}
+	for _, l := range d.Labels {
+		identifier := l.Identifier
+		policies.LabelValues = append(policies.LabelValues, &identifier)
+
+		definition := &comatproto.LabelDefs_LabelValueDefinition{
+			Identifier: l.Identifier,
+			Severity:   l.Severity,
+			Blurs:      l.Blurs,
+			Locales:    []*comatproto.LabelDefs_LabelValueDefinitionStrings{},
+		}
+		if l.DefaultSetting != "" {
+			setting := l.DefaultSetting
+			definition.DefaultSetting = &setting
+		}
+		if l.AdultOnly {
+			adultOnly := true
+			definition.AdultOnly = &adultOnly
+		}
+		for _, locale := range l.Locales {
+			definition.Locales = append(definition.Locales, &comatproto.LabelDefs_LabelValueDefinitionStrings{
+				Lang:        locale.Lang,
+				Name:        locale.Name,
+				Description: locale.Description,
+			})
+		}
+		policies.LabelValueDefinitions = append(policies.LabelValueDefinitions, definition)
+	}
+	return policies
+}
+
+// Current is the service record as published, if it exists
+type Current struct {
+	Record *bsky.LabelerService
+	CID    string
+}
+
+// Fetch reads did's service record. Record is nil if there is none.
+func Fetch(ctx context.Context, client lexutil.LexClient, did string) (Current, error) {
+	out, err := comatproto.RepoGetRecord(ctx, client, "", Collection, did, RecordKey)
+	if err != nil {
+		var xrpcErr *xrpc.XRPCError
+		if errors.As(err, &xrpcErr) && xrpcErr.ErrStr == "RecordNotFound" {
+			return Current{}, nil
+		}
+		return Current{}, fmt.Errorf("failed to fetch the labeler service record: %w", err)
+	}
+
+	record, ok := out.Value.Val.(*bsky.LabelerService)
+	if !ok {
+		return Current{}, fmt.Errorf("the labeler service record is a %T, not a %s", out.Value.Val, Collection)
+	}
+	current := Current{Record: record}
+	if out.Cid != nil {
+		current.CID = *out.Cid
+	}
+	return current, nil
+}
+
+// Declare returns the service record declaring def. Everything in the current
+// record other than its policies is kept.
+func (c Current) Declare(def *Definition, now time.Time) *bsky.LabelerService {
+	record := &bsky.LabelerService{CreatedAt: now.UTC().Format(syntax.AtprotoDatetimeLayout)}
+	if c.Record != nil {
+		kept := *c.Record
+		record = &kept
+	}
+	record.LexiconTypeID = Collection
+	record.Policies = def.Policies()
+	return record
+}
+
+// Put writes record as did's service record. It fails if the record changed
+// since current was fetched, or was created if there was none.
+func Put(ctx context.Context, client lexutil.LexClient, did string, current Current, record *bsky.LabelerService) error {
+	input := &comatproto.RepoPutRecord_Input{
+		Repo:       did,
+		Collection: Collection,
+		Rkey:       RecordKey,
+		Record:     &lexutil.LexiconTypeDecoder{Val: record},
+	}
+	if current.Record != nil {
+		input.SwapRecord = &current.CID
+	}
+
+	if _, err := comatproto.RepoPutRecord(ctx, client, input); err != nil {
+		return fmt.Errorf("failed to write the labeler service record: %w", err)
+	}
+	return nil
+}
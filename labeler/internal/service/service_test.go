@@ -0,0 +1,133 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+func writeDefinition(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "labels.toml")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const definition = `
+[[labels]]
+identifier = "ai-blocklist-subscriber"
+severity = "inform"
+blurs = "none"
+default_setting = "warn"
+
+[[labels.locales]]
+lang = "en"
+name = "AI blocklist subscriber"
+description = "Subscribes to an AI blocklist."
+
+[[labels.locales]]
+lang = "de"
+name = "KI-Blocklisten-Abonnent"
+description = "Abonniert eine KI-Blockliste."
+`
+
+func TestLoadDefinition(t *testing.T) {
+	def, err := LoadDefinition(writeDefinition(t, definition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(def.Labels) != 1 || len(def.Labels[0].Locales) != 2 || def.Labels[0].DefaultSetting != "warn" {
+		t.Errorf("got %+v", def)
+	}
+
+	// The sample shipped with the labeler
+	if _, err := LoadDefinition("../../labels.toml"); err != nil {
+		t.Error(err)
+	}
+
+	for _, tc := range []struct {
+		name, from, to, want string
+	}{
+		{"identifier", `identifier = "ai-blocklist-subscriber"`, `identifier = "AI_subscriber"`, "identifiers"},
+		{"severity", `severity = "inform"`, `severity = "loud"`, "severity must be one of"},
+		{"blurs", `blurs = "none"`, `blurs = "everything"`, "blurs must be one of"},
+		{"default setting", `default_setting = "warn"`, `default_setting = "block"`, "default_setting must be one of"},
+		{"duplicate locale", `lang = "de"`, `lang = "en"`, "two en locales"},
+		{"bad language", `lang = "de"`, `lang = "not a language"`, "language"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadDefinition(writeDefinition(t, strings.Replace(definition, tc.from, tc.to, 1)))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got %v, want an error about %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	def, err := LoadDefinition(writeDefinition(t, definition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := Current{}.Diff(def)
+	if want := []string{"+ service record", "+ label ai-blocklist-subscriber", "~ label values: [] → [ai-blocklist-subscriber]"}; !reflect.DeepEqual(diff, want) {
+		t.Errorf("new record got diff %q, want %q", diff, want)
+	}
+
+	reasonType := "com.atproto.moderation.defs#reasonSpam"
+	published := Current{
+		Record: &bsky.LabelerService{CreatedAt: "2025-01-01T00:00:00Z", ReasonTypes: []*string{&reasonType}},
+		CID:    "bafyrecord",
+	}
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	published.Record = published.Declare(def, now)
+	if published.Record.CreatedAt != "2025-01-01T00:00:00Z" || len(published.Record.ReasonTypes) != 1 {
+		t.Errorf("updating the record lost its other fields: %+v", published.Record)
+	}
+	if diff := published.Diff(def); len(diff) != 0 {
+		t.Errorf("unchanged definition got diff %q", diff)
+	}
+
+	def.Labels[0].Severity = "alert"
+	def.Labels[0].Locales[0].Name = "AI blocklist user"
+	def.Labels[0].Locales = def.Labels[0].Locales[:1]
+	def.Labels = append(def.Labels, Label{Identifier: "ai-curious", Severity: "none", Blurs: "none", Locales: []Locale{{Lang: "en", Name: "AI curious", Description: "Curious."}}})
+	want := []string{
+		`~ ai-blocklist-subscriber severity: "inform" → "alert"`,
+		`~ ai-blocklist-subscriber en name: "AI blocklist subscriber" → "AI blocklist user"`,
+		"- ai-blocklist-subscriber de locale",
+		"+ label ai-curious",
+		"~ label values: [ai-blocklist-subscriber] → [ai-blocklist-subscriber ai-curious]",
+	}
+	if diff := published.Diff(def); !reflect.DeepEqual(diff, want) {
+		t.Errorf("got diff %q, want %q", diff, want)
+	}
+
+	def.Labels = def.Labels[1:]
+	diff = published.Diff(def)
+	if len(diff) < 2 || diff[1] != "- label ai-blocklist-subscriber" {
+		t.Errorf("got diff %q, want the label removed", diff)
+	}
+}
+
+func TestNewRecord(t *testing.T) {
+	def, err := LoadDefinition(writeDefinition(t, definition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := Current{}.Declare(def, time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC))
+	if record.LexiconTypeID != Collection || record.CreatedAt != "2025-06-01T00:00:00Z" {
+		t.Errorf("got %+v", record)
+	}
+	definitions := record.Policies.LabelValueDefinitions
+	if len(definitions) != 1 || *definitions[0].DefaultSetting != "warn" || definitions[0].AdultOnly != nil || definitions[0].Locales[1].Lang != "de" {
+		t.Errorf("got definitions %+v", definitions)
+	}
+}
@@ -0,0 +1,249 @@
+// Package store is the labeler's label log in Postgres. Every label the
+// labeler emits is appended with a sequence number, and the log is what
+// queryLabels and subscribeLabels serve.
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/label"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrations are applied in order; append, never edit
+var migrations = []string{
+	`CREATE TABLE labels (
+		seq BIGSERIAL PRIMARY KEY,
+		src TEXT NOT NULL,
+		uri TEXT NOT NULL,
+		cid TEXT,
+		val TEXT NOT NULL,
+		neg BOOLEAN NOT NULL DEFAULT FALSE,
+		cts TEXT NOT NULL,
+		exp TEXT,
+		sig BYTEA NOT NULL
+	);
+	CREATE INDEX labels_latest ON labels (src, uri, val, seq DESC);
+	CREATE INDEX labels_uri ON labels (uri text_pattern_ops);`,
+}
+
+// Entry is a label in the log and its sequence number
+type Entry struct {
+	Seq   int64
+	Label label.Label
+}
+
+// Query selects labels for queryLabels: the latest label for each source,
+// subject and value whose subject matches one of URIPatterns (a trailing *
+// matches any suffix) and, if Sources is set, whose src is one of them. Only
+// labels after Cursor are returned, oldest first, at most Limit of them.
+type Query struct {
+	URIPatterns []string
+	Sources     []string
+	Cursor      int64
+	Limit       int
+}
+
+// Postgres is the label log in a Postgres database
+type Postgres struct {
+	pool *pgxpool.Pool
+}
+
+// Open connects to the Postgres database at url, or $LABELER_DB if url is
+// empty, and brings its schema up to date
+func Open(ctx context.Context, url string) (*Postgres, error) {
+	if url == "" {
+		url = os.Getenv("LABELER_DB")
+	}
+	if url == "" {
+		return nil, fmt.Errorf("no database: set LABELER_DB or pass --db")
+	}
+
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+
+	s := &Postgres{pool: pool}
+	if err := s.migrate(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to migrate postgres: %w", err)
+	}
+
+	return s, nil
+}
+
+func (s *Postgres) migrate(ctx context.Context) error {
+	if _, err := s.pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		// Serialise concurrent migrations from several processes starting at once
+		if _, err := tx.Exec(ctx, `LOCK TABLE schema_version IN EXCLUSIVE MODE`); err != nil {
+			return err
+		}
+
+		var version int
+		if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+			return err
+		}
+
+		for ; version < len(migrations); version++ {
+			if _, err := tx.Exec(ctx, migrations[version]); err != nil {
+				return fmt.Errorf("migration %d: %w", version+1, err)
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO schema_version (version) VALUES ($1)`, version+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes the connection pool
+func (s *Postgres) Close() {
+	s.pool.Close()
+}
+
+// Append adds labels to the log in order and returns them with their
+// sequence numbers. Appends are serialised, so a reader never sees a label
+// before one with a lower sequence number that is still being written.
+func (s *Postgres) Append(ctx context.Context, labels []label.Label) ([]Entry, error) {
+	entries := make([]Entry, 0, len(labels))
+
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `LOCK TABLE labels IN EXCLUSIVE MODE`); err != nil {
+			return err
+		}
+		for _, l := range labels {
+			var seq int64
+			if err := tx.QueryRow(ctx, `
+				INSERT INTO labels (src, uri, cid, val, neg, cts, exp, sig) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+				RETURNING seq`,
+				l.SourceDID, l.URI, l.CID, l.Val, l.Negated != nil && *l.Negated, l.CreatedAt, l.ExpiresAt, []byte(l.Sig)).Scan(&seq); err != nil {
+				return fmt.Errorf("failed to append label %s on %s: %w", l.Val, l.URI, err)
+			}
+			entries = append(entries, Entry{Seq: seq, Label: l})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// LatestSeq returns the sequence number of the newest label, or 0 for an empty log
+func (s *Postgres) LatestSeq(ctx context.Context) (int64, error) {
+	var seq int64
+	if err := s.pool.QueryRow(ctx, `SELECT COALESCE(MAX(seq), 0) FROM labels`).Scan(&seq); err != nil {
+		return 0, fmt.Errorf("failed to read the latest label: %w", err)
+	}
+	return seq, nil
+}
+
+// labelColumns are the columns scanEntries reads
+const labelColumns = `seq, src, uri, cid, val, neg, cts, exp, sig`
+
+// Since returns up to limit labels after cursor, oldest first
+func (s *Postgres) Since(ctx context.Context, cursor int64, limit int) ([]Entry, error) {
+	return s.query(ctx, `SELECT `+labelColumns+` FROM labels WHERE seq > $1 ORDER BY seq LIMIT $2`, cursor, limit)
+}
+
+// Current returns the latest label of val from src on each subject, by subject
+func (s *Postgres) Current(ctx context.Context, src, val string) (map[string]Entry, error) {
+	entries, err := s.query(ctx, `
+		SELECT DISTINCT ON (uri) `+labelColumns+` FROM labels
+		WHERE src = $1 AND val = $2
+		ORDER BY uri, seq DESC`, src, val)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]Entry, len(entries))
+	for _, entry := range entries {
+		current[entry.Label.URI] = entry
+	}
+	return current, nil
+}
+
+// Values returns every label value src has ever emitted
+func (s *Postgres) Values(ctx context.Context, src string) ([]string, error) {
+	rows, err := s.pool.Query(ctx, `SELECT DISTINCT val FROM labels WHERE src = $1 ORDER BY val`, src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read label values: %w", err)
+	}
+	values, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read label values: %w", err)
+	}
+	return values, nil
+}
+
+// Query returns the labels q selects
+func (s *Postgres) Query(ctx context.Context, q Query) ([]Entry, error) {
+	patterns := make([]string, 0, len(q.URIPatterns))
+	for _, pattern := range q.URIPatterns {
+		patterns = append(patterns, likePattern(pattern))
+	}
+	var sources []string
+	if len(q.Sources) > 0 {
+		sources = q.Sources
+	}
+
+	return s.query(ctx, `
+		SELECT `+labelColumns+` FROM (
+			SELECT DISTINCT ON (src, uri, val) * FROM labels
+			WHERE uri LIKE ANY($1) AND ($2::TEXT[] IS NULL OR src = ANY($2))
+			ORDER BY src, uri, val, seq DESC
+		) latest
+		WHERE seq > $3 ORDER BY seq LIMIT $4`, patterns, sources, q.Cursor, q.Limit)
+}
+
+func (s *Postgres) query(ctx context.Context, query string, args ...any) ([]Entry, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read labels: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var entry Entry
+		var neg bool
+		var sig []byte
+		l := &entry.Label
+		if err := rows.Scan(&entry.Seq, &l.SourceDID, &l.URI, &l.CID, &l.Val, &neg, &l.CreatedAt, &l.ExpiresAt, &sig); err != nil {
+			return nil, fmt.Errorf("failed to read labels: %w", err)
+		}
+		if neg {
+			l.Negated = &neg
+		}
+		l.Sig = data.Bytes(sig)
+		l.Version = label.ATPROTO_LABEL_VERSION
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read labels: %w", err)
+	}
+
+	return entries, nil
+}
+
+// likePattern turns a queryLabels URI pattern into a LIKE pattern: a
+// trailing * matches anything, and everything else matches itself
+func likePattern(pattern string) string {
+	prefix, wildcard := strings.CutSuffix(pattern, "*")
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+	if wildcard {
+		return escaped + "%"
+	}
+	return escaped
+}
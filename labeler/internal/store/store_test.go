@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/label"
+)
+
+func TestLikePattern(t *testing.T) {
+	for pattern, want := range map[string]string{
+		"did:plc:abc":                    "did:plc:abc",
+		"at://did:plc:abc/*":             "at://did:plc:abc/%",
+		"*":                              "%",
+		`at://did:web:a_b.example/50%\*`: `at://did:web:a\_b.example/50\%\\%`,
+	} {
+		if got := likePattern(pattern); got != want {
+			t.Errorf("likePattern(%q) = %q, want %q", pattern, got, want)
+		}
+	}
+}
+
+// openTestLog returns an empty label log in the scratch database named by
+// LABELER_TEST_POSTGRES, skipping the test without one
+func openTestLog(t *testing.T) *Postgres {
+	url := os.Getenv("LABELER_TEST_POSTGRES")
+	if url == "" {
+		t.Skip("LABELER_TEST_POSTGRES not set")
+	}
+
+	ctx := context.Background()
+	s, err := Open(ctx, url)
+	must(t, err)
+	if _, err := s.pool.Exec(ctx, "TRUNCATE labels RESTART IDENTITY"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newLabel(src, uri, val string) label.Label {
+	return label.Label{SourceDID: src, URI: uri, Val: val, CreatedAt: "2025-03-01T12:00:00.000Z", Version: label.ATPROTO_LABEL_VERSION, Sig: []byte("sig")}
+}
+
+func TestLabels(t *testing.T) {
+	ctx := context.Background()
+	s := openTestLog(t)
+
+	neg, exp := true, "2025-04-01T12:00:00.000Z"
+	negated := newLabel("did:plc:us", "did:plc:two", "ai")
+	negated.Negated = &neg
+	expiring := newLabel("did:plc:us", "did:plc:one", "ai")
+	expiring.ExpiresAt = &exp
+
+	entries, err := s.Append(ctx, []label.Label{
+		expiring,
+		newLabel("did:plc:us", "did:plc:two", "ai"),
+		newLabel("did:plc:them", "did:plc:one", "ai"),
+		negated,
+	})
+	must(t, err)
+	if len(entries) != 4 || entries[0].Seq != 1 || entries[3].Seq != 4 {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+
+	latest, err := s.LatestSeq(ctx)
+	must(t, err)
+	if latest != 4 {
+		t.Errorf("got latest seq %d", latest)
+	}
+
+	since, err := s.Since(ctx, 2, 10)
+	must(t, err)
+	if len(since) != 2 || since[0].Seq != 3 || string(since[0].Label.Sig) != "sig" {
+		t.Errorf("unexpected labels since 2: %+v", since)
+	}
+
+	// The negation replaces the label it negates
+	current, err := s.Current(ctx, "did:plc:us", "ai")
+	must(t, err)
+	if len(current) != 2 || current["did:plc:two"].Seq != 4 || current["did:plc:two"].Label.Negated == nil {
+		t.Errorf("unexpected current labels %+v", current)
+	}
+	if one := current["did:plc:one"].Label; one.ExpiresAt == nil || *one.ExpiresAt != exp || one.Negated != nil {
+		t.Errorf("unexpected expiring label %+v", one)
+	}
+
+	values, err := s.Values(ctx, "did:plc:us")
+	must(t, err)
+	if !reflect.DeepEqual(values, []string{"ai"}) {
+		t.Errorf("got values %v", values)
+	}
+
+	found, err := s.Query(ctx, Query{URIPatterns: []string{"did:plc:*"}, Sources: []string{"did:plc:us"}, Limit: 10})
+	must(t, err)
+	if len(found) != 2 || found[0].Seq != 1 || found[1].Seq != 4 {
+		t.Errorf("unexpected query result %+v", found)
+	}
+	found, err = s.Query(ctx, Query{URIPatterns: []string{"did:plc:one"}, Cursor: 1, Limit: 10})
+	must(t, err)
+	if len(found) != 1 || found[0].Label.SourceDID != "did:plc:them" {
+		t.Errorf("unexpected query result %+v", found)
+	}
+}
+
+func TestConcurrentAppends(t *testing.T) {
+	ctx := context.Background()
+	s := openTestLog(t)
+
+	// Concurrent appends are serialised, so their labels are numbered in the
+	// order they commit and none is skipped
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := s.Append(ctx, []label.Label{newLabel("did:plc:us", "did:plc:one", "ai")}); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, err := s.Since(ctx, 0, 1000)
+	must(t, err)
+	for i, entry := range entries {
+		if entry.Seq != int64(i+1) {
+			t.Fatalf("entry %d has seq %d", i, entry.Seq)
+		}
+	}
+	if len(entries) != 80 {
+		t.Errorf("got %d entries", len(entries))
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
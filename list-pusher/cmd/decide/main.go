@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"list-pusher/internal/blocklist"
+	"list-pusher/internal/policy"
+	"list-pusher/internal/store"
+)
+
+func run(ctx context.Context, databaseURL, policyPath, out string) error {
+	config, err := policy.LoadConfig(policyPath)
+	if err != nil {
+		return err
+	}
+
+	st, err := store.Open(ctx, databaseURL)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	// Only checking for deactivated accounts needs a login
+	var accounts policy.AccountChecker
+	if config.ExcludeDeactivated {
+		manager := blocklist.NewBlueskyBlocklistManager(blocklist.Options{})
+		if err := manager.LoadLoginConfig(); err != nil {
+			return fmt.Errorf("failed to load configuration: %w", err)
+		}
+		if err := manager.Authenticate(); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+		accounts = policy.ProfileChecker{Client: manager.Session()}
+	}
+
+	decisions, err := policy.NewEngine(config, st, accounts).DecideAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to apply the listing policy: %w", err)
+	}
+
+	counts := policy.Counts(decisions)
+	fmt.Printf("Decided %d DIDs: %d included\n", len(decisions), len(policy.Included(decisions)))
+	for _, rule := range []string{
+		policy.RuleListed, policy.RuleIncludeOverride, policy.RuleExcludeOverride,
+		policy.RuleNoSubscriptions, policy.RuleMinLists, policy.RuleMinScore, policy.RuleDeactivated,
+	} {
+		if counts[rule] > 0 {
+			fmt.Printf("  %-18s %d\n", rule, counts[rule])
+		}
+	}
+
+	return policy.WriteDecisions(decisions, out)
+}
+
+func main() {
+	policyPath := flag.String("policy", "policy.toml", "listing policy to apply")
+	out := flag.String("out", "policy-decisions.jsonl", "where to write the decision on every DID")
+	databaseURL := flag.String("db", "", "state store: a SQLite path or postgres:// URL (default $LIST_PUSHER_DB, then list-pusher.db)")
+	flag.Parse()
+
+	if err := run(context.Background(), *databaseURL, *policyPath, *out); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
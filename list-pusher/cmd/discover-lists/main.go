@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"list-pusher/internal/blocklist"
+	"list-pusher/internal/constellation"
+	"list-pusher/internal/discovery"
+	"list-pusher/internal/identifier"
+)
+
+// owners returns who to search: the owners of every tracked source list,
+// then the configured accounts resolved to DIDs
+func owners(ctx context.Context, manager *blocklist.BlueskyBlocklistManager, config *discovery.Config) ([]string, map[string]bool, error) {
+	lists, err := manager.Store().SourceLists(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var dids []string
+	seen := make(map[string]bool)
+	known := make(map[string]bool)
+	for _, list := range lists {
+		known[list.URI] = true
+		if list.Owner != "" && !seen[list.Owner] {
+			seen[list.Owner] = true
+			dids = append(dids, list.Owner)
+		}
+	}
+	sort.Strings(dids)
+
+	resolve := identifier.XRPCResolver(manager.Session())
+	for _, account := range config.Accounts {
+		did, err := identifier.NormalizeAccount(ctx, resolve, account)
+		if err != nil {
+			fmt.Printf("✗ Failed to resolve %s: %v (skipping)\n", account, err)
+			continue
+		}
+		if !seen[did] {
+			seen[did] = true
+			dids = append(dids, did)
+		}
+	}
+
+	return dids, known, nil
+}
+
+// writeQueue writes the candidates to path as JSON, or to stdout for "-"
+func writeQueue(path string, candidates []discovery.Candidate) error {
+	if candidates == nil {
+		candidates = []discovery.Candidate{}
+	}
+	data, err := json.MarshalIndent(candidates, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode review queue: %w", err)
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write review queue %s: %w", path, err)
+	}
+	return nil
+}
+
+func run(configPath, out, constellationURL string, counts bool, opts blocklist.Options) error {
+	ctx := context.Background()
+
+	config, err := discovery.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	manager := blocklist.NewBlueskyBlocklistManager(opts)
+	if err := manager.LoadLoginConfig(); err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if err := manager.OpenStore(); err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	fmt.Println("Connecting to Bluesky...")
+	if err := manager.Authenticate(); err != nil {
+		return fmt.Errorf("authentication failed: %w", err)
+	}
+	fmt.Println("✓ Successfully authenticated")
+
+	dids, known, err := owners(ctx, manager, config)
+	if err != nil {
+		return err
+	}
+	if len(dids) == 0 {
+		return fmt.Errorf("no accounts to search; add source lists or list accounts in %s", configPath)
+	}
+
+	var counter discovery.SubscriberCounter
+	if counts {
+		counter = constellation.NewClient(constellationURL)
+	}
+	candidates, err := discovery.NewFinder(config, manager.Session(), counter).Discover(ctx, dids, known)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nFound %d candidate lists\n", len(candidates))
+	for _, c := range candidates {
+		items, subscribers := "?", "?"
+		if c.ListItems != nil {
+			items = fmt.Sprint(*c.ListItems)
+		}
+		if c.Subscribers != nil {
+			subscribers = fmt.Sprint(*c.Subscribers)
+		}
+		fmt.Printf("  %3d  %q by @%s, %s accounts, %s subscribers\n       %s\n", c.Score, c.Name, c.OwnerHandle, items, subscribers, c.URI)
+	}
+
+	if err := writeQueue(out, candidates); err != nil {
+		return err
+	}
+	if out != "-" {
+		fmt.Printf("\n✓ Review queue written to %s; track lists with source-lists add\n", out)
+	}
+	return nil
+}
+
+func main() {
+	var opts blocklist.Options
+	configPath := flag.String("config", "discovery.toml", "keyword patterns and extra accounts to search")
+	out := flag.String("out", "discovery-queue.json", "where to write the review queue (- for stdout)")
+	constellationURL := flag.String("constellation", constellation.DefaultBaseURL, "Constellation instance to count subscribers with")
+	counts := flag.Bool("counts", true, "count each candidate's subscribers with Constellation")
+	flag.StringVar(&opts.DatabaseURL, "db", "", "state store: a SQLite path or postgres:// URL (default $LIST_PUSHER_DB, then list-pusher.db)")
+	flag.Parse()
+
+	if err := run(*configPath, *out, *constellationURL, *counts, opts); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"list-pusher/internal/clearsky"
+	"list-pusher/internal/merge"
+	"list-pusher/internal/sources"
+	"list-pusher/internal/store"
+)
+
+// listsToImport returns the tracked lists named in args, or every enabled source list
+func listsToImport(ctx context.Context, st store.Store, args []string) ([]string, error) {
+	if len(args) == 0 {
+		enabled, err := store.EnabledSourceLists(ctx, st)
+		if err != nil {
+			return nil, err
+		}
+		var uris []string
+		for _, list := range enabled {
+			uris = append(uris, list.URI)
+		}
+		return uris, nil
+	}
+
+	registry := sources.NewRegistry(st, nil)
+	var uris []string
+	for _, raw := range args {
+		list, err := registry.Lookup(ctx, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a tracked source list; add it with source-lists add first: %w", raw, err)
+		}
+		uris = append(uris, list.URI)
+	}
+	return uris, nil
+}
+
+func run(ctx context.Context, databaseURL, baseURL string, fresh bool, args []string) error {
+	st, err := store.Open(ctx, databaseURL)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	lists, err := listsToImport(ctx, st, args)
+	if err != nil {
+		return err
+	}
+	if len(lists) == 0 {
+		return fmt.Errorf("no enabled source lists; add some with source-lists add")
+	}
+	fmt.Printf("Importing subscribers of %d source lists from %s\n", len(lists), baseURL)
+
+	return merge.NewClearskyImporter(clearsky.NewClient(baseURL), st).Run(ctx, lists, fresh)
+}
+
+func main() {
+	baseURL := flag.String("clearsky", clearsky.DefaultBaseURL, "Clearsky subscribe-blocks endpoint; <owner>/<rkey>/<page> is appended")
+	fresh := flag.Bool("fresh", false, "start a new import even if the last one is unfinished")
+	databaseURL := flag.String("db", "", "state store: a SQLite path or postgres:// URL (default $LIST_PUSHER_DB, then list-pusher.db)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: import-clearsky [flags] [<list>...]\n\nImports the subscribers of the given source lists, or every enabled one.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, *databaseURL, *baseURL, *fresh, flag.Args()); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"list-pusher/internal/haters"
+	"list-pusher/internal/identifier"
+	"list-pusher/internal/merge"
+	"list-pusher/internal/store"
+)
+
+// importer loads processed_haters.json and an old allowlist.json into the state store
+type importer struct {
+	st store.Store
+}
+
+// maxProblems is how many problems with the file are listed individually
+const maxProblems = 20
+
+// load reads and validates processed_haters.json, reporting its problems
+func (i *importer) load(ctx context.Context, path string) (*haters.File, error) {
+	lists, err := i.st.SourceLists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(lists))
+	for _, list := range lists {
+		known[list.URI] = true
+	}
+
+	file, problems, err := haters.Load(ctx, path, haters.LoadOptions{Resolve: identifier.DirectoryResolver(), Known: known})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Loaded %d DIDs subscribed to %d lists from %s (format version %d)\n", len(file.Haters), len(file.ListURIs()), path, file.Version)
+
+	if len(problems) > 0 {
+		fmt.Printf("\n⚠️  %d problems:\n", len(problems))
+		for n, problem := range problems {
+			if n == maxProblems {
+				fmt.Printf("  … and %d more\n", len(problems)-maxProblems)
+				break
+			}
+			fmt.Printf("  • %s\n", problem)
+		}
+		fmt.Println()
+	}
+
+	return file, nil
+}
+
+// importHaters records a subscription for every DID and list in file, last
+// seen at seen. Lists we don't track are added as source lists if addLists
+// is set and skipped otherwise. With complete, each list in file is its whole
+// subscriber set as of seen, so subscriptions missing from it are ended.
+func (i *importer) importHaters(ctx context.Context, file *haters.File, seen time.Time, addLists, complete bool) error {
+	lists, err := i.st.SourceLists(ctx)
+	if err != nil {
+		return err
+	}
+	tracked := make(map[string]bool, len(lists))
+	for _, list := range lists {
+		tracked[list.URI] = true
+	}
+
+	var added int
+	for _, uri := range file.ListURIs() {
+		if tracked[uri] || !addLists {
+			continue
+		}
+		if err := i.st.AddSourceList(ctx, store.SourceList{URI: uri}); err != nil {
+			return err
+		}
+		tracked[uri] = true
+		added++
+	}
+	if added > 0 {
+		fmt.Printf("✓ Added %d new source lists\n", added)
+	}
+
+	dids := file.DIDs()
+	var recorded, skipped int
+	for n, did := range dids {
+		for _, entry := range file.Haters[did] {
+			if !tracked[entry.ListURI] {
+				skipped++
+				continue
+			}
+
+			sub := store.Subscription{DID: did, ListURI: entry.ListURI, LastSeen: seen}
+			if entry.DateAdded != nil {
+				sub.FirstSeen = *entry.DateAdded
+			}
+			if err := i.st.RecordSubscription(ctx, store.SourceClearsky, sub); err != nil {
+				return err
+			}
+			recorded++
+		}
+
+		if (n+1)%10000 == 0 {
+			fmt.Printf("  %d/%d DIDs imported\n", n+1, len(dids))
+		}
+	}
+
+	fmt.Printf("✓ Recorded %d subscriptions\n", recorded)
+	if skipped > 0 {
+		fmt.Printf("✗ Skipped %d subscriptions to lists we don't track\n", skipped)
+	}
+	if !complete {
+		return nil
+	}
+
+	snapshots := make(map[string]*merge.Snapshot)
+	batch := &merge.Batch{}
+	for _, uri := range file.ListURIs() {
+		snapshots[uri] = &merge.Snapshot{Source: store.SourceClearsky, ListURI: uri, At: seen, DIDs: make(map[string]bool)}
+	}
+	for did, entries := range file.Haters {
+		for _, entry := range entries {
+			snapshots[entry.ListURI].DIDs[did] = true
+		}
+	}
+	for _, uri := range file.ListURIs() {
+		batch.Snapshots = append(batch.Snapshots, *snapshots[uri])
+	}
+	result, err := merge.Apply(ctx, i.st, batch)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Ended %d subscriptions missing from the dump\n", result.Departed)
+	return nil
+}
+
+// importAllowlist turns the entries of an allowlist.json from before the
+// state store into exclude overrides. A missing file is not an error.
+func (i *importer) importAllowlist(ctx context.Context, path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read allowlist %s: %w", path, err)
+	}
+
+	var entries map[string]struct {
+		Reason string    `json:"reason"`
+		Added  time.Time `json:"added"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to parse allowlist %s: %w", path, err)
+	}
+
+	for did, entry := range entries {
+		override := store.Override{DID: did, Action: store.OverrideExclude, Reason: entry.Reason, CreatedAt: entry.Added}
+		if err := i.st.SetOverride(ctx, override); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("✓ Imported %d allowlisted DIDs from %s as exclude overrides\n", len(entries), path)
+	return nil
+}
+
+func main() {
+	hatersPath := flag.String("haters", "processed_haters.json", "processed clearsky dump to import")
+	allowlistPath := flag.String("allowlist", "allowlist.json", "allowlist from older versions to import as exclude overrides, if present")
+	seenFlag := flag.String("seen", "", "when the dump was taken, as RFC 3339; required unless --check")
+	addLists := flag.Bool("add-lists", true, "track lists in the dump that aren't source lists yet, rather than skipping them")
+	complete := flag.Bool("complete", false, "treat each list in the dump as its complete subscriber set: subscribers missing from it had left")
+	check := flag.Bool("check", false, "only validate the dump and report its problems")
+	upgrade := flag.String("upgrade", "", "also write the validated dump to this file in the current format")
+	databaseURL := flag.String("db", "", "state store: a SQLite path or postgres:// URL (default $LIST_PUSHER_DB, then list-pusher.db)")
+	flag.Parse()
+
+	var seen time.Time
+	if !*check {
+		if *seenFlag == "" {
+			log.Fatalf("Error: --seen is required, to say when the dump was taken")
+		}
+		at, err := time.Parse(time.RFC3339, *seenFlag)
+		if err != nil {
+			log.Fatalf("Error: invalid --seen: %v", err)
+		}
+		// The store keeps microseconds, so subscriptions read back as seen at exactly this
+		seen = at.UTC().Truncate(time.Microsecond)
+	}
+
+	ctx := context.Background()
+	st, err := store.Open(ctx, *databaseURL)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	defer st.Close()
+
+	i := &importer{st: st}
+	file, err := i.load(ctx, *hatersPath)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if *upgrade != "" {
+		if err := file.Save(*upgrade); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Printf("✓ Wrote %s in format version %d\n", *upgrade, haters.Version)
+	}
+	if *check {
+		return
+	}
+	if err := checkSeen(*hatersPath, file, seen); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if err := i.importAllowlist(ctx, *allowlistPath); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := i.importHaters(ctx, file, seen, *addLists, *complete); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+// checkSeen refuses a --seen that the dump contradicts: one before a
+// subscription it records began, or after the file was last written
+func checkSeen(path string, file *haters.File, seen time.Time) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+	if seen.After(info.ModTime()) {
+		return fmt.Errorf("--seen %s is after %s was last written, at %s", seen.Format(time.RFC3339), path, info.ModTime().UTC().Format(time.RFC3339))
+	}
+
+	for _, did := range file.DIDs() {
+		for _, entry := range file.Haters[did] {
+			if entry.DateAdded != nil && entry.DateAdded.After(seen) {
+				return fmt.Errorf("--seen %s is before %s subscribed to %s, at %s according to %s",
+					seen.Format(time.RFC3339), did, entry.ListURI, entry.DateAdded.Format(time.RFC3339), path)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"list-pusher/internal/haters"
+)
+
+func TestCheckSeen(t *testing.T) {
+	written := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "processed_haters.json")
+	if err := os.WriteFile(path, []byte("{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, written, written); err != nil {
+		t.Fatal(err)
+	}
+
+	const list = "at://did:plc:owner/app.bsky.graph.list/3lbxfscjqno2d"
+	older, newest := written.Add(-48*time.Hour), written.Add(-24*time.Hour)
+	file := &haters.File{Version: haters.Version, Haters: map[string][]haters.Subscription{
+		"did:plc:a": {{ListURI: list, DateAdded: &older}},
+		"did:plc:b": {{ListURI: list, DateAdded: &newest}},
+		"did:plc:c": {{ListURI: list}},
+	}}
+
+	tests := []struct {
+		name    string
+		seen    time.Time
+		wantErr bool
+	}{
+		{name: "when written", seen: written},
+		{name: "between the newest subscription and the write", seen: written.Add(-time.Hour)},
+		{name: "at the newest subscription", seen: newest},
+		{name: "after the file was written", seen: written.Add(time.Minute), wantErr: true},
+		{name: "before a subscription in the dump began", seen: written.Add(-36 * time.Hour), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkSeen(path, file, tt.seen); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
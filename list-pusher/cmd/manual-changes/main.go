@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"list-pusher/internal/blocklist"
+	"list-pusher/internal/identifier"
+	"list-pusher/internal/listsync"
+	"list-pusher/internal/prompt"
+	"list-pusher/internal/store"
+)
+
+// TOMLConfig represents the structure of the TOML file
+type TOMLConfig struct {
+	Removes struct {
+		Identifiers []string `toml:"identifiers"`
+	} `toml:"Removes"`
+	Adds struct {
+		Identifiers []string `toml:"identifiers"`
+	} `toml:"Adds"`
+}
+
+// BlueskyListEditor applies the hand-curated changes in manual-changes.toml
+type BlueskyListEditor struct {
+	manager *blocklist.BlueskyBlocklistManager
+
+	// yes skips the confirmation prompt; dryRun writes the plan to planOut instead of applying it
+	yes     bool
+	dryRun  bool
+	planOut string
+}
+
+// NewBlueskyListEditor creates a new editor instance. offline plans a dry
+// run from the state store without logging in.
+func NewBlueskyListEditor(databaseURL string, dryRun, offline bool) *BlueskyListEditor {
+	return &BlueskyListEditor{
+		manager: blocklist.NewBlueskyBlocklistManager(blocklist.Options{DatabaseURL: databaseURL, DryRun: dryRun, Offline: offline}),
+		dryRun:  dryRun,
+	}
+}
+
+// recordOverrides saves the changes as manual overrides in the state store,
+// so publish-list never re-adds removed DIDs and never syncs away added ones
+func (e *BlueskyListEditor) recordOverrides(adds, removes []string, addSources, removeSources map[string]string) error {
+	ctx := context.Background()
+	st := e.manager.Store()
+
+	for _, did := range removes {
+		override := store.Override{DID: did, Action: store.OverrideExclude, Reason: fmt.Sprintf("removed via manual-changes.toml (%s)", removeSources[did])}
+		if err := st.SetOverride(ctx, override); err != nil {
+			return err
+		}
+	}
+	for _, did := range adds {
+		override := store.Override{DID: did, Action: store.OverrideInclude, Reason: fmt.Sprintf("added via manual-changes.toml (%s)", addSources[did])}
+		if err := st.SetOverride(ctx, override); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("✓ Recorded overrides: %d excluded, %d included\n", len(removes), len(adds))
+	return nil
+}
+
+// succeeded returns the DIDs that aren't in failed
+func succeeded(dids []string, failed map[string]bool) []string {
+	var result []string
+	for _, did := range dids {
+		if !failed[did] {
+			result = append(result, did)
+		}
+	}
+	return result
+}
+
+// readChangesFromTOML reads the Adds and Removes sections from the TOML file
+func (e *BlueskyListEditor) readChangesFromTOML(filename string) (adds, removes []string, err error) {
+	var config TOMLConfig
+
+	if _, err := toml.DecodeFile(filename, &config); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse TOML file %s: %w", filename, err)
+	}
+
+	if len(config.Adds.Identifiers) == 0 && len(config.Removes.Identifiers) == 0 {
+		return nil, nil, fmt.Errorf("no identifiers found in [Adds] or [Removes] sections")
+	}
+
+	return config.Adds.Identifiers, config.Removes.Identifiers, nil
+}
+
+// resolveToDID resolves a handle, DID or bsky.app profile URL to a DID.
+// Offline only DIDs work.
+func (e *BlueskyListEditor) resolveToDID(account string) (string, error) {
+	resolve := identifier.OfflineResolver()
+	if !e.manager.Offline() {
+		resolve = identifier.XRPCResolver(e.manager.Session())
+	}
+	return identifier.NormalizeAccount(context.Background(), resolve, account)
+}
+
+// resolveAll resolves each identifier to a DID, skipping any that fail. It
+// returns the DIDs and which identifier each came from.
+func (e *BlueskyListEditor) resolveAll(section string, identifiers []string) ([]string, map[string]string) {
+	var dids []string
+	sources := make(map[string]string)
+
+	for i, identifier := range identifiers {
+		fmt.Printf("Resolving [%s] %d/%d: %s\n", section, i+1, len(identifiers), identifier)
+		did, err := e.resolveToDID(identifier)
+		if err != nil {
+			fmt.Printf("✗ Failed to resolve %s: %v (skipping)\n", identifier, err)
+			continue
+		}
+		if _, seen := sources[did]; !seen {
+			dids = append(dids, did)
+		}
+		sources[did] = identifier
+		fmt.Printf("  → %s\n", did)
+		if !e.manager.Offline() {
+			time.Sleep(100 * time.Millisecond) // Rate limit friendly
+		}
+	}
+
+	return dids, sources
+}
+
+// Conflict is an account listed under both [Adds] and [Removes]
+type Conflict struct {
+	DID       string
+	AddedAs   string
+	RemovedAs string
+}
+
+// splitConflicts removes DIDs that appear in both sections from each, since
+// there is no way to tell which change was meant
+func splitConflicts(adds, removes []string, addSources, removeSources map[string]string) ([]string, []string, []Conflict) {
+	var conflicts []Conflict
+	conflicting := make(map[string]bool)
+
+	for _, did := range adds {
+		if removedAs, ok := removeSources[did]; ok {
+			conflicts = append(conflicts, Conflict{DID: did, AddedAs: addSources[did], RemovedAs: removedAs})
+			conflicting[did] = true
+		}
+	}
+
+	keep := func(dids []string) []string {
+		var result []string
+		for _, did := range dids {
+			if !conflicting[did] {
+				result = append(result, did)
+			}
+		}
+		return result
+	}
+
+	return keep(adds), keep(removes), conflicts
+}
+
+// run executes the main application logic
+func (e *BlueskyListEditor) run() error {
+	fmt.Println("Bluesky Manual List Changes")
+	fmt.Println("=" + strings.Repeat("=", 26))
+
+	// Load config from environment
+	if err := e.manager.LoadConfig(); err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	config := e.manager.Config()
+	if !e.manager.Offline() {
+		fmt.Printf("Using handle: %s\n", config.Handle)
+	}
+	fmt.Printf("Using list: %s\n", config.ListURI)
+
+	if err := e.manager.OpenStore(); err != nil {
+		return err
+	}
+	defer e.manager.Close()
+
+	// Read identifiers from TOML file
+	addIdentifiers, removeIdentifiers, err := e.readChangesFromTOML("manual-changes.toml")
+	if err != nil {
+		return fmt.Errorf("failed to read TOML file: %w", err)
+	}
+
+	fmt.Printf("\nFound %d identifiers to add and %d to remove.\n", len(addIdentifiers), len(removeIdentifiers))
+
+	// Authenticate, unless planning from the store alone
+	if e.manager.Offline() {
+		fmt.Println("\nOffline: planning from the list items recorded in the state store; handles are skipped")
+	} else {
+		fmt.Println("\nConnecting to Bluesky...")
+		if err := e.manager.Authenticate(); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+		fmt.Println("✓ Successfully authenticated")
+	}
+
+	// Resolve handles to DIDs
+	fmt.Println("\nResolving handles to DIDs...")
+	addDIDs, addSources := e.resolveAll("Adds", addIdentifiers)
+	removeDIDs, removeSources := e.resolveAll("Removes", removeIdentifiers)
+
+	resolved := len(addDIDs) + len(removeDIDs)
+	if resolved == 0 {
+		return fmt.Errorf("no handles could be resolved to DIDs")
+	}
+
+	fmt.Printf("\nSuccessfully resolved %d/%d handles\n", resolved, len(addIdentifiers)+len(removeIdentifiers))
+
+	// Anything in both sections is reported and left alone
+	addDIDs, removeDIDs, conflicts := splitConflicts(addDIDs, removeDIDs, addSources, removeSources)
+	if len(conflicts) > 0 {
+		fmt.Printf("\n⚠️  %d accounts are listed under both [Adds] and [Removes] and will be skipped:\n", len(conflicts))
+		for _, conflict := range conflicts {
+			fmt.Printf("  • %s (added as %s, removed as %s)\n", conflict.DID, conflict.AddedAs, conflict.RemovedAs)
+		}
+	}
+
+	// Fetch all list items once to get record keys
+	fmt.Println("\nFetching list items...")
+	listItems, err := e.manager.FetchListItems()
+	if err != nil {
+		return fmt.Errorf("failed to fetch list items: %w", err)
+	}
+
+	fmt.Printf("Found %d total items in list\n", len(listItems))
+
+	// Only add DIDs that are missing, and only remove DIDs that are present
+	plan := listsync.Removals(config.ListURI, removeDIDs, listItems)
+	plan.Add = listsync.Difference(addDIDs, listsync.DIDs(listItems))
+
+	fmt.Printf("DIDs not yet in list to add: %d\n", len(plan.Add))
+	fmt.Printf("DIDs found in list to remove: %d\n", len(plan.Remove))
+
+	if e.dryRun {
+		if err := listsync.WritePlanFile(plan, e.planOut); err != nil {
+			return err
+		}
+		fmt.Println("Dry run: no changes made.")
+		return nil
+	}
+
+	if plan.Empty() {
+		fmt.Println("The list already reflects manual-changes.toml.")
+		return e.recordOverrides(addDIDs, removeDIDs, addSources, removeSources)
+	}
+
+	// Confirm before proceeding
+	question := fmt.Sprintf("\nAdd %d and remove %d users on list %s? (y/N): ", len(plan.Add), len(plan.Remove), config.ListURI)
+	ok, err := prompt.Confirm(question, e.yes)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		fmt.Println("Operation cancelled.")
+		return nil
+	}
+
+	// Apply the changes, noting which accounts' writes failed
+	failedDIDs := make(map[string]bool)
+	added, addFailed, err := e.manager.AddListItems(plan.Add, func(did, _ string, failure error) error {
+		if failure != nil {
+			failedDIDs[did] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	recordKeys := make([]string, 0, len(plan.Remove))
+	removedDIDs := make(map[string]string, len(plan.Remove))
+	for _, item := range plan.Remove {
+		recordKeys = append(recordKeys, item.RecordKey)
+		removedDIDs[item.RecordKey] = item.DID
+	}
+	removed, removeFailed, err := e.manager.RemoveListItems(recordKeys, func(rkey, _ string, failure error) error {
+		if failure != nil {
+			failedDIDs[removedDIDs[rkey]] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Keep removed accounts from coming back on the next publish-list run.
+	// Accounts whose change failed get no override until a rerun makes it.
+	if len(failedDIDs) > 0 {
+		fmt.Printf("\n⚠️  Not recording overrides for %d accounts whose change failed; rerun to retry them\n", len(failedDIDs))
+	}
+	if err := e.recordOverrides(succeeded(addDIDs, failedDIDs), succeeded(removeDIDs, failedDIDs), addSources, removeSources); err != nil {
+		return err
+	}
+
+	// Summary
+	fmt.Println("\nOperation complete!")
+	fmt.Printf("Successfully added: %d\n", added)
+	fmt.Printf("Successfully removed: %d\n", removed)
+	fmt.Printf("Failed: %d\n", addFailed+removeFailed)
+	if len(conflicts) > 0 {
+		fmt.Printf("Skipped as conflicting: %d\n", len(conflicts))
+	}
+
+	if added > 0 {
+		fmt.Printf("\n✓ %d users have been added to your list.\n", added)
+	}
+	if removed > 0 {
+		fmt.Printf("✓ %d users have been removed from your list.\n", removed)
+	}
+
+	return nil
+}
+
+func main() {
+	databaseURL := flag.String("db", "", "state store: a SQLite path or postgres:// URL (default $LIST_PUSHER_DB, then list-pusher.db)")
+	yes := flag.Bool("yes", false, "don't ask for confirmation before changing the list")
+	dryRun := flag.Bool("dry-run", false, "work out the plan and write it as JSON without changing the list")
+	planOut := flag.String("plan-out", "manual-plan.json", "where --dry-run writes the plan (- for stdout, mixed in with progress)")
+	offline := flag.Bool("offline", false, "with --dry-run, plan from the list items recorded in the state store without logging in or using the network (DIDs only, handles are skipped)")
+	flag.Parse()
+
+	editor := NewBlueskyListEditor(*databaseURL, *dryRun, *offline)
+	editor.yes = *yes
+	editor.planOut = *planOut
+
+	if err := editor.run(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitConflicts(t *testing.T) {
+	tests := []struct {
+		name          string
+		adds          []string
+		removes       []string
+		addSources    map[string]string
+		removeSources map[string]string
+		wantAdds      []string
+		wantRemoves   []string
+		wantConflicts []Conflict
+	}{
+		{
+			name:          "no overlap",
+			adds:          []string{"did:plc:a"},
+			removes:       []string{"did:plc:b"},
+			addSources:    map[string]string{"did:plc:a": "a.bsky.social"},
+			removeSources: map[string]string{"did:plc:b": "b.bsky.social"},
+			wantAdds:      []string{"did:plc:a"},
+			wantRemoves:   []string{"did:plc:b"},
+		},
+		{
+			name:          "same account in both sections",
+			adds:          []string{"did:plc:a", "did:plc:both"},
+			removes:       []string{"did:plc:both", "did:plc:b"},
+			addSources:    map[string]string{"did:plc:a": "a.bsky.social", "did:plc:both": "both.bsky.social"},
+			removeSources: map[string]string{"did:plc:both": "did:plc:both", "did:plc:b": "b.bsky.social"},
+			wantAdds:      []string{"did:plc:a"},
+			wantRemoves:   []string{"did:plc:b"},
+			wantConflicts: []Conflict{{DID: "did:plc:both", AddedAs: "both.bsky.social", RemovedAs: "did:plc:both"}},
+		},
+		{
+			name:          "everything conflicts",
+			adds:          []string{"did:plc:x", "did:plc:y"},
+			removes:       []string{"did:plc:y", "did:plc:x"},
+			addSources:    map[string]string{"did:plc:x": "x.bsky.social", "did:plc:y": "y.bsky.social"},
+			removeSources: map[string]string{"did:plc:x": "https://bsky.app/profile/x.bsky.social", "did:plc:y": "y.bsky.social"},
+			wantConflicts: []Conflict{
+				{DID: "did:plc:x", AddedAs: "x.bsky.social", RemovedAs: "https://bsky.app/profile/x.bsky.social"},
+				{DID: "did:plc:y", AddedAs: "y.bsky.social", RemovedAs: "y.bsky.social"},
+			},
+		},
+		{
+			name:          "only removes",
+			removes:       []string{"did:plc:b"},
+			removeSources: map[string]string{"did:plc:b": "b.bsky.social"},
+			wantRemoves:   []string{"did:plc:b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adds, removes, conflicts := splitConflicts(tt.adds, tt.removes, tt.addSources, tt.removeSources)
+			if !reflect.DeepEqual(adds, tt.wantAdds) {
+				t.Errorf("got adds %v, want %v", adds, tt.wantAdds)
+			}
+			if !reflect.DeepEqual(removes, tt.wantRemoves) {
+				t.Errorf("got removes %v, want %v", removes, tt.wantRemoves)
+			}
+			if !reflect.DeepEqual(conflicts, tt.wantConflicts) {
+				t.Errorf("got conflicts %+v, want %+v", conflicts, tt.wantConflicts)
+			}
+		})
+	}
+}
+
+func TestSucceeded(t *testing.T) {
+	got := succeeded([]string{"did:plc:a", "did:plc:b", "did:plc:c"}, map[string]bool{"did:plc:b": true})
+	if want := []string{"did:plc:a", "did:plc:c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"list-pusher/internal/constellation"
+	"list-pusher/internal/identifier"
+	"list-pusher/internal/merge"
+	"list-pusher/internal/store"
+)
+
+// paths is a repeatable path flag
+type paths []string
+
+func (p *paths) String() string { return strings.Join(*p, ",") }
+
+func (p *paths) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// options are the inputs to one merge
+type options struct {
+	clearskyDumps    paths
+	haters           paths
+	jetstream        paths
+	constellation    bool
+	constellationURL string
+	complete         bool
+	reportPath       string
+
+	// asOf is when the Clearsky dumps were taken. File times change with every
+	// copy or restore, so it has to be given.
+	asOf time.Time
+}
+
+// checkAsOf refuses an --as-of that a dump contradicts: one before a
+// subscription the dump records began, or after the file was last written
+func checkAsOf(path string, dump *merge.Batch, at time.Time) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+	if at.After(info.ModTime()) {
+		return fmt.Errorf("--as-of %s is after %s was last written, at %s", at.Format(time.RFC3339), path, info.ModTime().UTC().Format(time.RFC3339))
+	}
+
+	for _, sighting := range dump.Sightings {
+		if sighting.FirstSeen.After(at) {
+			return fmt.Errorf("--as-of %s is before %s subscribed to %s, at %s according to %s",
+				at.Format(time.RFC3339), sighting.DID, sighting.ListURI, sighting.FirstSeen.Format(time.RFC3339), path)
+		}
+	}
+	return nil
+}
+
+// read gathers the evidence from every input
+func read(ctx context.Context, st store.Store, opts options) (*merge.Batch, error) {
+	resolve := identifier.DirectoryResolver()
+	batch := &merge.Batch{}
+
+	dumps := func(kind string, files paths, readFile func(string, time.Time) (*merge.Batch, error)) error {
+		for _, path := range files {
+			at := opts.asOf
+			dump, err := readFile(path, at)
+			if err != nil {
+				return err
+			}
+			if err := checkAsOf(path, dump, at); err != nil {
+				return err
+			}
+			if opts.complete {
+				dump.Complete()
+			}
+			fmt.Printf("✓ Read %d sightings from %s %s (as of %s)\n", len(dump.Sightings), kind, path, at.Format(time.RFC3339))
+			batch.Add(dump)
+		}
+		return nil
+	}
+	if err := dumps("clearsky dump", opts.clearskyDumps, func(path string, at time.Time) (*merge.Batch, error) {
+		return merge.ReadClearskyDump(ctx, path, resolve, at)
+	}); err != nil {
+		return nil, err
+	}
+	if err := dumps("haters file", opts.haters, func(path string, at time.Time) (*merge.Batch, error) {
+		return merge.ReadHaters(ctx, path, resolve, at)
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, path := range opts.jetstream {
+		capture, err := merge.ReadJetstream(path)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("✓ Read %d sightings and %d departures from jetstream capture %s\n", len(capture.Sightings), len(capture.Departures), path)
+		batch.Add(capture)
+	}
+
+	if opts.constellation {
+		enabled, err := store.EnabledSourceLists(ctx, st)
+		if err != nil {
+			return nil, err
+		}
+		var lists []string
+		for _, list := range enabled {
+			lists = append(lists, list.URI)
+		}
+		live, err := merge.FetchConstellation(ctx, constellation.NewClient(opts.constellationURL), lists)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("✓ Read %d sightings of %d lists from constellation\n", len(live.Sightings), len(live.Snapshots))
+		batch.Add(live)
+	}
+
+	return batch, nil
+}
+
+func run(ctx context.Context, databaseURL string, opts options) error {
+	st, err := store.Open(ctx, databaseURL)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	// The first merge has nothing saved to diff against, so it diffs against
+	// what the store held beforehand
+	before, ok, err := merge.LoadState(ctx, st)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if before, err = merge.CurrentState(ctx, st, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	batch, err := read(ctx, st, opts)
+	if err != nil {
+		return err
+	}
+
+	result, err := merge.Apply(ctx, st, batch)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\n✓ Recorded %d sightings and %d departures\n", result.Recorded, result.Departed)
+	if result.Untracked > 0 {
+		fmt.Printf("✗ Skipped %d sightings of lists we don't track\n", result.Untracked)
+	}
+	if result.Unknown > 0 {
+		fmt.Printf("✗ Skipped %d departures from subscriptions we never saw\n", result.Unknown)
+	}
+
+	after, err := merge.CurrentState(ctx, st, time.Now())
+	if err != nil {
+		return err
+	}
+	evidence, err := st.Evidence(ctx)
+	if err != nil {
+		return err
+	}
+	report := merge.Diff(before, after, evidence)
+
+	fmt.Printf("\nSince %s:\n", report.Since.Format(time.RFC3339))
+	fmt.Printf("  %d subscriptions added, %d ended\n", len(report.Added), len(report.Ended))
+	fmt.Printf("  %d new DIDs, %d no longer subscribed to any source list\n", len(report.NewDIDs), len(report.GoneDIDs))
+
+	if err := report.Save(opts.reportPath); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Wrote report to %s\n", opts.reportPath)
+
+	return after.Save(ctx, st)
+}
+
+func main() {
+	var opts options
+	flag.Var(&opts.clearskyDumps, "clearsky-dump", "raw clearsky pages, one per line, as in haters.jsonl (repeatable)")
+	flag.Var(&opts.haters, "haters", "processed clearsky dump, as in processed_haters.json (repeatable)")
+	flag.Var(&opts.jetstream, "jetstream", "captured jetstream events, as in relevant_blocks.jsonl (repeatable)")
+	flag.BoolVar(&opts.constellation, "live", false, "fetch the current subscribers of every enabled source list from Constellation")
+	flag.StringVar(&opts.constellationURL, "constellation", constellation.DefaultBaseURL, "Constellation instance used by --live")
+	flag.BoolVar(&opts.complete, "complete", false, "treat clearsky dumps as complete: subscribers missing from them had left")
+	flag.StringVar(&opts.reportPath, "report", "merge-report.json", "where to write the report of what changed since the last merge")
+	asOf := flag.String("as-of", "", "when the clearsky dumps were taken, as RFC 3339; required with --clearsky-dump and --haters")
+	databaseURL := flag.String("db", "", "state store: a SQLite path or postgres:// URL (default $LIST_PUSHER_DB, then list-pusher.db)")
+	flag.Parse()
+
+	if len(opts.clearskyDumps)+len(opts.haters)+len(opts.jetstream) == 0 && !opts.constellation {
+		log.Fatalf("Error: nothing to merge; give at least one of --clearsky-dump, --haters, --jetstream or --live")
+	}
+	if len(opts.clearskyDumps)+len(opts.haters) > 0 {
+		if *asOf == "" {
+			log.Fatalf("Error: --as-of is required with --clearsky-dump and --haters, to say when the dumps were taken")
+		}
+		at, err := time.Parse(time.RFC3339, *asOf)
+		if err != nil {
+			log.Fatalf("Error: invalid --as-of: %v", err)
+		}
+		opts.asOf = at.UTC()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, *databaseURL, opts); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
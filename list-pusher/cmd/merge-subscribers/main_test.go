@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"list-pusher/internal/merge"
+)
+
+func TestCheckAsOf(t *testing.T) {
+	written := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "haters.jsonl")
+	if err := os.WriteFile(path, []byte("{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, written, written); err != nil {
+		t.Fatal(err)
+	}
+
+	dump := &merge.Batch{Sightings: []merge.Sighting{
+		{DID: "did:plc:a", FirstSeen: written.Add(-48 * time.Hour)},
+		{DID: "did:plc:b", FirstSeen: written.Add(-24 * time.Hour)},
+		{DID: "did:plc:c"},
+	}}
+
+	tests := []struct {
+		name    string
+		asOf    time.Time
+		wantErr bool
+	}{
+		{name: "when written", asOf: written},
+		{name: "between the newest subscription and the write", asOf: written.Add(-time.Hour)},
+		{name: "at the newest subscription", asOf: written.Add(-24 * time.Hour)},
+		{name: "after the file was written", asOf: written.Add(time.Minute), wantErr: true},
+		{name: "before a subscription in the dump began", asOf: written.Add(-36 * time.Hour), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkAsOf(path, dump, tt.asOf); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
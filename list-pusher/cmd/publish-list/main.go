@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"list-pusher/internal/blocklist"
+)
+
+func main() {
+	var opts blocklist.Options
+
+	flag.BoolVar(&opts.Sync, "sync", false, "also remove list items for DIDs no longer wanted by the state store")
+	flag.Float64Var(&opts.MaxRemovalPercent, "max-removal-percent", 10, "in sync mode, refuse to remove more than this percentage of the list")
+	flag.BoolVar(&opts.Force, "force", false, "in sync mode, remove items even beyond --max-removal-percent")
+	flag.BoolVar(&opts.Fresh, "fresh", false, "plan a new push even if the journal holds an unfinished one")
+	flag.BoolVar(&opts.Yes, "yes", false, "don't ask for confirmation before changing the list")
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "work out the plan and write it as JSON without changing the list")
+	flag.StringVar(&opts.PlanOut, "plan-out", "push-plan.json", "where --dry-run writes the plan (- for stdout, mixed in with progress)")
+	flag.StringVar(&opts.ExplainOut, "explain-out", "dry-run-decisions.jsonl", "where --dry-run writes the policy's decision on every DID, with its reasons (empty to skip)")
+	flag.BoolVar(&opts.Offline, "offline", false, "with --dry-run, plan from the list items recorded in the state store without logging in or using the network")
+	flag.StringVar(&opts.PolicyPath, "policy", "policy.toml", "listing policy deciding who belongs on the list")
+	flag.StringVar(&opts.DatabaseURL, "db", "", "state store: a SQLite path or postgres:// URL (default $LIST_PUSHER_DB, then list-pusher.db)")
+	flag.Parse()
+
+	manager := blocklist.NewBlueskyBlocklistManager(opts)
+	if err := manager.Run(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
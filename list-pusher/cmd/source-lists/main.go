@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"list-pusher/internal/blocklist"
+	"list-pusher/internal/identifier"
+	"list-pusher/internal/sources"
+	"list-pusher/internal/store"
+)
+
+const usage = `Usage: source-lists [--db <store>] <command> [arguments]
+
+Commands:
+  list                              show every source list
+  add [--notes <text>] <list>...    track lists, fetching their name and description
+  add --file <path>                 track every list in a file, one per line
+  remove <list>...                  stop tracking lists
+  enable <list>...                  want the lists' subscribers on our list again
+  disable <list>...                 stop wanting the lists' subscribers, keeping the lists
+  notes <list> <text>               replace our notes on a list
+  refresh [<list>...]               re-fetch names and descriptions (default every list)
+  export [--out <path>]             write the enabled lists' AT-URIs, one per line, for
+                                    quick-and-dirty-jetstream-tailer's anti-ai-lists.txt
+
+Lists can be AT-URIs, bsky.app or clearsky URLs.
+`
+
+// app runs one source-lists command against the state store
+type app struct {
+	manager  *blocklist.BlueskyBlocklistManager
+	registry *sources.Registry
+}
+
+// login authenticates so the registry can call getList
+func (a *app) login() error {
+	if err := a.manager.LoadLoginConfig(); err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if err := a.manager.Authenticate(); err != nil {
+		return fmt.Errorf("authentication failed: %w", err)
+	}
+	a.registry = sources.NewRegistry(a.manager.Store(), a.manager.Session())
+	return nil
+}
+
+// describe names a list for progress output
+func describe(list store.SourceList) string {
+	if list.Name == "" {
+		return list.URI
+	}
+	return fmt.Sprintf("%q (%s)", list.Name, list.URI)
+}
+
+func (a *app) list(ctx context.Context) error {
+	st := a.manager.Store()
+	lists, err := st.SourceLists(ctx)
+	if err != nil {
+		return err
+	}
+	subs, err := st.Subscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	active := make(map[string]int)
+	for _, sub := range subs {
+		if sub.Active() {
+			active[sub.ListURI]++
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tSUBSCRIBERS\tNAME\tURI\tADDED\tREFRESHED\tNOTES")
+	for _, list := range lists {
+		status := "enabled"
+		if !list.Enabled {
+			status = "disabled"
+		}
+		refreshed := "never"
+		if list.RefreshedAt != nil {
+			refreshed = list.RefreshedAt.Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", status, active[list.URI], list.Name, list.URI,
+			list.AddedAt.Format("2006-01-02"), refreshed, list.Notes)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%d source lists\n", len(lists))
+	return nil
+}
+
+func (a *app) add(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	notes := fs.String("notes", "", "notes to keep with the lists")
+	file := fs.String("file", "", "file of lists to add, one per line, with # comments")
+	fs.Parse(args)
+
+	lists := fs.Args()
+	if *file != "" {
+		fromFile, err := identifier.ReadListFile(ctx, identifier.DirectoryResolver(), *file)
+		if err != nil {
+			return err
+		}
+		lists = append(lists, fromFile...)
+	}
+	if len(lists) == 0 {
+		return fmt.Errorf("add needs at least one list or --file")
+	}
+
+	if err := a.login(); err != nil {
+		return err
+	}
+
+	var failed int
+	for _, raw := range lists {
+		list, err := a.registry.Add(ctx, raw, *notes)
+		if err != nil {
+			fmt.Printf("✗ %s: %v\n", raw, err)
+			failed++
+			continue
+		}
+		fmt.Printf("✓ Tracking %s\n", describe(list))
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to add %d of %d lists", failed, len(lists))
+	}
+	return nil
+}
+
+func (a *app) remove(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("remove needs at least one list")
+	}
+	for _, raw := range args {
+		list, err := a.registry.Remove(ctx, raw)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✓ No longer tracking %s\n", describe(list))
+	}
+	return nil
+}
+
+func (a *app) setEnabled(ctx context.Context, args []string, enabled bool) error {
+	if len(args) == 0 {
+		return fmt.Errorf("needs at least one list")
+	}
+	for _, raw := range args {
+		list, err := a.registry.SetEnabled(ctx, raw, enabled)
+		if err != nil {
+			return err
+		}
+		if enabled {
+			fmt.Printf("✓ Enabled %s\n", describe(list))
+		} else {
+			fmt.Printf("✓ Disabled %s\n", describe(list))
+		}
+	}
+	return nil
+}
+
+func (a *app) notes(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("notes needs a list and the text")
+	}
+	list, err := a.registry.SetNotes(ctx, args[0], strings.Join(args[1:], " "))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Updated notes on %s\n", describe(list))
+	return nil
+}
+
+func (a *app) refresh(ctx context.Context, args []string) error {
+	var lists []store.SourceList
+	if len(args) == 0 {
+		all, err := a.manager.Store().SourceLists(ctx)
+		if err != nil {
+			return err
+		}
+		lists = all
+	}
+	for _, raw := range args {
+		list, err := a.registry.Lookup(ctx, raw)
+		if err != nil {
+			return err
+		}
+		lists = append(lists, list)
+	}
+
+	if err := a.login(); err != nil {
+		return err
+	}
+
+	var failed int
+	for i, list := range lists {
+		refreshed, err := a.registry.Refresh(ctx, list.URI)
+		if err != nil {
+			fmt.Printf("✗ %d/%d %s: %v\n", i+1, len(lists), list.URI, err)
+			failed++
+			continue
+		}
+		fmt.Printf("✓ %d/%d %s\n", i+1, len(lists), describe(refreshed))
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to refresh %d of %d lists", failed, len(lists))
+	}
+	return nil
+}
+
+// exportHeader starts an exported file, so nobody edits it by hand
+const exportHeader = `# Generated by list-pusher's "source-lists export" from the source list
+# registry. Edit the registry with source-lists instead and export again.
+`
+
+// writeExport writes the AT-URIs of the enabled lists to w, one per line,
+// and returns how many there were
+func writeExport(w io.Writer, lists []store.SourceList) (int, error) {
+	if _, err := io.WriteString(w, exportHeader); err != nil {
+		return 0, err
+	}
+	var written int
+	for _, list := range lists {
+		if !list.Enabled {
+			continue
+		}
+		if _, err := fmt.Fprintln(w, list.URI); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+func (a *app) export(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	out := fs.String("out", "", "file to write (default stdout)")
+	fs.Parse(args)
+
+	lists, err := a.manager.Store().SourceLists(ctx)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err := writeExport(os.Stdout, lists)
+		return err
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	written, err := writeExport(f, lists)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", *out, err)
+	}
+	fmt.Printf("✓ Wrote %d enabled source lists to %s\n", written, *out)
+	return nil
+}
+
+func main() {
+	databaseURL := flag.String("db", "", "state store: a SQLite path or postgres:// URL (default $LIST_PUSHER_DB, then list-pusher.db)")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	a := &app{manager: blocklist.NewBlueskyBlocklistManager(blocklist.Options{DatabaseURL: *databaseURL})}
+	if err := a.manager.OpenStore(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	defer a.manager.Close()
+	a.registry = sources.NewRegistry(a.manager.Store(), nil)
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	var err error
+	switch command {
+	case "list":
+		err = a.list(ctx)
+	case "add":
+		err = a.add(ctx, args)
+	case "remove":
+		err = a.remove(ctx, args)
+	case "enable":
+		err = a.setEnabled(ctx, args, true)
+	case "disable":
+		err = a.setEnabled(ctx, args, false)
+	case "notes":
+		err = a.notes(ctx, args)
+	case "refresh":
+		err = a.refresh(ctx, args)
+	case "export":
+		err = a.export(ctx, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		a.manager.Close()
+		log.Fatalf("Error: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"list-pusher/internal/store"
+)
+
+func TestWriteExport(t *testing.T) {
+	lists := []store.SourceList{
+		{URI: "at://did:plc:a/app.bsky.graph.list/one", Name: "One", Enabled: true},
+		{URI: "at://did:plc:b/app.bsky.graph.list/two", Enabled: false},
+		{URI: "at://did:plc:c/app.bsky.graph.list/three", Enabled: true},
+	}
+
+	var b strings.Builder
+	written, err := writeExport(&b, lists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 2 {
+		t.Errorf("wrote %d lists, want the 2 enabled", written)
+	}
+
+	// The tailer skips blank lines and # comments and reads the rest as URIs
+	var uris []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			uris = append(uris, line)
+		}
+	}
+	want := []string{"at://did:plc:a/app.bsky.graph.list/one", "at://did:plc:c/app.bsky.graph.list/three"}
+	if strings.Join(uris, " ") != strings.Join(want, " ") {
+		t.Errorf("got %v, want %v", uris, want)
+	}
+}
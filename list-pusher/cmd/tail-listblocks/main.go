@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"list-pusher/internal/blocklist"
+	"list-pusher/internal/constellation"
+	"list-pusher/internal/jetstream"
+	"list-pusher/internal/tailer"
+)
+
+func main() {
+	var opts tailer.Options
+	databaseURL := flag.String("db", "", "state store: a SQLite path or postgres:// URL (default $LIST_PUSHER_DB, then list-pusher.db)")
+	policyPath := flag.String("policy", "policy.toml", "listing policy new subscribers must meet to be added")
+
+	flag.StringVar(&opts.Endpoint, "jetstream", jetstream.DefaultEndpoint, "Jetstream subscribe endpoint")
+	flag.DurationVar(&opts.FlushInterval, "flush-interval", 30*time.Second, "how often to push new subscribers to the list")
+	flag.IntVar(&opts.FlushSize, "flush-size", blocklist.MaxBatchSize, "push as soon as this many new subscribers are waiting")
+	flag.DurationVar(&opts.UnlistGrace, "grace", 0, "how long to keep someone listed after they unsubscribe from every source list")
+	flag.BoolVar(&opts.Backfill, "backfill", false, "seed the state store with current subscribers from Constellation")
+	flag.StringVar(&opts.BackfillURL, "constellation", constellation.DefaultBaseURL, "Constellation instance used by --backfill")
+	flag.Parse()
+	if opts.FlushInterval <= 0 {
+		log.Fatalf("Error: --flush-interval must be positive")
+	}
+	if opts.FlushSize < 1 {
+		log.Fatalf("Error: --flush-size must be at least 1")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	manager := blocklist.NewBlueskyBlocklistManager(blocklist.Options{DatabaseURL: *databaseURL, PolicyPath: *policyPath})
+	if err := tailer.New(manager, opts).Run(ctx); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
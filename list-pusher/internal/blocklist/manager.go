@@ -0,0 +1,261 @@
+// Package blocklist manages our published Bluesky list: it reads what is on the
+// list, writes list items in retried applyWrites batches, keeps the state store
+// in step with both and runs the publish-list push.
+package blocklist
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"list-pusher/internal/journal"
+	"list-pusher/internal/listsync"
+	"list-pusher/internal/policy"
+	"list-pusher/internal/session"
+	"list-pusher/internal/store"
+)
+
+// RetryConfig holds retry configuration
+type RetryConfig struct {
+	MaxRetries int
+	BaseWait   time.Duration
+}
+
+// MaxBatchSize is the most writes a PDS accepts in a single applyWrites call
+const MaxBatchSize = 200
+
+// Options controls how Run behaves; they map onto publish-list's flags
+type Options struct {
+	// Sync also removes DIDs that no longer belong on the list,
+	// refusing to remove more than MaxRemovalPercent of the list unless Force
+	Sync              bool
+	MaxRemovalPercent float64
+	Force             bool
+
+	// Fresh ignores an unfinished push in the journal
+	Fresh bool
+
+	// Yes skips confirmation prompts; DryRun writes the plan to PlanOut instead
+	// of applying it, and the policy's decision on every DID to ExplainOut,
+	// without writing to the list or the state store
+	Yes        bool
+	DryRun     bool
+	PlanOut    string
+	ExplainOut string
+
+	// Offline, for a DryRun, plans from the list items recorded in the state
+	// store instead of fetching the list, and never logs in. Handles can't be
+	// resolved and deactivated accounts aren't checked.
+	Offline bool
+
+	// PolicyPath is the listing policy (see policy.LoadConfig)
+	PolicyPath string
+
+	// DatabaseURL is the state store to use (see store.Open)
+	DatabaseURL string
+}
+
+// BlueskyBlocklistManager manages blocklist operations
+type BlueskyBlocklistManager struct {
+	session     *session.Session
+	config      session.Config
+	retryConfig RetryConfig
+	batchSize   int
+	opts        Options
+	journal     *journal.Journal
+	store       store.Store
+	policy      *policy.Engine
+
+	// recordKeys picks the record key of every list item we create
+	recordKeys syntax.TIDClock
+
+	// failedRetryConfig governs further rounds for DIDs that exhausted retryConfig
+	failedRetryConfig RetryConfig
+}
+
+// NewBlueskyBlocklistManager creates a new manager instance
+func NewBlueskyBlocklistManager(opts Options) *BlueskyBlocklistManager {
+	return &BlueskyBlocklistManager{
+		retryConfig: RetryConfig{
+			MaxRetries: 5,
+			BaseWait:   60 * time.Second,
+		},
+		failedRetryConfig: RetryConfig{
+			MaxRetries: 3,
+			BaseWait:   5 * time.Minute,
+		},
+		batchSize:  MaxBatchSize,
+		opts:       opts,
+		recordKeys: syntax.NewTIDClock(0),
+	}
+}
+
+// LoadConfig loads configuration from environment variables. Offline only
+// our list is needed.
+func (m *BlueskyBlocklistManager) LoadConfig() error {
+	if m.opts.Offline && !m.opts.DryRun {
+		return fmt.Errorf("--offline only works with --dry-run")
+	}
+
+	load := session.LoadConfig
+	if m.opts.Offline {
+		load = session.LoadListConfig
+	}
+	config, err := load()
+	if err != nil {
+		return err
+	}
+	m.config = config
+
+	// Optional: number of list items to create per applyWrites call
+	if batchSize := os.Getenv("BLUESKY_BATCH_SIZE"); batchSize != "" {
+		n, err := strconv.Atoi(batchSize)
+		if err != nil || n < 1 || n > MaxBatchSize {
+			return fmt.Errorf("BLUESKY_BATCH_SIZE must be a number between 1 and %d", MaxBatchSize)
+		}
+		m.batchSize = n
+	}
+
+	return nil
+}
+
+// LoadLoginConfig loads just the login from environment variables, for
+// commands that log in but never touch our list
+func (m *BlueskyBlocklistManager) LoadLoginConfig() error {
+	config, err := session.LoadLoginConfig()
+	if err != nil {
+		return err
+	}
+	m.config = config
+	return nil
+}
+
+// Config returns the loaded configuration
+func (m *BlueskyBlocklistManager) Config() session.Config {
+	return m.config
+}
+
+// Authenticate logs in to Bluesky
+func (m *BlueskyBlocklistManager) Authenticate() error {
+	m.session = session.New(m.config)
+	return m.session.Login(context.Background())
+}
+
+// Session returns the authenticated session
+func (m *BlueskyBlocklistManager) Session() *session.Session {
+	return m.session
+}
+
+// FetchListItems fetches every item currently on our list, and records them
+// as the published items in the state store unless this is a dry run.
+// Offline it returns the items recorded in the store instead.
+func (m *BlueskyBlocklistManager) FetchListItems() ([]listsync.ListItem, error) {
+	ctx := context.Background()
+
+	if m.opts.Offline {
+		return m.recordedListItems(ctx)
+	}
+
+	items, err := listsync.FetchListItems(ctx, m.session, m.config.ListURI)
+	if err != nil {
+		return nil, err
+	}
+	if m.opts.DryRun {
+		return items, nil
+	}
+
+	published := make([]store.ListItem, 0, len(items))
+	for _, item := range items {
+		published = append(published, store.ListItem{ListURI: m.config.ListURI, DID: item.DID, RecordKey: item.RecordKey})
+	}
+	if err := m.store.ReplaceListItems(ctx, m.config.ListURI, published); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// recordedListItems returns the items the state store last saw on our list,
+// as of the last run that fetched or changed it
+func (m *BlueskyBlocklistManager) recordedListItems(ctx context.Context) ([]listsync.ListItem, error) {
+	recorded, err := m.store.ListItems(ctx, m.config.ListURI)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]listsync.ListItem, 0, len(recorded))
+	for _, item := range recorded {
+		items = append(items, listsync.ListItem{DID: item.DID, RecordKey: item.RecordKey})
+	}
+	return items, nil
+}
+
+// Offline reports whether the manager works from the state store alone
+func (m *BlueskyBlocklistManager) Offline() bool {
+	return m.opts.Offline
+}
+
+// OpenStore opens the state store named in the options
+func (m *BlueskyBlocklistManager) OpenStore() error {
+	st, err := store.Open(context.Background(), m.opts.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	m.store = st
+	return nil
+}
+
+// Store returns the open state store
+func (m *BlueskyBlocklistManager) Store() store.Store {
+	return m.store
+}
+
+// Close closes the state store
+func (m *BlueskyBlocklistManager) Close() error {
+	if m.store == nil {
+		return nil
+	}
+	return m.store.Close()
+}
+
+// LoadPolicy reads the listing policy named in the options. Deactivated
+// accounts are looked up through the session, so it follows Authenticate;
+// offline they aren't checked.
+func (m *BlueskyBlocklistManager) LoadPolicy() error {
+	config, err := policy.LoadConfig(m.opts.PolicyPath)
+	if err != nil {
+		return err
+	}
+	if m.opts.Offline && config.ExcludeDeactivated {
+		fmt.Println("⚠️  Offline: not checking for deactivated accounts, so the plan may list some")
+		config.ExcludeDeactivated = false
+	}
+	m.policy = policy.NewEngine(config, m.store, policy.ProfileChecker{Client: m.session})
+	return nil
+}
+
+// Policy returns the loaded listing policy
+func (m *BlueskyBlocklistManager) Policy() *policy.Engine {
+	return m.policy
+}
+
+// ApplyPolicy returns the DIDs among dids that the policy wants on the list,
+// and its decisions against the rest. The state store is re-read each time,
+// so long-running commands see changes made by manual-changes.
+func (m *BlueskyBlocklistManager) ApplyPolicy(dids []string) ([]string, []policy.Decision, error) {
+	decisions, err := m.policy.Decide(context.Background(), dids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rejected []policy.Decision
+	for _, decision := range decisions {
+		if !decision.Include {
+			rejected = append(rejected, decision)
+		}
+	}
+	return policy.Included(decisions), rejected, nil
+}
@@ -0,0 +1,347 @@
+package blocklist
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"list-pusher/internal/journal"
+	"list-pusher/internal/listsync"
+	"list-pusher/internal/policy"
+	"list-pusher/internal/prompt"
+)
+
+// journaled applies op to keys, recording every outcome in the journal
+func (m *BlueskyBlocklistManager) journaled(op journal.Op, keys []string) (successful, failed int, err error) {
+	record := func(key, recordURI string, failure error) error {
+		if failure != nil {
+			return m.journal.Failed(op, key, failure)
+		}
+		return m.journal.Done(op, key, recordURI)
+	}
+
+	if op == journal.OpRemove {
+		return m.RemoveListItems(keys, record)
+	}
+	return m.AddListItems(keys, record)
+}
+
+// retryableFailures returns the failed items for op that still have attempts
+// left under the failed-item retry policy
+func (m *BlueskyBlocklistManager) retryableFailures(op journal.Op) []*journal.Item {
+	var items []*journal.Item
+	for _, item := range m.journal.Items(op, journal.StatusFailed) {
+		if item.Attempts <= m.failedRetryConfig.MaxRetries {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// retryFailed gives items that failed, in this run or an earlier one, further
+// rounds with their own retry policy, backing off exponentially between rounds
+func (m *BlueskyBlocklistManager) retryFailed() (successful int, err error) {
+	for round := 1; round <= m.failedRetryConfig.MaxRetries; round++ {
+		adds := m.retryableFailures(journal.OpAdd)
+		removes := m.retryableFailures(journal.OpRemove)
+		if len(adds) == 0 && len(removes) == 0 {
+			return successful, nil
+		}
+
+		wait := time.Duration(float64(m.failedRetryConfig.BaseWait) * math.Pow(2, float64(round-1)))
+		fmt.Printf("\nRetrying %d failed items (round %d/%d) in %v...\n", len(adds)+len(removes), round, m.failedRetryConfig.MaxRetries, wait)
+		time.Sleep(wait)
+
+		added, _, err := m.journaled(journal.OpAdd, journal.Keys(adds))
+		if err != nil {
+			return successful, err
+		}
+		removed, _, err := m.journaled(journal.OpRemove, journal.Keys(removes))
+		if err != nil {
+			return successful, err
+		}
+		successful += added + removed
+	}
+
+	return successful, nil
+}
+
+// checkRemovalGuardrail refuses plans that would remove more than the allowed
+// share of the list, which usually means the subscriber data is incomplete
+func (m *BlueskyBlocklistManager) checkRemovalGuardrail(plan listsync.Plan, listedCount int) error {
+	if len(plan.Remove) == 0 || listedCount == 0 {
+		return nil
+	}
+
+	percent := 100 * float64(len(plan.Remove)) / float64(listedCount)
+	if percent <= m.opts.MaxRemovalPercent {
+		return nil
+	}
+
+	if m.opts.Force {
+		fmt.Printf("⚠️  Removing %.1f%% of the list (limit %.1f%%), continuing because --force was given\n", percent, m.opts.MaxRemovalPercent)
+		return nil
+	}
+
+	return fmt.Errorf("refusing to remove %d of %d list items (%.1f%%, limit %.1f%%); rerun with --force if this is intended",
+		len(plan.Remove), listedCount, percent, m.opts.MaxRemovalPercent)
+}
+
+// printPlan shows what a sync is about to do
+func printPlan(plan listsync.Plan, desiredCount, leftOff, listedCount int) {
+	fmt.Println("\nSync plan")
+	fmt.Println("-" + strings.Repeat("-", 8))
+	fmt.Printf("DIDs wanted on the list:       %d\n", desiredCount)
+	fmt.Printf("Left off by policy:            %d\n", leftOff)
+	fmt.Printf("Items currently in list:       %d\n", listedCount)
+	fmt.Printf("To add:                        %d\n", len(plan.Add))
+	fmt.Printf("To remove:                     %d\n", len(plan.Remove))
+	fmt.Printf("Unchanged:                     %d\n", listedCount-len(plan.Remove))
+}
+
+// printDecisions shows how many DIDs each policy rule settled
+func printDecisions(decisions []policy.Decision) {
+	counts := policy.Counts(decisions)
+	rules := []struct{ rule, label string }{
+		{policy.RuleListed, "Meet the policy"},
+		{policy.RuleIncludeOverride, "Included by overrides"},
+		{policy.RuleExcludeOverride, "Excluded by overrides"},
+		{policy.RuleNoSubscriptions, "No counted subscriptions"},
+		{policy.RuleMinLists, "Too few lists"},
+		{policy.RuleMinScore, "Score too low"},
+		{policy.RuleDeactivated, "Deactivated"},
+	}
+	for _, r := range rules {
+		if counts[r.rule] > 0 {
+			fmt.Printf("  %-26s %d\n", r.label+":", counts[r.rule])
+		}
+	}
+}
+
+// plan computes the changes to make from the state store and the current
+// list, and records them in a fresh journal run. It returns false if there is
+// nothing to do or the user cancelled.
+func (m *BlueskyBlocklistManager) plan() (bool, error) {
+	// Ask the policy who belongs on the list: subscribers of the source lists
+	// who meet its rules, plus and minus manual overrides. Everyone else is
+	// never added, and a sync takes them off the list.
+	fmt.Println("\nApplying the listing policy...")
+	decisions, err := m.policy.DecideAll(context.Background())
+	if err != nil {
+		return false, fmt.Errorf("failed to apply the listing policy: %w", err)
+	}
+	desired := policy.Included(decisions)
+
+	if m.opts.DryRun && m.opts.ExplainOut != "" {
+		if err := policy.WriteDecisions(decisions, m.opts.ExplainOut); err != nil {
+			return false, err
+		}
+	}
+
+	if len(desired) == 0 {
+		return false, fmt.Errorf("nobody in the state store meets the listing policy; run import-clearsky or tail-listblocks first, or check %s", m.opts.PolicyPath)
+	}
+
+	fmt.Printf("Found %d DIDs that belong on the list.\n", len(desired))
+	printDecisions(decisions)
+	leftOff := len(decisions) - len(desired)
+
+	// Fetch existing entries from the blocklist
+	if m.opts.Offline {
+		fmt.Println("Reading the blocklist entries recorded in the state store...")
+	} else {
+		fmt.Println("Fetching existing blocklist entries...")
+	}
+	listed, err := m.FetchListItems()
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch existing blocklist: %w", err)
+	}
+
+	fmt.Printf("List already contains %d DIDs\n", len(listed))
+
+	plan := listsync.Compute(m.config.ListURI, desired, listed, m.opts.Sync)
+	if m.opts.Sync {
+		printPlan(plan, len(desired), leftOff, len(listed))
+	} else {
+		fmt.Printf("DIDs to be added to list: %d\n", len(plan.Add))
+	}
+
+	if m.opts.DryRun {
+		if err := listsync.WritePlanFile(plan, m.opts.PlanOut); err != nil {
+			return false, err
+		}
+		fmt.Println("Dry run: no changes made.")
+		return false, m.checkRemovalGuardrail(plan, len(listed))
+	}
+
+	if plan.Empty() {
+		fmt.Println("The list is already up to date. Nothing to do.")
+		return false, nil
+	}
+
+	if err := m.checkRemovalGuardrail(plan, len(listed)); err != nil {
+		return false, err
+	}
+
+	// Confirm before proceeding
+	var question string
+	if m.opts.Sync {
+		question = fmt.Sprintf("\nAdd %d and remove %d users on list %s? (y/N): ", len(plan.Add), len(plan.Remove), m.config.ListURI)
+	} else {
+		question = fmt.Sprintf("Add %d users to list %s? (y/N): ", len(plan.Add), m.config.ListURI)
+	}
+	if ok, err := prompt.Confirm(question, m.opts.Yes); err != nil || !ok {
+		if err == nil {
+			fmt.Println("Operation cancelled.")
+		}
+		return false, err
+	}
+
+	removals := make([]journal.Removal, 0, len(plan.Remove))
+	for _, item := range plan.Remove {
+		removals = append(removals, journal.Removal{DID: item.DID, RecordKey: item.RecordKey})
+	}
+
+	if err := m.journal.Begin(m.config.ListURI, plan.Add, removals); err != nil {
+		return false, fmt.Errorf("failed to write journal: %w", err)
+	}
+
+	return true, nil
+}
+
+// resume offers to continue an unfinished run recorded in the journal
+func (m *BlueskyBlocklistManager) resume() (bool, error) {
+	adds := m.journal.Counts(journal.OpAdd)
+	removes := m.journal.Counts(journal.OpRemove)
+
+	fmt.Println("\nFound an unfinished push in the journal")
+	fmt.Printf("Additions: %d done, %d pending, %d failed\n", adds[journal.StatusDone], adds[journal.StatusPending], adds[journal.StatusFailed])
+	fmt.Printf("Removals:  %d done, %d pending, %d failed\n", removes[journal.StatusDone], removes[journal.StatusPending], removes[journal.StatusFailed])
+
+	ok, err := prompt.Confirm("Resume it? (y/N): ", m.opts.Yes)
+	if err == nil && !ok {
+		fmt.Println("Operation cancelled.")
+	}
+	return ok, err
+}
+
+// Run pushes the subscribers in the state store to the list: it plans the changes, or
+// resumes an unfinished push from the journal, and applies them
+func (m *BlueskyBlocklistManager) Run() error {
+	fmt.Println("Bluesky Blocklist Manager")
+	fmt.Println("=" + strings.Repeat("=", 24))
+
+	// Load config from environment
+	if err := m.LoadConfig(); err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	if !m.opts.Offline {
+		fmt.Printf("Using handle: %s\n", m.config.Handle)
+	}
+	fmt.Printf("Using list: %s\n", m.config.ListURI)
+	if m.opts.Sync {
+		fmt.Println("Mode: sync (add and remove)")
+	}
+
+	if err := m.OpenStore(); err != nil {
+		return err
+	}
+	defer m.Close()
+
+	// A dry run never touches the journal
+	if !m.opts.DryRun {
+		j, err := journal.Open(context.Background(), m.store)
+		if err != nil {
+			return err
+		}
+		m.journal = j
+	}
+
+	// Authenticate, unless planning from the store alone
+	if m.opts.Offline {
+		fmt.Println("\nOffline: planning from the list items recorded in the state store")
+	} else {
+		fmt.Println("\nConnecting to Bluesky...")
+		if err := m.Authenticate(); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+		fmt.Println("✓ Successfully authenticated")
+	}
+
+	if err := m.LoadPolicy(); err != nil {
+		return err
+	}
+
+	// Pick up an interrupted run where it left off, or plan a new one
+	var proceed bool
+	var err error
+	if !m.opts.DryRun && !m.opts.Fresh && m.journal.Resumable(m.config.ListURI) {
+		proceed, err = m.resume()
+	} else {
+		proceed, err = m.plan()
+	}
+	if err != nil || !proceed {
+		return err
+	}
+
+	// Add users to the list. A resumed push may predate an exclude override
+	// or a change of policy, so pending additions are decided again.
+	pending, rejected, err := m.ApplyPolicy(journal.Keys(m.journal.Items(journal.OpAdd, journal.StatusPending)))
+	if err != nil {
+		return err
+	}
+	if len(rejected) > 0 {
+		fmt.Printf("Skipping %d pending additions the policy no longer wants\n", len(rejected))
+	}
+	added, _, err := m.journaled(journal.OpAdd, pending)
+	if err != nil {
+		return err
+	}
+
+	// Remove stale users from the list. Duplicate items share a DID, so these
+	// are tracked by record key instead.
+	removed, _, err := m.journaled(journal.OpRemove, journal.Keys(m.journal.Items(journal.OpRemove, journal.StatusPending)))
+	if err != nil {
+		return err
+	}
+
+	// Give anything that failed, now or in an earlier run, its own retry rounds
+	retried, err := m.retryFailed()
+	if err != nil {
+		return err
+	}
+
+	givenUp := append(m.journal.Items(journal.OpAdd, journal.StatusFailed), m.journal.Items(journal.OpRemove, journal.StatusFailed)...)
+	if err := m.journal.Finish(); err != nil {
+		return err
+	}
+
+	// Summary
+	fmt.Println("\nOperation complete!")
+	fmt.Printf("Successfully added: %d\n", added)
+	if m.opts.Sync || removed > 0 {
+		fmt.Printf("Successfully removed: %d\n", removed)
+	}
+	if retried > 0 {
+		fmt.Printf("Succeeded on retry: %d\n", retried)
+	}
+	fmt.Printf("Failed: %d\n", len(givenUp))
+	for _, item := range givenUp {
+		fmt.Printf("  ✗ %s %s after %d attempts: %s\n", item.Op, item.DID, item.Attempts, item.LastError)
+	}
+	if len(givenUp) > 0 {
+		fmt.Println("Failures are recorded in the push journal until the next push")
+	}
+
+	if added > 0 {
+		fmt.Printf("\n✓ %d users have been added to your blocklist.\n", added)
+	}
+	if removed > 0 {
+		fmt.Printf("✓ %d users have been removed from your blocklist.\n", removed)
+	}
+
+	return nil
+}
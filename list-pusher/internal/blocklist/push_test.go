@@ -0,0 +1,80 @@
+package blocklist
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"list-pusher/internal/listsync"
+	"list-pusher/internal/store"
+)
+
+func TestOfflineDryRun(t *testing.T) {
+	// No login, so nothing could reach the network
+	t.Setenv("BLUESKY_HANDLE", "")
+	t.Setenv("BLUESKY_APP_PASSWORD", "")
+	t.Setenv("BLUESKY_LIST_URI", testList)
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "list-pusher.db")
+	st, err := store.Open(ctx, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const source = "at://did:plc:source/app.bsky.graph.list/source"
+	seen := time.Now().Add(-time.Hour)
+	steps := []error{
+		st.AddSourceList(ctx, store.SourceList{URI: source, Enabled: true, AddedAt: seen}),
+		st.RecordSubscription(ctx, store.SourceJetstream, store.Subscription{DID: "did:plc:user0", ListURI: source, FirstSeen: seen, LastSeen: seen}),
+		st.RecordSubscription(ctx, store.SourceJetstream, store.Subscription{DID: "did:plc:user1", ListURI: source, FirstSeen: seen, LastSeen: seen}),
+		st.PutListItem(ctx, store.ListItem{ListURI: testList, DID: "did:plc:user1", RecordKey: "rk1", CreatedAt: seen}),
+		st.PutListItem(ctx, store.ListItem{ListURI: testList, DID: "did:plc:gone", RecordKey: "rk9", CreatedAt: seen}),
+		st.Close(),
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Deactivated accounts can't be checked offline, so the policy's check is skipped
+	policyPath := filepath.Join(dir, "policy.toml")
+	if err := os.WriteFile(policyPath, []byte("exclude_deactivated = true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	planPath := filepath.Join(dir, "plan.json")
+	m := NewBlueskyBlocklistManager(Options{
+		Sync: true, MaxRemovalPercent: 100, DryRun: true, Offline: true,
+		PlanOut: planPath, PolicyPath: policyPath, DatabaseURL: dbPath,
+	})
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(planPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plan listsync.Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		t.Fatal(err)
+	}
+	want := listsync.Plan{ListURI: testList, Add: []string{"did:plc:user0"}, Remove: []listsync.ListItem{{DID: "did:plc:gone", RecordKey: "rk9"}}}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("got plan %+v, want %+v", plan, want)
+	}
+}
+
+func TestOfflineNeedsDryRun(t *testing.T) {
+	t.Setenv("BLUESKY_LIST_URI", testList)
+	m := NewBlueskyBlocklistManager(Options{Offline: true})
+	if err := m.LoadConfig(); err == nil {
+		t.Error("got no error for --offline without --dry-run")
+	}
+}
@@ -0,0 +1,317 @@
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"list-pusher/internal/ratelimit"
+	"list-pusher/internal/session"
+	"list-pusher/internal/store"
+)
+
+// calculateWaitTime determines how long to wait before retrying
+func (m *BlueskyBlocklistManager) calculateWaitTime(err error, attempt int) time.Duration {
+	// If the PDS told us when the rate limit resets, wait exactly that long
+	var xrpcErr *xrpc.Error
+	if errors.As(err, &xrpcErr) && xrpcErr.Ratelimit != nil && !xrpcErr.Ratelimit.Reset.IsZero() {
+		waitUntilReset := time.Until(xrpcErr.Ratelimit.Reset) + time.Second
+
+		if waitUntilReset > 0 && waitUntilReset < 48*time.Hour {
+			fmt.Printf("Rate limit exceeded (remaining: %d), waiting until reset + 1s\n", xrpcErr.Ratelimit.Remaining)
+			return waitUntilReset
+		}
+	}
+
+	// Exponential backoff: BaseWait * (2 ^ (attempt - 1))
+	exponentialWait := time.Duration(float64(m.retryConfig.BaseWait) * math.Pow(2, float64(attempt-1)))
+	fmt.Printf("Using exponential backoff for attempt #%d: %v\n", attempt, exponentialWait)
+	return exponentialWait
+}
+
+// isRejected reports whether the PDS refused the request itself, as opposed to
+// a transient failure. Retrying a rejected request unchanged will not help.
+func isRejected(err error) bool {
+	var xrpcErr *xrpc.Error
+	if !errors.As(err, &xrpcErr) {
+		return false
+	}
+	return xrpcErr.StatusCode >= 400 && xrpcErr.StatusCode < 500 && !xrpcErr.IsThrottled()
+}
+
+// alreadyExists reports whether the PDS refused to create a record because
+// it is already there
+func alreadyExists(err error) bool {
+	var xrpcErr *xrpc.Error
+	return errors.As(err, &xrpcErr) && strings.Contains(strings.ToLower(xrpcErr.Error()), "already exists")
+}
+
+// withRetry runs op, refreshing the token and backing off between failed
+// attempts. Retrying with a refreshed token doesn't use up an attempt, but a
+// token that has expired again straight away fails like any other rejection.
+func (m *BlueskyBlocklistManager) withRetry(op func() error) error {
+	refreshed := false
+	for attempt := 1; attempt <= m.retryConfig.MaxRetries; attempt++ {
+		err := op()
+		if err == nil {
+			return nil // Success
+		}
+
+		// Check if token expired and refresh
+		if session.IsTokenExpired(err) && !refreshed {
+			fmt.Println("Token expired, attempting to refresh...")
+			if refreshErr := m.session.Refresh(context.Background()); refreshErr != nil {
+				return fmt.Errorf("failed to refresh token: %w", refreshErr)
+			}
+			// Retry immediately with fresh token instead of waiting
+			refreshed = true
+			attempt--
+			continue
+		}
+		refreshed = false
+
+		if isRejected(err) {
+			return fmt.Errorf("request rejected: %w", err)
+		}
+
+		if attempt == m.retryConfig.MaxRetries {
+			return fmt.Errorf("final attempt failed after %d tries: %w", m.retryConfig.MaxRetries, err)
+		}
+
+		waitTime := m.calculateWaitTime(err, attempt)
+		fmt.Printf("Attempt %d/%d failed: %v\n", attempt, m.retryConfig.MaxRetries, err)
+		fmt.Printf("Waiting %v before retry...\n", waitTime)
+		time.Sleep(waitTime)
+	}
+
+	return fmt.Errorf("maximum retries exceeded")
+}
+
+// newListItemRecord builds the listitem record adding userDID to listURI
+func newListItemRecord(userDID, listURI string) *bsky.GraphListitem {
+	return &bsky.GraphListitem{
+		Subject:   userDID,
+		List:      listURI,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// createListItemWrite is the applyWrites operation adding userDID to listURI
+// as the listitem record recordKey
+func createListItemWrite(userDID, listURI, recordKey string) *atproto.RepoApplyWrites_Input_Writes_Elem {
+	return &atproto.RepoApplyWrites_Input_Writes_Elem{
+		RepoApplyWrites_Create: &atproto.RepoApplyWrites_Create{
+			Collection: "app.bsky.graph.listitem",
+			Rkey:       &recordKey,
+			Value:      &util.LexiconTypeDecoder{Val: newListItemRecord(userDID, listURI)}, // Wrap in LexiconTypeDecoder
+		},
+	}
+}
+
+// deleteListItemWrite is the applyWrites operation deleting the listitem record recordKey
+func deleteListItemWrite(recordKey string) *atproto.RepoApplyWrites_Input_Writes_Elem {
+	return &atproto.RepoApplyWrites_Input_Writes_Elem{
+		RepoApplyWrites_Delete: &atproto.RepoApplyWrites_Delete{
+			Collection: "app.bsky.graph.listitem",
+			Rkey:       recordKey,
+		},
+	}
+}
+
+// writeCost is how many points writes count against the PDS's repo write budget
+func writeCost(writes []*atproto.RepoApplyWrites_Input_Writes_Elem) int {
+	cost := 0
+	for _, write := range writes {
+		switch {
+		case write.RepoApplyWrites_Create != nil:
+			cost += ratelimit.CreatePoints
+		case write.RepoApplyWrites_Update != nil:
+			cost += ratelimit.UpdatePoints
+		case write.RepoApplyWrites_Delete != nil:
+			cost += ratelimit.DeletePoints
+		}
+	}
+	return cost
+}
+
+// applyListItemWrites applies writes to our repo in a single applyWrites call,
+// returning the URI of each record created (empty for deletes). Creates carry
+// their own record keys, so the URIs don't depend on the order, or number, of
+// the results the PDS sends back.
+func (m *BlueskyBlocklistManager) applyListItemWrites(writes []*atproto.RepoApplyWrites_Input_Writes_Elem) ([]string, error) {
+	ctx := ratelimit.WithCost(context.Background(), writeCost(writes))
+	repo := m.session.DID()
+
+	if _, err := atproto.RepoApplyWrites(ctx, m.session, &atproto.RepoApplyWrites_Input{
+		Repo:   repo,
+		Writes: writes,
+	}); err != nil {
+		return nil, err
+	}
+	return createdURIs(repo, writes), nil
+}
+
+// createdURIs returns the URI of each record writes create in repo (empty for deletes)
+func createdURIs(repo string, writes []*atproto.RepoApplyWrites_Input_Writes_Elem) []string {
+	uris := make([]string, len(writes))
+	for i, write := range writes {
+		if create := write.RepoApplyWrites_Create; create != nil && create.Rkey != nil {
+			uris[i] = fmt.Sprintf("at://%s/%s/%s", repo, create.Collection, *create.Rkey)
+		}
+	}
+	return uris
+}
+
+// writeListItemBatchWithRetry applies one write per key (a DID or record key)
+// in a single applyWrites call. applyWrites is all-or-nothing, so when the PDS
+// rejects the batch the offending keys are isolated by splitting it in half and
+// retrying each half. It returns the URIs of created records and the errors for
+// keys whose write failed.
+func (m *BlueskyBlocklistManager) writeListItemBatchWithRetry(keys []string, writeFor func(key string) *atproto.RepoApplyWrites_Input_Writes_Elem) (map[string]string, map[string]error) {
+	writes := make([]*atproto.RepoApplyWrites_Input_Writes_Elem, 0, len(keys))
+	for _, key := range keys {
+		writes = append(writes, writeFor(key))
+	}
+
+	var uris []string
+	tries := 0
+	err := m.withRetry(func() error {
+		tries++
+		var err error
+		uris, err = m.applyListItemWrites(writes)
+		// Creates carry their record keys, so a retry refused because the
+		// records exist means an earlier try went through after all
+		if err != nil && tries > 1 && alreadyExists(err) {
+			fmt.Printf("Batch of %d was already written by an earlier try\n", len(keys))
+			uris, err = createdURIs(m.session.DID(), writes), nil
+		}
+		return err
+	})
+	if err == nil {
+		created := make(map[string]string, len(keys))
+		for i, key := range keys {
+			created[key] = uris[i]
+		}
+		return created, nil
+	}
+
+	if len(keys) == 1 || !isRejected(err) {
+		failures := make(map[string]error, len(keys))
+		for _, key := range keys {
+			failures[key] = err
+		}
+		return nil, failures
+	}
+
+	fmt.Printf("Batch of %d rejected (%v), splitting and retrying...\n", len(keys), err)
+	mid := len(keys) / 2
+	created, failures := m.writeListItemBatchWithRetry(keys[:mid], writeFor)
+	moreCreated, moreFailures := m.writeListItemBatchWithRetry(keys[mid:], writeFor)
+	if created == nil {
+		created = make(map[string]string)
+	}
+	if failures == nil {
+		failures = make(map[string]error)
+	}
+	maps.Copy(created, moreCreated)
+	maps.Copy(failures, moreFailures)
+	return created, failures
+}
+
+// createListItemBatchWithRetry adds a batch of users to the list, returning
+// the new record URIs and the errors for DIDs that could not be added
+func (m *BlueskyBlocklistManager) createListItemBatchWithRetry(userDIDs []string, listURI string) (map[string]string, map[string]error) {
+	return m.writeListItemBatchWithRetry(userDIDs, func(userDID string) *atproto.RepoApplyWrites_Input_Writes_Elem {
+		return createListItemWrite(userDID, listURI, m.recordKeys.Next().String())
+	})
+}
+
+// deleteListItemBatchWithRetry removes a batch of list items by record key,
+// returning the errors for records that could not be deleted
+func (m *BlueskyBlocklistManager) deleteListItemBatchWithRetry(recordKeys []string) (map[string]string, map[string]error) {
+	return m.writeListItemBatchWithRetry(recordKeys, deleteListItemWrite)
+}
+
+// Outcome is called with the result of each write made by AddListItems or
+// RemoveListItems. recordURI is set for successful additions.
+type Outcome func(key, recordURI string, err error) error
+
+// AddListItems adds userDIDs to the list in batches of the configured size,
+// reporting progress, recording each new item in the state store and passing
+// each DID's outcome to record if it is set
+func (m *BlueskyBlocklistManager) AddListItems(userDIDs []string, record Outcome) (successful, failed int, err error) {
+	return m.inBatches("Adding", userDIDs, func(batch []string) (map[string]string, map[string]error) {
+		return m.createListItemBatchWithRetry(batch, m.config.ListURI)
+	}, func(did, recordURI string, failure error) error {
+		// Without a record URI the item is recorded when the list is next fetched
+		if failure == nil && recordURI != "" {
+			aturi, err := syntax.ParseATURI(recordURI)
+			if err != nil {
+				return fmt.Errorf("PDS returned an invalid record URI %q for %s: %w", recordURI, did, err)
+			}
+			item := store.ListItem{ListURI: m.config.ListURI, DID: did, RecordKey: aturi.RecordKey().String()}
+			if err := m.store.PutListItem(context.Background(), item); err != nil {
+				return err
+			}
+		}
+		if record != nil {
+			return record(did, recordURI, failure)
+		}
+		return nil
+	})
+}
+
+// RemoveListItems deletes the list items with the given record keys in batches
+// of the configured size, dropping each from the state store and passing each
+// record key's outcome to record if it is set
+func (m *BlueskyBlocklistManager) RemoveListItems(recordKeys []string, record Outcome) (successful, failed int, err error) {
+	return m.inBatches("Removing", recordKeys, m.deleteListItemBatchWithRetry, func(rkey, recordURI string, failure error) error {
+		if failure == nil {
+			if err := m.store.DeleteListItem(context.Background(), m.config.ListURI, rkey); err != nil {
+				return err
+			}
+		}
+		if record != nil {
+			return record(rkey, recordURI, failure)
+		}
+		return nil
+	})
+}
+
+// inBatches runs apply over keys in batches of the configured size
+func (m *BlueskyBlocklistManager) inBatches(verb string, keys []string, apply func(batch []string) (map[string]string, map[string]error), record Outcome) (successful, failed int, err error) {
+	for start := 0; start < len(keys); start += m.batchSize {
+		end := min(start+m.batchSize, len(keys))
+		batch := keys[start:end]
+
+		fmt.Printf("%s users %d-%d/%d\n", verb, start+1, end, len(keys))
+		created, failures := apply(batch)
+
+		for _, key := range batch {
+			failure, ok := failures[key]
+			if ok {
+				fmt.Printf("✗ Failed on %s: %v\n", key, failure)
+				failed++
+			} else {
+				successful++
+			}
+
+			if err := record(key, created[key], failure); err != nil {
+				return successful, failed, err
+			}
+		}
+		fmt.Printf("✓ %d/%d in batch succeeded\n", len(batch)-len(failures), len(batch))
+	}
+
+	return successful, failed, nil
+}
@@ -0,0 +1,392 @@
+package blocklist
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"list-pusher/internal/session"
+	"list-pusher/internal/store"
+)
+
+const (
+	testRepo = "did:plc:listowner"
+	testList = "at://did:plc:listowner/app.bsky.graph.list/3lbxfscjqno2d"
+)
+
+// write is one applyWrites operation as the fake PDS sees it
+type write struct {
+	Type  string `json:"$type"`
+	Rkey  string `json:"rkey"`
+	Value struct {
+		Subject string `json:"subject"`
+	} `json:"value"`
+}
+
+// fakePDS accepts createSession and hands each applyWrites call to respond,
+// recording the writes of every call
+type fakePDS struct {
+	mu      sync.Mutex
+	calls   [][]write
+	respond func(call int, writes []write) (status int, body any)
+}
+
+func (p *fakePDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/xrpc/com.atproto.server.createSession":
+		json.NewEncoder(w).Encode(map[string]string{"accessJwt": "access", "refreshJwt": "refresh", "handle": "owner.test", "did": testRepo})
+	case "/xrpc/com.atproto.repo.applyWrites":
+		var input struct {
+			Repo   string  `json:"repo"`
+			Writes []write `json:"writes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Repo != testRepo {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "InvalidRequest", "message": "bad input"})
+			return
+		}
+
+		p.mu.Lock()
+		p.calls = append(p.calls, input.Writes)
+		call := len(p.calls)
+		p.mu.Unlock()
+
+		status, body := http.StatusOK, any(results(input.Writes))
+		if p.respond != nil {
+			status, body = p.respond(call, input.Writes)
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// results is the applyWrites output for writes, in order
+func results(writes []write) map[string]any {
+	var out []map[string]string
+	for _, wr := range writes {
+		if wr.Type == "com.atproto.repo.applyWrites#delete" {
+			out = append(out, map[string]string{"$type": "com.atproto.repo.applyWrites#deleteResult"})
+			continue
+		}
+		out = append(out, map[string]string{
+			"$type": "com.atproto.repo.applyWrites#createResult",
+			"uri":   itemURI(wr.Rkey),
+			"cid":   "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm",
+		})
+	}
+	return map[string]any{"results": out}
+}
+
+func xrpcError(name string) map[string]string {
+	return map[string]string{"error": name, "message": name}
+}
+
+func itemURI(rkey string) string {
+	return "at://" + testRepo + "/app.bsky.graph.listitem/" + rkey
+}
+
+// newTestManager logs a manager in to pds, with retries that don't wait
+func newTestManager(t *testing.T, pds *fakePDS) *BlueskyBlocklistManager {
+	t.Helper()
+	server := httptest.NewServer(pds)
+	t.Cleanup(server.Close)
+
+	st, err := store.OpenSQLite(context.Background(), ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+
+	m := NewBlueskyBlocklistManager(Options{})
+	m.config = session.Config{Handle: "owner.test", AppPassword: "password", ListURI: testList, PDSHost: server.URL}
+	m.retryConfig = RetryConfig{MaxRetries: 3, BaseWait: time.Millisecond}
+	m.store = st
+	m.session = session.NewWithClient(m.config, &http.Client{})
+	if err := m.session.Login(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func dids(n int) []string {
+	var dids []string
+	for i := range n {
+		dids = append(dids, fmt.Sprintf("did:plc:user%d", i))
+	}
+	return dids
+}
+
+// sentRecordKeys returns the record key each DID was created under across calls
+func (p *fakePDS) sentRecordKeys() map[string][]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sent := make(map[string][]string)
+	for _, call := range p.calls {
+		for _, wr := range call {
+			sent[wr.Value.Subject] = append(sent[wr.Value.Subject], wr.Rkey)
+		}
+	}
+	return sent
+}
+
+// checkCreated checks that every DID in want was created once, under the
+// record key it was sent with
+func checkCreated(t *testing.T, pds *fakePDS, created map[string]string, want []string) {
+	t.Helper()
+	sent := pds.sentRecordKeys()
+	var got []string
+	for did, uri := range created {
+		got = append(got, did)
+		rkeys := sent[did]
+		if len(rkeys) == 0 || uri != itemURI(rkeys[len(rkeys)-1]) {
+			t.Errorf("%s created as %s, but sent with record keys %v", did, uri, rkeys)
+		}
+	}
+	sort.Strings(got)
+	want = slices.Sorted(slices.Values(want))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got created %v, want %v", got, want)
+	}
+}
+
+func TestCreateBatch(t *testing.T) {
+	pds := &fakePDS{}
+	m := newTestManager(t, pds)
+
+	created, failures := m.createListItemBatchWithRetry(dids(5), testList)
+	if len(failures) != 0 {
+		t.Fatalf("got failures %v", failures)
+	}
+	if len(pds.calls) != 1 || len(pds.calls[0]) != 5 {
+		t.Fatalf("expected one call with 5 writes, got %d calls", len(pds.calls))
+	}
+	checkCreated(t, pds, created, dids(5))
+
+	// Every item gets its own record key
+	seen := make(map[string]bool)
+	for _, wr := range pds.calls[0] {
+		if wr.Rkey == "" || seen[wr.Rkey] {
+			t.Errorf("record key %q missing or reused", wr.Rkey)
+		}
+		seen[wr.Rkey] = true
+	}
+}
+
+func TestAddListItemsRecordsStore(t *testing.T) {
+	pds := &fakePDS{}
+	m := newTestManager(t, pds)
+	m.batchSize = 2
+
+	var outcomes []string
+	successful, failed, err := m.AddListItems(dids(3), func(did, recordURI string, failure error) error {
+		outcomes = append(outcomes, did)
+		return failure
+	})
+	if err != nil || successful != 3 || failed != 0 {
+		t.Fatalf("got %d successful, %d failed, error %v", successful, failed, err)
+	}
+	if len(pds.calls) != 2 || !reflect.DeepEqual(outcomes, dids(3)) {
+		t.Errorf("got %d calls and outcomes %v", len(pds.calls), outcomes)
+	}
+
+	items, err := m.store.ListItems(context.Background(), testList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := pds.sentRecordKeys()
+	if len(items) != 3 {
+		t.Fatalf("got stored items %+v", items)
+	}
+	for _, item := range items {
+		if rkeys := sent[item.DID]; len(rkeys) != 1 || item.RecordKey != rkeys[0] {
+			t.Errorf("stored %s under %s, sent %v", item.DID, item.RecordKey, rkeys)
+		}
+	}
+}
+
+func TestDeleteBatch(t *testing.T) {
+	pds := &fakePDS{}
+	m := newTestManager(t, pds)
+
+	created, failures := m.deleteListItemBatchWithRetry([]string{"rk1", "rk2"})
+	if len(failures) != 0 {
+		t.Fatalf("got failures %v", failures)
+	}
+	if !reflect.DeepEqual(created, map[string]string{"rk1": "", "rk2": ""}) {
+		t.Errorf("got created %v", created)
+	}
+	if len(pds.calls) != 1 || pds.calls[0][0].Rkey != "rk1" || pds.calls[0][1].Rkey != "rk2" {
+		t.Errorf("got calls %+v", pds.calls)
+	}
+}
+
+func TestRejectedBatchIsSplit(t *testing.T) {
+	const bad = "did:plc:user5"
+	pds := &fakePDS{respond: func(call int, writes []write) (int, any) {
+		for _, wr := range writes {
+			if wr.Value.Subject == bad {
+				return http.StatusBadRequest, xrpcError("InvalidRequest")
+			}
+		}
+		return http.StatusOK, results(writes)
+	}}
+	m := newTestManager(t, pds)
+
+	created, failures := m.createListItemBatchWithRetry(dids(8), testList)
+
+	if len(failures) != 1 || failures[bad] == nil {
+		t.Fatalf("got failures %v, want just %s", failures, bad)
+	}
+	if !isRejected(failures[bad]) {
+		t.Errorf("got failure %v, want the PDS's rejection", failures[bad])
+	}
+	checkCreated(t, pds, created, slices.DeleteFunc(dids(8), func(did string) bool { return did == bad }))
+
+	// 8 → 4+4 → 2+2 → 1+1, rejected halves split without being retried
+	if len(pds.calls) != 7 {
+		t.Errorf("got %d calls, want 7", len(pds.calls))
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		failures   int
+		wantCalls  int
+		wantFailed bool
+	}{
+		{name: "rate limited", status: http.StatusTooManyRequests, failures: 2, wantCalls: 3},
+		{name: "server error", status: http.StatusBadGateway, failures: 2, wantCalls: 3},
+		{name: "unavailable", status: http.StatusServiceUnavailable, failures: 1, wantCalls: 2},
+		{name: "server error every time", status: http.StatusInternalServerError, failures: 10, wantCalls: 3, wantFailed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pds := &fakePDS{respond: func(call int, writes []write) (int, any) {
+				if call <= tt.failures {
+					return tt.status, xrpcError("Oops")
+				}
+				return http.StatusOK, results(writes)
+			}}
+			m := newTestManager(t, pds)
+
+			created, failures := m.createListItemBatchWithRetry(dids(4), testList)
+
+			if len(pds.calls) != tt.wantCalls {
+				t.Errorf("got %d calls, want %d", len(pds.calls), tt.wantCalls)
+			}
+			if tt.wantFailed {
+				if len(failures) != 4 || len(created) != 0 {
+					t.Errorf("got created %v, failures %v", created, failures)
+				}
+				for _, failure := range failures {
+					if !strings.Contains(failure.Error(), "final attempt failed") {
+						t.Errorf("got failure %v", failure)
+					}
+				}
+				return
+			}
+			if len(failures) != 0 {
+				t.Fatalf("got failures %v", failures)
+			}
+			// Retries resend the same records
+			checkCreated(t, pds, created, dids(4))
+			for did, rkeys := range pds.sentRecordKeys() {
+				if len(slices.Compact(slices.Clone(rkeys))) != 1 {
+					t.Errorf("%s sent with record keys %v", did, rkeys)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenRefreshIsNotAnAttempt(t *testing.T) {
+	// Between two server errors the token expires, and stays expired through
+	// the session's own refresh
+	pds := &fakePDS{respond: func(call int, writes []write) (int, any) {
+		switch call {
+		case 1, 4:
+			return http.StatusBadGateway, xrpcError("Oops")
+		case 2, 3:
+			return http.StatusBadRequest, xrpcError("ExpiredToken")
+		}
+		return http.StatusOK, results(writes)
+	}}
+	m := newTestManager(t, pds)
+
+	created, failures := m.createListItemBatchWithRetry(dids(4), testList)
+
+	if len(failures) != 0 || len(pds.calls) != 5 {
+		t.Fatalf("got failures %v after %d calls", failures, len(pds.calls))
+	}
+	checkCreated(t, pds, created, dids(4))
+}
+
+func TestRetriedCreateAlreadyExists(t *testing.T) {
+	// The first try goes through but its response is lost, so the retry
+	// finds the records already there
+	pds := &fakePDS{respond: func(call int, writes []write) (int, any) {
+		if call == 1 {
+			return http.StatusBadGateway, xrpcError("Oops")
+		}
+		return http.StatusBadRequest, map[string]string{"error": "InvalidRequest", "message": "Record already exists"}
+	}}
+	m := newTestManager(t, pds)
+
+	created, failures := m.createListItemBatchWithRetry(dids(4), testList)
+
+	if len(failures) != 0 || len(pds.calls) != 2 {
+		t.Fatalf("got failures %v after %d calls", failures, len(pds.calls))
+	}
+	checkCreated(t, pds, created, dids(4))
+}
+
+func TestResultsDontDecideRecordKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		results func(writes []write) any
+	}{
+		{name: "out of order", results: func(writes []write) any {
+			reversed := slices.Clone(writes)
+			slices.Reverse(reversed)
+			return results(reversed)
+		}},
+		{name: "incomplete", results: func(writes []write) any {
+			return results(writes[:2])
+		}},
+		{name: "none", results: func(writes []write) any {
+			return map[string]any{}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pds := &fakePDS{respond: func(call int, writes []write) (int, any) {
+				return http.StatusOK, tt.results(writes)
+			}}
+			m := newTestManager(t, pds)
+
+			created, failures := m.createListItemBatchWithRetry(dids(5), testList)
+
+			// The writes went through, so they must not be retried
+			if len(failures) != 0 || len(pds.calls) != 1 {
+				t.Fatalf("got failures %v after %d calls", failures, len(pds.calls))
+			}
+			checkCreated(t, pds, created, dids(5))
+		})
+	}
+}
@@ -0,0 +1,245 @@
+// Package clearsky reads the subscribers of a blocklist from the Clearsky
+// API, which pages through them by number and wraps its objects and arrays as
+// ["dict", {...}] and ["list", [...]]. It replaces
+// scrape_clearsky_blocklist_api.py and process-haters.py.
+package clearsky
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"list-pusher/internal/identifier"
+)
+
+// DefaultBaseURL is the Clearsky endpoint listing a blocklist's subscribers;
+// the list owner's DID, the list rkey and the page number are appended to it
+const DefaultBaseURL = "https://api.clearsky.services/api/v1/anon/subscribe-blocks-single-blocklist"
+
+// ErrListNotFound is returned when Clearsky does not know a list
+var ErrListNotFound = errors.New("list not found on clearsky")
+
+// RetryConfig holds retry configuration
+type RetryConfig struct {
+	MaxRetries int
+	BaseWait   time.Duration
+}
+
+// Client is a Clearsky API client
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	UserAgent  string
+
+	// PageDelay is the pause between pages, to be polite to Clearsky
+	PageDelay   time.Duration
+	RetryConfig RetryConfig
+
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewClient creates a client for the Clearsky API at baseURL
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		UserAgent:  "list-pusher",
+		PageDelay:  2 * time.Second,
+		RetryConfig: RetryConfig{
+			MaxRetries: 5,
+			BaseWait:   5 * time.Second,
+		},
+		sleep: sleepContext,
+	}
+}
+
+// Subscriber is an account subscribed to a blocklist, and when Clearsky says they subscribed
+type Subscriber struct {
+	DID       string `json:"did"`
+	DateAdded string `json:"date_added"`
+}
+
+// Page is one page of a blocklist's subscribers
+type Page struct {
+	ListURL     string
+	ListName    string
+	ListOwner   string
+	Description string
+	Subscribers []Subscriber
+}
+
+// unwrap strips Clearsky's ["dict", x] and ["list", x] wrappers, leaving
+// anything else as it is
+func unwrap(data json.RawMessage) json.RawMessage {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '[' {
+		return data
+	}
+
+	var wrapped []json.RawMessage
+	if err := json.Unmarshal(data, &wrapped); err != nil || len(wrapped) != 2 {
+		return data
+	}
+	var tag string
+	if err := json.Unmarshal(wrapped[0], &tag); err != nil || (tag != "dict" && tag != "list") {
+		return data
+	}
+	return wrapped[1]
+}
+
+// ParsePage decodes one subscribe-blocks response, wrapped or not, as the
+// API returns it or as scrape_clearsky_blocklist_api.py saved it
+func ParsePage(body []byte) (*Page, error) {
+	var response struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(unwrap(body), &response); err != nil {
+		return nil, err
+	}
+	if len(response.Data) == 0 {
+		return nil, fmt.Errorf("response has no data")
+	}
+
+	var data struct {
+		ListURL     string          `json:"list_url"`
+		ListName    string          `json:"list_name"`
+		ListOwner   string          `json:"list_owner"`
+		Description string          `json:"description"`
+		Users       json.RawMessage `json:"users"`
+	}
+	if err := json.Unmarshal(unwrap(response.Data), &data); err != nil {
+		return nil, fmt.Errorf("unexpected data: %w", err)
+	}
+
+	page := &Page{ListURL: data.ListURL, ListName: data.ListName, ListOwner: data.ListOwner, Description: data.Description}
+	if len(data.Users) == 0 || string(data.Users) == "null" {
+		return page, nil
+	}
+
+	var users []json.RawMessage
+	if err := json.Unmarshal(unwrap(data.Users), &users); err != nil {
+		return nil, fmt.Errorf("unexpected users: %w", err)
+	}
+	for _, user := range users {
+		var subscriber Subscriber
+		if err := json.Unmarshal(unwrap(user), &subscriber); err != nil {
+			return nil, fmt.Errorf("unexpected user: %w", err)
+		}
+		if subscriber.DID != "" {
+			page.Subscribers = append(page.Subscribers, subscriber)
+		}
+	}
+
+	return page, nil
+}
+
+// Subscribers pages through listURI's subscribers from page start until an
+// empty page, passing each page and its number to fn as it arrives
+func (c *Client) Subscribers(ctx context.Context, listURI string, start int, fn func(number int, page *Page) error) error {
+	ref, err := identifier.ParseList(listURI)
+	if err != nil {
+		return err
+	}
+
+	for number := start; ; number++ {
+		if number > start {
+			if err := c.sleep(ctx, c.PageDelay); err != nil {
+				return err
+			}
+		}
+
+		endpoint := fmt.Sprintf("%s/%s/%s/%s", c.BaseURL, ref.Owner, ref.RecordKey, strconv.Itoa(number))
+		page, err := c.page(ctx, endpoint)
+		if err != nil {
+			return err
+		}
+		if len(page.Subscribers) == 0 {
+			return nil
+		}
+		if err := fn(number, page); err != nil {
+			return err
+		}
+	}
+}
+
+// page fetches one page, retrying transient failures
+func (c *Client) page(ctx context.Context, endpoint string) (*Page, error) {
+	var lastErr error
+	for attempt := 0; attempt <= c.RetryConfig.MaxRetries; attempt++ {
+		if attempt > 0 {
+			wait := time.Duration(float64(c.RetryConfig.BaseWait) * math.Pow(2, float64(attempt-1)))
+			fmt.Printf("Clearsky request failed (attempt %d/%d): %v. Waiting %v...\n", attempt, c.RetryConfig.MaxRetries, lastErr, wait)
+			if err := c.sleep(ctx, wait); err != nil {
+				return nil, err
+			}
+		}
+
+		page, retry, err := c.get(ctx, endpoint)
+		if err == nil {
+			return page, nil
+		}
+		if !retry {
+			return nil, err
+		}
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("failed to query clearsky after %d retries: %w", c.RetryConfig.MaxRetries, lastErr)
+}
+
+// get makes a single request, reporting whether a failure is worth retrying
+func (c *Client) get(ctx context.Context, endpoint string) (*Page, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.UserAgent)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, ctx.Err() == nil, fmt.Errorf("failed to query clearsky: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to read clearsky response: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, ErrListNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return nil, retry, fmt.Errorf("clearsky returned %s: %s", resp.Status, body)
+	}
+
+	page, err := ParsePage(body)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse clearsky response: %w", err)
+	}
+
+	return page, false, nil
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
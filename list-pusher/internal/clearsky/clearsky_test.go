@@ -0,0 +1,124 @@
+package clearsky
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testList  = "at://did:plc:2bij7yypmcuvwyz4gyqwtluy/app.bsky.graph.list/3lbxfscjqno2d"
+	testPages = "/did:plc:2bij7yypmcuvwyz4gyqwtluy/3lbxfscjqno2d/"
+)
+
+// fakeClearsky serves the recorded pages of testList, failing requests for
+// the pages in fail with status
+type fakeClearsky struct {
+	t        *testing.T
+	fail     map[string]int
+	status   int
+	requests []string
+}
+
+func (f *fakeClearsky) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests = append(f.requests, r.URL.Path)
+
+	number, ok := strings.CutPrefix(r.URL.Path, testPages)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if f.fail[number] > 0 {
+		f.fail[number]--
+		http.Error(w, "upstream unavailable", f.status)
+		return
+	}
+
+	data, err := os.ReadFile("testdata/page-" + number + ".json")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(data)
+}
+
+func newTestClient(t *testing.T, fake *fakeClearsky) (*Client, *[]time.Duration) {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	var waits []time.Duration
+	client := NewClient(server.URL)
+	client.PageDelay = time.Millisecond
+	client.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	return client, &waits
+}
+
+func TestSubscribersUnwrapsEveryPage(t *testing.T) {
+	fake := &fakeClearsky{t: t}
+	client, _ := newTestClient(t, fake)
+
+	var got []Subscriber
+	err := client.Subscribers(context.Background(), testList, 1, func(number int, page *Page) error {
+		if page.ListName != "AI art" {
+			t.Errorf("page %d has list name %q", number, page.ListName)
+		}
+		got = append(got, page.Subscribers...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Subscriber{
+		{DID: "did:plc:a2cm7qv3xgbk4ufmmt7dwkbe", DateAdded: "2024-11-27T01:02:03.456000+00:00"},
+		{DID: "did:plc:jfhpnnst6flqway4eaeqzj2a", DateAdded: "2024-11-28T10:00:00"},
+		{DID: "did:web:example.com"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+	if len(fake.requests) != 3 {
+		t.Errorf("expected 3 requests, got %v", fake.requests)
+	}
+}
+
+func TestSubscribersRetriesServerErrors(t *testing.T) {
+	fake := &fakeClearsky{t: t, fail: map[string]int{"2": 2}, status: http.StatusServiceUnavailable}
+	client, waits := newTestClient(t, fake)
+
+	var count int
+	err := client.Subscribers(context.Background(), testList, 1, func(number int, page *Page) error {
+		count += len(page.Subscribers)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 subscribers, got %d", count)
+	}
+
+	// Page delays around two backoffs
+	want := []time.Duration{time.Millisecond, 5 * time.Second, 10 * time.Second, time.Millisecond}
+	if !reflect.DeepEqual(*waits, want) {
+		t.Errorf("got waits %v, want %v", *waits, want)
+	}
+}
+
+func TestSubscribersUnknownList(t *testing.T) {
+	client, _ := newTestClient(t, &fakeClearsky{t: t})
+
+	err := client.Subscribers(context.Background(), "at://did:plc:other/app.bsky.graph.list/3lbxfscjqno2d", 1, func(int, *Page) error { return nil })
+	if !errors.Is(err, ErrListNotFound) {
+		t.Errorf("expected ErrListNotFound, got %v", err)
+	}
+}
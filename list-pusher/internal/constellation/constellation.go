@@ -0,0 +1,221 @@
+// Package constellation queries a Constellation backlinks index for the
+// records that link to a target, such as every listblock subscribing to one
+// of our source blocklists. The links API pages with an opaque cursor, which
+// has to be followed to the end to see every record.
+package constellation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DefaultBaseURL is the public Constellation instance
+const DefaultBaseURL = "https://constellation.microcosm.blue"
+
+// ListBlockCollection is the collection of records that subscribe to a blocklist
+const ListBlockCollection = "app.bsky.graph.listblock"
+
+// RetryConfig holds retry configuration
+type RetryConfig struct {
+	MaxRetries int
+	BaseWait   time.Duration
+}
+
+// Client is a Constellation API client
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	UserAgent  string
+
+	// PageSize is how many records to ask for per request
+	PageSize    int
+	RetryConfig RetryConfig
+
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewClient creates a client for the Constellation instance at baseURL
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    baseURL,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		UserAgent:  "list-pusher",
+		PageSize:   100,
+		RetryConfig: RetryConfig{
+			MaxRetries: 5,
+			BaseWait:   2 * time.Second,
+		},
+		sleep: sleepContext,
+	}
+}
+
+// LinkingRecord is one record that links to the target
+type LinkingRecord struct {
+	DID        string `json:"did"`
+	Collection string `json:"collection"`
+	RecordKey  string `json:"rkey"`
+}
+
+// URI returns the AT-URI of the record
+func (r LinkingRecord) URI() string {
+	return fmt.Sprintf("at://%s/%s/%s", r.DID, r.Collection, r.RecordKey)
+}
+
+// linksResponse is a page of the links API
+type linksResponse struct {
+	Total          int             `json:"total"`
+	LinkingRecords []LinkingRecord `json:"linking_records"`
+	Cursor         *string         `json:"cursor"`
+}
+
+// Subscriber is an account subscribed to a blocklist, and the listblock record doing it
+type Subscriber struct {
+	DID       string
+	RecordURI string
+}
+
+// ListSubscribers returns every account with an app.bsky.graph.listblock
+// record whose subject is listURI
+func (c *Client) ListSubscribers(ctx context.Context, listURI string) ([]Subscriber, error) {
+	var subscribers []Subscriber
+
+	err := c.Links(ctx, listURI, ListBlockCollection, ".subject", func(records []LinkingRecord) error {
+		for _, record := range records {
+			subscribers = append(subscribers, Subscriber{DID: record.DID, RecordURI: record.URI()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return subscribers, nil
+}
+
+// CountSubscribers returns how many listblock records have listURI as their
+// subject, as Constellation counts them, from a single page
+func (c *Client) CountSubscribers(ctx context.Context, listURI string) (int, error) {
+	page, err := c.linksPage(ctx, listURI, ListBlockCollection, ".subject", "")
+	if err != nil {
+		return 0, err
+	}
+	return page.Total, nil
+}
+
+// Links pages through every record in collection that links to target at
+// path, passing each page to fn as it arrives
+func (c *Client) Links(ctx context.Context, target, collection, path string, fn func([]LinkingRecord) error) error {
+	cursor := ""
+	seen := make(map[string]bool)
+
+	for {
+		page, err := c.linksPage(ctx, target, collection, path, cursor)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(page.LinkingRecords); err != nil {
+			return err
+		}
+
+		// Stop if there's no next page
+		if page.Cursor == nil || *page.Cursor == "" {
+			return nil
+		}
+
+		// The cursor is undocumented; make sure a repeat can't loop forever
+		if seen[*page.Cursor] {
+			return fmt.Errorf("constellation returned cursor %q twice for %s", *page.Cursor, target)
+		}
+		seen[*page.Cursor] = true
+		cursor = *page.Cursor
+	}
+}
+
+// linksPage fetches one page of the links API, retrying transient failures
+func (c *Client) linksPage(ctx context.Context, target, collection, path, cursor string) (*linksResponse, error) {
+	params := url.Values{}
+	params.Set("target", target)
+	params.Set("collection", collection)
+	params.Set("path", path)
+	params.Set("limit", strconv.Itoa(c.PageSize))
+	if cursor != "" {
+		params.Set("cursor", cursor)
+	}
+	endpoint := c.BaseURL + "/links?" + params.Encode()
+
+	var lastErr error
+	for attempt := 0; attempt <= c.RetryConfig.MaxRetries; attempt++ {
+		if attempt > 0 {
+			wait := time.Duration(float64(c.RetryConfig.BaseWait) * math.Pow(2, float64(attempt-1)))
+			fmt.Printf("Constellation request failed (attempt %d/%d): %v. Waiting %v...\n", attempt, c.RetryConfig.MaxRetries, lastErr, wait)
+			if err := c.sleep(ctx, wait); err != nil {
+				return nil, err
+			}
+		}
+
+		page, retry, err := c.get(ctx, endpoint)
+		if err == nil {
+			return page, nil
+		}
+		if !retry {
+			return nil, err
+		}
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("failed to query constellation after %d retries: %w", c.RetryConfig.MaxRetries, lastErr)
+}
+
+// get makes a single request, reporting whether a failure is worth retrying
+func (c *Client) get(ctx context.Context, endpoint string) (*linksResponse, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.UserAgent)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, ctx.Err() == nil, fmt.Errorf("failed to query constellation: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to read constellation response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return nil, retry, fmt.Errorf("constellation returned %s: %s", resp.Status, body)
+	}
+
+	var page linksResponse
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, false, fmt.Errorf("failed to parse constellation response: %w", err)
+	}
+
+	return &page, false, nil
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
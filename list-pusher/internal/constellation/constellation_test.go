@@ -0,0 +1,167 @@
+package constellation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testList = "at://did:plc:2bij7yypmcuvwyz4gyqwtluy/app.bsky.graph.list/3lbxfscjqno2d"
+
+// fixturePages maps the cursor a request carries to the recorded response for it
+var fixturePages = map[string]string{
+	"": "testdata/links-page-1.json",
+	"3lc2kyddvmq2o::did:plc:jfhpnnst6flqway4eaeqzj2a": "testdata/links-page-2.json",
+	"3ldhqxmivfs2y::did:web:example.com":              "testdata/links-page-3.json",
+}
+
+// fakeConstellation serves the recorded pages, failing the first failures
+// requests with status
+type fakeConstellation struct {
+	t        *testing.T
+	failures int
+	status   int
+	requests int
+}
+
+func (f *fakeConstellation) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests++
+
+	if r.URL.Path != "/links" {
+		f.t.Errorf("unexpected path %s", r.URL.Path)
+	}
+	query := r.URL.Query()
+	if query.Get("target") != testList || query.Get("collection") != ListBlockCollection || query.Get("path") != ".subject" {
+		f.t.Errorf("unexpected query %s", r.URL.RawQuery)
+	}
+
+	if f.failures > 0 {
+		f.failures--
+		http.Error(w, "upstream unavailable", f.status)
+		return
+	}
+
+	fixture, ok := fixturePages[query.Get("cursor")]
+	if !ok {
+		http.Error(w, "bad cursor", http.StatusBadRequest)
+		return
+	}
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func newTestClient(t *testing.T, fake *fakeConstellation) (*Client, *[]time.Duration) {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	var waits []time.Duration
+	client := NewClient(server.URL)
+	client.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	return client, &waits
+}
+
+func TestListSubscribersPagesToTheEnd(t *testing.T) {
+	fake := &fakeConstellation{t: t}
+	client, _ := newTestClient(t, fake)
+
+	subscribers, err := client.ListSubscribers(context.Background(), testList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Subscriber{
+		{DID: "did:plc:a2cm7qv3xgbk4ufmmt7dwkbe", RecordURI: "at://did:plc:a2cm7qv3xgbk4ufmmt7dwkbe/app.bsky.graph.listblock/3lbz4ahbeoy2c"},
+		{DID: "did:plc:jfhpnnst6flqway4eaeqzj2a", RecordURI: "at://did:plc:jfhpnnst6flqway4eaeqzj2a/app.bsky.graph.listblock/3lc2kyddvmq2o"},
+		{DID: "did:plc:ragtjsm2j2vknwkz3zp4oxrd", RecordURI: "at://did:plc:ragtjsm2j2vknwkz3zp4oxrd/app.bsky.graph.listblock/3ld6rdgcmnc2e"},
+		{DID: "did:web:example.com", RecordURI: "at://did:web:example.com/app.bsky.graph.listblock/3ldhqxmivfs2y"},
+		{DID: "did:plc:z72i7hdynmk6r22z27h6tvur", RecordURI: "at://did:plc:z72i7hdynmk6r22z27h6tvur/app.bsky.graph.listblock/3lfkbzwz3wk2x"},
+	}
+	if !reflect.DeepEqual(subscribers, want) {
+		t.Errorf("got %v\nwant %v", subscribers, want)
+	}
+	if fake.requests != 3 {
+		t.Errorf("expected 3 requests, got %d", fake.requests)
+	}
+}
+
+func TestListSubscribersRetriesServerErrors(t *testing.T) {
+	fake := &fakeConstellation{t: t, failures: 2, status: http.StatusBadGateway}
+	client, waits := newTestClient(t, fake)
+
+	subscribers, err := client.ListSubscribers(context.Background(), testList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscribers) != 5 {
+		t.Errorf("expected 5 subscribers, got %d", len(subscribers))
+	}
+
+	want := []time.Duration{2 * time.Second, 4 * time.Second}
+	if !reflect.DeepEqual(*waits, want) {
+		t.Errorf("got waits %v, want %v", *waits, want)
+	}
+}
+
+func TestListSubscribersGivesUp(t *testing.T) {
+	fake := &fakeConstellation{t: t, failures: 100, status: http.StatusTooManyRequests}
+	client, waits := newTestClient(t, fake)
+	client.RetryConfig.MaxRetries = 2
+
+	if _, err := client.ListSubscribers(context.Background(), testList); err == nil {
+		t.Fatal("expected an error")
+	}
+	if fake.requests != 3 || len(*waits) != 2 {
+		t.Errorf("expected 3 requests and 2 waits, got %d and %d", fake.requests, len(*waits))
+	}
+}
+
+func TestListSubscribersDoesNotRetryClientErrors(t *testing.T) {
+	fake := &fakeConstellation{t: t, failures: 1, status: http.StatusBadRequest}
+	client, waits := newTestClient(t, fake)
+
+	_, err := client.ListSubscribers(context.Background(), testList)
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("expected a 400 error, got %v", err)
+	}
+	if len(*waits) != 0 {
+		t.Errorf("expected no retries, got %v", *waits)
+	}
+}
+
+func TestLinksStopsOnRepeatedCursor(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"total":1,"linking_records":[],"cursor":"same"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	err := client.Links(context.Background(), testList, ListBlockCollection, ".subject", func([]LinkingRecord) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "twice") {
+		t.Fatalf("expected a repeated cursor error, got %v", err)
+	}
+}
+
+func TestCountSubscribers(t *testing.T) {
+	fake := &fakeConstellation{t: t}
+	client, _ := newTestClient(t, fake)
+
+	count, err := client.CountSubscribers(context.Background(), testList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 || fake.requests != 1 {
+		t.Errorf("expected 5 from 1 request, got %d from %d", count, fake.requests)
+	}
+}
@@ -0,0 +1,224 @@
+// Package discovery looks for blocklists we might want to track: it pages
+// through the lists made by the owners of our source lists and other related
+// accounts, and scores each one's name and description against keyword patterns.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/lex/util"
+)
+
+// modlistPurpose is the only list purpose that can be subscribed to as a blocklist
+const modlistPurpose = "app.bsky.graph.defs#modlist"
+
+// Pattern is a regular expression worth Weight points when it matches a
+// list's name or description
+type Pattern struct {
+	Pattern string `toml:"pattern"`
+	Weight  int    `toml:"weight"`
+
+	re *regexp.Regexp
+}
+
+// Config is discovery.toml
+type Config struct {
+	// MinScore is the lowest score that makes a list a candidate
+	MinScore int `toml:"min_score"`
+	// Accounts are searched as well as the owners of our source lists
+	Accounts []string  `toml:"accounts"`
+	Patterns []Pattern `toml:"patterns"`
+}
+
+// LoadConfig reads and compiles the discovery config at path. Patterns
+// without a weight are worth 1.
+func LoadConfig(path string) (*Config, error) {
+	var config Config
+	if _, err := toml.DecodeFile(path, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse TOML file %s: %w", path, err)
+	}
+	if err := config.compile(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+func (c *Config) compile() error {
+	if len(c.Patterns) == 0 {
+		return fmt.Errorf("no patterns")
+	}
+	for i := range c.Patterns {
+		re, err := regexp.Compile(c.Patterns[i].Pattern)
+		if err != nil {
+			return fmt.Errorf("pattern %q: %w", c.Patterns[i].Pattern, err)
+		}
+		c.Patterns[i].re = re
+		if c.Patterns[i].Weight == 0 {
+			c.Patterns[i].Weight = 1
+		}
+	}
+	return nil
+}
+
+// Score adds up the weights of the patterns matching name or description,
+// each counted once, and returns which ones matched
+func (c *Config) Score(name, description string) (int, []string) {
+	var score int
+	var matched []string
+	for _, p := range c.Patterns {
+		if p.re.MatchString(name) || p.re.MatchString(description) {
+			score += p.Weight
+			matched = append(matched, p.Pattern)
+		}
+	}
+	return score, matched
+}
+
+// Candidate is a list waiting for review. ListItems and Subscribers are nil
+// when unknown.
+type Candidate struct {
+	URI         string   `json:"uri"`
+	Owner       string   `json:"owner"`
+	OwnerHandle string   `json:"owner_handle"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	ListItems   *int64   `json:"list_items"`
+	Subscribers *int     `json:"subscribers"`
+	Score       int      `json:"score"`
+	Matched     []string `json:"matched"`
+}
+
+// SubscriberCounter counts a list's subscribers, as constellation.Client does
+type SubscriberCounter interface {
+	CountSubscribers(ctx context.Context, listURI string) (int, error)
+}
+
+// Finder searches accounts' lists for candidates
+type Finder struct {
+	config *Config
+	client util.LexClient
+	// counter is optional; without it subscriber counts are left unknown
+	counter SubscriberCounter
+}
+
+// NewFinder creates a finder that reads lists through client and counts
+// subscribers with counter, if it is not nil
+func NewFinder(config *Config, client util.LexClient, counter SubscriberCounter) *Finder {
+	return &Finder{config: config, client: client, counter: counter}
+}
+
+// Discover returns the moderation lists owned by owners that score at least
+// MinScore and are not in known, best first
+func (f *Finder) Discover(ctx context.Context, owners []string, known map[string]bool) ([]Candidate, error) {
+	var candidates []Candidate
+	seen := make(map[string]bool)
+
+	for i, owner := range owners {
+		fmt.Printf("Searching lists %d/%d: %s\n", i+1, len(owners), owner)
+		lists, err := f.lists(ctx, owner)
+		if err != nil {
+			fmt.Printf("✗ %v (skipping)\n", err)
+			continue
+		}
+
+		var found int
+		for _, list := range lists {
+			if known[list.Uri] || seen[list.Uri] {
+				continue
+			}
+			seen[list.Uri] = true
+
+			candidate, ok := f.candidate(list)
+			if !ok {
+				continue
+			}
+			if f.counter != nil {
+				count, err := f.counter.CountSubscribers(ctx, list.Uri)
+				if err != nil {
+					fmt.Printf("Warning: failed to count subscribers of %s: %v\n", list.Uri, err)
+				} else {
+					candidate.Subscribers = &count
+				}
+			}
+			candidates = append(candidates, candidate)
+			found++
+		}
+		fmt.Printf("  %d lists, %d new candidates\n", len(lists), found)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return subscribers(candidates[i]) > subscribers(candidates[j])
+	})
+	return candidates, nil
+}
+
+// candidate scores a list, reporting whether it qualifies
+func (f *Finder) candidate(list *bsky.GraphDefs_ListView) (Candidate, bool) {
+	var description string
+	if list.Description != nil {
+		description = *list.Description
+	}
+
+	score, matched := f.config.Score(list.Name, description)
+	if len(matched) == 0 || score < f.config.MinScore {
+		return Candidate{}, false
+	}
+
+	candidate := Candidate{
+		URI:         list.Uri,
+		Name:        list.Name,
+		Description: description,
+		ListItems:   list.ListItemCount,
+		Score:       score,
+		Matched:     matched,
+	}
+	if list.Creator != nil {
+		candidate.Owner = list.Creator.Did
+		candidate.OwnerHandle = list.Creator.Handle
+	}
+	return candidate, true
+}
+
+// lists pages through every moderation list actor has made
+func (f *Finder) lists(ctx context.Context, actor string) ([]*bsky.GraphDefs_ListView, error) {
+	var lists []*bsky.GraphDefs_ListView
+	cursor := ""
+
+	for {
+		resp, err := bsky.GraphGetLists(ctx, f.client, actor, cursor, 100, []string{"modlist"})
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch lists of %s: %w", actor, err)
+		}
+		for _, list := range resp.Lists {
+			// Older AppViews ignore the purposes filter
+			if list.Purpose == nil || *list.Purpose == modlistPurpose {
+				lists = append(lists, list)
+			}
+		}
+
+		// Stop if there's no next page
+		if resp.Cursor == nil || *resp.Cursor == "" {
+			return lists, nil
+		}
+		cursor = *resp.Cursor
+
+		// Add a small delay to avoid rate limiting
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func subscribers(c Candidate) int {
+	if c.Subscribers == nil {
+		return -1
+	}
+	return *c.Subscribers
+}
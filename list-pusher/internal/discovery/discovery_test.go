@@ -0,0 +1,132 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+const testConfig = `
+min_score = 3
+
+[[patterns]]
+pattern = '(?i)\bai\b'
+weight = 2
+
+[[patterns]]
+pattern = '(?i)\bai[ -]?(art|bros?)\b'
+weight = 3
+
+[[patterns]]
+pattern = '(?i)\bblock\b'
+`
+
+func loadTestConfig(t *testing.T) *Config {
+	path := filepath.Join(t.TempDir(), "discovery.toml")
+	if err := os.WriteFile(path, []byte(testConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestScore(t *testing.T) {
+	config := loadTestConfig(t)
+
+	tests := []struct {
+		name, description string
+		score             int
+	}{
+		{"AI bros", "", 5},
+		{"AI art block list", "", 6},
+		{"Block these", "people who post AI", 3},
+		{"Said", "nothing relevant here", 0},
+		{"Mountain climbing", "bonsai, wasabi, aikido", 0},
+	}
+	for _, test := range tests {
+		if score, _ := config.Score(test.name, test.description); score != test.score {
+			t.Errorf("Score(%q, %q) = %v, want %v", test.name, test.description, score, test.score)
+		}
+	}
+}
+
+func TestLoadConfigRejectsBadPatterns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "discovery.toml")
+	os.WriteFile(path, []byte("[[patterns]]\npattern = '(unclosed'\n"), 0o644)
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+// fakeCounter returns a fixed subscriber count per list
+type fakeCounter map[string]int
+
+func (f fakeCounter) CountSubscribers(ctx context.Context, listURI string) (int, error) {
+	return f[listURI], nil
+}
+
+func listView(uri, owner, name, description string, items int64) map[string]any {
+	return map[string]any{
+		"uri":           uri,
+		"cid":           "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm",
+		"name":          name,
+		"description":   description,
+		"purpose":       modlistPurpose,
+		"listItemCount": items,
+		"indexedAt":     "2024-11-20T00:00:00Z",
+		"creator":       map[string]any{"did": owner, "handle": "owner.example.com"},
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	const owner = "did:plc:owner"
+	known := "at://did:plc:owner/app.bsky.graph.list/3known"
+	aiBros := "at://did:plc:owner/app.bsky.graph.list/3aibros"
+	aiArt := "at://did:plc:owner/app.bsky.graph.list/3aiart"
+
+	// Two pages of lists
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/xrpc/app.bsky.graph.getLists" || r.URL.Query().Get("actor") != owner {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		var page map[string]any
+		if r.URL.Query().Get("cursor") == "" {
+			page = map[string]any{"cursor": "next", "lists": []any{
+				listView(known, owner, "AI bros", "", 10),
+				listView(aiBros, owner, "AI bros 2", "", 20),
+				listView("at://did:plc:owner/app.bsky.graph.list/3hike", owner, "Hiking", "", 30),
+			}}
+		} else {
+			page = map[string]any{"lists": []any{listView(aiArt, owner, "AI art", "block them", 40)}}
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer server.Close()
+
+	finder := NewFinder(loadTestConfig(t), &xrpc.Client{Host: server.URL}, fakeCounter{aiBros: 7, aiArt: 3})
+	candidates, err := finder.Discover(context.Background(), []string{owner}, map[string]bool{known: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, c := range candidates {
+		got = append(got, c.URI)
+	}
+	if want := []string{aiArt, aiBros}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got candidates %v, want %v", got, want)
+	}
+	if c := candidates[1]; c.Score != 5 || *c.Subscribers != 7 || *c.ListItems != 20 || c.OwnerHandle != "owner.example.com" {
+		t.Errorf("unexpected candidate %+v", c)
+	}
+}
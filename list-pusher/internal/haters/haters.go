@@ -0,0 +1,269 @@
+// Package haters reads and writes processed_haters.json: for every DID, the
+// source lists it subscribes to and when Clearsky says it subscribed. Files
+// written by process-haters.py are a bare {did: [[list_url, date_added], ...]}
+// map and are read as version 0; Save writes the current, versioned format.
+package haters
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"list-pusher/internal/identifier"
+)
+
+// Version is the format Save writes and the newest Load understands
+const Version = 1
+
+// Subscription is one source list a DID subscribes to. DateAdded is nil when
+// Clearsky did not say.
+type Subscription struct {
+	ListURI   string     `json:"list_uri"`
+	DateAdded *time.Time `json:"date_added,omitempty"`
+}
+
+// File is the contents of processed_haters.json
+type File struct {
+	Version int                       `json:"version"`
+	Haters  map[string][]Subscription `json:"haters"`
+}
+
+// DIDs returns every DID in the file, sorted
+func (f *File) DIDs() []string {
+	dids := make([]string, 0, len(f.Haters))
+	for did := range f.Haters {
+		dids = append(dids, did)
+	}
+	sort.Strings(dids)
+	return dids
+}
+
+// ListURIs returns every list the file mentions, sorted
+func (f *File) ListURIs() []string {
+	seen := make(map[string]bool)
+	var uris []string
+	for _, subs := range f.Haters {
+		for _, sub := range subs {
+			if !seen[sub.ListURI] {
+				seen[sub.ListURI] = true
+				uris = append(uris, sub.ListURI)
+			}
+		}
+	}
+	sort.Strings(uris)
+	return uris
+}
+
+// Save writes the file at path in the current format
+func (f *File) Save(path string) error {
+	out := File{Version: Version, Haters: f.Haters}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Problem is something wrong with one DID or entry. Malformed DIDs and
+// unusable list URLs are dropped; unknown lists and bad dates are kept.
+type Problem struct {
+	DID     string
+	ListURL string
+	Err     error
+}
+
+func (p Problem) String() string {
+	if p.ListURL == "" {
+		return fmt.Sprintf("%s: %v", p.DID, p.Err)
+	}
+	return fmt.Sprintf("%s on %s: %v", p.DID, p.ListURL, p.Err)
+}
+
+// LoadOptions controls how Load checks a file
+type LoadOptions struct {
+	// Resolve resolves the handles in version 0 list URLs; without it only
+	// URLs naming the owner by DID can be used
+	Resolve identifier.HandleResolver
+	// Known, if set, holds the list AT-URIs we track; entries for other lists
+	// are reported
+	Known map[string]bool
+}
+
+// Load reads and validates the file at path, in any supported version
+func Load(ctx context.Context, path string, opts LoadOptions) (*File, []Problem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse JSON from %s: %w", path, err)
+	}
+
+	l := loader{ctx: ctx, opts: opts, listURIs: make(map[string]listResult)}
+	var file *File
+	if _, versioned := top["version"]; versioned {
+		file, err = l.versioned(data)
+	} else {
+		file, err = l.legacy(top)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	sort.SliceStable(l.problems, func(i, j int) bool { return l.problems[i].DID < l.problems[j].DID })
+	return file, l.problems, nil
+}
+
+// listResult is a list URL's normalized AT-URI, or why it has none
+type listResult struct {
+	uri string
+	err error
+}
+
+// loader validates one file, collecting its problems
+type loader struct {
+	ctx      context.Context
+	opts     LoadOptions
+	listURIs map[string]listResult
+	problems []Problem
+}
+
+func (l *loader) problem(did, listURL string, err error) {
+	l.problems = append(l.problems, Problem{DID: did, ListURL: listURL, Err: err})
+}
+
+// legacy reads process-haters.py's {did: [[list_url, date_added], ...]}
+func (l *loader) legacy(top map[string]json.RawMessage) (*File, error) {
+	file := &File{Version: 0, Haters: make(map[string][]Subscription, len(top))}
+
+	for did, raw := range top {
+		var pairs [][]*string
+		if err := json.Unmarshal(raw, &pairs); err != nil {
+			l.problem(did, "", fmt.Errorf("expected a list of [list_url, date_added] pairs"))
+			continue
+		}
+
+		var subs []Subscription
+		for _, pair := range pairs {
+			if len(pair) != 2 || pair[0] == nil {
+				l.problem(did, "", fmt.Errorf("expected [list_url, date_added], got %d values", len(pair)))
+				continue
+			}
+			sub := Subscription{ListURI: *pair[0]}
+			if pair[1] != nil && *pair[1] != "" {
+				added, err := ParseDate(*pair[1])
+				if err != nil {
+					l.problem(did, sub.ListURI, err)
+				} else {
+					sub.DateAdded = &added
+				}
+			}
+			subs = append(subs, sub)
+		}
+		l.add(file, did, subs)
+	}
+
+	return file, nil
+}
+
+// versioned reads the format Save writes
+func (l *loader) versioned(data []byte) (*File, error) {
+	var raw File
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	if raw.Version < 1 || raw.Version > Version {
+		return nil, fmt.Errorf("unsupported version %d (this build reads up to %d)", raw.Version, Version)
+	}
+
+	file := &File{Version: raw.Version, Haters: make(map[string][]Subscription, len(raw.Haters))}
+	for did, subs := range raw.Haters {
+		l.add(file, did, subs)
+	}
+	return file, nil
+}
+
+// add validates did and its subscriptions, putting what is usable into file
+func (l *loader) add(file *File, did string, subs []Subscription) {
+	if _, err := syntax.ParseDID(did); err != nil {
+		l.problem(did, "", fmt.Errorf("malformed DID: %w", err))
+		return
+	}
+
+	var kept []Subscription
+	seen := make(map[string]bool)
+	for _, sub := range subs {
+		uri, err := l.listURI(sub.ListURI)
+		if err != nil {
+			l.problem(did, sub.ListURI, err)
+			continue
+		}
+		if l.opts.Known != nil && !l.opts.Known[uri] {
+			l.problem(did, sub.ListURI, fmt.Errorf("not a tracked source list"))
+		}
+		if seen[uri] {
+			continue
+		}
+		seen[uri] = true
+		sub.ListURI = uri
+		kept = append(kept, sub)
+	}
+
+	if len(kept) > 0 {
+		file.Haters[did] = kept
+	}
+}
+
+// listURI normalizes a list URL, remembering the answer
+func (l *loader) listURI(listURL string) (string, error) {
+	if result, ok := l.listURIs[listURL]; ok {
+		return result.uri, result.err
+	}
+
+	var result listResult
+	ref, err := identifier.ParseList(listURL)
+	switch {
+	case err != nil:
+		result.err = fmt.Errorf("unusable list URL: %w", err)
+	case ref.Owner.IsDID() || l.opts.Resolve != nil:
+		result.uri, result.err = identifier.NormalizeList(l.ctx, l.opts.Resolve, listURL)
+	default:
+		result.err = fmt.Errorf("list owner %s is a handle and nothing can resolve it", ref.Owner)
+	}
+	if result.err == nil && !strings.HasPrefix(result.uri, "at://did:") {
+		result.err = fmt.Errorf("unusable list URL %s", listURL)
+	}
+
+	l.listURIs[listURL] = result
+	return result.uri, result.err
+}
+
+// dateLayouts are the date_added formats clearsky has been seen to use
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// ParseDate parses a date_added value, taking dates without a zone as UTC
+func ParseDate(s string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised date %q", s)
+}
@@ -0,0 +1,114 @@
+package haters
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+const (
+	listA = "at://did:plc:2bij7yypmcuvwyz4gyqwtluy/app.bsky.graph.list/3lbxfscjqno2d"
+	listB = "at://did:plc:owner2/app.bsky.graph.list/3lbrgpl44zp2f"
+)
+
+// legacyFile is what process-haters.py writes
+const legacyFile = `{
+  "did:plc:aaa": [
+    ["https://bsky.app/profile/did:plc:2bij7yypmcuvwyz4gyqwtluy/lists/3lbxfscjqno2d", "2024-11-27T01:02:03.456Z"],
+    ["https://bsky.app/profile/lists.example.com/lists/3lbrgpl44zp2f", "2024-11-28 10:00:00"]
+  ],
+  "did:plc:bbb": [
+    ["https://bsky.app/profile/did:plc:2bij7yypmcuvwyz4gyqwtluy/lists/3lbxfscjqno2d", null],
+    ["at://did:plc:2bij7yypmcuvwyz4gyqwtluy/app.bsky.graph.list/3lbxfscjqno2d", "yesterday"],
+    ["not a list", "2024-01-01"]
+  ],
+  "not-a-did": [["https://bsky.app/profile/did:plc:2bij7yypmcuvwyz4gyqwtluy/lists/3lbxfscjqno2d", null]]
+}`
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "processed_haters.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func resolveExample(ctx context.Context, handle syntax.Handle) (syntax.DID, error) {
+	if handle == "lists.example.com" {
+		return "did:plc:owner2", nil
+	}
+	return "", fmt.Errorf("no such handle")
+}
+
+func TestLoadLegacy(t *testing.T) {
+	path := writeFile(t, legacyFile)
+	file, problems, err := Load(context.Background(), path, LoadOptions{Resolve: resolveExample, Known: map[string]bool{listA: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added := time.Date(2024, 11, 27, 1, 2, 3, 456000000, time.UTC)
+	added2 := time.Date(2024, 11, 28, 10, 0, 0, 0, time.UTC)
+	want := map[string][]Subscription{
+		"did:plc:aaa": {{ListURI: listA, DateAdded: &added}, {ListURI: listB, DateAdded: &added2}},
+		"did:plc:bbb": {{ListURI: listA}},
+	}
+	if file.Version != 0 || !reflect.DeepEqual(file.Haters, want) {
+		t.Errorf("got %+v", file)
+	}
+
+	var got []string
+	for _, problem := range problems {
+		got = append(got, problem.String())
+	}
+	wantProblems := []string{
+		"did:plc:aaa on https://bsky.app/profile/lists.example.com/lists/3lbrgpl44zp2f: not a tracked source list",
+		"did:plc:bbb on at://did:plc:2bij7yypmcuvwyz4gyqwtluy/app.bsky.graph.list/3lbxfscjqno2d: unrecognised date \"yesterday\"",
+		"did:plc:bbb on not a list: unusable list URL",
+		"not-a-did: malformed DID",
+	}
+	if len(got) != len(wantProblems) {
+		t.Fatalf("got problems %q", got)
+	}
+	for n := range got {
+		if !strings.HasPrefix(got[n], wantProblems[n]) {
+			t.Errorf("problem %d is %q, want %q…", n, got[n], wantProblems[n])
+		}
+	}
+}
+
+func TestSaveRoundTrips(t *testing.T) {
+	ctx := context.Background()
+	file, _, err := Load(ctx, writeFile(t, legacyFile), LoadOptions{Resolve: resolveExample})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upgraded := filepath.Join(t.TempDir(), "upgraded.json")
+	if err := file.Save(upgraded); err != nil {
+		t.Fatal(err)
+	}
+
+	// The upgraded file needs no resolver: its lists are already AT-URIs
+	again, problems, err := Load(ctx, upgraded, LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Version != Version || !reflect.DeepEqual(again.Haters, file.Haters) || len(problems) != 0 {
+		t.Errorf("round trip gave %+v with problems %v", again, problems)
+	}
+}
+
+func TestLoadRejectsNewerVersions(t *testing.T) {
+	path := writeFile(t, `{"version": 99, "haters": {}}`)
+	if _, _, err := Load(context.Background(), path, LoadOptions{}); err == nil || !strings.Contains(err.Error(), "version 99") {
+		t.Errorf("expected an unsupported version error, got %v", err)
+	}
+}
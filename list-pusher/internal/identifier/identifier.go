@@ -0,0 +1,290 @@
+// Package identifier turns the many ways people write down Bluesky lists and
+// accounts into canonical AT-URIs and DIDs. It accepts bsky.app list and
+// profile URLs, clearsky URLs, AT-URIs, raw DIDs and handles (with or without
+// a leading @), resolving handles to DIDs where needed.
+package identifier
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/lex/util"
+)
+
+// ListCollection is the collection list records live in
+const ListCollection = "app.bsky.graph.list"
+
+// HandleResolver resolves a handle to the DID it belongs to
+type HandleResolver func(ctx context.Context, handle syntax.Handle) (syntax.DID, error)
+
+// DirectoryResolver resolves handles through DNS and .well-known, without
+// needing a session
+func DirectoryResolver() HandleResolver {
+	dir := identity.DefaultDirectory()
+	return func(ctx context.Context, handle syntax.Handle) (syntax.DID, error) {
+		ident, err := dir.LookupHandle(ctx, handle)
+		if err != nil {
+			return "", err
+		}
+		return ident.DID, nil
+	}
+}
+
+// XRPCResolver resolves handles with com.atproto.identity.resolveHandle on client
+func XRPCResolver(client util.LexClient) HandleResolver {
+	return func(ctx context.Context, handle syntax.Handle) (syntax.DID, error) {
+		resp, err := atproto.IdentityResolveHandle(ctx, client, handle.String())
+		if err != nil {
+			return "", err
+		}
+		return syntax.ParseDID(resp.Did)
+	}
+}
+
+// OfflineResolver refuses to resolve handles, for commands that must not
+// touch the network. DIDs still work.
+func OfflineResolver() HandleResolver {
+	return func(_ context.Context, handle syntax.Handle) (syntax.DID, error) {
+		return "", fmt.Errorf("can't resolve handle %s offline, give the DID instead", handle)
+	}
+}
+
+// ListRef is a list as written, before its owner's handle is resolved
+type ListRef struct {
+	Owner     syntax.AtIdentifier
+	RecordKey syntax.RecordKey
+}
+
+// ParseList parses a list reference without touching the network. It accepts
+// at://<owner>/app.bsky.graph.list/<rkey>, https://bsky.app/profile/<owner>/lists/<rkey>
+// and clearsky URLs that contain an owner followed by a list record key.
+func ParseList(raw string) (ListRef, error) {
+	s := strings.TrimSpace(raw)
+
+	if strings.HasPrefix(s, "at://") {
+		aturi, err := syntax.ParseATURI(s)
+		if err != nil {
+			return ListRef{}, fmt.Errorf("invalid AT-URI %s: %w", raw, err)
+		}
+		if aturi.Collection() != ListCollection || aturi.RecordKey() == "" {
+			return ListRef{}, fmt.Errorf("%s is not a list AT-URI (want at://<did>/%s/<rkey>)", raw, ListCollection)
+		}
+		return ListRef{Owner: aturi.Authority(), RecordKey: aturi.RecordKey()}, nil
+	}
+
+	segments, host, err := urlSegments(s)
+	if err != nil {
+		return ListRef{}, fmt.Errorf("unrecognised list %s: %w", raw, err)
+	}
+
+	switch {
+	case isBskyApp(host):
+		// /profile/<owner>/lists/<rkey>
+		if len(segments) >= 4 && segments[0] == "profile" && segments[2] == "lists" {
+			return newListRef(raw, segments[1], segments[3])
+		}
+	case isClearsky(host):
+		// Clearsky puts the owner and list rkey next to each other somewhere in the path
+		for i := 0; i+1 < len(segments); i++ {
+			if _, err := syntax.ParseAtIdentifier(segments[i]); err != nil {
+				continue
+			}
+			if _, err := syntax.ParseTID(segments[i+1]); err != nil {
+				continue
+			}
+			return newListRef(raw, segments[i], segments[i+1])
+		}
+	}
+
+	return ListRef{}, fmt.Errorf("unrecognised list %s (want an AT-URI or a bsky.app list URL)", raw)
+}
+
+func newListRef(raw, owner, rkey string) (ListRef, error) {
+	atid, err := syntax.ParseAtIdentifier(owner)
+	if err != nil {
+		return ListRef{}, fmt.Errorf("invalid list owner in %s: %w", raw, err)
+	}
+	key, err := syntax.ParseRecordKey(rkey)
+	if err != nil {
+		return ListRef{}, fmt.Errorf("invalid list record key in %s: %w", raw, err)
+	}
+	return ListRef{Owner: *atid, RecordKey: key}, nil
+}
+
+// NormalizeList returns the canonical at://<did>/app.bsky.graph.list/<rkey>
+// form of a list, resolving the owner's handle if it has one
+func NormalizeList(ctx context.Context, resolve HandleResolver, raw string) (string, error) {
+	ref, err := ParseList(raw)
+	if err != nil {
+		return "", err
+	}
+
+	did, err := ownerDID(ctx, resolve, ref.Owner)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("at://%s/%s/%s", did, ListCollection, ref.RecordKey), nil
+}
+
+// ParseAccount parses an account reference without touching the network. It
+// accepts DIDs, handles, @handles, at://<owner> URIs, bsky.app profile URLs
+// and clearsky profile URLs.
+func ParseAccount(raw string) (syntax.AtIdentifier, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(raw), "@")
+
+	if strings.HasPrefix(s, "at://") {
+		aturi, err := syntax.ParseATURI(s)
+		if err != nil {
+			return syntax.AtIdentifier{}, fmt.Errorf("invalid AT-URI %s: %w", raw, err)
+		}
+		return aturi.Authority(), nil
+	}
+
+	if !strings.Contains(s, "/") {
+		atid, err := syntax.ParseAtIdentifier(s)
+		if err != nil {
+			return syntax.AtIdentifier{}, fmt.Errorf("invalid handle or DID %s: %w", raw, err)
+		}
+		return *atid, nil
+	}
+
+	segments, host, err := urlSegments(s)
+	if err != nil {
+		return syntax.AtIdentifier{}, fmt.Errorf("unrecognised account %s: %w", raw, err)
+	}
+
+	switch {
+	case isBskyApp(host):
+		// /profile/<account>[/...]
+		if len(segments) >= 2 && segments[0] == "profile" {
+			atid, err := syntax.ParseAtIdentifier(segments[1])
+			if err != nil {
+				return syntax.AtIdentifier{}, fmt.Errorf("invalid account in %s: %w", raw, err)
+			}
+			return *atid, nil
+		}
+	case isClearsky(host):
+		// The first path segment that is a handle or DID is the account
+		for _, segment := range segments {
+			if atid, err := syntax.ParseAtIdentifier(segment); err == nil {
+				return *atid, nil
+			}
+		}
+	}
+
+	return syntax.AtIdentifier{}, fmt.Errorf("unrecognised account %s (want a handle, DID or bsky.app profile URL)", raw)
+}
+
+// NormalizeAccount returns the DID of an account, resolving its handle if needed
+func NormalizeAccount(ctx context.Context, resolve HandleResolver, raw string) (string, error) {
+	atid, err := ParseAccount(raw)
+	if err != nil {
+		return "", err
+	}
+
+	did, err := ownerDID(ctx, resolve, atid)
+	if err != nil {
+		return "", err
+	}
+
+	return did.String(), nil
+}
+
+// ReadListFile reads a file of lists, one per line in any form ParseList
+// accepts, skipping blank lines and # comments. It returns the canonical
+// AT-URIs in file order without duplicates.
+func ReadListFile(ctx context.Context, resolve HandleResolver, path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open list file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var lists []string
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		listURI, err := NormalizeList(ctx, resolve, text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if !seen[listURI] {
+			seen[listURI] = true
+			lists = append(lists, listURI)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read list file %s: %w", path, err)
+	}
+
+	return lists, nil
+}
+
+// ownerDID returns atid as a DID, resolving it if it is a handle
+func ownerDID(ctx context.Context, resolve HandleResolver, atid syntax.AtIdentifier) (syntax.DID, error) {
+	if did, err := atid.AsDID(); err == nil {
+		return did, nil
+	}
+
+	handle, err := atid.AsHandle()
+	if err != nil {
+		return "", err
+	}
+
+	did, err := resolve(ctx, handle.Normalize())
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve handle %s: %w", handle, err)
+	}
+
+	return did, nil
+}
+
+// urlSegments splits an http(s) URL into its host and unescaped path segments
+func urlSegments(s string) ([]string, string, error) {
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, "", fmt.Errorf("unsupported scheme %s", u.Scheme)
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	return segments, strings.ToLower(u.Hostname()), nil
+}
+
+func isBskyApp(host string) bool {
+	return host == "bsky.app" || strings.HasSuffix(host, ".bsky.app")
+}
+
+func isClearsky(host string) bool {
+	return host == "clearsky.app" || strings.HasSuffix(host, ".clearsky.app") ||
+		host == "clearsky.services" || strings.HasSuffix(host, ".clearsky.services")
+}
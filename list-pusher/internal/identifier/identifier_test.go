@@ -0,0 +1,124 @@
+package identifier
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+const (
+	testOwner = "did:plc:2bij7yypmcuvwyz4gyqwtluy"
+	canonical = "at://did:plc:2bij7yypmcuvwyz4gyqwtluy/app.bsky.graph.list/3lbxfscjqno2d"
+)
+
+// fakeResolver knows a single handle
+func fakeResolver(ctx context.Context, handle syntax.Handle) (syntax.DID, error) {
+	if handle == "lists.example.com" {
+		return testOwner, nil
+	}
+	return "", fmt.Errorf("unknown handle %s", handle)
+}
+
+func TestNormalizeList(t *testing.T) {
+	inputs := []string{
+		canonical,
+		"  " + canonical + "  ",
+		"at://lists.example.com/app.bsky.graph.list/3lbxfscjqno2d",
+		"https://bsky.app/profile/did:plc:2bij7yypmcuvwyz4gyqwtluy/lists/3lbxfscjqno2d",
+		"https://bsky.app/profile/Lists.Example.com/lists/3lbxfscjqno2d",
+		"bsky.app/profile/lists.example.com/lists/3lbxfscjqno2d?foo=bar",
+		"https://clearsky.app/did:plc:2bij7yypmcuvwyz4gyqwtluy/3lbxfscjqno2d/1",
+		"https://api.clearsky.services/api/v1/anon/get-list/specific/did:plc:2bij7yypmcuvwyz4gyqwtluy/3lbxfscjqno2d/1",
+	}
+
+	for _, input := range inputs {
+		got, err := NormalizeList(context.Background(), fakeResolver, input)
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+			continue
+		}
+		if got != canonical {
+			t.Errorf("%q: got %s", input, got)
+		}
+	}
+}
+
+func TestNormalizeListRejects(t *testing.T) {
+	inputs := []string{
+		"",
+		"at://did:plc:2bij7yypmcuvwyz4gyqwtluy/app.bsky.feed.post/3lbxfscjqno2d",
+		"at://did:plc:2bij7yypmcuvwyz4gyqwtluy",
+		"https://bsky.app/profile/did:plc:2bij7yypmcuvwyz4gyqwtluy",
+		"https://example.com/profile/did:plc:2bij7yypmcuvwyz4gyqwtluy/lists/3lbxfscjqno2d",
+		"https://bsky.app/profile/unknown.example.com/lists/3lbxfscjqno2d",
+		"https://notclearsky.app/did:plc:2bij7yypmcuvwyz4gyqwtluy/3lbxfscjqno2d/1",
+		"https://evil-clearsky.services/did:plc:2bij7yypmcuvwyz4gyqwtluy/3lbxfscjqno2d/1",
+	}
+
+	for _, input := range inputs {
+		if got, err := NormalizeList(context.Background(), fakeResolver, input); err == nil {
+			t.Errorf("%q: expected an error, got %s", input, got)
+		}
+	}
+}
+
+func TestNormalizeAccount(t *testing.T) {
+	inputs := []string{
+		testOwner,
+		"lists.example.com",
+		"@lists.example.com",
+		"at://did:plc:2bij7yypmcuvwyz4gyqwtluy",
+		"https://bsky.app/profile/lists.example.com",
+		"https://bsky.app/profile/did:plc:2bij7yypmcuvwyz4gyqwtluy/post/3lbxfscjqno2d",
+		"https://clearsky.app/lists.example.com/blocked-by",
+	}
+
+	for _, input := range inputs {
+		got, err := NormalizeAccount(context.Background(), fakeResolver, input)
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+			continue
+		}
+		if got != testOwner {
+			t.Errorf("%q: got %s", input, got)
+		}
+	}
+}
+
+func TestOfflineResolver(t *testing.T) {
+	ctx := context.Background()
+	if got, err := NormalizeAccount(ctx, OfflineResolver(), "https://bsky.app/profile/"+testOwner); err != nil || got != testOwner {
+		t.Errorf("got %s, %v for a DID", got, err)
+	}
+	if got, err := NormalizeList(ctx, OfflineResolver(), canonical); err != nil || got != canonical {
+		t.Errorf("got %s, %v for a list AT-URI", got, err)
+	}
+	if got, err := NormalizeAccount(ctx, OfflineResolver(), "lists.example.com"); err == nil || !strings.Contains(err.Error(), "offline") {
+		t.Errorf("got %s, %v for a handle, want an error", got, err)
+	}
+}
+
+func TestReadListFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lists.txt")
+	contents := "# source lists\n" + canonical + "\n\nhttps://bsky.app/profile/lists.example.com/lists/3lbxfscjqno2d\n" +
+		"https://bsky.app/profile/did:plc:vp3irkl7h5mcc55ny7fdxa33/lists/3lbrgpl44zp2f\n"
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	lists, err := ReadListFile(context.Background(), fakeResolver, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{canonical, "at://did:plc:vp3irkl7h5mcc55ny7fdxa33/app.bsky.graph.list/3lbrgpl44zp2f"}
+	if !reflect.DeepEqual(lists, want) {
+		t.Errorf("got %v, want %v", lists, want)
+	}
+}
@@ -0,0 +1,161 @@
+// Package jetstream subscribes to a Jetstream instance, the JSON re-encoding
+// of the atproto firehose, and hands its events to the caller. Streams
+// reconnect on their own and resume from the last event received.
+package jetstream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultEndpoint is the public Jetstream instance the Python tailer used
+const DefaultEndpoint = "wss://jetstream2.us-west.bsky.network/subscribe"
+
+// Event kinds
+const (
+	KindCommit   = "commit"
+	KindIdentity = "identity"
+	KindAccount  = "account"
+)
+
+// ListBlockCollection holds the records that subscribe an account to a blocklist
+const ListBlockCollection = "app.bsky.graph.listblock"
+
+// Commit operations
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// Event is one message from Jetstream
+type Event struct {
+	DID    string  `json:"did"`
+	TimeUS int64   `json:"time_us"`
+	Kind   string  `json:"kind"`
+	Commit *Commit `json:"commit,omitempty"`
+}
+
+// Commit is a record operation. Deletes carry no record.
+type Commit struct {
+	Rev        string          `json:"rev"`
+	Operation  string          `json:"operation"`
+	Collection string          `json:"collection"`
+	RecordKey  string          `json:"rkey"`
+	Record     json.RawMessage `json:"record,omitempty"`
+	CID        string          `json:"cid,omitempty"`
+}
+
+// ListBlock is the part of an app.bsky.graph.listblock record we need
+type ListBlock struct {
+	Subject string `json:"subject"`
+}
+
+// SubscribeURL builds the subscription URL for collections, resuming at
+// cursor (a time_us) if it is non-zero
+func SubscribeURL(endpoint string, collections []string, cursor int64) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid jetstream endpoint %s: %w", endpoint, err)
+	}
+
+	query := u.Query()
+	for _, collection := range collections {
+		query.Add("wantedCollections", collection)
+	}
+	if cursor > 0 {
+		query.Set("cursor", strconv.FormatInt(cursor, 10))
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// Subscribe connects once and sends every event to events until the
+// connection fails or ctx is done. It returns the time_us of the last event sent.
+func Subscribe(ctx context.Context, endpoint string, collections []string, cursor int64, events chan<- *Event) (int64, error) {
+	subscribeURL, err := SubscribeURL(endpoint, collections, cursor)
+	if err != nil {
+		return cursor, err
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, subscribeURL, nil)
+	if err != nil {
+		return cursor, fmt.Errorf("failed to connect to jetstream: %w", err)
+	}
+	defer conn.Close()
+
+	// Unblock ReadMessage when we are asked to stop
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return cursor, ctx.Err()
+			}
+			return cursor, fmt.Errorf("jetstream connection failed: %w", err)
+		}
+
+		var event Event
+		if err := json.Unmarshal(message, &event); err != nil {
+			fmt.Printf("Warning: skipping unreadable jetstream message: %v\n", err)
+			continue
+		}
+
+		select {
+		case events <- &event:
+			cursor = event.TimeUS
+		case <-ctx.Done():
+			return cursor, ctx.Err()
+		}
+	}
+}
+
+// Stream subscribes until ctx is done, reconnecting with exponential backoff
+// (capped at maxWait) and resuming from the last event received. It closes
+// events when it returns.
+func Stream(ctx context.Context, endpoint string, collections []string, cursor int64, events chan<- *Event) error {
+	defer close(events)
+
+	const baseWait, maxWait = time.Second, 5 * time.Minute
+
+	failures := 0
+	for {
+		last, err := Subscribe(ctx, endpoint, collections, cursor, events)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// Any progress means the connection was healthy, so start backing off afresh
+		if last != cursor {
+			failures = 0
+		}
+		cursor = last
+		failures++
+
+		wait := min(time.Duration(float64(baseWait)*math.Pow(2, float64(failures-1))), maxWait)
+		fmt.Printf("%v. Reconnecting in %v...\n", err, wait)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package jetstream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSubscribeURL(t *testing.T) {
+	got, err := SubscribeURL(DefaultEndpoint, []string{ListBlockCollection}, 1732669323456000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultEndpoint + "?cursor=1732669323456000&wantedCollections=app.bsky.graph.listblock"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if got, _ := SubscribeURL(DefaultEndpoint, nil, 0); got != DefaultEndpoint {
+		t.Errorf("without a cursor got %s", got)
+	}
+}
+
+// fakeJetstream serves each connection the next batch of messages, then
+// drops it. It records the cursor each connection asked for.
+type fakeJetstream struct {
+	mu      sync.Mutex
+	batches [][]string
+	cursors []string
+}
+
+func (f *fakeJetstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	f.mu.Lock()
+	f.cursors = append(f.cursors, r.URL.Query().Get("cursor"))
+	var batch []string
+	if len(f.batches) > 0 {
+		batch, f.batches = f.batches[0], f.batches[1:]
+	}
+	f.mu.Unlock()
+
+	for _, message := range batch {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+			return
+		}
+	}
+	if batch == nil {
+		// Nothing left to send: hold the connection until the client goes
+		conn.ReadMessage()
+	}
+}
+
+func TestStreamResumes(t *testing.T) {
+	fake := &fakeJetstream{batches: [][]string{
+		{
+			`{"did": "did:plc:a", "time_us": 100, "kind": "commit", "commit": {"operation": "create", "collection": "app.bsky.graph.listblock", "rkey": "rka", "record": {"subject": "at://did:plc:x/app.bsky.graph.list/1"}}}`,
+			`not json`,
+			`{"did": "did:plc:b", "time_us": 200, "kind": "commit", "commit": {"operation": "delete", "collection": "app.bsky.graph.listblock", "rkey": "rkb"}}`,
+		},
+		{
+			`{"did": "did:plc:c", "time_us": 300, "kind": "identity"}`,
+		},
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	events := make(chan *Event)
+	done := make(chan error)
+	endpoint := "ws" + strings.TrimPrefix(server.URL, "http")
+	go func() { done <- Stream(ctx, endpoint, []string{ListBlockCollection}, 50, events) }()
+
+	var got []int64
+	for len(got) < 3 {
+		select {
+		case event := <-events:
+			got = append(got, event.TimeUS)
+		case <-ctx.Done():
+			t.Fatalf("timed out with events %v", got)
+		}
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("got %v, want the stream to end when cancelled", err)
+	}
+	if _, open := <-events; open {
+		t.Error("events should be closed when the stream ends")
+	}
+
+	if got[0] != 100 || got[1] != 200 || got[2] != 300 {
+		t.Errorf("got events %v", got)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.cursors) < 2 || fake.cursors[0] != "50" || fake.cursors[1] != strconv.Itoa(200) {
+		t.Errorf("reconnected with cursors %v, want 50 then the last event's 200", fake.cursors)
+	}
+}
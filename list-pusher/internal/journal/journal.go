@@ -0,0 +1,261 @@
+// Package journal keeps a record of a list push in the state store, so that a
+// crashed or interrupted run can pick up where it left off instead of paging
+// the whole list again, and so failed DIDs are remembered between runs.
+// Entries are appended as the push goes; each new run starts the journal afresh.
+package journal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"list-pusher/internal/store"
+)
+
+// Op is the kind of change made to the list
+type Op string
+
+const (
+	OpAdd    Op = "add"
+	OpRemove Op = "remove"
+)
+
+// Status is where an item stands in the push
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+// entry kinds, one per journal line
+const (
+	kindBegin   = "begin"
+	kindPlanned = "planned"
+	kindDone    = "done"
+	kindFailed  = "failed"
+	kindFinish  = "finish"
+)
+
+// entry is a single journal entry, stored as JSON
+type entry struct {
+	Kind      string    `json:"kind"`
+	Time      time.Time `json:"time"`
+	ListURI   string    `json:"list,omitempty"`
+	Op        Op        `json:"op,omitempty"`
+	DID       string    `json:"did,omitempty"`
+	RecordKey string    `json:"rkey,omitempty"`
+	RecordURI string    `json:"uri,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Item is the current state of one planned change. Additions are keyed by DID
+// and removals by record key, since a DID can be listed more than once.
+type Item struct {
+	Op        Op
+	DID       string
+	RecordKey string
+	RecordURI string
+	Status    Status
+	Attempts  int
+	LastError string
+}
+
+// Key identifies the item within its op
+func (i *Item) Key() string {
+	if i.Op == OpRemove {
+		return i.RecordKey
+	}
+	return i.DID
+}
+
+// Journal is an open push journal
+type Journal struct {
+	st       store.Store
+	listURI  string
+	finished bool
+	items    map[Op]map[string]*Item
+	order    map[Op][]string
+}
+
+// Open loads the push journal from st
+func Open(ctx context.Context, st store.Store) (*Journal, error) {
+	j := &Journal{st: st}
+	j.reset("")
+
+	entries, err := st.Journal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for n, data := range entries {
+		var e entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			fmt.Printf("Warning: ignoring unreadable journal entry %d: %v\n", n+1, err)
+			continue
+		}
+		j.apply(e)
+	}
+
+	return j, nil
+}
+
+// reset forgets everything and starts tracking a run against listURI
+func (j *Journal) reset(listURI string) {
+	j.listURI = listURI
+	j.finished = false
+	j.items = map[Op]map[string]*Item{OpAdd: {}, OpRemove: {}}
+	j.order = map[Op][]string{}
+}
+
+// apply updates the in-memory state with one journal entry
+func (j *Journal) apply(e entry) {
+	switch e.Kind {
+	case kindBegin:
+		j.reset(e.ListURI)
+	case kindFinish:
+		j.finished = true
+	case kindPlanned:
+		item := &Item{Op: e.Op, DID: e.DID, RecordKey: e.RecordKey, Status: StatusPending}
+		if _, exists := j.items[e.Op][item.Key()]; !exists {
+			j.order[e.Op] = append(j.order[e.Op], item.Key())
+		}
+		j.items[e.Op][item.Key()] = item
+	case kindDone, kindFailed:
+		item, ok := j.items[e.Op][e.key()]
+		if !ok {
+			return
+		}
+		item.Attempts++
+		if e.Kind == kindDone {
+			item.Status = StatusDone
+			item.RecordURI = e.RecordURI
+			item.LastError = ""
+		} else {
+			item.Status = StatusFailed
+			item.LastError = e.Error
+		}
+	}
+}
+
+func (e entry) key() string {
+	if e.Op == OpRemove {
+		return e.RecordKey
+	}
+	return e.DID
+}
+
+// write appends entries to the journal, replacing what it held if reset is
+// set, and applies them
+func (j *Journal) write(reset bool, entries ...entry) error {
+	now := time.Now().UTC()
+	encoded := make([][]byte, 0, len(entries))
+	for i := range entries {
+		entries[i].Time = now
+		data, err := json.Marshal(entries[i])
+		if err != nil {
+			return err
+		}
+		encoded = append(encoded, data)
+	}
+
+	if err := j.st.AppendJournal(context.Background(), reset, encoded...); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		j.apply(e)
+	}
+	return nil
+}
+
+// Resumable reports whether the journal holds an unfinished run against listURI
+func (j *Journal) Resumable(listURI string) bool {
+	return j.listURI == listURI && !j.finished && (len(j.order[OpAdd]) > 0 || len(j.order[OpRemove]) > 0)
+}
+
+// Removal is a planned deletion of a list item
+type Removal struct {
+	DID       string
+	RecordKey string
+}
+
+// Begin starts a new run, recording every planned change up front. Earlier
+// runs are dropped from the journal, since only the latest can be resumed.
+func (j *Journal) Begin(listURI string, adds []string, removes []Removal) error {
+	entries := make([]entry, 0, 1+len(adds)+len(removes))
+	entries = append(entries, entry{Kind: kindBegin, ListURI: listURI})
+	for _, did := range adds {
+		entries = append(entries, entry{Kind: kindPlanned, Op: OpAdd, DID: did})
+	}
+	for _, removal := range removes {
+		entries = append(entries, entry{Kind: kindPlanned, Op: OpRemove, DID: removal.DID, RecordKey: removal.RecordKey})
+	}
+
+	if err := j.write(true, entries...); err != nil {
+		return fmt.Errorf("failed to begin push journal: %w", err)
+	}
+	return nil
+}
+
+// Done records that the change for key succeeded, with the URI of any record created
+func (j *Journal) Done(op Op, key, recordURI string) error {
+	e := entry{Kind: kindDone, Op: op, RecordURI: recordURI}
+	j.setKey(&e, key)
+	return j.write(false, e)
+}
+
+// Failed records that the change for key failed
+func (j *Journal) Failed(op Op, key string, cause error) error {
+	e := entry{Kind: kindFailed, Op: op, Error: cause.Error()}
+	j.setKey(&e, key)
+	return j.write(false, e)
+}
+
+func (j *Journal) setKey(e *entry, key string) {
+	if item, ok := j.items[e.Op][key]; ok {
+		e.DID = item.DID
+		e.RecordKey = item.RecordKey
+		return
+	}
+	if e.Op == OpRemove {
+		e.RecordKey = key
+	} else {
+		e.DID = key
+	}
+}
+
+// Finish marks the run as complete, so the next run plans from scratch
+func (j *Journal) Finish() error {
+	return j.write(false, entry{Kind: kindFinish, ListURI: j.listURI})
+}
+
+// Items returns the items for op that have the given status, in planned order
+func (j *Journal) Items(op Op, status Status) []*Item {
+	var items []*Item
+	for _, key := range j.order[op] {
+		if item := j.items[op][key]; item.Status == status {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Counts returns how many items for op are in each status
+func (j *Journal) Counts(op Op) map[Status]int {
+	counts := make(map[Status]int)
+	for _, item := range j.items[op] {
+		counts[item.Status]++
+	}
+	return counts
+}
+
+// Keys returns the keys of items
+func Keys(items []*Item) []string {
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		keys = append(keys, item.Key())
+	}
+	return keys
+}
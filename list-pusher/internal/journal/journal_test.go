@@ -0,0 +1,127 @@
+package journal
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"list-pusher/internal/store"
+)
+
+const (
+	testList  = "at://did:plc:2bij7yypmcuvwyz4gyqwtluy/app.bsky.graph.list/3lbxfscjqno2d"
+	otherList = "at://did:plc:someone/app.bsky.graph.list/3lzzzzzzzzz2a"
+)
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// openStore opens an in-memory store for a test
+func openStore(t *testing.T) store.Store {
+	st, err := store.OpenSQLite(context.Background(), ":memory:")
+	must(t, err)
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+// reopen loads the journal from st again, as a restarted run would
+func reopen(t *testing.T, st store.Store) *Journal {
+	t.Helper()
+	j, err := Open(context.Background(), st)
+	must(t, err)
+	return j
+}
+
+func TestResume(t *testing.T) {
+	st := openStore(t)
+	j := reopen(t, st)
+	if j.Resumable(testList) {
+		t.Fatal("new journal is resumable")
+	}
+
+	must(t, j.Begin(testList, []string{"did:plc:a", "did:plc:b", "did:plc:c"}, []Removal{{DID: "did:plc:a", RecordKey: "rka"}}))
+	must(t, j.Done(OpAdd, "did:plc:a", "at://did:plc:owner/app.bsky.graph.listitem/new"))
+	must(t, j.Failed(OpAdd, "did:plc:b", errors.New("rate limited")))
+	must(t, j.Done(OpRemove, "rka", ""))
+
+	// An interrupted run is picked up again after a restart
+	j = reopen(t, st)
+	if !j.Resumable(testList) || j.Resumable(otherList) {
+		t.Fatal("interrupted run should be resumable against its own list only")
+	}
+	if keys := Keys(j.Items(OpAdd, StatusPending)); !reflect.DeepEqual(keys, []string{"did:plc:c"}) {
+		t.Errorf("got pending adds %v", keys)
+	}
+	failed := j.Items(OpAdd, StatusFailed)
+	if len(failed) != 1 || failed[0].DID != "did:plc:b" || failed[0].LastError != "rate limited" || failed[0].Attempts != 1 {
+		t.Errorf("got failed adds %+v", failed)
+	}
+	done := j.Items(OpAdd, StatusDone)
+	if len(done) != 1 || done[0].RecordURI != "at://did:plc:owner/app.bsky.graph.listitem/new" {
+		t.Errorf("got done adds %+v", done)
+	}
+	if counts := j.Counts(OpRemove); counts[StatusDone] != 1 || counts[StatusPending] != 0 {
+		t.Errorf("got remove counts %v", counts)
+	}
+
+	// A retry that succeeds clears the error
+	must(t, j.Done(OpAdd, "did:plc:b", "at://did:plc:owner/app.bsky.graph.listitem/retried"))
+	must(t, j.Done(OpAdd, "did:plc:c", "at://did:plc:owner/app.bsky.graph.listitem/late"))
+	must(t, j.Finish())
+
+	j = reopen(t, st)
+	if j.Resumable(testList) {
+		t.Error("finished run should not be resumable")
+	}
+	retried := j.Items(OpAdd, StatusDone)
+	if len(retried) != 3 || retried[1].Attempts != 2 || retried[1].LastError != "" {
+		t.Errorf("got done adds after finishing %+v", retried)
+	}
+}
+
+func TestUnreadableEntry(t *testing.T) {
+	st := openStore(t)
+	j := reopen(t, st)
+	must(t, j.Begin(testList, []string{"did:plc:a", "did:plc:b"}, nil))
+	must(t, j.Done(OpAdd, "did:plc:a", ""))
+	must(t, st.AppendJournal(context.Background(), false, []byte(`{"kind":"done","op":"add","did":"did:p`)))
+
+	j = reopen(t, st)
+	if !j.Resumable(testList) {
+		t.Fatal("journal with an unreadable entry should still be resumable")
+	}
+	if keys := Keys(j.Items(OpAdd, StatusPending)); !reflect.DeepEqual(keys, []string{"did:plc:b"}) {
+		t.Errorf("got pending adds %v", keys)
+	}
+}
+
+func TestBeginStartsAfresh(t *testing.T) {
+	st := openStore(t)
+	j := reopen(t, st)
+	must(t, j.Begin(testList, []string{"did:plc:a", "did:plc:b", "did:plc:c"}, nil))
+	for _, did := range []string{"did:plc:a", "did:plc:b", "did:plc:c"} {
+		must(t, j.Done(OpAdd, did, ""))
+	}
+	must(t, j.Finish())
+
+	must(t, j.Begin(otherList, []string{"did:plc:d"}, nil))
+	j = reopen(t, st)
+
+	// Only the latest run is kept: its begin entry and one planned item
+	entries, err := st.Journal(context.Background())
+	must(t, err)
+	if len(entries) != 2 {
+		t.Errorf("journal has %d entries after a new run began, want 2", len(entries))
+	}
+	if !j.Resumable(otherList) || j.Resumable(testList) {
+		t.Error("only the latest run should be resumable")
+	}
+	if counts := j.Counts(OpAdd); !reflect.DeepEqual(counts, map[Status]int{StatusPending: 1}) {
+		t.Errorf("got add counts %v", counts)
+	}
+}
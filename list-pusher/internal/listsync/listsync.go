@@ -0,0 +1,198 @@
+// Package listsync reads the current contents of a Bluesky list and works out
+// the changes needed to bring it in line with the DIDs we want on it. Planning
+// is kept free of network access so plans can be built and checked offline.
+package listsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/lex/util"
+)
+
+// ListItem represents a list item with its record key
+type ListItem struct {
+	DID       string `json:"did"`
+	RecordKey string `json:"rkey"`
+}
+
+// FetchListItems fetches all list items and their record keys
+func FetchListItems(ctx context.Context, client util.LexClient, listURI string) ([]ListItem, error) {
+	var items []ListItem
+	cursor := ""
+	limit := int64(100) // Maximum items per request
+
+	for {
+		resp, err := bsky.GraphGetList(ctx, client, cursor, limit, listURI)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch list: %w", err)
+		}
+
+		// Extract DIDs and record keys from the response
+		for _, item := range resp.Items {
+			if item.Subject == nil || item.Subject.Did == "" {
+				continue
+			}
+			aturi, err := syntax.ParseATURI(item.Uri)
+			if err != nil {
+				continue
+			}
+			items = append(items, ListItem{
+				DID:       item.Subject.Did,
+				RecordKey: aturi.RecordKey().String(),
+			})
+		}
+
+		// Stop if there's no next page
+		if resp.Cursor == nil || *resp.Cursor == "" {
+			break
+		}
+		cursor = *resp.Cursor
+
+		// Add a small delay to avoid rate limiting
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return items, nil
+}
+
+// DIDs returns the DIDs of items
+func DIDs(items []ListItem) []string {
+	dids := make([]string, 0, len(items))
+	for _, item := range items {
+		dids = append(dids, item.DID)
+	}
+	return dids
+}
+
+// RemoveDuplicates removes duplicate DIDs from a slice
+func RemoveDuplicates(slice []string) []string {
+	keys := make(map[string]bool)
+	var result []string
+
+	for _, item := range slice {
+		if !keys[item] {
+			keys[item] = true
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+// Difference returns elements in a that are not in b
+func Difference(a, b []string) []string {
+	mb := make(map[string]bool, len(b))
+	for _, x := range b {
+		mb[x] = true
+	}
+
+	var result []string
+	for _, x := range a {
+		if !mb[x] {
+			result = append(result, x)
+		}
+	}
+
+	return result
+}
+
+// Plan is the exact set of changes a run will make to a list. Only removals
+// carry record keys; items added get theirs when they are written.
+type Plan struct {
+	ListURI string     `json:"list"`
+	Add     []string   `json:"add"`
+	Remove  []ListItem `json:"remove"`
+}
+
+// Empty reports whether the plan changes nothing
+func (p Plan) Empty() bool {
+	return len(p.Add) == 0 && len(p.Remove) == 0
+}
+
+// Compute works out which DIDs to add to the list and, when removals is set,
+// which list items to delete because their DID is no longer desired or is
+// listed more than once
+func Compute(listURI string, desired []string, listed []ListItem, removals bool) Plan {
+	plan := Plan{
+		ListURI: listURI,
+		Add:     Difference(RemoveDuplicates(desired), DIDs(listed)),
+	}
+
+	if !removals {
+		return plan
+	}
+
+	wanted := make(map[string]bool, len(desired))
+	for _, did := range desired {
+		wanted[did] = true
+	}
+
+	seen := make(map[string]bool, len(listed))
+	for _, item := range listed {
+		if !wanted[item.DID] || seen[item.DID] {
+			plan.Remove = append(plan.Remove, item)
+		}
+		seen[item.DID] = true
+	}
+
+	return plan
+}
+
+// Removals returns the list items to delete so that none of dids remain listed
+func Removals(listURI string, dids []string, listed []ListItem) Plan {
+	unwanted := make(map[string]bool, len(dids))
+	for _, did := range dids {
+		unwanted[did] = true
+	}
+
+	plan := Plan{ListURI: listURI}
+	for _, item := range listed {
+		if unwanted[item.DID] {
+			plan.Remove = append(plan.Remove, item)
+		}
+	}
+
+	return plan
+}
+
+// WriteJSON writes the plan as indented JSON. Empty sides are written as []
+// rather than null so consumers don't have to special-case them.
+func (p Plan) WriteJSON(w io.Writer) error {
+	if p.Add == nil {
+		p.Add = []string{}
+	}
+	if p.Remove == nil {
+		p.Remove = []ListItem{}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
+
+// WritePlanFile writes the plan as JSON to path, or to stdout if path is "-"
+func WritePlanFile(plan Plan, path string) error {
+	if path == "-" {
+		return plan.WriteJSON(os.Stdout)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create plan file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	if err := plan.WriteJSON(file); err != nil {
+		return fmt.Errorf("failed to write plan file %s: %w", path, err)
+	}
+
+	fmt.Printf("Plan written to %s\n", path)
+	return nil
+}
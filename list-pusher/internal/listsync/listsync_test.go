@@ -0,0 +1,89 @@
+package listsync
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+const testList = "at://did:plc:owner/app.bsky.graph.list/3lbxfscjqno2d"
+
+var testListed = []ListItem{
+	{DID: "did:plc:keep", RecordKey: "rk1"},
+	{DID: "did:plc:stale", RecordKey: "rk2"},
+	{DID: "did:plc:keep", RecordKey: "rk3"},
+}
+
+func TestComputeAddOnly(t *testing.T) {
+	desired := []string{"did:plc:keep", "did:plc:new", "did:plc:new"}
+
+	plan := Compute(testList, desired, testListed, false)
+
+	if !reflect.DeepEqual(plan.Add, []string{"did:plc:new"}) {
+		t.Errorf("got adds %v", plan.Add)
+	}
+	if len(plan.Remove) != 0 {
+		t.Errorf("expected no removals without sync, got %v", plan.Remove)
+	}
+}
+
+func TestComputeWithRemovals(t *testing.T) {
+	desired := []string{"did:plc:keep", "did:plc:new"}
+
+	plan := Compute(testList, desired, testListed, true)
+
+	if !reflect.DeepEqual(plan.Add, []string{"did:plc:new"}) {
+		t.Errorf("got adds %v", plan.Add)
+	}
+
+	// The stale DID goes, as does the second copy of the kept one
+	want := []ListItem{
+		{DID: "did:plc:stale", RecordKey: "rk2"},
+		{DID: "did:plc:keep", RecordKey: "rk3"},
+	}
+	if !reflect.DeepEqual(plan.Remove, want) {
+		t.Errorf("got removals %v, want %v", plan.Remove, want)
+	}
+}
+
+func TestRemovals(t *testing.T) {
+	plan := Removals(testList, []string{"did:plc:keep", "did:plc:absent"}, testListed)
+
+	want := []ListItem{
+		{DID: "did:plc:keep", RecordKey: "rk1"},
+		{DID: "did:plc:keep", RecordKey: "rk3"},
+	}
+	if !reflect.DeepEqual(plan.Remove, want) {
+		t.Errorf("got removals %v, want %v", plan.Remove, want)
+	}
+	if len(plan.Add) != 0 {
+		t.Errorf("expected no adds, got %v", plan.Add)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	plan := Plan{
+		ListURI: testList,
+		Remove:  []ListItem{{DID: "did:plc:stale", RecordKey: "rk2"}},
+	}
+
+	var buf bytes.Buffer
+	if err := plan.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{
+  "list": "at://did:plc:owner/app.bsky.graph.list/3lbxfscjqno2d",
+  "add": [],
+  "remove": [
+    {
+      "did": "did:plc:stale",
+      "rkey": "rk2"
+    }
+  ]
+}
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
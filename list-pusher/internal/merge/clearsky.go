@@ -0,0 +1,184 @@
+package merge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"list-pusher/internal/clearsky"
+	"list-pusher/internal/haters"
+	"list-pusher/internal/store"
+)
+
+// clearskyStateName is the Clearsky importer's entry in the state store
+const clearskyStateName = "import-clearsky"
+
+// ClearskyProgress is how far a Clearsky import run has got. Every
+// subscription it records is last seen at StartedAt, so a resumed run reads
+// as one snapshot.
+type ClearskyProgress struct {
+	StartedAt time.Time                       `json:"started_at"`
+	Lists     map[string]ClearskyListProgress `json:"lists"`
+	Complete  bool                            `json:"complete"`
+}
+
+// ClearskyListProgress is how far an import run has got through one list.
+// Ended counts the subscriptions ended because Clearsky no longer listed them.
+type ClearskyListProgress struct {
+	NextPage    int  `json:"next_page"`
+	Subscribers int  `json:"subscribers"`
+	Ended       int  `json:"ended,omitempty"`
+	Done        bool `json:"done"`
+	NotFound    bool `json:"not_found,omitempty"`
+}
+
+// ClearskyImporter records the subscribers Clearsky reports in the state
+// store. Clearsky lists every subscriber, so each finished list is applied as
+// a snapshot.
+type ClearskyImporter struct {
+	client *clearsky.Client
+	st     store.Store
+}
+
+// NewClearskyImporter creates an importer reading from client into st
+func NewClearskyImporter(client *clearsky.Client, st store.Store) *ClearskyImporter {
+	return &ClearskyImporter{client: client, st: st}
+}
+
+// loadProgress returns the unfinished run to resume, or a new one
+func (i *ClearskyImporter) loadProgress(ctx context.Context, fresh bool) (ClearskyProgress, error) {
+	// The store keeps microseconds, so subscriptions read back as last seen
+	// exactly at StartedAt
+	progress := ClearskyProgress{StartedAt: time.Now().UTC().Truncate(time.Microsecond), Lists: make(map[string]ClearskyListProgress)}
+	if fresh {
+		return progress, nil
+	}
+
+	data, err := i.st.State(ctx, clearskyStateName)
+	if errors.Is(err, store.ErrNotFound) {
+		return progress, nil
+	}
+	if err != nil {
+		return progress, err
+	}
+
+	var saved ClearskyProgress
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return progress, fmt.Errorf("failed to parse import progress: %w", err)
+	}
+	if saved.Complete {
+		return progress, nil
+	}
+	if saved.Lists == nil {
+		saved.Lists = make(map[string]ClearskyListProgress)
+	}
+	return saved, nil
+}
+
+func (i *ClearskyImporter) saveProgress(ctx context.Context, progress ClearskyProgress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("failed to encode import progress: %w", err)
+	}
+	return i.st.SetState(ctx, clearskyStateName, data)
+}
+
+// Run imports every subscriber of lists, resuming the last unfinished run
+// unless fresh is set. Progress is saved after every page, so an interrupted
+// run picks up where it stopped.
+func (i *ClearskyImporter) Run(ctx context.Context, lists []string, fresh bool) error {
+	progress, err := i.loadProgress(ctx, fresh)
+	if err != nil {
+		return err
+	}
+	if len(progress.Lists) > 0 {
+		fmt.Printf("Resuming the import started at %s\n", progress.StartedAt.Format(time.RFC3339))
+	}
+
+	for n, listURI := range lists {
+		list := progress.Lists[listURI]
+		if list.Done {
+			fmt.Printf("List %d/%d: %s already imported (%d subscribers)\n", n+1, len(lists), listURI, list.Subscribers)
+			continue
+		}
+		if list.NextPage == 0 {
+			list.NextPage = 1
+		}
+		fmt.Printf("List %d/%d: %s from page %d\n", n+1, len(lists), listURI, list.NextPage)
+
+		snapshot, err := i.resumeSnapshot(ctx, listURI, list, progress.StartedAt)
+		if err != nil {
+			return err
+		}
+		err = i.client.Subscribers(ctx, listURI, list.NextPage, func(number int, page *clearsky.Page) error {
+			for _, subscriber := range page.Subscribers {
+				if err := i.record(ctx, listURI, subscriber, progress.StartedAt); err != nil {
+					return err
+				}
+				snapshot.DIDs[subscriber.DID] = true
+			}
+			list.NextPage = number + 1
+			list.Subscribers += len(page.Subscribers)
+			progress.Lists[listURI] = list
+			fmt.Printf("  page %d: %d subscribers\n", number, len(page.Subscribers))
+			return i.saveProgress(ctx, progress)
+		})
+		if errors.Is(err, clearsky.ErrListNotFound) {
+			fmt.Printf("✗ Clearsky does not know %s (skipping)\n", listURI)
+			list.NotFound = true
+		} else if err != nil {
+			return fmt.Errorf("failed to import %s: %w", listURI, err)
+		} else {
+			result, err := Apply(ctx, i.st, &Batch{Snapshots: []Snapshot{snapshot}})
+			if err != nil {
+				return err
+			}
+			list.Ended = result.Departed
+		}
+
+		list.Done = true
+		progress.Lists[listURI] = list
+		if err := i.saveProgress(ctx, progress); err != nil {
+			return err
+		}
+		fmt.Printf("✓ %d subscribers, %d no longer subscribed\n", list.Subscribers, list.Ended)
+	}
+
+	progress.Complete = true
+	return i.saveProgress(ctx, progress)
+}
+
+// resumeSnapshot starts the snapshot of listURI, with the subscribers
+// recorded by the pages an interrupted run already imported
+func (i *ClearskyImporter) resumeSnapshot(ctx context.Context, listURI string, list ClearskyListProgress, startedAt time.Time) (Snapshot, error) {
+	snapshot := Snapshot{Source: store.SourceClearsky, ListURI: listURI, At: startedAt, DIDs: make(map[string]bool)}
+	if list.NextPage <= 1 {
+		return snapshot, nil
+	}
+
+	subscriptions, err := i.st.SubscriptionsTo(ctx, listURI)
+	if err != nil {
+		return snapshot, err
+	}
+	for _, sub := range subscriptions {
+		if !sub.LastSeen.Before(startedAt) {
+			snapshot.DIDs[sub.DID] = true
+		}
+	}
+	return snapshot, nil
+}
+
+// record stores one subscriber, first seen when Clearsky says they subscribed
+func (i *ClearskyImporter) record(ctx context.Context, listURI string, subscriber clearsky.Subscriber, seen time.Time) error {
+	sub := store.Subscription{DID: subscriber.DID, ListURI: listURI, LastSeen: seen}
+	if subscriber.DateAdded != "" {
+		if added, err := haters.ParseDate(subscriber.DateAdded); err == nil {
+			sub.FirstSeen = added
+		} else {
+			fmt.Printf("Warning: %s on %s: %v\n", subscriber.DID, listURI, err)
+		}
+	}
+	return i.st.RecordSubscription(ctx, store.SourceClearsky, sub)
+}
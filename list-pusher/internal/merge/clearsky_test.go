@@ -0,0 +1,163 @@
+package merge
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"list-pusher/internal/clearsky"
+	"list-pusher/internal/store"
+)
+
+// testPages is where the fake Clearsky serves testList's pages
+const testPages = "/did:plc:2bij7yypmcuvwyz4gyqwtluy/3lbxfscjqno2d/"
+
+// fakeClearsky serves the recorded pages of testList, failing requests for
+// the pages in fail
+type fakeClearsky struct {
+	fail     map[string]int
+	requests []string
+}
+
+func (f *fakeClearsky) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests = append(f.requests, r.URL.Path)
+
+	number, ok := strings.CutPrefix(r.URL.Path, testPages)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if f.fail[number] > 0 {
+		f.fail[number]--
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		return
+	}
+
+	data, err := os.ReadFile(filepath.Join("..", "clearsky", "testdata", "page-"+number+".json"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(data)
+}
+
+func newClearskyClient(t *testing.T, fake *fakeClearsky) *clearsky.Client {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := clearsky.NewClient(server.URL)
+	client.PageDelay = time.Millisecond
+	client.RetryConfig = clearsky.RetryConfig{MaxRetries: 1, BaseWait: time.Millisecond}
+	return client
+}
+
+func TestImporterResumes(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.OpenSQLite(ctx, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	must(t, st.AddSourceList(ctx, store.SourceList{URI: testList, AddedAt: t0}))
+
+	// The first run gives up on page 2
+	fake := &fakeClearsky{fail: map[string]int{"2": 100}}
+	client := newClearskyClient(t, fake)
+	if err := NewClearskyImporter(client, st).Run(ctx, []string{testList}, false); err == nil {
+		t.Fatal("expected the first run to fail")
+	}
+
+	// The second picks up from page 2
+	fake.fail = nil
+	fake.requests = nil
+	if err := NewClearskyImporter(client, st).Run(ctx, []string{testList}, false); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{testPages + "2", testPages + "3"}; !reflect.DeepEqual(fake.requests, want) {
+		t.Errorf("resumed with requests %v, want %v", fake.requests, want)
+	}
+
+	subs, err := st.Subscriptions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 3 {
+		t.Fatalf("expected 3 subscriptions, got %+v", subs)
+	}
+	if first := subs[0]; first.DID != "did:plc:a2cm7qv3xgbk4ufmmt7dwkbe" || !first.FirstSeen.Equal(time.Date(2024, 11, 27, 1, 2, 3, 456000000, time.UTC)) {
+		t.Errorf("unexpected subscription %+v", first)
+	}
+	for _, sub := range subs {
+		if !sub.Active() {
+			t.Errorf("resumed run ended %+v", sub)
+		}
+	}
+	if !subs[0].LastSeen.Equal(subs[2].LastSeen) {
+		t.Errorf("resumed run saw subscriptions at different times: %v and %v", subs[0].LastSeen, subs[2].LastSeen)
+	}
+
+	// A finished run is not resumed
+	fake.requests = nil
+	if err := NewClearskyImporter(client, st).Run(ctx, []string{testList}, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.requests) != 3 {
+		t.Errorf("expected a complete new run, got %v", fake.requests)
+	}
+}
+
+func TestImporterEndsMissing(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.OpenSQLite(ctx, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	must(t, st.AddSourceList(ctx, store.SourceList{URI: testList, AddedAt: t0}))
+
+	// Known before the import: someone Clearsky no longer lists, someone
+	// seen subscribing since it started, and a subscriber of another list
+	before := time.Now().Add(-24 * time.Hour)
+	after := time.Now().Add(time.Hour)
+	for _, sub := range []store.Subscription{
+		{DID: "did:plc:gone", ListURI: testList, LastSeen: before},
+		{DID: "did:plc:recent", ListURI: testList, LastSeen: after},
+		{DID: "did:plc:gone", ListURI: otherList, LastSeen: before},
+	} {
+		if err := st.RecordSubscription(ctx, store.SourceJetstream, sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := newClearskyClient(t, &fakeClearsky{})
+	if err := NewClearskyImporter(client, st).Run(ctx, []string{testList}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	subs, err := st.Subscriptions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := make(map[string]bool)
+	for _, sub := range subs {
+		if sub.Active() {
+			active[sub.DID+" "+sub.ListURI] = true
+		}
+	}
+	if active["did:plc:gone "+testList] {
+		t.Error("subscriber missing from the import was not ended")
+	}
+	if !active["did:plc:recent "+testList] || !active["did:plc:gone "+otherList] {
+		t.Errorf("import ended subscriptions it shouldn't have: active %v", active)
+	}
+	if len(active) != 5 {
+		t.Errorf("expected the 3 imported subscriptions and 2 others active, got %v", active)
+	}
+}
@@ -0,0 +1,197 @@
+// Package merge folds subscriber data from every source we have into the
+// store's canonical subscription set: Clearsky dumps, Jetstream captures and
+// Constellation. Each piece of evidence is recorded against the source that
+// gave it, conflicts are settled by the most recent evidence, and each merge
+// is diffed against the last.
+package merge
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"list-pusher/internal/store"
+)
+
+// Sighting is a source seeing DID subscribed to ListURI at At. FirstSeen is
+// when the source says the subscription began, if it says.
+type Sighting struct {
+	Source    string
+	DID       string
+	ListURI   string
+	RecordKey string
+	FirstSeen time.Time
+	At        time.Time
+}
+
+// Departure is a source seeing a subscription end at At. Sources that see the
+// listblock record give its RecordKey; the rest give ListURI.
+type Departure struct {
+	Source    string
+	DID       string
+	ListURI   string
+	RecordKey string
+	At        time.Time
+}
+
+// Snapshot is a source's complete subscriber set for a list as of At: anyone
+// not in it had left by then
+type Snapshot struct {
+	Source  string
+	ListURI string
+	At      time.Time
+	DIDs    map[string]bool
+}
+
+// Batch is evidence read from one or more sources
+type Batch struct {
+	Sightings  []Sighting
+	Departures []Departure
+	Snapshots  []Snapshot
+}
+
+// Add appends other's evidence to b
+func (b *Batch) Add(other *Batch) {
+	b.Sightings = append(b.Sightings, other.Sightings...)
+	b.Departures = append(b.Departures, other.Departures...)
+	b.Snapshots = append(b.Snapshots, other.Snapshots...)
+}
+
+// Complete treats the sightings of each list as a complete subscriber set,
+// as of the latest of them, adding a snapshot per list
+func (b *Batch) Complete() {
+	snapshots := make(map[string]*Snapshot)
+	var order []string
+	for _, sighting := range b.Sightings {
+		snapshot, ok := snapshots[sighting.ListURI]
+		if !ok {
+			snapshot = &Snapshot{Source: sighting.Source, ListURI: sighting.ListURI, DIDs: make(map[string]bool)}
+			snapshots[sighting.ListURI] = snapshot
+			order = append(order, sighting.ListURI)
+		}
+		snapshot.DIDs[sighting.DID] = true
+		if sighting.At.After(snapshot.At) {
+			snapshot.At = sighting.At
+		}
+	}
+	for _, listURI := range order {
+		b.Snapshots = append(b.Snapshots, *snapshots[listURI])
+	}
+}
+
+// Result counts the evidence Apply recorded and skipped. Evidence older than
+// what the store already knows is recorded but changes nothing, so Departed
+// only counts the departures and snapshots that end a subscription.
+type Result struct {
+	Recorded  int
+	Departed  int
+	Untracked int
+	Unknown   int
+}
+
+// Apply records a batch in st, oldest evidence first so that the store keeps
+// the most recent word on each subscription. Sightings of lists we don't
+// track are skipped, as are departures of subscriptions we never saw.
+func Apply(ctx context.Context, st store.Store, batch *Batch) (Result, error) {
+	var result Result
+
+	lists, err := st.SourceLists(ctx)
+	if err != nil {
+		return result, err
+	}
+	tracked := make(map[string]bool, len(lists))
+	for _, list := range lists {
+		tracked[list.URI] = true
+	}
+
+	type event struct {
+		at        time.Time
+		sighting  *Sighting
+		departure *Departure
+	}
+	var events []event
+	for i := range batch.Sightings {
+		events = append(events, event{at: batch.Sightings[i].At, sighting: &batch.Sightings[i]})
+	}
+	for i := range batch.Departures {
+		events = append(events, event{at: batch.Departures[i].At, departure: &batch.Departures[i]})
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].at.Before(events[j].at) })
+
+	for _, e := range events {
+		if s := e.sighting; s != nil {
+			if !tracked[s.ListURI] {
+				result.Untracked++
+				continue
+			}
+			sub := store.Subscription{DID: s.DID, ListURI: s.ListURI, RecordKey: s.RecordKey, FirstSeen: s.FirstSeen, LastSeen: s.At}
+			if err := st.RecordSubscription(ctx, s.Source, sub); err != nil {
+				return result, err
+			}
+			result.Recorded++
+			continue
+		}
+
+		d := e.departure
+		var ended store.Subscription
+		var err error
+		if d.RecordKey != "" {
+			ended, err = st.EndSubscription(ctx, d.Source, d.DID, d.RecordKey, d.At)
+		} else {
+			ended, err = st.EndListSubscription(ctx, d.Source, d.DID, d.ListURI, d.At)
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			result.Unknown++
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		if endedBy(ended, d.At) {
+			result.Departed++
+		}
+	}
+
+	for _, snapshot := range batch.Snapshots {
+		if !tracked[snapshot.ListURI] {
+			continue
+		}
+		subscriptions, err := st.SubscriptionsTo(ctx, snapshot.ListURI)
+		if err != nil {
+			return result, err
+		}
+		for _, sub := range subscriptions {
+			if !sub.Active() || snapshot.DIDs[sub.DID] {
+				continue
+			}
+			// A subscription seen after the snapshot keeps running
+			ended, err := st.EndListSubscription(ctx, snapshot.Source, sub.DID, sub.ListURI, snapshot.At)
+			if err != nil {
+				return result, err
+			}
+			if endedBy(ended, snapshot.At) {
+				result.Departed++
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// endedBy reports whether sub, as returned from ending it at at, was ended
+// then, rather than left running or ended earlier
+func endedBy(sub store.Subscription, at time.Time) bool {
+	return sub.EndedAt != nil && sub.EndedAt.Equal(at)
+}
+
+// recordKey returns the rkey of a record AT-URI, or "" if it isn't one
+func recordKey(uri string) string {
+	aturi, err := syntax.ParseATURI(uri)
+	if err != nil {
+		return ""
+	}
+	return aturi.RecordKey().String()
+}
@@ -0,0 +1,150 @@
+package merge
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"list-pusher/internal/store"
+)
+
+const (
+	testList  = "at://did:plc:2bij7yypmcuvwyz4gyqwtluy/app.bsky.graph.list/3lbxfscjqno2d"
+	otherList = "at://did:plc:someone/app.bsky.graph.list/3lzzzzzzzzz2a"
+)
+
+var t0 = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// writeFile writes lines to a file in a temporary directory
+func writeFile(t *testing.T, name string, lines ...string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// listblock is a jetstream event for a listblock record, with a subject unless it is a delete
+func listblock(did, operation, rkey, subject string, at time.Time) string {
+	record := ""
+	if subject != "" {
+		record = fmt.Sprintf(`, "record": {"$type": "app.bsky.graph.listblock", "subject": %q}`, subject)
+	}
+	return fmt.Sprintf(`{"did": %q, "time_us": %d, "kind": "commit", "commit": {"operation": %q, "collection": "app.bsky.graph.listblock", "rkey": %q%s}}`,
+		did, at.UnixMicro(), operation, rkey, record)
+}
+
+func TestMerge(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.OpenSQLite(ctx, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	must(t, st.AddSourceList(ctx, store.SourceList{URI: testList, AddedAt: t0}))
+
+	// Before the merge: one subscriber last seen before the dump, one after
+	must(t, st.RecordSubscription(ctx, store.SourceJetstream, store.Subscription{DID: "did:plc:old", ListURI: testList, LastSeen: t0.Add(-time.Hour)}))
+	must(t, st.RecordSubscription(ctx, store.SourceJetstream, store.Subscription{DID: "did:plc:fresh", ListURI: testList, LastSeen: t0.Add(time.Hour)}))
+	before, err := CurrentState(ctx, st, t0)
+	must(t, err)
+
+	var pages []string
+	for _, name := range []string{"page-1.json", "page-2.json", "page-3.json"} {
+		data, err := os.ReadFile(filepath.Join("..", "clearsky", "testdata", name))
+		must(t, err)
+		pages = append(pages, strings.TrimSpace(string(data)))
+	}
+	batch, err := ReadClearskyDump(ctx, writeFile(t, "haters.jsonl", pages...), nil, t0)
+	must(t, err)
+	if len(batch.Sightings) != 3 || !batch.Sightings[0].FirstSeen.Equal(time.Date(2024, 11, 27, 1, 2, 3, 456000000, time.UTC)) {
+		t.Fatalf("unexpected clearsky sightings %+v", batch.Sightings)
+	}
+	batch.Complete()
+
+	capture, err := ReadJetstream(writeFile(t, "relevant_blocks.jsonl",
+		listblock("did:plc:jfhpnnst6flqway4eaeqzj2a", "create", "rkj", testList, t0.Add(-2*time.Hour)),
+		listblock("did:plc:elsewhere", "create", "rke", otherList, t0),
+		listblock("did:plc:jfhpnnst6flqway4eaeqzj2a", "delete", "rkj", "", t0.Add(time.Hour)),
+		listblock("did:plc:stranger", "delete", "rks", "", t0.Add(time.Hour)),
+	))
+	must(t, err)
+	if len(capture.Sightings) != 2 || len(capture.Departures) != 2 || capture.Sightings[0].RecordKey != "rkj" {
+		t.Fatalf("unexpected jetstream capture %+v", capture)
+	}
+	batch.Add(capture)
+
+	result, err := Apply(ctx, st, batch)
+	must(t, err)
+	// The fresh subscriber is missing from the dump but was seen after it, so
+	// only the unsubscribe and the old subscriber count as departures
+	if result != (Result{Recorded: 4, Departed: 2, Untracked: 1, Unknown: 1}) {
+		t.Errorf("got result %+v", result)
+	}
+
+	after, err := CurrentState(ctx, st, t0.Add(2*time.Hour))
+	must(t, err)
+	evidence, err := st.Evidence(ctx)
+	must(t, err)
+	report := Diff(before, after, evidence)
+
+	// The unsubscribe came after the dump, so it stands; the old subscriber
+	// was missing from the complete dump, so they left; the fresh one was
+	// seen after it, so they stay
+	added := []Change{
+		{DID: "did:plc:a2cm7qv3xgbk4ufmmt7dwkbe", ListURI: testList, Sources: []string{store.SourceClearsky}},
+		{DID: "did:web:example.com", ListURI: testList, Sources: []string{store.SourceClearsky}},
+	}
+	ended := []Change{{DID: "did:plc:old", ListURI: testList, Sources: []string{store.SourceClearsky}}}
+	if !reflect.DeepEqual(report.Added, added) {
+		t.Errorf("got added %+v", report.Added)
+	}
+	if !reflect.DeepEqual(report.Ended, ended) {
+		t.Errorf("got ended %+v", report.Ended)
+	}
+	if !reflect.DeepEqual(report.NewDIDs, []string{"did:plc:a2cm7qv3xgbk4ufmmt7dwkbe", "did:web:example.com"}) || !reflect.DeepEqual(report.GoneDIDs, []string{"did:plc:old"}) {
+		t.Errorf("got new %v, gone %v", report.NewDIDs, report.GoneDIDs)
+	}
+
+	// The next merge diffs against this one
+	must(t, after.Save(ctx, st))
+	saved, ok, err := LoadState(ctx, st)
+	must(t, err)
+	if !ok || !saved.MergedAt.Equal(after.MergedAt) || len(Diff(saved, after, evidence).Added) != 0 {
+		t.Errorf("saved state doesn't match: %+v", saved)
+	}
+}
+
+func TestApplyCountsOnlyEndedSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.OpenSQLite(ctx, ":memory:")
+	must(t, err)
+	defer st.Close()
+	must(t, st.AddSourceList(ctx, store.SourceList{URI: testList, AddedAt: t0}))
+	must(t, st.RecordSubscription(ctx, store.SourceJetstream, store.Subscription{DID: "did:plc:a", ListURI: testList, RecordKey: "rka", LastSeen: t0}))
+	must(t, st.RecordSubscription(ctx, store.SourceJetstream, store.Subscription{DID: "did:plc:b", ListURI: testList, RecordKey: "rkb", LastSeen: t0}))
+
+	// a's unsubscribe predates the last sighting and b's is replayed, so only
+	// b's first unsubscribe ends anything
+	result, err := Apply(ctx, st, &Batch{Departures: []Departure{
+		{Source: store.SourceJetstream, DID: "did:plc:a", RecordKey: "rka", At: t0.Add(-time.Hour)},
+		{Source: store.SourceJetstream, DID: "did:plc:b", RecordKey: "rkb", At: t0.Add(time.Hour)},
+		{Source: store.SourceJetstream, DID: "did:plc:b", RecordKey: "rkb", At: t0.Add(2 * time.Hour)},
+	}})
+	must(t, err)
+	if result.Departed != 1 {
+		t.Errorf("got %d departures, want 1", result.Departed)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,178 @@
+package merge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"list-pusher/internal/store"
+)
+
+// stateName is the merge's entry in the state store
+const stateName = "merge-subscribers"
+
+// State is the active subscriber set of each source list as of a merge
+type State struct {
+	MergedAt time.Time           `json:"merged_at"`
+	Active   map[string][]string `json:"active"`
+}
+
+// LoadState returns the state saved by the last merge, or false if there
+// hasn't been one
+func LoadState(ctx context.Context, st store.Store) (State, bool, error) {
+	var state State
+
+	data, err := st.State(ctx, stateName)
+	if errors.Is(err, store.ErrNotFound) {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, false, fmt.Errorf("failed to parse merge state: %w", err)
+	}
+	return state, true, nil
+}
+
+// CurrentState reads the active subscriptions in st as of at
+func CurrentState(ctx context.Context, st store.Store, at time.Time) (State, error) {
+	state := State{MergedAt: at.UTC(), Active: make(map[string][]string)}
+
+	subscriptions, err := st.Subscriptions(ctx)
+	if err != nil {
+		return state, err
+	}
+	for _, sub := range subscriptions {
+		if sub.Active() {
+			state.Active[sub.ListURI] = append(state.Active[sub.ListURI], sub.DID)
+		}
+	}
+	return state, nil
+}
+
+// Save writes the state to st for the next merge to diff against
+func (s State) Save(ctx context.Context, st store.Store) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to encode merge state: %w", err)
+	}
+	return st.SetState(ctx, stateName, data)
+}
+
+// pairs returns the state's subscriptions keyed by list and DID
+func (s State) pairs() map[pair]bool {
+	pairs := make(map[pair]bool)
+	for listURI, dids := range s.Active {
+		for _, did := range dids {
+			pairs[pair{did, listURI}] = true
+		}
+	}
+	return pairs
+}
+
+// pair is a DID's subscription to a list
+type pair struct {
+	did     string
+	listURI string
+}
+
+// Change is a subscription that started or ended between two merges, and the
+// sources attesting to it
+type Change struct {
+	DID     string   `json:"did"`
+	ListURI string   `json:"list_uri"`
+	Sources []string `json:"sources"`
+}
+
+// Report is what changed in the canonical subscriber set between two merges
+type Report struct {
+	Since   time.Time `json:"since"`
+	At      time.Time `json:"at"`
+	Added   []Change  `json:"added"`
+	Ended   []Change  `json:"ended"`
+	NewDIDs []string  `json:"new_dids"`
+	// GoneDIDs no longer subscribe to any source list
+	GoneDIDs []string `json:"gone_dids"`
+}
+
+// Diff reports the changes from before to after, crediting each to the
+// sources in evidence that saw it: those that saw an added subscription and
+// those that saw an ended one end
+func Diff(before, after State, evidence []store.Evidence) *Report {
+	report := &Report{Since: before.MergedAt, At: after.MergedAt, Added: []Change{}, Ended: []Change{}, NewDIDs: []string{}, GoneDIDs: []string{}}
+
+	seen := make(map[pair][]string)
+	ended := make(map[pair][]string)
+	for _, e := range evidence {
+		p := pair{e.DID, e.ListURI}
+		if !e.LastSeen.IsZero() && (e.EndedAt == nil || e.EndedAt.Before(e.LastSeen)) {
+			seen[p] = append(seen[p], e.Source)
+		}
+		if e.EndedAt != nil {
+			ended[p] = append(ended[p], e.Source)
+		}
+	}
+
+	beforePairs, afterPairs := before.pairs(), after.pairs()
+	beforeDIDs, afterDIDs := make(map[string]bool), make(map[string]bool)
+	for p := range beforePairs {
+		beforeDIDs[p.did] = true
+		if !afterPairs[p] {
+			report.Ended = append(report.Ended, Change{DID: p.did, ListURI: p.listURI, Sources: sources(ended[p])})
+		}
+	}
+	for p := range afterPairs {
+		afterDIDs[p.did] = true
+		if !beforePairs[p] {
+			report.Added = append(report.Added, Change{DID: p.did, ListURI: p.listURI, Sources: sources(seen[p])})
+		}
+	}
+	for did := range afterDIDs {
+		if !beforeDIDs[did] {
+			report.NewDIDs = append(report.NewDIDs, did)
+		}
+	}
+	for did := range beforeDIDs {
+		if !afterDIDs[did] {
+			report.GoneDIDs = append(report.GoneDIDs, did)
+		}
+	}
+
+	sortChanges(report.Added)
+	sortChanges(report.Ended)
+	sort.Strings(report.NewDIDs)
+	sort.Strings(report.GoneDIDs)
+	return report
+}
+
+// Save writes the report to path as JSON
+func (r *Report) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// sources returns a non-nil copy of names for the report
+func sources(names []string) []string {
+	return append([]string{}, names...)
+}
+
+func sortChanges(changes []Change) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].DID != changes[j].DID {
+			return changes[i].DID < changes[j].DID
+		}
+		return changes[i].ListURI < changes[j].ListURI
+	})
+}
@@ -0,0 +1,173 @@
+package merge
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"list-pusher/internal/clearsky"
+	"list-pusher/internal/constellation"
+	"list-pusher/internal/haters"
+	"list-pusher/internal/identifier"
+	"list-pusher/internal/jetstream"
+	"list-pusher/internal/store"
+)
+
+// maxLine is the longest line read from a JSONL capture; clearsky pages can be large
+const maxLine = 64 << 20
+
+// ReadClearskyDump reads haters.jsonl, the raw pages saved by
+// scrape_clearsky_blocklist_api.py, as sightings at at. Pages that can't be
+// parsed or whose list can't be resolved are reported and skipped.
+func ReadClearskyDump(ctx context.Context, path string, resolve identifier.HandleResolver, at time.Time) (*Batch, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	batch := &Batch{}
+	listURIs := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), maxLine)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		page, err := clearsky.ParsePage(scanner.Bytes())
+		if err != nil {
+			fmt.Printf("Warning: %s line %d: %v (skipping)\n", path, line, err)
+			continue
+		}
+
+		listURI, ok := listURIs[page.ListURL]
+		if !ok {
+			listURI, err = identifier.NormalizeList(ctx, resolve, page.ListURL)
+			if err != nil {
+				fmt.Printf("Warning: %s line %d: %v (skipping)\n", path, line, err)
+			}
+			listURIs[page.ListURL] = listURI
+		}
+		if listURI == "" {
+			continue
+		}
+
+		for _, subscriber := range page.Subscribers {
+			sighting := Sighting{Source: store.SourceClearsky, DID: subscriber.DID, ListURI: listURI, At: at}
+			if added, err := haters.ParseDate(subscriber.DateAdded); err == nil {
+				sighting.FirstSeen = added
+			}
+			batch.Sightings = append(batch.Sightings, sighting)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+
+	return batch, nil
+}
+
+// ReadHaters reads processed_haters.json as clearsky sightings at at
+func ReadHaters(ctx context.Context, path string, resolve identifier.HandleResolver, at time.Time) (*Batch, error) {
+	file, problems, err := haters.Load(ctx, path, haters.LoadOptions{Resolve: resolve})
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		fmt.Printf("Warning: %d problems in %s; run import-haters --check to see them\n", len(problems), path)
+	}
+
+	batch := &Batch{}
+	for _, did := range file.DIDs() {
+		for _, sub := range file.Haters[did] {
+			sighting := Sighting{Source: store.SourceClearsky, DID: did, ListURI: sub.ListURI, At: at}
+			if sub.DateAdded != nil {
+				sighting.FirstSeen = *sub.DateAdded
+			}
+			batch.Sightings = append(batch.Sightings, sighting)
+		}
+	}
+	return batch, nil
+}
+
+// ReadJetstream reads a capture of Jetstream events, one per line, such as the
+// relevant_blocks.jsonl and all_block_deletions.jsonl written by
+// quick-and-dirty-jetstream-tailer. Listblock creates and updates are
+// sightings and deletes are departures, each at the event's time.
+func ReadJetstream(path string) (*Batch, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	batch := &Batch{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event jetstream.Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			fmt.Printf("Warning: %s line %d: %v (skipping)\n", path, line, err)
+			continue
+		}
+		commit := event.Commit
+		if event.Kind != jetstream.KindCommit || commit == nil || commit.Collection != jetstream.ListBlockCollection {
+			continue
+		}
+		at := time.UnixMicro(event.TimeUS).UTC()
+
+		switch commit.Operation {
+		case jetstream.OpCreate, jetstream.OpUpdate:
+			var record jetstream.ListBlock
+			if err := json.Unmarshal(commit.Record, &record); err != nil {
+				fmt.Printf("Warning: %s line %d: unreadable listblock: %v (skipping)\n", path, line, err)
+				continue
+			}
+			batch.Sightings = append(batch.Sightings, Sighting{
+				Source: store.SourceJetstream, DID: event.DID, ListURI: record.Subject, RecordKey: commit.RecordKey, At: at,
+			})
+		case jetstream.OpDelete:
+			batch.Departures = append(batch.Departures, Departure{
+				Source: store.SourceJetstream, DID: event.DID, RecordKey: commit.RecordKey, At: at,
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+
+	return batch, nil
+}
+
+// FetchConstellation asks Constellation for every current subscriber of
+// lists. Its answer for each list is complete, so it is a snapshot too.
+func FetchConstellation(ctx context.Context, client *constellation.Client, lists []string) (*Batch, error) {
+	batch := &Batch{}
+	for i, listURI := range lists {
+		fmt.Printf("Fetching subscribers %d/%d: %s\n", i+1, len(lists), listURI)
+		at := time.Now().UTC()
+		subscribers, err := client.ListSubscribers(ctx, listURI)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch subscribers of %s: %w", listURI, err)
+		}
+
+		snapshot := Snapshot{Source: store.SourceConstellation, ListURI: listURI, At: at, DIDs: make(map[string]bool, len(subscribers))}
+		for _, subscriber := range subscribers {
+			batch.Sightings = append(batch.Sightings, Sighting{
+				Source: store.SourceConstellation, DID: subscriber.DID, ListURI: listURI, RecordKey: recordKey(subscriber.RecordURI), At: at,
+			})
+			snapshot.DIDs[subscriber.DID] = true
+		}
+		batch.Snapshots = append(batch.Snapshots, snapshot)
+		fmt.Printf("  %d subscribers\n", len(subscribers))
+	}
+	return batch, nil
+}
@@ -0,0 +1,47 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/lex/util"
+)
+
+// profilesPerRequest is the most actors app.bsky.actor.getProfiles takes
+const profilesPerRequest = 25
+
+// ProfileChecker finds deactivated accounts with app.bsky.actor.getProfiles,
+// which leaves out accounts that are deactivated, deleted or taken down
+type ProfileChecker struct {
+	Client util.LexClient
+}
+
+// Deactivated returns the DIDs among dids that have no profile
+func (p ProfileChecker) Deactivated(ctx context.Context, dids []string) (map[string]bool, error) {
+	deactivated := make(map[string]bool)
+
+	for start := 0; start < len(dids); start += profilesPerRequest {
+		batch := dids[start:min(start+profilesPerRequest, len(dids))]
+		out, err := bsky.ActorGetProfiles(ctx, p.Client, batch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get profiles: %w", err)
+		}
+
+		found := make(map[string]bool, len(out.Profiles))
+		for _, profile := range out.Profiles {
+			found[profile.Did] = true
+		}
+		for _, did := range batch {
+			if !found[did] {
+				deactivated[did] = true
+			}
+		}
+
+		if (start/profilesPerRequest+1)%100 == 0 {
+			fmt.Printf("  checked %d/%d accounts\n", start+len(batch), len(dids))
+		}
+	}
+
+	return deactivated, nil
+}
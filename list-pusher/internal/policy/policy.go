@@ -0,0 +1,353 @@
+// Package policy decides who belongs on our list. Each DID's subscriptions to
+// the source lists are weighed against configurable rules, and manual
+// overrides have the last word. Every decision carries the reasons for it.
+package policy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"list-pusher/internal/store"
+)
+
+// DefaultWeight is the weight of a source list not given one in the config
+const DefaultWeight = 1
+
+// Config holds the listing rules. A DID is listed when its subscriptions to
+// enabled source lists, counting only those seen in the last SeenWithinDays
+// days if set, cover at least MinLists lists and their weights add up to at
+// least MinScore.
+type Config struct {
+	MinLists           int            `toml:"min_lists"`
+	MinScore           int            `toml:"min_score"`
+	SeenWithinDays     int            `toml:"seen_within_days"`
+	ExcludeDeactivated bool           `toml:"exclude_deactivated"`
+	Weights            map[string]int `toml:"weights"`
+}
+
+// Default lists everyone with an active subscription to an enabled source list
+func Default() *Config {
+	return &Config{MinLists: 1}
+}
+
+// LoadConfig reads the rules from a TOML file. A missing file is the default policy.
+func LoadConfig(path string) (*Config, error) {
+	config := Default()
+	if _, err := toml.DecodeFile(path, config); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Default(), nil
+		}
+		return nil, fmt.Errorf("failed to parse TOML file %s: %w", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	return config, nil
+}
+
+func (c *Config) validate() error {
+	if c.MinLists < 1 {
+		return fmt.Errorf("min_lists must be at least 1")
+	}
+	if c.SeenWithinDays < 0 {
+		return fmt.Errorf("seen_within_days can't be negative")
+	}
+	for uri := range c.Weights {
+		if _, err := syntax.ParseATURI(uri); err != nil {
+			return fmt.Errorf("weight for %q: lists are given by AT-URI: %w", uri, err)
+		}
+	}
+	return nil
+}
+
+// Weight returns the weight of a source list
+func (c *Config) Weight(listURI string) int {
+	if weight, ok := c.Weights[listURI]; ok {
+		return weight
+	}
+	return DefaultWeight
+}
+
+// The rules that can decide a DID. Overrides beat everything else.
+const (
+	RuleListed          = "listed"
+	RuleIncludeOverride = "include-override"
+	RuleExcludeOverride = "exclude-override"
+	RuleNoSubscriptions = "no-subscriptions"
+	RuleMinLists        = "min-lists"
+	RuleMinScore        = "min-score"
+	RuleDeactivated     = "deactivated"
+)
+
+// Decision is whether a DID belongs on our list, the rule that settled it and
+// why. Lists are the source lists its subscriptions counted for and Score
+// their total weight.
+type Decision struct {
+	DID     string   `json:"did"`
+	Include bool     `json:"include"`
+	Rule    string   `json:"rule"`
+	Score   int      `json:"score"`
+	Lists   []string `json:"lists"`
+	Reasons []string `json:"reasons"`
+}
+
+// AccountChecker finds accounts that are deactivated, deleted or taken down
+type AccountChecker interface {
+	Deactivated(ctx context.Context, dids []string) (map[string]bool, error)
+}
+
+// Engine applies a policy to the state store
+type Engine struct {
+	config   *Config
+	st       store.Store
+	accounts AccountChecker
+	now      func() time.Time
+}
+
+// NewEngine creates an engine deciding from st. accounts is only needed if
+// the policy excludes deactivated accounts.
+func NewEngine(config *Config, st store.Store, accounts AccountChecker) *Engine {
+	return &Engine{config: config, st: st, accounts: accounts, now: time.Now}
+}
+
+// DecideAll decides every DID with a subscription or an override, sorted by DID
+func (e *Engine) DecideAll(ctx context.Context) ([]Decision, error) {
+	subscriptions, err := e.st.Subscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byDID := make(map[string][]store.Subscription)
+	for _, sub := range subscriptions {
+		byDID[sub.DID] = append(byDID[sub.DID], sub)
+	}
+	return e.decide(ctx, byDID, nil)
+}
+
+// Decide decides the given DIDs, in order
+func (e *Engine) Decide(ctx context.Context, dids []string) ([]Decision, error) {
+	byDID := make(map[string][]store.Subscription, len(dids))
+	for _, did := range dids {
+		subs, err := e.st.SubscriptionsOf(ctx, did)
+		if err != nil {
+			return nil, err
+		}
+		byDID[did] = subs
+	}
+	return e.decide(ctx, byDID, dids)
+}
+
+// decide decides dids, or everyone in byDID or with an override if dids is nil
+func (e *Engine) decide(ctx context.Context, byDID map[string][]store.Subscription, dids []string) ([]Decision, error) {
+	sources, err := store.EnabledSourceLists(ctx, e.st)
+	if err != nil {
+		return nil, err
+	}
+	enabled := make(map[string]store.SourceList, len(sources))
+	for _, source := range sources {
+		enabled[source.URI] = source
+	}
+
+	overrides, err := e.st.Overrides(ctx)
+	if err != nil {
+		return nil, err
+	}
+	overridden := make(map[string]store.Override, len(overrides))
+	for _, override := range overrides {
+		overridden[override.DID] = override
+	}
+
+	if dids == nil {
+		seen := make(map[string]bool)
+		for did := range byDID {
+			seen[did] = true
+		}
+		for did := range overridden {
+			seen[did] = true
+		}
+		for did := range seen {
+			dids = append(dids, did)
+		}
+		sort.Strings(dids)
+	}
+
+	var cutoff time.Time
+	if e.config.SeenWithinDays > 0 {
+		cutoff = e.now().AddDate(0, 0, -e.config.SeenWithinDays)
+	}
+
+	decisions := make([]Decision, 0, len(dids))
+	var check []int
+	for _, did := range dids {
+		var override *store.Override
+		if o, ok := overridden[did]; ok {
+			override = &o
+		}
+		decision := e.config.decide(did, byDID[did], enabled, override, cutoff)
+		if decision.Rule == RuleListed {
+			check = append(check, len(decisions))
+		}
+		decisions = append(decisions, decision)
+	}
+
+	if e.config.ExcludeDeactivated && len(check) > 0 {
+		if err := e.excludeDeactivated(ctx, decisions, check); err != nil {
+			return nil, err
+		}
+	}
+
+	return decisions, nil
+}
+
+// decide applies the rules to one DID's subscriptions and override
+func (c *Config) decide(did string, subs []store.Subscription, enabled map[string]store.SourceList, override *store.Override, cutoff time.Time) Decision {
+	decision := Decision{DID: did, Lists: []string{}, Reasons: []string{}}
+
+	var counted []string
+	var stale int
+	for _, sub := range subs {
+		source, ok := enabled[sub.ListURI]
+		if !ok || !sub.Active() {
+			continue
+		}
+		if sub.LastSeen.Before(cutoff) {
+			stale++
+			continue
+		}
+		weight := c.Weight(sub.ListURI)
+		decision.Lists = append(decision.Lists, sub.ListURI)
+		decision.Score += weight
+		counted = append(counted, fmt.Sprintf("%s (weight %d)", listName(source), weight))
+	}
+
+	if len(counted) > 0 {
+		decision.Reasons = append(decision.Reasons, fmt.Sprintf("subscribed to %d source lists: %s", len(counted), strings.Join(counted, ", ")))
+	}
+	if stale > 0 {
+		decision.Reasons = append(decision.Reasons, fmt.Sprintf("%d subscriptions last seen before %s not counted", stale, cutoff.Format(time.DateOnly)))
+	}
+
+	switch {
+	case override != nil && override.Action == store.OverrideInclude:
+		decision.Include, decision.Rule = true, RuleIncludeOverride
+		decision.Reasons = append(decision.Reasons, overrideReason("included", override))
+	case override != nil && override.Action == store.OverrideExclude:
+		decision.Rule = RuleExcludeOverride
+		decision.Reasons = append(decision.Reasons, overrideReason("excluded", override))
+	case len(decision.Lists) == 0:
+		decision.Rule = RuleNoSubscriptions
+		decision.Reasons = append(decision.Reasons, "no counted subscriptions to enabled source lists")
+	case len(decision.Lists) < c.MinLists:
+		decision.Rule = RuleMinLists
+		decision.Reasons = append(decision.Reasons, fmt.Sprintf("subscribed to %d lists, fewer than the %d required", len(decision.Lists), c.MinLists))
+	case decision.Score < c.MinScore:
+		decision.Rule = RuleMinScore
+		decision.Reasons = append(decision.Reasons, fmt.Sprintf("score %d is below the %d required", decision.Score, c.MinScore))
+	default:
+		decision.Include, decision.Rule = true, RuleListed
+	}
+
+	return decision
+}
+
+// excludeDeactivated drops the decisions at indexes check whose accounts are gone
+func (e *Engine) excludeDeactivated(ctx context.Context, decisions []Decision, check []int) error {
+	if e.accounts == nil {
+		return fmt.Errorf("the policy excludes deactivated accounts but has no way to check them")
+	}
+
+	dids := make([]string, 0, len(check))
+	for _, i := range check {
+		dids = append(dids, decisions[i].DID)
+	}
+	deactivated, err := e.accounts.Deactivated(ctx, dids)
+	if err != nil {
+		return fmt.Errorf("failed to check for deactivated accounts: %w", err)
+	}
+
+	for _, i := range check {
+		if deactivated[decisions[i].DID] {
+			decisions[i].Include, decisions[i].Rule = false, RuleDeactivated
+			decisions[i].Reasons = append(decisions[i].Reasons, "account is deactivated, deleted or taken down")
+		}
+	}
+	return nil
+}
+
+// Included returns the DIDs that belong on the list, in decision order
+func Included(decisions []Decision) []string {
+	var dids []string
+	for _, decision := range decisions {
+		if decision.Include {
+			dids = append(dids, decision.DID)
+		}
+	}
+	return dids
+}
+
+// Counts returns how many decisions each rule settled
+func Counts(decisions []Decision) map[string]int {
+	counts := make(map[string]int)
+	for _, decision := range decisions {
+		counts[decision.Rule]++
+	}
+	return counts
+}
+
+// listName names a source list for a reason
+func listName(list store.SourceList) string {
+	if list.Name != "" {
+		return fmt.Sprintf("%q", list.Name)
+	}
+	return list.URI
+}
+
+func overrideReason(verb string, override *store.Override) string {
+	if override.Reason == "" {
+		return verb + " by override"
+	}
+	return fmt.Sprintf("%s by override: %s", verb, override.Reason)
+}
+
+// WriteDecisions writes decisions to path as JSON lines, one per DID. The
+// file is replaced in one step, so anything polling it never reads half of it.
+func WriteDecisions(decisions []Decision, path string) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create decisions file %s: %w", path, err)
+	}
+	defer os.Remove(tmp)
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	for _, decision := range decisions {
+		if err := encoder.Encode(decision); err != nil {
+			return fmt.Errorf("failed to write decisions file %s: %w", path, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write decisions file %s: %w", path, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write decisions file %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write decisions file %s: %w", path, err)
+	}
+
+	fmt.Printf("Policy decisions written to %s\n", path)
+	return nil
+}
@@ -0,0 +1,194 @@
+package policy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"list-pusher/internal/store"
+)
+
+const (
+	listA    = "at://did:plc:owner/app.bsky.graph.list/3lbxfscjqno2d"
+	listB    = "at://did:plc:owner/app.bsky.graph.list/3lbrgpl44zp2f"
+	disabled = "at://did:plc:owner/app.bsky.graph.list/3labcdefghi2k"
+)
+
+var t0 = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// gone is an AccountChecker that knows a fixed set of deactivated accounts
+type gone map[string]bool
+
+func (g gone) Deactivated(ctx context.Context, dids []string) (map[string]bool, error) {
+	deactivated := make(map[string]bool)
+	for _, did := range dids {
+		if g[did] {
+			deactivated[did] = true
+		}
+	}
+	return deactivated, nil
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	config, err := LoadConfig(filepath.Join(dir, "missing.toml"))
+	if err != nil || !reflect.DeepEqual(config, Default()) {
+		t.Errorf("missing file gave %+v, %v", config, err)
+	}
+
+	path := filepath.Join(dir, "policy.toml")
+	must(t, os.WriteFile(path, []byte("min_lists = 2\nmin_score = 3\n[weights]\n\""+listA+"\" = 2\n"), 0644))
+	config, err = LoadConfig(path)
+	must(t, err)
+	if config.MinLists != 2 || config.MinScore != 3 || config.Weight(listA) != 2 || config.Weight(listB) != DefaultWeight {
+		t.Errorf("unexpected config %+v", config)
+	}
+
+	must(t, os.WriteFile(path, []byte("[weights]\n\"https://bsky.app/profile/someone/lists/3lbxfscjqno2d\" = 2\n"), 0644))
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("expected an error for a weight not keyed by AT-URI")
+	}
+}
+
+func TestDecide(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.OpenSQLite(ctx, filepath.Join(t.TempDir(), "test.db"))
+	must(t, err)
+	defer st.Close()
+
+	must(t, st.AddSourceList(ctx, store.SourceList{URI: listA, Name: "AI art", AddedAt: t0}))
+	must(t, st.AddSourceList(ctx, store.SourceList{URI: listB, AddedAt: t0}))
+	must(t, st.AddSourceList(ctx, store.SourceList{URI: disabled, AddedAt: t0}))
+	list, err := st.SourceList(ctx, disabled)
+	must(t, err)
+	list.Enabled = false
+	must(t, st.UpdateSourceList(ctx, list))
+
+	subscribe := func(did, listURI string, seen time.Time) {
+		must(t, st.RecordSubscription(ctx, store.SourceJetstream, store.Subscription{DID: did, ListURI: listURI, LastSeen: seen}))
+	}
+	subscribe("did:plc:both", listA, t0)
+	subscribe("did:plc:both", listB, t0)
+	subscribe("did:plc:heavy", listA, t0)
+	subscribe("did:plc:light", listB, t0)
+	subscribe("did:plc:stale", listA, t0.AddDate(0, 0, -60))
+	subscribe("did:plc:stale", listB, t0)
+	subscribe("did:plc:disabled", disabled, t0)
+	subscribe("did:plc:gone", listA, t0)
+	subscribe("did:plc:gone", listB, t0)
+	subscribe("did:plc:left", listA, t0)
+	subscribe("did:plc:left", listB, t0)
+	_, err = st.EndListSubscription(ctx, store.SourceJetstream, "did:plc:left", listB, t0.Add(time.Hour))
+	must(t, err)
+	must(t, st.SetOverride(ctx, store.Override{DID: "did:plc:excluded", Action: store.OverrideExclude, Reason: "not a hater", CreatedAt: t0}))
+	must(t, st.SetOverride(ctx, store.Override{DID: "did:plc:included", Action: store.OverrideInclude, CreatedAt: t0}))
+	subscribe("did:plc:excluded", listA, t0)
+	subscribe("did:plc:excluded", listB, t0)
+
+	// Two lists, or one list weighing 2, scoring at least 2, seen in the last
+	// 30 days, from accounts that still exist
+	config := &Config{MinLists: 1, MinScore: 2, SeenWithinDays: 30, ExcludeDeactivated: true, Weights: map[string]int{listA: 2}}
+	engine := NewEngine(config, st, gone{"did:plc:gone": true})
+	engine.now = func() time.Time { return t0 }
+
+	decisions, err := engine.DecideAll(ctx)
+	must(t, err)
+	rules := make(map[string]string)
+	for _, decision := range decisions {
+		rules[decision.DID] = decision.Rule
+	}
+	want := map[string]string{
+		"did:plc:both":     RuleListed,
+		"did:plc:heavy":    RuleListed,
+		"did:plc:light":    RuleMinScore,
+		"did:plc:stale":    RuleMinScore,
+		"did:plc:disabled": RuleNoSubscriptions,
+		"did:plc:gone":     RuleDeactivated,
+		"did:plc:left":     RuleListed,
+		"did:plc:excluded": RuleExcludeOverride,
+		"did:plc:included": RuleIncludeOverride,
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("got rules %v", rules)
+	}
+	if !reflect.DeepEqual(Included(decisions), []string{"did:plc:both", "did:plc:heavy", "did:plc:included", "did:plc:left"}) {
+		t.Errorf("got included %v", Included(decisions))
+	}
+
+	// Deciding a few DIDs agrees, and explains itself
+	some, err := engine.Decide(ctx, []string{"did:plc:stale", "did:plc:nobody"})
+	must(t, err)
+	stale := Decision{
+		DID: "did:plc:stale", Rule: RuleMinScore, Score: 1, Lists: []string{listB},
+		Reasons: []string{
+			"subscribed to 1 source lists: " + listB + " (weight 1)",
+			"1 subscriptions last seen before 2025-01-30 not counted",
+			"score 1 is below the 2 required",
+		},
+	}
+	if !reflect.DeepEqual(some[0], stale) {
+		t.Errorf("got decision %+v", some[0])
+	}
+	if some[1].Rule != RuleNoSubscriptions || some[1].Include {
+		t.Errorf("got decision %+v", some[1])
+	}
+
+	// Loosening the policy lists the light subscriber
+	engine.config = &Config{MinLists: 1}
+	some, err = engine.Decide(ctx, []string{"did:plc:light"})
+	must(t, err)
+	if !some[0].Include {
+		t.Errorf("got decision %+v", some[0])
+	}
+}
+
+func TestDecideAllDefault(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.OpenSQLite(ctx, filepath.Join(t.TempDir(), "test.db"))
+	must(t, err)
+	defer st.Close()
+
+	must(t, st.AddSourceList(ctx, store.SourceList{URI: listA, AddedAt: t0}))
+	must(t, st.AddSourceList(ctx, store.SourceList{URI: listB, AddedAt: t0}))
+	subscribe := func(did, listURI string, seen time.Time) {
+		must(t, st.RecordSubscription(ctx, store.SourceJetstream, store.Subscription{DID: did, ListURI: listURI, LastSeen: seen}))
+	}
+	subscribe("did:plc:one", listA, t0)
+	subscribe("did:plc:two", listB, t0)
+	subscribe("did:plc:untracked", "at://did:plc:other/app.bsky.graph.list/3lzzzzzzzzz2a", t0)
+	_, err = st.EndListSubscription(ctx, store.SourceJetstream, "did:plc:two", listB, t0.Add(time.Hour))
+	must(t, err)
+	must(t, st.SetOverride(ctx, store.Override{DID: "did:plc:one", Action: store.OverrideExclude, CreatedAt: t0}))
+	must(t, st.SetOverride(ctx, store.Override{DID: "did:plc:three", Action: store.OverrideInclude, CreatedAt: t0}))
+
+	// Without a policy file, any active subscriber of a tracked list is
+	// listed, plus and minus the overrides
+	engine := NewEngine(Default(), st, nil)
+	included := func() []string {
+		decisions, err := engine.DecideAll(ctx)
+		must(t, err)
+		return Included(decisions)
+	}
+	if got := included(); !reflect.DeepEqual(got, []string{"did:plc:three"}) {
+		t.Errorf("got included %v", got)
+	}
+
+	// Seeing the ended subscription again reopens it, and dropping the
+	// exclusion lists its DID again
+	subscribe("did:plc:two", listB, t0.Add(2*time.Hour))
+	must(t, st.DeleteOverride(ctx, "did:plc:one"))
+	if got := included(); !reflect.DeepEqual(got, []string{"did:plc:one", "did:plc:three", "did:plc:two"}) {
+		t.Errorf("got included %v", got)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,29 @@
+// Package prompt asks the operator to confirm destructive operations, unless
+// they have already said yes on the command line.
+package prompt
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Confirm asks a y/N question on stdin. With assumeYes it prints the question
+// and answers it without waiting, so commands can run from cron or systemd.
+func Confirm(question string, assumeYes bool) (bool, error) {
+	fmt.Print(question)
+
+	if assumeYes {
+		fmt.Println("y (--yes)")
+		return true, nil
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	answer, err := reader.ReadString('\n')
+	if err != nil {
+		return false, fmt.Errorf("failed to read confirmation: %w", err)
+	}
+
+	return strings.TrimSpace(strings.ToLower(answer)) == "y", nil
+}
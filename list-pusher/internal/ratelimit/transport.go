@@ -0,0 +1,274 @@
+// Package ratelimit paces HTTP requests using the ratelimit-* headers a PDS
+// sends on every response, so we stay under budget instead of running into 429s.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Budget is the rate limit state a server last reported for one endpoint
+type Budget struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+	Policy    string
+	// Window is the policy window parsed from Policy ("w=" seconds), if any
+	Window time.Duration
+}
+
+// ParseHeaders reads the ratelimit-* headers from a response. ok is false when
+// the response carries no rate limit information.
+func ParseHeaders(header http.Header) (budget Budget, ok bool) {
+	limit, err := strconv.Atoi(header.Get("ratelimit-limit"))
+	if err != nil {
+		return Budget{}, false
+	}
+	budget.Limit = limit
+
+	if remaining, err := strconv.Atoi(header.Get("ratelimit-remaining")); err == nil {
+		budget.Remaining = remaining
+	} else {
+		budget.Remaining = limit
+	}
+
+	if reset, err := strconv.ParseInt(header.Get("ratelimit-reset"), 10, 64); err == nil {
+		budget.Reset = time.Unix(reset, 0)
+	}
+
+	budget.Policy = header.Get("ratelimit-policy")
+	budget.Window = parsePolicyWindow(budget.Policy)
+
+	return budget, true
+}
+
+// parsePolicyWindow extracts the window from a policy such as "3000;w=300".
+// When several policies are listed the first window is used.
+func parsePolicyWindow(policy string) time.Duration {
+	for _, part := range strings.Split(strings.Split(policy, ",")[0], ";") {
+		part = strings.TrimSpace(part)
+		if seconds, ok := strings.CutPrefix(part, "w="); ok {
+			if n, err := strconv.Atoi(seconds); err == nil {
+				return time.Duration(n) * time.Second
+			}
+		}
+	}
+	return 0
+}
+
+// Points a PDS charges against the repo write budget for each kind of write
+const (
+	CreatePoints = 3
+	UpdatePoints = 2
+	DeletePoints = 1
+)
+
+type costKey struct{}
+
+// WithCost returns a context whose requests count cost against their budget
+// instead of one, for callers whose request is made deep inside a library
+func WithCost(ctx context.Context, cost int) context.Context {
+	return context.WithValue(ctx, costKey{}, cost)
+}
+
+// Cost returns what requests made with ctx count against their budget: the
+// cost set by WithCost, or one
+func Cost(ctx context.Context) int {
+	if cost, ok := ctx.Value(costKey{}).(int); ok && cost > 0 {
+		return cost
+	}
+	return 1
+}
+
+// writeMethods are the repo writes a PDS counts against one budget for the
+// whole account rather than per method
+var writeMethods = map[string]bool{
+	"com.atproto.repo.applyWrites":  true,
+	"com.atproto.repo.createRecord": true,
+	"com.atproto.repo.putRecord":    true,
+	"com.atproto.repo.deleteRecord": true,
+}
+
+// writeBudget is the key repo writes share
+const writeBudget = "repo writes"
+
+// budgetKey returns the budget a request to path counts against
+func budgetKey(path string) string {
+	if writeMethods[strings.TrimPrefix(path, "/xrpc/")] {
+		return writeBudget
+	}
+	return path
+}
+
+// endpointState tracks the budget for one XRPC method, or for all repo writes
+type endpointState struct {
+	budget Budget
+	known  bool
+	next   time.Time
+}
+
+// Transport is an http.RoundTripper that records the rate limit headers of
+// every response, and Wait delays requests so the remaining budget is spread
+// evenly until the window resets. Budgets are tracked per request path, since
+// the PDS limits most XRPC methods separately, except that repo writes share
+// one budget for the account.
+//
+// Waiting is done by the caller before the request is made rather than in
+// RoundTrip, so that a wait for the window to reset doesn't count against the
+// HTTP client's timeout.
+type Transport struct {
+	base http.RoundTripper
+
+	// Reserve is how much budget to hold back from each window so that other
+	// clients of the same account are not starved
+	Reserve int
+
+	// OnWait, if set, is called whenever a request is delayed
+	OnWait func(path string, wait time.Duration, budget Budget)
+
+	mu        sync.Mutex
+	endpoints map[string]*endpointState
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewTransport wraps base, or http.DefaultTransport if base is nil
+func NewTransport(base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{
+		base:      base,
+		endpoints: make(map[string]*endpointState),
+		now:       time.Now,
+		sleep:     sleepContext,
+	}
+}
+
+// Wait claims cost from the budget for a request to path, waiting until it is
+// due or ctx is done. Most requests cost one; a repo write costs the points
+// of every write in it.
+func (t *Transport) Wait(ctx context.Context, path string, cost int) error {
+	wait, budget := t.reserve(budgetKey(path), max(cost, 1))
+	if wait <= 0 {
+		return nil
+	}
+	if t.OnWait != nil {
+		t.OnWait(path, wait, budget)
+	}
+	return t.sleep(ctx, wait)
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	t.observe(budgetKey(req.URL.Path), resp)
+	return resp, nil
+}
+
+// Budget returns the last budget reported for requests to path
+func (t *Transport) Budget(path string) (Budget, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.endpoints[budgetKey(path)]
+	if !ok || !state.known {
+		return Budget{}, false
+	}
+	return state.budget, true
+}
+
+// reserve claims cost against the budget key and returns how long to wait
+// before sending the request
+func (t *Transport) reserve(key string, cost int) (time.Duration, Budget) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.endpoints[key]
+	if !ok || !state.known {
+		return 0, Budget{}
+	}
+
+	now := t.now()
+	budget := state.budget
+
+	// The window has rolled over, so whatever we knew is stale
+	if !budget.Reset.After(now) {
+		state.known = false
+		return 0, Budget{}
+	}
+
+	start := now
+	if state.next.After(start) {
+		start = state.next
+	}
+
+	usable := budget.Remaining - t.Reserve
+	if usable < cost {
+		// Out of budget: nothing goes out until the window resets
+		start = budget.Reset
+		if budget.Window > 0 {
+			state.budget.Remaining = budget.Limit - cost
+			state.budget.Reset = budget.Reset.Add(budget.Window)
+		} else {
+			state.known = false
+		}
+		state.next = start
+		return start.Sub(now), budget
+	}
+
+	// Spread what is left evenly over the rest of the window, so a request
+	// holds off the next one in proportion to its cost
+	interval := budget.Reset.Sub(start) * time.Duration(cost) / time.Duration(usable)
+	state.budget.Remaining -= cost
+	state.next = start.Add(interval)
+
+	return start.Sub(now), budget
+}
+
+// observe records the budget reported by resp against the budget key
+func (t *Transport) observe(key string, resp *http.Response) {
+	budget, ok := ParseHeaders(resp.Header)
+	if !ok {
+		return
+	}
+
+	// A 429 means the budget is gone regardless of what the headers claim
+	if resp.StatusCode == http.StatusTooManyRequests {
+		budget.Remaining = 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, exists := t.endpoints[key]
+	if !exists {
+		state = &endpointState{}
+		t.endpoints[key] = state
+	}
+	state.budget = budget
+	state.known = true
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for rate limit: %w", ctx.Err())
+	}
+}
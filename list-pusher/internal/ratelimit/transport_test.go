@@ -0,0 +1,302 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePDS is an httptest stand-in that reports a fixed-window rate limit
+type fakePDS struct {
+	mu        sync.Mutex
+	limit     int
+	remaining int
+	reset     time.Time
+	window    time.Duration
+	status    int
+	noHeaders bool
+	requests  int
+
+	// points is what each request costs, one if unset
+	points int
+}
+
+func (p *fakePDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.requests++
+	p.remaining -= max(p.points, 1)
+	if p.remaining < 0 {
+		p.remaining = 0
+	}
+
+	if !p.noHeaders {
+		w.Header().Set("ratelimit-limit", strconv.Itoa(p.limit))
+		w.Header().Set("ratelimit-remaining", strconv.Itoa(p.remaining))
+		w.Header().Set("ratelimit-reset", strconv.FormatInt(p.reset.Unix(), 10))
+		w.Header().Set("ratelimit-policy", strconv.Itoa(p.limit)+";w="+strconv.Itoa(int(p.window.Seconds())))
+	}
+
+	status := p.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(`{}`))
+}
+
+// fakeClock stands in for time.Now and sleeping, recording every wait
+type fakeClock struct {
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) install(t *Transport) {
+	t.now = func() time.Time { return c.now }
+	t.sleep = func(ctx context.Context, d time.Duration) error {
+		c.waits = append(c.waits, d)
+		c.now = c.now.Add(d)
+		return nil
+	}
+}
+
+func newTestClient(t *testing.T, pds *fakePDS) (*http.Client, *Transport, *fakeClock, string) {
+	t.Helper()
+
+	server := httptest.NewServer(pds)
+	t.Cleanup(server.Close)
+
+	transport := NewTransport(nil)
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	clock.install(transport)
+
+	return &http.Client{Transport: transport}, transport, clock, server.URL + "/xrpc/com.atproto.repo.applyWrites"
+}
+
+// get waits for a slot the way Session.LexDo does, then makes the request
+func get(t *testing.T, client *http.Client, url string) *http.Response {
+	t.Helper()
+	return send(t, client, url, 1)
+}
+
+// send is get for a request costing cost
+func send(t *testing.T, client *http.Client, url string, cost int) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Transport.(*Transport).Wait(context.Background(), req.URL.Path, cost); err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestParseHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("ratelimit-limit", "5000")
+	header.Set("ratelimit-remaining", "4321")
+	header.Set("ratelimit-reset", "1700003600")
+	header.Set("ratelimit-policy", "5000;w=3600")
+
+	budget, ok := ParseHeaders(header)
+	if !ok {
+		t.Fatal("expected headers to parse")
+	}
+	if budget.Limit != 5000 || budget.Remaining != 4321 {
+		t.Errorf("got limit %d remaining %d, want 5000 and 4321", budget.Limit, budget.Remaining)
+	}
+	if !budget.Reset.Equal(time.Unix(1700003600, 0)) {
+		t.Errorf("got reset %v", budget.Reset)
+	}
+	if budget.Policy != "5000;w=3600" || budget.Window != time.Hour {
+		t.Errorf("got policy %q window %v", budget.Policy, budget.Window)
+	}
+
+	if _, ok := ParseHeaders(http.Header{}); ok {
+		t.Error("expected no budget without headers")
+	}
+}
+
+func TestTransportPacesRemainingBudget(t *testing.T) {
+	pds := &fakePDS{limit: 100, remaining: 11, window: 100 * time.Second}
+	client, transport, clock, url := newTestClient(t, pds)
+	pds.reset = clock.now.Add(100 * time.Second)
+
+	// The first response tells us 10 requests are left for the next 100s
+	get(t, client, url)
+	if len(clock.waits) != 0 {
+		t.Fatalf("first request should not wait, waited %v", clock.waits)
+	}
+
+	budget, ok := transport.Budget("/xrpc/com.atproto.repo.applyWrites")
+	if !ok || budget.Remaining != 10 {
+		t.Fatalf("expected 10 remaining, got %+v (known %v)", budget, ok)
+	}
+
+	get(t, client, url)
+	get(t, client, url)
+
+	// The first of these goes straight out, the next is spaced ~10s after it
+	if len(clock.waits) != 1 {
+		t.Fatalf("expected one wait, got %v", clock.waits)
+	}
+	if clock.waits[0] < 9*time.Second || clock.waits[0] > 11*time.Second {
+		t.Errorf("expected to wait about 10s, waited %v", clock.waits[0])
+	}
+}
+
+func TestTransportPacesByCost(t *testing.T) {
+	pds := &fakePDS{limit: 901, remaining: 901, window: time.Minute}
+	client, _, clock, url := newTestClient(t, pds)
+	pds.reset = clock.now.Add(time.Minute)
+
+	// The first request learns that 900 points are left for the next minute
+	get(t, client, url)
+
+	// Three batches of 100 creates use up the budget, so they go out 20s apart
+	// and the request after them waits for the window to reset
+	pds.points = 300
+	for range 3 {
+		send(t, client, url, 300)
+	}
+	pds.points = 1
+	get(t, client, url)
+
+	want := []time.Duration{20 * time.Second, 20 * time.Second, 20 * time.Second}
+	if !reflect.DeepEqual(clock.waits, want) {
+		t.Fatalf("got waits %v, want %v", clock.waits, want)
+	}
+	if !clock.now.Equal(pds.reset) {
+		t.Errorf("last request went out at %v, want the reset at %v", clock.now, pds.reset)
+	}
+}
+
+func TestTransportWaitsForResetWhenExhausted(t *testing.T) {
+	pds := &fakePDS{limit: 5, remaining: 1, window: time.Minute}
+	client, _, clock, url := newTestClient(t, pds)
+	pds.reset = clock.now.Add(30 * time.Second)
+
+	get(t, client, url)
+	get(t, client, url)
+
+	if len(clock.waits) != 1 || clock.waits[0] != 30*time.Second {
+		t.Fatalf("expected to wait 30s for the reset, waited %v", clock.waits)
+	}
+}
+
+func TestTransportBacksOffAfter429(t *testing.T) {
+	pds := &fakePDS{limit: 5, remaining: 3, window: time.Minute, status: http.StatusTooManyRequests}
+	client, _, clock, url := newTestClient(t, pds)
+	pds.reset = clock.now.Add(45 * time.Second)
+
+	if resp := get(t, client, url); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", resp.StatusCode)
+	}
+
+	pds.status = http.StatusOK
+	get(t, client, url)
+
+	if len(clock.waits) != 1 || clock.waits[0] != 45*time.Second {
+		t.Fatalf("expected to wait 45s after a 429, waited %v", clock.waits)
+	}
+}
+
+func TestTransportForgetsExpiredWindow(t *testing.T) {
+	pds := &fakePDS{limit: 5, remaining: 1, window: time.Minute}
+	client, _, clock, url := newTestClient(t, pds)
+	pds.reset = clock.now.Add(30 * time.Second)
+
+	get(t, client, url)
+	clock.now = clock.now.Add(time.Minute)
+	get(t, client, url)
+
+	if len(clock.waits) != 0 {
+		t.Fatalf("expected no wait once the window has reset, waited %v", clock.waits)
+	}
+}
+
+func TestTransportWithoutHeaders(t *testing.T) {
+	pds := &fakePDS{noHeaders: true}
+	client, transport, clock, url := newTestClient(t, pds)
+
+	for range 5 {
+		get(t, client, url)
+	}
+
+	if len(clock.waits) != 0 {
+		t.Fatalf("expected no waits, got %v", clock.waits)
+	}
+	if _, ok := transport.Budget("/xrpc/com.atproto.repo.applyWrites"); ok {
+		t.Error("expected no budget to be recorded")
+	}
+	if pds.requests != 5 {
+		t.Errorf("expected 5 requests, got %d", pds.requests)
+	}
+}
+
+func TestTransportTracksEndpointsSeparately(t *testing.T) {
+	pds := &fakePDS{limit: 5, remaining: 1, window: time.Minute}
+	client, _, clock, url := newTestClient(t, pds)
+	pds.reset = clock.now.Add(30 * time.Second)
+
+	get(t, client, url)
+
+	// A different method has its own budget, which we know nothing about yet
+	other := url[:len(url)-len("com.atproto.repo.applyWrites")] + "app.bsky.graph.getList"
+	get(t, client, other)
+
+	if len(clock.waits) != 0 {
+		t.Fatalf("expected no waits, got %v", clock.waits)
+	}
+}
+
+func TestTransportSharesWriteBudget(t *testing.T) {
+	pds := &fakePDS{limit: 5, remaining: 1, window: time.Minute}
+	client, transport, clock, url := newTestClient(t, pds)
+	pds.reset = clock.now.Add(30 * time.Second)
+
+	get(t, client, url)
+
+	// Every repo write counts against the same budget for the account
+	deleteRecord := url[:len(url)-len("com.atproto.repo.applyWrites")] + "com.atproto.repo.deleteRecord"
+	if _, ok := transport.Budget("/xrpc/com.atproto.repo.createRecord"); !ok {
+		t.Error("expected createRecord to share the applyWrites budget")
+	}
+	get(t, client, deleteRecord)
+
+	if len(clock.waits) != 1 || clock.waits[0] != 30*time.Second {
+		t.Fatalf("expected to wait 30s for the shared write budget, waited %v", clock.waits)
+	}
+}
+
+func TestWaitIsOutsideClientTimeout(t *testing.T) {
+	pds := &fakePDS{limit: 5, remaining: 1, window: time.Minute}
+	client, transport, clock, url := newTestClient(t, pds)
+	pds.reset = clock.now.Add(30 * time.Second)
+	client.Timeout = 50 * time.Millisecond
+
+	// Waiting longer than the client's timeout must not fail the request
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		time.Sleep(100 * time.Millisecond)
+		clock.now = clock.now.Add(d)
+		return nil
+	}
+
+	get(t, client, url)
+	if resp := get(t, client, url); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the request after the wait to succeed, got %d", resp.StatusCode)
+	}
+}
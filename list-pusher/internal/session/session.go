@@ -0,0 +1,350 @@
+// Package session owns the atproto login for the list-pusher commands: loading
+// credentials from the environment, creating the session, refreshing the access
+// token before it expires and logging in again when the refresh token is dead.
+package session
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"list-pusher/internal/identifier"
+	"list-pusher/internal/ratelimit"
+)
+
+// refreshMargin is how long before the access token expires that we refresh it
+const refreshMargin = 5 * time.Minute
+
+// Config holds the account and list every command works against
+type Config struct {
+	Handle      string
+	AppPassword string
+	ListURI     string
+	PDSHost     string
+}
+
+// LoadLoginConfig loads just the login from environment variables, for
+// commands that never touch our list
+func LoadLoginConfig() (Config, error) {
+	config := Config{
+		Handle:      os.Getenv("BLUESKY_HANDLE"),
+		AppPassword: os.Getenv("BLUESKY_APP_PASSWORD"),
+		PDSHost:     strings.TrimSuffix(os.Getenv("BLUESKY_PDS_HOST"), "/"),
+	}
+
+	// Validate required fields
+	if config.Handle == "" {
+		return config, fmt.Errorf("BLUESKY_HANDLE environment variable is required")
+	}
+	if config.AppPassword == "" {
+		return config, fmt.Errorf("BLUESKY_APP_PASSWORD environment variable is required")
+	}
+
+	return config, nil
+}
+
+// LoadConfig loads configuration from environment variables
+func LoadConfig() (Config, error) {
+	config, err := LoadLoginConfig()
+	if err != nil {
+		return config, err
+	}
+
+	config.ListURI, err = loadListURI(identifier.DirectoryResolver())
+	return config, err
+}
+
+// LoadListConfig loads just our list from BLUESKY_LIST_URI, for commands that
+// work offline from the state store. The list's owner must be given by DID.
+func LoadListConfig() (Config, error) {
+	listURI, err := loadListURI(identifier.OfflineResolver())
+	return Config{ListURI: listURI}, err
+}
+
+// loadListURI reads BLUESKY_LIST_URI, resolving its owner's handle with resolve
+func loadListURI(resolve identifier.HandleResolver) (string, error) {
+	raw := os.Getenv("BLUESKY_LIST_URI")
+	if raw == "" {
+		return "", fmt.Errorf("BLUESKY_LIST_URI environment variable is required")
+	}
+
+	// Accept bsky.app list URLs as well as AT-URIs
+	listURI, err := identifier.NormalizeList(context.Background(), resolve, raw)
+	if err != nil {
+		return "", fmt.Errorf("BLUESKY_LIST_URI should be a list AT-URI or bsky.app list URL: %w", err)
+	}
+	return listURI, nil
+}
+
+// ResolvePDSHost finds the PDS hosting an account by resolving its handle (or
+// DID) through dir and reading the #atproto_pds service endpoint from the DID
+// document
+func ResolvePDSHost(ctx context.Context, dir identity.Directory, identifier string) (string, error) {
+	atid, err := syntax.ParseAtIdentifier(identifier)
+	if err != nil {
+		return "", fmt.Errorf("invalid handle or DID %s: %w", identifier, err)
+	}
+
+	ident, err := dir.Lookup(ctx, *atid)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", identifier, err)
+	}
+
+	pdsHost := ident.PDSEndpoint()
+	if pdsHost == "" {
+		return "", fmt.Errorf("DID document for %s has no #atproto_pds service endpoint", ident.DID)
+	}
+
+	return strings.TrimSuffix(pdsHost, "/"), nil
+}
+
+// Session is an authenticated connection to the account's PDS. It implements
+// util.LexClient, so it can be passed straight to the indigo API functions, and
+// is safe for concurrent use.
+type Session struct {
+	mu         sync.Mutex
+	config     Config
+	client     *xrpc.Client
+	expires    time.Time
+	httpClient *http.Client
+	limiter    *ratelimit.Transport
+
+	// directory finds the PDS when the config doesn't name one
+	directory identity.Directory
+}
+
+// New creates a session for config. Call Login before making requests.
+func New(config Config) *Session {
+	return NewWithClient(config, util.RobustHTTPClient())
+}
+
+// NewWithClient is New making requests through httpClient, whose transport is
+// wrapped in the rate limiter
+func NewWithClient(config Config, httpClient *http.Client) *Session {
+	limiter := ratelimit.NewTransport(httpClient.Transport)
+	limiter.OnWait = func(path string, wait time.Duration, budget ratelimit.Budget) {
+		if wait >= time.Second {
+			fmt.Printf("Rate limit: %d/%d left for %s, waiting %v\n", budget.Remaining, budget.Limit, path, wait.Round(time.Second))
+		}
+	}
+	httpClient.Transport = limiter
+
+	return &Session{
+		config:     config,
+		httpClient: httpClient,
+		limiter:    limiter,
+		directory:  identity.DefaultDirectory(),
+	}
+}
+
+// Config returns the configuration the session was created with
+func (s *Session) Config() Config {
+	return s.config
+}
+
+// Login creates a new session with com.atproto.server.createSession
+func (s *Session) Login(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.login(ctx)
+}
+
+func (s *Session) login(ctx context.Context) error {
+	// Use the configured PDS, or discover it from the handle's DID document
+	if s.config.PDSHost == "" {
+		pdsHost, err := ResolvePDSHost(ctx, s.directory, s.config.Handle)
+		if err != nil {
+			return fmt.Errorf("failed to discover PDS (set BLUESKY_PDS_HOST to skip discovery): %w", err)
+		}
+		s.config.PDSHost = pdsHost
+	}
+
+	auth := &atproto.ServerCreateSession_Input{
+		Identifier: s.config.Handle,
+		Password:   s.config.AppPassword,
+	}
+
+	out, err := atproto.ServerCreateSession(ctx, &xrpc.Client{Client: s.httpClient, Host: s.config.PDSHost}, auth)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	s.setAuth(&xrpc.AuthInfo{
+		AccessJwt:  out.AccessJwt,
+		RefreshJwt: out.RefreshJwt,
+		Handle:     out.Handle,
+		Did:        out.Did,
+	})
+
+	return nil
+}
+
+// Refresh swaps the refresh token for a new access token, logging in from
+// scratch if the refresh token has been rejected
+func (s *Session) Refresh(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refresh(ctx)
+}
+
+func (s *Session) refresh(ctx context.Context) error {
+	if s.client == nil || s.client.Auth.RefreshJwt == "" {
+		return s.login(ctx)
+	}
+
+	refreshClient := &xrpc.Client{
+		Client: s.httpClient,
+		Host:   s.client.Host,
+		Auth: &xrpc.AuthInfo{
+			AccessJwt: s.client.Auth.RefreshJwt,
+		},
+	}
+
+	out, err := atproto.ServerRefreshSession(ctx, refreshClient)
+	if err != nil {
+		// If refresh fails, try full reauthentication
+		fmt.Println("Refresh token failed, attempting full reauthentication...")
+		return s.login(ctx)
+	}
+
+	s.setAuth(&xrpc.AuthInfo{
+		AccessJwt:  out.AccessJwt,
+		RefreshJwt: out.RefreshJwt,
+		Handle:     out.Handle,
+		Did:        out.Did,
+	})
+
+	fmt.Println("✓ Authentication token refreshed")
+	return nil
+}
+
+// setAuth replaces the client rather than mutating it, so requests already in
+// flight on the old client are unaffected
+func (s *Session) setAuth(auth *xrpc.AuthInfo) {
+	s.client = &xrpc.Client{
+		Client: s.httpClient,
+		Host:   s.config.PDSHost,
+		Auth:   auth,
+	}
+	s.expires = jwtExpiry(auth.AccessJwt)
+}
+
+// current returns a client whose access token is not about to expire
+func (s *Session) current(ctx context.Context) (*xrpc.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		return nil, fmt.Errorf("not authenticated")
+	}
+
+	if !s.expires.IsZero() && time.Until(s.expires) < refreshMargin {
+		if err := s.refresh(ctx); err != nil {
+			return nil, fmt.Errorf("failed to refresh session: %w", err)
+		}
+	}
+
+	return s.client, nil
+}
+
+// Limiter returns the rate limit transport all of the session's requests go through
+func (s *Session) Limiter() *ratelimit.Transport {
+	return s.limiter
+}
+
+// DID returns the DID of the logged in account
+func (s *Session) DID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		return ""
+	}
+	return s.client.Auth.Did
+}
+
+// Host returns the PDS the session is connected to
+func (s *Session) Host() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.config.PDSHost
+}
+
+// LexDo implements util.LexClient. Requests are paced to the PDS's rate
+// limits, counting the cost set on ctx with ratelimit.WithCost, and an
+// expired token is refreshed and the request retried once.
+func (s *Session) LexDo(ctx context.Context, method string, inputEncoding string, endpoint string, params map[string]any, bodyData any, out any) error {
+	if err := s.limiter.Wait(ctx, "/xrpc/"+endpoint, ratelimit.Cost(ctx)); err != nil {
+		return err
+	}
+
+	client, err := s.current(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = client.LexDo(ctx, method, inputEncoding, endpoint, params, bodyData, out)
+	if err == nil || !IsTokenExpired(err) {
+		return err
+	}
+
+	// A streamed body has already been consumed and cannot be resent
+	if _, ok := bodyData.(io.Reader); ok {
+		return err
+	}
+
+	fmt.Println("Token expired, attempting to refresh...")
+	if refreshErr := s.Refresh(ctx); refreshErr != nil {
+		return fmt.Errorf("failed to refresh token: %w", refreshErr)
+	}
+
+	if err := s.limiter.Wait(ctx, "/xrpc/"+endpoint, ratelimit.Cost(ctx)); err != nil {
+		return err
+	}
+	client, err = s.current(ctx)
+	if err != nil {
+		return err
+	}
+	return client.LexDo(ctx, method, inputEncoding, endpoint, params, bodyData, out)
+}
+
+// IsTokenExpired reports whether err means the access token needs refreshing
+func IsTokenExpired(err error) bool {
+	return strings.Contains(err.Error(), "ExpiredToken") || strings.Contains(err.Error(), "Token has expired")
+}
+
+// jwtExpiry reads the exp claim from a JWT without verifying it. It returns
+// the zero time if the token cannot be parsed.
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(claims.Exp, 0)
+}
@@ -0,0 +1,370 @@
+package session
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+const (
+	testDID    = "did:plc:testaccount"
+	testHandle = "alice.test"
+)
+
+// testDirectory resolves testHandle to a DID document whose #atproto_pds
+// endpoint is pds, or which has no PDS service if pds is empty
+func testDirectory(pds string) identity.Directory {
+	dir := identity.NewMockDirectory()
+	ident := identity.Identity{DID: syntax.DID(testDID), Handle: syntax.Handle(testHandle)}
+	if pds != "" {
+		ident.Services = map[string]identity.ServiceEndpoint{
+			"atproto_pds": {Type: "AtprotoPersonalDataServer", URL: pds},
+		}
+	}
+	dir.Insert(ident)
+	return &dir
+}
+
+// newCreateSessionServer is a PDS that only accepts com.atproto.server.createSession
+func newCreateSessionServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/xrpc/com.atproto.server.createSession" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"accessJwt": "access", "refreshJwt": "refresh", "handle": testHandle, "did": testDID,
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestResolvePDSHost(t *testing.T) {
+	tests := []struct {
+		name       string
+		identifier string
+		pds        string
+		want       string
+		wantErr    bool
+	}{
+		{name: "handle", identifier: testHandle, pds: "https://pds.example.com", want: "https://pds.example.com"},
+		{name: "DID", identifier: testDID, pds: "https://pds.example.com", want: "https://pds.example.com"},
+		{name: "trailing slash trimmed", identifier: testHandle, pds: "https://pds.example.com/", want: "https://pds.example.com"},
+		{name: "no PDS service", identifier: testHandle, wantErr: true},
+		{name: "unknown handle", identifier: "bob.test", pds: "https://pds.example.com", wantErr: true},
+		{name: "not a handle or DID", identifier: "not a handle", pds: "https://pds.example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolvePDSHost(context.Background(), testDirectory(tt.pds), tt.identifier)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginPDSHost(t *testing.T) {
+	server := newCreateSessionServer(t)
+
+	tests := []struct {
+		name     string
+		override string
+		pds      string
+		wantErr  bool
+	}{
+		// The override is used without looking at the DID document at all
+		{name: "override", override: server.URL, pds: "https://elsewhere.example.com"},
+		{name: "override without a PDS service", override: server.URL},
+		{name: "from the DID document", pds: server.URL},
+		{name: "no override and no PDS service", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(Config{Handle: testHandle, AppPassword: "password", PDSHost: tt.override})
+			s.directory = testDirectory(tt.pds)
+
+			err := s.Login(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if s.Host() != server.URL || s.DID() != testDID {
+				t.Errorf("logged in to %s as %s, want %s as %s", s.Host(), s.DID(), server.URL, testDID)
+			}
+		})
+	}
+}
+
+// jwt builds an unsigned token whose payload is claims
+func jwt(claims string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"ES256K","typ":"JWT"}`)) + "." + encode([]byte(claims)) + ".c2lnbmF0dXJl"
+}
+
+// expiringJWT builds a token that expires at exp
+func expiringJWT(exp time.Time) string {
+	return jwt(fmt.Sprintf(`{"sub":%q,"exp":%d}`, testDID, exp.Unix()))
+}
+
+func TestJWTExpiry(t *testing.T) {
+	exp := time.Unix(1_700_000_000, 0)
+
+	tests := []struct {
+		name  string
+		token string
+		want  time.Time
+	}{
+		{name: "valid", token: expiringJWT(exp), want: exp},
+		{name: "empty", token: ""},
+		{name: "two parts", token: "a.b"},
+		{name: "four parts", token: expiringJWT(exp) + ".extra"},
+		{name: "payload not base64", token: "header.!!!.signature"},
+		{name: "payload padded base64", token: "header." + base64.URLEncoding.EncodeToString([]byte(`{"exp": 1700000000}`)) + ".signature"},
+		{name: "payload not JSON", token: jwt("not json")},
+		{name: "payload a JSON array", token: jwt(`[1, 2]`)},
+		{name: "no exp claim", token: jwt(`{"sub":"did:plc:x"}`)},
+		{name: "exp a string", token: jwt(`{"exp":"1700000000"}`)},
+		{name: "exp zero", token: jwt(`{"exp":0}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jwtExpiry(tt.token); !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakePDS is an XRPC server handing out access tokens that live for
+// accessTTL and counting the calls to each method
+type fakePDS struct {
+	mu        sync.Mutex
+	accessTTL time.Duration
+	calls     map[string]int
+	tokens    int
+
+	// refreshRejected makes refreshSession fail as it does for an expired
+	// refresh token; expireNext makes the next getSession call reject its
+	// access token
+	refreshRejected bool
+	expireNext      bool
+
+	// rateLimited makes every response report an exhausted budget
+	rateLimited bool
+
+	// accessToken is the token getSession last accepted
+	accessToken string
+}
+
+func newFakePDS(t *testing.T, accessTTL time.Duration) (*fakePDS, *Session) {
+	t.Helper()
+	pds := &fakePDS{accessTTL: accessTTL, calls: make(map[string]int)}
+	server := httptest.NewServer(pds)
+	t.Cleanup(server.Close)
+
+	s := NewWithClient(Config{Handle: testHandle, AppPassword: "password", PDSHost: server.URL}, &http.Client{})
+	return pds, s
+}
+
+func (p *fakePDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	method := r.URL.Path[len("/xrpc/"):]
+	p.calls[method]++
+
+	if p.rateLimited {
+		w.Header().Set("ratelimit-limit", "100")
+		w.Header().Set("ratelimit-remaining", "0")
+		w.Header().Set("ratelimit-reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	}
+
+	switch method {
+	case "com.atproto.server.createSession":
+		p.issue(w)
+	case "com.atproto.server.refreshSession":
+		if p.refreshRejected {
+			xrpcError(w, "ExpiredToken", "Token has expired")
+			return
+		}
+		p.issue(w)
+	case "com.atproto.server.getSession":
+		if p.expireNext {
+			p.expireNext = false
+			xrpcError(w, "ExpiredToken", "Token has expired")
+			return
+		}
+		p.accessToken = r.Header.Get("Authorization")
+		json.NewEncoder(w).Encode(map[string]string{"handle": testHandle, "did": testDID})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// issue responds with a new pair of tokens
+func (p *fakePDS) issue(w http.ResponseWriter) {
+	p.tokens++
+	json.NewEncoder(w).Encode(map[string]string{
+		"accessJwt":  expiringJWT(time.Now().Add(p.accessTTL)),
+		"refreshJwt": fmt.Sprintf("refresh-%d", p.tokens),
+		"handle":     testHandle,
+		"did":        testDID,
+	})
+}
+
+func xrpcError(w http.ResponseWriter, name, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": name, "message": message})
+}
+
+func (p *fakePDS) count(method string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls[method]
+}
+
+func TestLexDoBeforeLogin(t *testing.T) {
+	pds, s := newFakePDS(t, time.Hour)
+
+	if _, err := atproto.ServerGetSession(context.Background(), s); err == nil {
+		t.Fatal("expected an error before logging in")
+	}
+	if n := pds.count("com.atproto.server.getSession"); n != 0 {
+		t.Errorf("made %d requests without a session", n)
+	}
+}
+
+func TestProactiveRefresh(t *testing.T) {
+	ctx := context.Background()
+
+	// A token with more than refreshMargin left is used as it is; one closer
+	// to expiring is refreshed before the request goes out
+	for _, tt := range []struct {
+		ttl         time.Duration
+		wantRefresh int
+	}{
+		{ttl: time.Hour, wantRefresh: 0},
+		{ttl: time.Minute, wantRefresh: 1},
+	} {
+		t.Run(tt.ttl.String(), func(t *testing.T) {
+			pds, s := newFakePDS(t, tt.ttl)
+			if err := s.Login(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantRefresh > 0 {
+				// Tokens from the refresh are long lived
+				pds.mu.Lock()
+				pds.accessTTL = time.Hour
+				pds.mu.Unlock()
+			}
+			issued := s.client.Auth.AccessJwt
+
+			if _, err := atproto.ServerGetSession(ctx, s); err != nil {
+				t.Fatal(err)
+			}
+
+			if n := pds.count("com.atproto.server.refreshSession"); n != tt.wantRefresh {
+				t.Errorf("got %d refreshes, want %d", n, tt.wantRefresh)
+			}
+			if pds.count("com.atproto.server.createSession") != 1 {
+				t.Errorf("logged in again: %v", pds.calls)
+			}
+			if used := pds.accessToken == "Bearer "+issued; used != (tt.wantRefresh == 0) {
+				t.Errorf("request used the login's access token: %v", used)
+			}
+		})
+	}
+}
+
+func TestRefreshAfterExpiredToken(t *testing.T) {
+	ctx := context.Background()
+	pds, s := newFakePDS(t, time.Hour)
+	if err := s.Login(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The PDS expires the token early; the request is retried once refreshed
+	pds.expireNext = true
+	if _, err := atproto.ServerGetSession(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := pds.count("com.atproto.server.getSession"); n != 2 {
+		t.Errorf("got %d getSession calls, want 2", n)
+	}
+	if n := pds.count("com.atproto.server.refreshSession"); n != 1 {
+		t.Errorf("got %d refreshes, want 1", n)
+	}
+}
+
+func TestReloginAfterRefreshTokenExpired(t *testing.T) {
+	ctx := context.Background()
+	pds, s := newFakePDS(t, time.Hour)
+	if err := s.Login(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	pds.refreshRejected = true
+	if err := s.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := pds.count("com.atproto.server.createSession"); n != 2 {
+		t.Errorf("got %d logins, want 2", n)
+	}
+	if got := s.client.Auth.RefreshJwt; got != "refresh-2" {
+		t.Errorf("got refresh token %q from the new login, want refresh-2", got)
+	}
+	if _, err := atproto.ServerGetSession(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLexDoWaitsForRateLimit(t *testing.T) {
+	ctx := context.Background()
+	pds, s := newFakePDS(t, time.Hour)
+	if err := s.Login(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	pds.rateLimited = true
+	if _, err := atproto.ServerGetSession(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	// The budget is gone for an hour, so the next request waits rather than
+	// going out, until its context gives up
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err := atproto.ServerGetSession(ctx, s)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want the wait to time out", err)
+	}
+	if n := pds.count("com.atproto.server.getSession"); n != 1 {
+		t.Errorf("got %d getSession calls, want 1", n)
+	}
+}
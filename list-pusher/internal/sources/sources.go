@@ -0,0 +1,155 @@
+// Package sources manages the registry of source blocklists in the state
+// store: which lists we track, whether each is enabled, our notes on it, and
+// its name and description as last fetched from app.bsky.graph.getList.
+package sources
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/lex/util"
+
+	"list-pusher/internal/identifier"
+	"list-pusher/internal/store"
+)
+
+// modlistPurpose is the only list purpose that can be subscribed to as a blocklist
+const modlistPurpose = "app.bsky.graph.defs#modlist"
+
+// Metadata is what getList tells us about a list
+type Metadata struct {
+	Owner       string
+	Name        string
+	Description string
+	Purpose     string
+}
+
+// Fetch reads a list's metadata with app.bsky.graph.getList
+func Fetch(ctx context.Context, client util.LexClient, uri string) (Metadata, error) {
+	resp, err := bsky.GraphGetList(ctx, client, "", 1, uri)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("failed to fetch list %s: %w", uri, err)
+	}
+	if resp.List == nil {
+		return Metadata{}, fmt.Errorf("failed to fetch list %s: empty response", uri)
+	}
+
+	meta := Metadata{Name: resp.List.Name}
+	if resp.List.Creator != nil {
+		meta.Owner = resp.List.Creator.Did
+	}
+	if resp.List.Description != nil {
+		meta.Description = *resp.List.Description
+	}
+	if resp.List.Purpose != nil {
+		meta.Purpose = *resp.List.Purpose
+	}
+	return meta, nil
+}
+
+// Registry edits the source lists in a state store. client is only needed to
+// add and refresh lists.
+type Registry struct {
+	st      store.Store
+	client  util.LexClient
+	resolve identifier.HandleResolver
+}
+
+// NewRegistry creates a registry over st, fetching metadata through client
+func NewRegistry(st store.Store, client util.LexClient) *Registry {
+	return &Registry{st: st, client: client, resolve: identifier.DirectoryResolver()}
+}
+
+// Lookup returns the tracked list raw refers to, in any form identifier.NormalizeList accepts
+func (r *Registry) Lookup(ctx context.Context, raw string) (store.SourceList, error) {
+	uri, err := identifier.NormalizeList(ctx, r.resolve, raw)
+	if err != nil {
+		return store.SourceList{}, err
+	}
+	return r.st.SourceList(ctx, uri)
+}
+
+// Add starts tracking the list raw refers to, fetching its metadata first so
+// a mistyped list is caught. Adding a tracked list again refreshes it.
+func (r *Registry) Add(ctx context.Context, raw, notes string) (store.SourceList, error) {
+	uri, err := identifier.NormalizeList(ctx, r.resolve, raw)
+	if err != nil {
+		return store.SourceList{}, err
+	}
+
+	meta, err := Fetch(ctx, r.client, uri)
+	if err != nil {
+		return store.SourceList{}, err
+	}
+	if meta.Purpose != "" && meta.Purpose != modlistPurpose {
+		fmt.Printf("Warning: %s is a %s, not a moderation list, so nobody can subscribe to it as a blocklist\n", uri, meta.Purpose)
+	}
+
+	list := store.SourceList{URI: uri, Owner: meta.Owner, Name: meta.Name, Description: meta.Description, Notes: notes}
+	if err := r.st.AddSourceList(ctx, list); err != nil {
+		return store.SourceList{}, err
+	}
+	return r.apply(ctx, uri, meta)
+}
+
+// Refresh re-fetches a tracked list's owner, name and description
+func (r *Registry) Refresh(ctx context.Context, uri string) (store.SourceList, error) {
+	meta, err := Fetch(ctx, r.client, uri)
+	if err != nil {
+		return store.SourceList{}, err
+	}
+	return r.apply(ctx, uri, meta)
+}
+
+// apply saves freshly fetched metadata for a tracked list, keeping our own
+// settings as they are now
+func (r *Registry) apply(ctx context.Context, uri string, meta Metadata) (store.SourceList, error) {
+	list, err := r.st.SourceList(ctx, uri)
+	if err != nil {
+		return list, err
+	}
+
+	now := time.Now().UTC()
+	if meta.Owner != "" {
+		list.Owner = meta.Owner
+	}
+	list.Name = meta.Name
+	list.Description = meta.Description
+	list.RefreshedAt = &now
+
+	if err := r.st.UpdateSourceList(ctx, list); err != nil {
+		return list, err
+	}
+	return list, nil
+}
+
+// Remove stops tracking the list raw refers to
+func (r *Registry) Remove(ctx context.Context, raw string) (store.SourceList, error) {
+	list, err := r.Lookup(ctx, raw)
+	if err != nil {
+		return list, err
+	}
+	return list, r.st.RemoveSourceList(ctx, list.URI)
+}
+
+// SetEnabled enables or disables the list raw refers to
+func (r *Registry) SetEnabled(ctx context.Context, raw string, enabled bool) (store.SourceList, error) {
+	list, err := r.Lookup(ctx, raw)
+	if err != nil {
+		return list, err
+	}
+	list.Enabled = enabled
+	return list, r.st.UpdateSourceList(ctx, list)
+}
+
+// SetNotes replaces our notes on the list raw refers to
+func (r *Registry) SetNotes(ctx context.Context, raw, notes string) (store.SourceList, error) {
+	list, err := r.Lookup(ctx, raw)
+	if err != nil {
+		return list, err
+	}
+	list.Notes = notes
+	return list, r.st.UpdateSourceList(ctx, list)
+}
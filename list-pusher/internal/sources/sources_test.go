@@ -0,0 +1,94 @@
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"list-pusher/internal/store"
+)
+
+const (
+	testOwner = "did:plc:2bij7yypmcuvwyz4gyqwtluy"
+	testList  = "at://" + testOwner + "/app.bsky.graph.list/3lbxfscjqno2d"
+)
+
+// fakeAppView answers getList for testList with the current name
+type fakeAppView struct {
+	t    *testing.T
+	name string
+}
+
+func (f *fakeAppView) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/xrpc/app.bsky.graph.getList" {
+		f.t.Errorf("unexpected path %s", r.URL.Path)
+	}
+	if r.URL.Query().Get("list") != testList {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "InvalidRequest", "message": "List not found"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"list": map[string]any{
+			"uri":         testList,
+			"cid":         "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm",
+			"name":        f.name,
+			"description": "Accounts that post AI art",
+			"purpose":     modlistPurpose,
+			"indexedAt":   "2024-11-20T00:00:00Z",
+			"creator":     map[string]any{"did": testOwner, "handle": "owner.example.com"},
+		},
+		"items": []any{},
+	})
+}
+
+func TestAddAndRefresh(t *testing.T) {
+	ctx := context.Background()
+	appview := &fakeAppView{t: t, name: "AI art"}
+	server := httptest.NewServer(appview)
+	defer server.Close()
+
+	st, err := store.OpenSQLite(ctx, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	registry := NewRegistry(st, &xrpc.Client{Host: server.URL})
+
+	list, err := registry.Add(ctx, "https://bsky.app/profile/"+testOwner+"/lists/3lbxfscjqno2d", "seen on the original list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.URI != testList || list.Owner != testOwner || list.Name != "AI art" || list.Description != "Accounts that post AI art" ||
+		!list.Enabled || list.Notes != "seen on the original list" || list.RefreshedAt == nil {
+		t.Errorf("unexpected added list %+v", list)
+	}
+
+	if _, err := registry.Add(ctx, "at://"+testOwner+"/app.bsky.graph.list/3zzzzzzzzzz2a", ""); err == nil {
+		t.Error("expected adding a missing list to fail")
+	}
+
+	// A disabled list stays disabled, and keeps its notes, through a refresh
+	if _, err := registry.SetEnabled(ctx, testList, false); err != nil {
+		t.Fatal(err)
+	}
+	appview.name = "AI art (renamed)"
+	if _, err := registry.Refresh(ctx, list.URI); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := st.SourceList(ctx, testList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Name != "AI art (renamed)" || stored.Enabled || stored.Notes != "seen on the original list" {
+		t.Errorf("unexpected refreshed list %+v", stored)
+	}
+}
@@ -0,0 +1,569 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresMigrations are applied in order; append, never edit
+var postgresMigrations = []string{
+	`CREATE TABLE source_lists (
+		uri      TEXT PRIMARY KEY,
+		name     TEXT NOT NULL DEFAULT '',
+		added_at TIMESTAMPTZ NOT NULL
+	);
+	CREATE TABLE subscriptions (
+		did        TEXT NOT NULL,
+		list_uri   TEXT NOT NULL,
+		rkey       TEXT NOT NULL DEFAULT '',
+		first_seen TIMESTAMPTZ NOT NULL,
+		last_seen  TIMESTAMPTZ NOT NULL,
+		ended_at   TIMESTAMPTZ,
+		PRIMARY KEY (did, list_uri)
+	);
+	CREATE INDEX subscriptions_rkey ON subscriptions (did, rkey);
+	CREATE TABLE list_items (
+		list_uri   TEXT NOT NULL,
+		rkey       TEXT NOT NULL,
+		did        TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (list_uri, rkey)
+	);
+	CREATE INDEX list_items_did ON list_items (list_uri, did);
+	CREATE TABLE overrides (
+		did        TEXT PRIMARY KEY,
+		action     TEXT NOT NULL,
+		reason     TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL
+	);
+	CREATE TABLE state (
+		name       TEXT PRIMARY KEY,
+		value      BYTEA NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	);`,
+	`ALTER TABLE source_lists
+		ADD COLUMN owner TEXT NOT NULL DEFAULT '',
+		ADD COLUMN description TEXT NOT NULL DEFAULT '',
+		ADD COLUMN enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		ADD COLUMN notes TEXT NOT NULL DEFAULT '',
+		ADD COLUMN refreshed_at TIMESTAMPTZ;
+	UPDATE source_lists SET owner = split_part(uri, '/', 3) WHERE uri LIKE 'at://%/%';`,
+	`CREATE TABLE evidence (
+		did        TEXT NOT NULL,
+		list_uri   TEXT NOT NULL,
+		source     TEXT NOT NULL,
+		first_seen TIMESTAMPTZ,
+		last_seen  TIMESTAMPTZ,
+		ended_at   TIMESTAMPTZ,
+		PRIMARY KEY (did, list_uri, source)
+	);
+	INSERT INTO evidence (did, list_uri, source, first_seen, last_seen, ended_at)
+		SELECT did, list_uri, 'legacy', first_seen, last_seen, ended_at FROM subscriptions;`,
+	`CREATE TABLE push_journal (
+		seq   BIGSERIAL PRIMARY KEY,
+		entry BYTEA NOT NULL
+	);`,
+	`CREATE INDEX subscriptions_list ON subscriptions (list_uri);`,
+}
+
+// Postgres is a Store in a Postgres database
+type Postgres struct {
+	pool *pgxpool.Pool
+}
+
+// OpenPostgres connects to the Postgres database at url and brings its schema up to date
+func OpenPostgres(ctx context.Context, url string) (*Postgres, error) {
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+
+	s := &Postgres{pool: pool}
+	if err := s.migrate(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to migrate postgres: %w", err)
+	}
+
+	return s, nil
+}
+
+func (s *Postgres) migrate(ctx context.Context) error {
+	if _, err := s.pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		// Serialise concurrent migrations from several commands starting at once
+		if _, err := tx.Exec(ctx, `LOCK TABLE schema_version IN EXCLUSIVE MODE`); err != nil {
+			return err
+		}
+
+		var version int
+		if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+			return err
+		}
+
+		for ; version < len(postgresMigrations); version++ {
+			if _, err := tx.Exec(ctx, postgresMigrations[version]); err != nil {
+				return fmt.Errorf("migration %d: %w", version+1, err)
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO schema_version (version) VALUES ($1)`, version+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Postgres) AddSourceList(ctx context.Context, list SourceList) error {
+	if list.AddedAt.IsZero() {
+		list.AddedAt = time.Now()
+	}
+	if list.Owner == "" {
+		list.Owner = listOwner(list.URI)
+	}
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO source_lists (uri, owner, name, description, enabled, notes, added_at) VALUES ($1, $2, $3, $4, TRUE, $5, $6)
+		ON CONFLICT (uri) DO UPDATE SET
+			owner = CASE WHEN excluded.owner <> '' THEN excluded.owner ELSE source_lists.owner END,
+			name = CASE WHEN excluded.name <> '' THEN excluded.name ELSE source_lists.name END,
+			description = CASE WHEN excluded.description <> '' THEN excluded.description ELSE source_lists.description END,
+			notes = CASE WHEN excluded.notes <> '' THEN excluded.notes ELSE source_lists.notes END`,
+		list.URI, list.Owner, list.Name, list.Description, list.Notes, list.AddedAt)
+	if err != nil {
+		return fmt.Errorf("failed to add source list %s: %w", list.URI, err)
+	}
+	return nil
+}
+
+func (s *Postgres) UpdateSourceList(ctx context.Context, list SourceList) error {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE source_lists SET owner = $1, name = $2, description = $3, enabled = $4, notes = $5, refreshed_at = $6 WHERE uri = $7`,
+		list.Owner, list.Name, list.Description, list.Enabled, list.Notes, list.RefreshedAt, list.URI)
+	if err != nil {
+		return fmt.Errorf("failed to update source list %s: %w", list.URI, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("source list %s: %w", list.URI, ErrNotFound)
+	}
+	return nil
+}
+
+func (s *Postgres) RemoveSourceList(ctx context.Context, uri string) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM source_lists WHERE uri = $1`, uri)
+	if err != nil {
+		return fmt.Errorf("failed to remove source list %s: %w", uri, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("source list %s: %w", uri, ErrNotFound)
+	}
+	return nil
+}
+
+const postgresSourceListColumns = `uri, owner, name, description, enabled, notes, added_at, refreshed_at`
+
+func (s *Postgres) SourceList(ctx context.Context, uri string) (SourceList, error) {
+	list, err := scanPostgresSourceList(s.pool.QueryRow(ctx, `SELECT `+postgresSourceListColumns+` FROM source_lists WHERE uri = $1`, uri))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return SourceList{}, fmt.Errorf("source list %s: %w", uri, ErrNotFound)
+	}
+	if err != nil {
+		return SourceList{}, fmt.Errorf("failed to read source list %s: %w", uri, err)
+	}
+	return list, nil
+}
+
+func (s *Postgres) SourceLists(ctx context.Context) ([]SourceList, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+postgresSourceListColumns+` FROM source_lists ORDER BY added_at, uri`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source lists: %w", err)
+	}
+
+	lists, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (SourceList, error) {
+		return scanPostgresSourceList(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source lists: %w", err)
+	}
+	return lists, nil
+}
+
+func scanPostgresSourceList(row pgx.Row) (SourceList, error) {
+	var list SourceList
+	if err := row.Scan(&list.URI, &list.Owner, &list.Name, &list.Description, &list.Enabled, &list.Notes, &list.AddedAt, &list.RefreshedAt); err != nil {
+		return list, err
+	}
+	list.AddedAt = list.AddedAt.UTC()
+	if list.RefreshedAt != nil {
+		refreshed := list.RefreshedAt.UTC()
+		list.RefreshedAt = &refreshed
+	}
+	return list, nil
+}
+
+func (s *Postgres) RecordSubscription(ctx context.Context, source string, sub Subscription) error {
+	if sub.FirstSeen.IsZero() {
+		sub.FirstSeen = sub.LastSeen
+	}
+
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO subscriptions (did, list_uri, rkey, first_seen, last_seen) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (did, list_uri) DO UPDATE SET
+				rkey       = CASE WHEN excluded.rkey <> '' THEN excluded.rkey ELSE subscriptions.rkey END,
+				first_seen = LEAST(subscriptions.first_seen, excluded.first_seen),
+				last_seen  = GREATEST(subscriptions.last_seen, excluded.last_seen),
+				ended_at   = CASE WHEN subscriptions.ended_at <= excluded.last_seen THEN NULL ELSE subscriptions.ended_at END`,
+			sub.DID, sub.ListURI, sub.RecordKey, sub.FirstSeen, sub.LastSeen); err != nil {
+			return fmt.Errorf("failed to record subscription of %s to %s: %w", sub.DID, sub.ListURI, err)
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO evidence (did, list_uri, source, first_seen, last_seen) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (did, list_uri, source) DO UPDATE SET
+				first_seen = LEAST(evidence.first_seen, excluded.first_seen),
+				last_seen  = GREATEST(evidence.last_seen, excluded.last_seen),
+				ended_at   = CASE WHEN evidence.ended_at <= excluded.last_seen THEN NULL ELSE evidence.ended_at END`,
+			sub.DID, sub.ListURI, source, sub.FirstSeen, sub.LastSeen); err != nil {
+			return fmt.Errorf("failed to record %s evidence of %s on %s: %w", source, sub.DID, sub.ListURI, err)
+		}
+		return nil
+	})
+}
+
+func (s *Postgres) EndSubscription(ctx context.Context, source, did, rkey string, at time.Time) (Subscription, error) {
+	return s.endSubscription(ctx, source, at, `did = $1 AND rkey = $2`, did, rkey)
+}
+
+func (s *Postgres) EndListSubscription(ctx context.Context, source, did, listURI string, at time.Time) (Subscription, error) {
+	return s.endSubscription(ctx, source, at, `did = $1 AND list_uri = $2`, did, listURI)
+}
+
+// endSubscription ends the subscription matching where, unless it was seen after at
+func (s *Postgres) endSubscription(ctx context.Context, source string, at time.Time, where string, key1, key2 string) (Subscription, error) {
+	var sub Subscription
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		var err error
+		sub, err = scanPostgresSubscription(tx.QueryRow(ctx, `
+			SELECT did, list_uri, rkey, first_seen, last_seen, ended_at FROM subscriptions
+			WHERE `+where+` FOR UPDATE`, key1, key2))
+		if err != nil {
+			return err
+		}
+
+		if sub.Active() && !at.Before(sub.LastSeen) {
+			if _, err := tx.Exec(ctx, `UPDATE subscriptions SET ended_at = $1 WHERE did = $2 AND list_uri = $3`, at, sub.DID, sub.ListURI); err != nil {
+				return err
+			}
+			ended := at.UTC()
+			sub.EndedAt = &ended
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO evidence (did, list_uri, source, ended_at) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (did, list_uri, source) DO UPDATE SET
+				ended_at = CASE WHEN evidence.last_seen > excluded.ended_at THEN evidence.ended_at
+					ELSE LEAST(evidence.ended_at, excluded.ended_at) END`,
+			sub.DID, sub.ListURI, source, at)
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Subscription{}, fmt.Errorf("subscription %s/%s: %w", key1, key2, ErrNotFound)
+	}
+	if err != nil {
+		return Subscription{}, fmt.Errorf("failed to end subscription %s/%s: %w", key1, key2, err)
+	}
+	return sub, nil
+}
+
+func (s *Postgres) Evidence(ctx context.Context) ([]Evidence, error) {
+	return s.queryEvidence(ctx, `SELECT did, list_uri, source, first_seen, last_seen, ended_at FROM evidence ORDER BY did, list_uri, source`)
+}
+
+func (s *Postgres) EvidenceOf(ctx context.Context, did string) ([]Evidence, error) {
+	return s.queryEvidence(ctx, `SELECT did, list_uri, source, first_seen, last_seen, ended_at FROM evidence WHERE did = $1 ORDER BY list_uri, source`, did)
+}
+
+func (s *Postgres) queryEvidence(ctx context.Context, query string, args ...any) ([]Evidence, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read evidence: %w", err)
+	}
+
+	evidence, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Evidence, error) {
+		var e Evidence
+		var firstSeen, lastSeen *time.Time
+		if err := row.Scan(&e.DID, &e.ListURI, &e.Source, &firstSeen, &lastSeen, &e.EndedAt); err != nil {
+			return e, err
+		}
+		if firstSeen != nil {
+			e.FirstSeen = firstSeen.UTC()
+		}
+		if lastSeen != nil {
+			e.LastSeen = lastSeen.UTC()
+		}
+		if e.EndedAt != nil {
+			ended := e.EndedAt.UTC()
+			e.EndedAt = &ended
+		}
+		return e, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read evidence: %w", err)
+	}
+	return evidence, nil
+}
+
+func (s *Postgres) Subscriptions(ctx context.Context) ([]Subscription, error) {
+	rows, err := s.pool.Query(ctx, `SELECT did, list_uri, rkey, first_seen, last_seen, ended_at FROM subscriptions ORDER BY did, list_uri`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subscriptions: %w", err)
+	}
+
+	subs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Subscription, error) {
+		return scanPostgresSubscription(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subscriptions: %w", err)
+	}
+	return subs, nil
+}
+
+func (s *Postgres) SubscriptionsOf(ctx context.Context, did string) ([]Subscription, error) {
+	rows, err := s.pool.Query(ctx, `SELECT did, list_uri, rkey, first_seen, last_seen, ended_at FROM subscriptions WHERE did = $1 ORDER BY list_uri`, did)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subscriptions of %s: %w", did, err)
+	}
+
+	subs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Subscription, error) {
+		return scanPostgresSubscription(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subscriptions of %s: %w", did, err)
+	}
+	return subs, nil
+}
+
+func (s *Postgres) SubscriptionsTo(ctx context.Context, listURI string) ([]Subscription, error) {
+	rows, err := s.pool.Query(ctx, `SELECT did, list_uri, rkey, first_seen, last_seen, ended_at FROM subscriptions WHERE list_uri = $1 ORDER BY did`, listURI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subscriptions to %s: %w", listURI, err)
+	}
+
+	subs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Subscription, error) {
+		return scanPostgresSubscription(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subscriptions to %s: %w", listURI, err)
+	}
+	return subs, nil
+}
+
+func scanPostgresSubscription(row pgx.Row) (Subscription, error) {
+	var sub Subscription
+	if err := row.Scan(&sub.DID, &sub.ListURI, &sub.RecordKey, &sub.FirstSeen, &sub.LastSeen, &sub.EndedAt); err != nil {
+		return sub, err
+	}
+	sub.FirstSeen = sub.FirstSeen.UTC()
+	sub.LastSeen = sub.LastSeen.UTC()
+	if sub.EndedAt != nil {
+		ended := sub.EndedAt.UTC()
+		sub.EndedAt = &ended
+	}
+	return sub, nil
+}
+
+func (s *Postgres) ListItems(ctx context.Context, listURI string) ([]ListItem, error) {
+	rows, err := s.pool.Query(ctx, `SELECT list_uri, did, rkey, created_at FROM list_items WHERE list_uri = $1 ORDER BY created_at, rkey`, listURI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read list items: %w", err)
+	}
+
+	items, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (ListItem, error) {
+		var item ListItem
+		err := row.Scan(&item.ListURI, &item.DID, &item.RecordKey, &item.CreatedAt)
+		item.CreatedAt = item.CreatedAt.UTC()
+		return item, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read list items: %w", err)
+	}
+	return items, nil
+}
+
+func (s *Postgres) PutListItem(ctx context.Context, item ListItem) error {
+	if item.CreatedAt.IsZero() {
+		item.CreatedAt = time.Now()
+	}
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO list_items (list_uri, rkey, did, created_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (list_uri, rkey) DO UPDATE SET did = excluded.did`,
+		item.ListURI, item.RecordKey, item.DID, item.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save list item %s: %w", item.RecordKey, err)
+	}
+	return nil
+}
+
+func (s *Postgres) DeleteListItem(ctx context.Context, listURI, rkey string) error {
+	if _, err := s.pool.Exec(ctx, `DELETE FROM list_items WHERE list_uri = $1 AND rkey = $2`, listURI, rkey); err != nil {
+		return fmt.Errorf("failed to delete list item %s: %w", rkey, err)
+	}
+	return nil
+}
+
+func (s *Postgres) ReplaceListItems(ctx context.Context, listURI string, items []ListItem) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		// Keep the creation times we already know about
+		rows, err := tx.Query(ctx, `SELECT rkey, created_at FROM list_items WHERE list_uri = $1`, listURI)
+		if err != nil {
+			return fmt.Errorf("failed to read list items: %w", err)
+		}
+		known := make(map[string]time.Time)
+		var rkey string
+		var createdAt time.Time
+		if _, err := pgx.ForEachRow(rows, []any{&rkey, &createdAt}, func() error {
+			known[rkey] = createdAt
+			return nil
+		}); err != nil {
+			return fmt.Errorf("failed to read list items: %w", err)
+		}
+
+		if _, err := tx.Exec(ctx, `DELETE FROM list_items WHERE list_uri = $1`, listURI); err != nil {
+			return fmt.Errorf("failed to clear list items: %w", err)
+		}
+
+		now := time.Now()
+		seen := make(map[string]bool, len(items))
+		var copyRows [][]any
+		for _, item := range items {
+			if seen[item.RecordKey] {
+				continue
+			}
+			seen[item.RecordKey] = true
+
+			created, ok := known[item.RecordKey]
+			if !item.CreatedAt.IsZero() {
+				created = item.CreatedAt
+			} else if !ok {
+				created = now
+			}
+			copyRows = append(copyRows, []any{listURI, item.RecordKey, item.DID, created})
+		}
+
+		_, err = tx.CopyFrom(ctx, pgx.Identifier{"list_items"}, []string{"list_uri", "rkey", "did", "created_at"}, pgx.CopyFromRows(copyRows))
+		if err != nil {
+			return fmt.Errorf("failed to save list items: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *Postgres) SetOverride(ctx context.Context, override Override) error {
+	if override.CreatedAt.IsZero() {
+		override.CreatedAt = time.Now()
+	}
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO overrides (did, action, reason, created_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (did) DO UPDATE SET action = excluded.action, reason = excluded.reason, created_at = excluded.created_at
+		WHERE overrides.action <> excluded.action`,
+		override.DID, string(override.Action), override.Reason, override.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save override for %s: %w", override.DID, err)
+	}
+	return nil
+}
+
+func (s *Postgres) DeleteOverride(ctx context.Context, did string) error {
+	if _, err := s.pool.Exec(ctx, `DELETE FROM overrides WHERE did = $1`, did); err != nil {
+		return fmt.Errorf("failed to delete override for %s: %w", did, err)
+	}
+	return nil
+}
+
+func (s *Postgres) Overrides(ctx context.Context) ([]Override, error) {
+	rows, err := s.pool.Query(ctx, `SELECT did, action, reason, created_at FROM overrides ORDER BY did`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overrides: %w", err)
+	}
+
+	overrides, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Override, error) {
+		var override Override
+		var action string
+		err := row.Scan(&override.DID, &action, &override.Reason, &override.CreatedAt)
+		override.Action = OverrideAction(action)
+		override.CreatedAt = override.CreatedAt.UTC()
+		return override, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overrides: %w", err)
+	}
+	return overrides, nil
+}
+
+func (s *Postgres) State(ctx context.Context, name string) ([]byte, error) {
+	var value []byte
+	err := s.pool.QueryRow(ctx, `SELECT value FROM state WHERE name = $1`, name).Scan(&value)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("state %s: %w", name, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state %s: %w", name, err)
+	}
+	return value, nil
+}
+
+func (s *Postgres) SetState(ctx context.Context, name string, value []byte) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO state (name, value, updated_at) VALUES ($1, $2, now())
+		ON CONFLICT (name) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+		name, value)
+	if err != nil {
+		return fmt.Errorf("failed to save state %s: %w", name, err)
+	}
+	return nil
+}
+
+func (s *Postgres) Journal(ctx context.Context) ([][]byte, error) {
+	rows, err := s.pool.Query(ctx, `SELECT entry FROM push_journal ORDER BY seq`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read push journal: %w", err)
+	}
+
+	var entries [][]byte
+	var entry []byte
+	if _, err := pgx.ForEachRow(rows, []any{&entry}, func() error {
+		entries = append(entries, append([]byte(nil), entry...))
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to read push journal: %w", err)
+	}
+	return entries, nil
+}
+
+func (s *Postgres) AppendJournal(ctx context.Context, reset bool, entries ...[]byte) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		if reset {
+			if _, err := tx.Exec(ctx, `DELETE FROM push_journal`); err != nil {
+				return fmt.Errorf("failed to clear push journal: %w", err)
+			}
+		}
+
+		rows := make([][]any, 0, len(entries))
+		for _, entry := range entries {
+			rows = append(rows, []any{entry})
+		}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"push_journal"}, []string{"entry"}, pgx.CopyFromRows(rows)); err != nil {
+			return fmt.Errorf("failed to write push journal: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *Postgres) Close() error {
+	s.pool.Close()
+	return nil
+}
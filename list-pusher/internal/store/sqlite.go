@@ -0,0 +1,622 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations are applied in order; append, never edit
+var sqliteMigrations = []string{
+	`CREATE TABLE source_lists (
+		uri      TEXT PRIMARY KEY,
+		name     TEXT NOT NULL DEFAULT '',
+		added_at INTEGER NOT NULL
+	);
+	CREATE TABLE subscriptions (
+		did        TEXT NOT NULL,
+		list_uri   TEXT NOT NULL,
+		rkey       TEXT NOT NULL DEFAULT '',
+		first_seen INTEGER NOT NULL,
+		last_seen  INTEGER NOT NULL,
+		ended_at   INTEGER,
+		PRIMARY KEY (did, list_uri)
+	);
+	CREATE INDEX subscriptions_rkey ON subscriptions (did, rkey);
+	CREATE TABLE list_items (
+		list_uri   TEXT NOT NULL,
+		rkey       TEXT NOT NULL,
+		did        TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (list_uri, rkey)
+	);
+	CREATE INDEX list_items_did ON list_items (list_uri, did);
+	CREATE TABLE overrides (
+		did        TEXT PRIMARY KEY,
+		action     TEXT NOT NULL,
+		reason     TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL
+	);
+	CREATE TABLE state (
+		name       TEXT PRIMARY KEY,
+		value      BLOB NOT NULL,
+		updated_at INTEGER NOT NULL
+	);`,
+	`ALTER TABLE source_lists ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+	ALTER TABLE source_lists ADD COLUMN description TEXT NOT NULL DEFAULT '';
+	ALTER TABLE source_lists ADD COLUMN enabled INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE source_lists ADD COLUMN notes TEXT NOT NULL DEFAULT '';
+	ALTER TABLE source_lists ADD COLUMN refreshed_at INTEGER;
+	UPDATE source_lists SET owner = substr(uri, 6, instr(substr(uri, 6), '/') - 1) WHERE uri LIKE 'at://%/%';`,
+	`CREATE TABLE evidence (
+		did        TEXT NOT NULL,
+		list_uri   TEXT NOT NULL,
+		source     TEXT NOT NULL,
+		first_seen INTEGER,
+		last_seen  INTEGER,
+		ended_at   INTEGER,
+		PRIMARY KEY (did, list_uri, source)
+	);
+	INSERT INTO evidence (did, list_uri, source, first_seen, last_seen, ended_at)
+		SELECT did, list_uri, 'legacy', first_seen, last_seen, ended_at FROM subscriptions;`,
+	`CREATE TABLE push_journal (
+		seq   INTEGER PRIMARY KEY AUTOINCREMENT,
+		entry BLOB NOT NULL
+	);`,
+	`CREATE INDEX subscriptions_list ON subscriptions (list_uri);`,
+}
+
+// SQLite is a Store in a local SQLite database. Times are stored as unix microseconds.
+type SQLite struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens (creating if needed) the SQLite database at path and brings its schema up to date
+func OpenSQLite(ctx context.Context, path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+	// One writer at a time is all SQLite allows anyway
+	db.SetMaxOpenConns(1)
+
+	s := &SQLite{db: db}
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+func (s *SQLite) migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+
+	var version int
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, sqliteMigrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_version (version) VALUES (?)`, version+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func toMicros(t time.Time) int64 {
+	return t.UnixMicro()
+}
+
+func fromMicros(us int64) time.Time {
+	return time.UnixMicro(us).UTC()
+}
+
+func (s *SQLite) AddSourceList(ctx context.Context, list SourceList) error {
+	if list.AddedAt.IsZero() {
+		list.AddedAt = time.Now()
+	}
+	if list.Owner == "" {
+		list.Owner = listOwner(list.URI)
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO source_lists (uri, owner, name, description, enabled, notes, added_at) VALUES (?, ?, ?, ?, 1, ?, ?)
+		ON CONFLICT (uri) DO UPDATE SET
+			owner = CASE WHEN excluded.owner <> '' THEN excluded.owner ELSE source_lists.owner END,
+			name = CASE WHEN excluded.name <> '' THEN excluded.name ELSE source_lists.name END,
+			description = CASE WHEN excluded.description <> '' THEN excluded.description ELSE source_lists.description END,
+			notes = CASE WHEN excluded.notes <> '' THEN excluded.notes ELSE source_lists.notes END`,
+		list.URI, list.Owner, list.Name, list.Description, list.Notes, toMicros(list.AddedAt))
+	if err != nil {
+		return fmt.Errorf("failed to add source list %s: %w", list.URI, err)
+	}
+	return nil
+}
+
+func (s *SQLite) UpdateSourceList(ctx context.Context, list SourceList) error {
+	var refreshedAt sql.NullInt64
+	if list.RefreshedAt != nil {
+		refreshedAt = sql.NullInt64{Int64: toMicros(*list.RefreshedAt), Valid: true}
+	}
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE source_lists SET owner = ?, name = ?, description = ?, enabled = ?, notes = ?, refreshed_at = ? WHERE uri = ?`,
+		list.Owner, list.Name, list.Description, list.Enabled, list.Notes, refreshedAt, list.URI)
+	if err != nil {
+		return fmt.Errorf("failed to update source list %s: %w", list.URI, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("source list %s: %w", list.URI, ErrNotFound)
+	}
+	return nil
+}
+
+func (s *SQLite) RemoveSourceList(ctx context.Context, uri string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM source_lists WHERE uri = ?`, uri)
+	if err != nil {
+		return fmt.Errorf("failed to remove source list %s: %w", uri, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("source list %s: %w", uri, ErrNotFound)
+	}
+	return nil
+}
+
+const sqliteSourceListColumns = `uri, owner, name, description, enabled, notes, added_at, refreshed_at`
+
+func (s *SQLite) SourceList(ctx context.Context, uri string) (SourceList, error) {
+	list, err := scanSQLiteSourceList(s.db.QueryRowContext(ctx, `SELECT `+sqliteSourceListColumns+` FROM source_lists WHERE uri = ?`, uri))
+	if errors.Is(err, sql.ErrNoRows) {
+		return SourceList{}, fmt.Errorf("source list %s: %w", uri, ErrNotFound)
+	}
+	if err != nil {
+		return SourceList{}, fmt.Errorf("failed to read source list %s: %w", uri, err)
+	}
+	return list, nil
+}
+
+func (s *SQLite) SourceLists(ctx context.Context) ([]SourceList, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sqliteSourceListColumns+` FROM source_lists ORDER BY added_at, uri`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source lists: %w", err)
+	}
+	defer rows.Close()
+
+	var lists []SourceList
+	for rows.Next() {
+		list, err := scanSQLiteSourceList(rows)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+	return lists, rows.Err()
+}
+
+func scanSQLiteSourceList(row interface{ Scan(...any) error }) (SourceList, error) {
+	var list SourceList
+	var addedAt int64
+	var refreshedAt sql.NullInt64
+	if err := row.Scan(&list.URI, &list.Owner, &list.Name, &list.Description, &list.Enabled, &list.Notes, &addedAt, &refreshedAt); err != nil {
+		return list, err
+	}
+	list.AddedAt = fromMicros(addedAt)
+	if refreshedAt.Valid {
+		refreshed := fromMicros(refreshedAt.Int64)
+		list.RefreshedAt = &refreshed
+	}
+	return list, nil
+}
+
+func (s *SQLite) RecordSubscription(ctx context.Context, source string, sub Subscription) error {
+	if sub.FirstSeen.IsZero() {
+		sub.FirstSeen = sub.LastSeen
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO subscriptions (did, list_uri, rkey, first_seen, last_seen) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (did, list_uri) DO UPDATE SET
+			rkey       = CASE WHEN excluded.rkey <> '' THEN excluded.rkey ELSE subscriptions.rkey END,
+			first_seen = MIN(subscriptions.first_seen, excluded.first_seen),
+			last_seen  = MAX(subscriptions.last_seen, excluded.last_seen),
+			ended_at   = CASE WHEN subscriptions.ended_at <= excluded.last_seen THEN NULL ELSE subscriptions.ended_at END`,
+		sub.DID, sub.ListURI, sub.RecordKey, toMicros(sub.FirstSeen), toMicros(sub.LastSeen)); err != nil {
+		return fmt.Errorf("failed to record subscription of %s to %s: %w", sub.DID, sub.ListURI, err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO evidence (did, list_uri, source, first_seen, last_seen) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (did, list_uri, source) DO UPDATE SET
+			first_seen = MIN(COALESCE(evidence.first_seen, excluded.first_seen), excluded.first_seen),
+			last_seen  = MAX(COALESCE(evidence.last_seen, excluded.last_seen), excluded.last_seen),
+			ended_at   = CASE WHEN evidence.ended_at <= excluded.last_seen THEN NULL ELSE evidence.ended_at END`,
+		sub.DID, sub.ListURI, source, toMicros(sub.FirstSeen), toMicros(sub.LastSeen)); err != nil {
+		return fmt.Errorf("failed to record %s evidence of %s on %s: %w", source, sub.DID, sub.ListURI, err)
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLite) EndSubscription(ctx context.Context, source, did, rkey string, at time.Time) (Subscription, error) {
+	return s.endSubscription(ctx, source, at, `did = ? AND rkey = ?`, did, rkey)
+}
+
+func (s *SQLite) EndListSubscription(ctx context.Context, source, did, listURI string, at time.Time) (Subscription, error) {
+	return s.endSubscription(ctx, source, at, `did = ? AND list_uri = ?`, did, listURI)
+}
+
+// endSubscription ends the subscription matching where, unless it was seen after at
+func (s *SQLite) endSubscription(ctx context.Context, source string, at time.Time, where string, args ...any) (Subscription, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Subscription{}, err
+	}
+	defer tx.Rollback()
+
+	sub, err := scanSQLiteSubscription(tx.QueryRowContext(ctx, `
+		SELECT did, list_uri, rkey, first_seen, last_seen, ended_at FROM subscriptions WHERE `+where, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Subscription{}, fmt.Errorf("subscription %s/%s: %w", args[0], args[1], ErrNotFound)
+	}
+	if err != nil {
+		return Subscription{}, fmt.Errorf("failed to read subscription %s/%s: %w", args[0], args[1], err)
+	}
+
+	if sub.Active() && !at.Before(sub.LastSeen) {
+		if _, err := tx.ExecContext(ctx, `UPDATE subscriptions SET ended_at = ? WHERE did = ? AND list_uri = ?`,
+			toMicros(at), sub.DID, sub.ListURI); err != nil {
+			return Subscription{}, fmt.Errorf("failed to end subscription %s/%s: %w", args[0], args[1], err)
+		}
+		ended := at.UTC()
+		sub.EndedAt = &ended
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO evidence (did, list_uri, source, ended_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (did, list_uri, source) DO UPDATE SET
+			ended_at = CASE WHEN evidence.last_seen > excluded.ended_at THEN evidence.ended_at
+				ELSE MIN(COALESCE(evidence.ended_at, excluded.ended_at), excluded.ended_at) END`,
+		sub.DID, sub.ListURI, source, toMicros(at)); err != nil {
+		return Subscription{}, fmt.Errorf("failed to record %s evidence of %s leaving %s: %w", source, sub.DID, sub.ListURI, err)
+	}
+
+	return sub, tx.Commit()
+}
+
+func (s *SQLite) Evidence(ctx context.Context) ([]Evidence, error) {
+	return s.queryEvidence(ctx, `SELECT did, list_uri, source, first_seen, last_seen, ended_at FROM evidence ORDER BY did, list_uri, source`)
+}
+
+func (s *SQLite) EvidenceOf(ctx context.Context, did string) ([]Evidence, error) {
+	return s.queryEvidence(ctx, `SELECT did, list_uri, source, first_seen, last_seen, ended_at FROM evidence WHERE did = ? ORDER BY list_uri, source`, did)
+}
+
+func (s *SQLite) queryEvidence(ctx context.Context, query string, args ...any) ([]Evidence, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read evidence: %w", err)
+	}
+	defer rows.Close()
+
+	var evidence []Evidence
+	for rows.Next() {
+		var e Evidence
+		var firstSeen, lastSeen, endedAt sql.NullInt64
+		if err := rows.Scan(&e.DID, &e.ListURI, &e.Source, &firstSeen, &lastSeen, &endedAt); err != nil {
+			return nil, err
+		}
+		if firstSeen.Valid {
+			e.FirstSeen = fromMicros(firstSeen.Int64)
+		}
+		if lastSeen.Valid {
+			e.LastSeen = fromMicros(lastSeen.Int64)
+		}
+		if endedAt.Valid {
+			ended := fromMicros(endedAt.Int64)
+			e.EndedAt = &ended
+		}
+		evidence = append(evidence, e)
+	}
+	return evidence, rows.Err()
+}
+
+func (s *SQLite) Subscriptions(ctx context.Context) ([]Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT did, list_uri, rkey, first_seen, last_seen, ended_at FROM subscriptions ORDER BY did, list_uri`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []Subscription
+	for rows.Next() {
+		sub, err := scanSQLiteSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+func (s *SQLite) SubscriptionsOf(ctx context.Context, did string) ([]Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT did, list_uri, rkey, first_seen, last_seen, ended_at FROM subscriptions WHERE did = ? ORDER BY list_uri`, did)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subscriptions of %s: %w", did, err)
+	}
+	defer rows.Close()
+
+	var subs []Subscription
+	for rows.Next() {
+		sub, err := scanSQLiteSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+func (s *SQLite) SubscriptionsTo(ctx context.Context, listURI string) ([]Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT did, list_uri, rkey, first_seen, last_seen, ended_at FROM subscriptions WHERE list_uri = ? ORDER BY did`, listURI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subscriptions to %s: %w", listURI, err)
+	}
+	defer rows.Close()
+
+	var subs []Subscription
+	for rows.Next() {
+		sub, err := scanSQLiteSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+func scanSQLiteSubscription(row interface{ Scan(...any) error }) (Subscription, error) {
+	var sub Subscription
+	var firstSeen, lastSeen int64
+	var endedAt sql.NullInt64
+	if err := row.Scan(&sub.DID, &sub.ListURI, &sub.RecordKey, &firstSeen, &lastSeen, &endedAt); err != nil {
+		return sub, err
+	}
+	sub.FirstSeen = fromMicros(firstSeen)
+	sub.LastSeen = fromMicros(lastSeen)
+	if endedAt.Valid {
+		ended := fromMicros(endedAt.Int64)
+		sub.EndedAt = &ended
+	}
+	return sub, nil
+}
+
+func (s *SQLite) ListItems(ctx context.Context, listURI string) ([]ListItem, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT list_uri, did, rkey, created_at FROM list_items WHERE list_uri = ? ORDER BY created_at, rkey`, listURI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read list items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []ListItem
+	for rows.Next() {
+		var item ListItem
+		var createdAt int64
+		if err := rows.Scan(&item.ListURI, &item.DID, &item.RecordKey, &createdAt); err != nil {
+			return nil, err
+		}
+		item.CreatedAt = fromMicros(createdAt)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (s *SQLite) PutListItem(ctx context.Context, item ListItem) error {
+	if item.CreatedAt.IsZero() {
+		item.CreatedAt = time.Now()
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO list_items (list_uri, rkey, did, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (list_uri, rkey) DO UPDATE SET did = excluded.did`,
+		item.ListURI, item.RecordKey, item.DID, toMicros(item.CreatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to save list item %s: %w", item.RecordKey, err)
+	}
+	return nil
+}
+
+func (s *SQLite) DeleteListItem(ctx context.Context, listURI, rkey string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM list_items WHERE list_uri = ? AND rkey = ?`, listURI, rkey); err != nil {
+		return fmt.Errorf("failed to delete list item %s: %w", rkey, err)
+	}
+	return nil
+}
+
+func (s *SQLite) ReplaceListItems(ctx context.Context, listURI string, items []ListItem) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Keep the creation times we already know about
+	known := make(map[string]int64)
+	rows, err := tx.QueryContext(ctx, `SELECT rkey, created_at FROM list_items WHERE list_uri = ?`, listURI)
+	if err != nil {
+		return fmt.Errorf("failed to read list items: %w", err)
+	}
+	for rows.Next() {
+		var rkey string
+		var createdAt int64
+		if err := rows.Scan(&rkey, &createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		known[rkey] = createdAt
+	}
+	rows.Close()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM list_items WHERE list_uri = ?`, listURI); err != nil {
+		return fmt.Errorf("failed to clear list items: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT OR REPLACE INTO list_items (list_uri, rkey, did, created_at) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := toMicros(time.Now())
+	for _, item := range items {
+		createdAt, ok := known[item.RecordKey]
+		if !item.CreatedAt.IsZero() {
+			createdAt = toMicros(item.CreatedAt)
+		} else if !ok {
+			createdAt = now
+		}
+		if _, err := stmt.ExecContext(ctx, listURI, item.RecordKey, item.DID, createdAt); err != nil {
+			return fmt.Errorf("failed to save list item %s: %w", item.RecordKey, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLite) SetOverride(ctx context.Context, override Override) error {
+	if override.CreatedAt.IsZero() {
+		override.CreatedAt = time.Now()
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO overrides (did, action, reason, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (did) DO UPDATE SET action = excluded.action, reason = excluded.reason, created_at = excluded.created_at
+		WHERE overrides.action <> excluded.action`,
+		override.DID, string(override.Action), override.Reason, toMicros(override.CreatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to save override for %s: %w", override.DID, err)
+	}
+	return nil
+}
+
+func (s *SQLite) DeleteOverride(ctx context.Context, did string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM overrides WHERE did = ?`, did); err != nil {
+		return fmt.Errorf("failed to delete override for %s: %w", did, err)
+	}
+	return nil
+}
+
+func (s *SQLite) Overrides(ctx context.Context) ([]Override, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT did, action, reason, created_at FROM overrides ORDER BY did`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overrides: %w", err)
+	}
+	defer rows.Close()
+
+	var overrides []Override
+	for rows.Next() {
+		var override Override
+		var action string
+		var createdAt int64
+		if err := rows.Scan(&override.DID, &action, &override.Reason, &createdAt); err != nil {
+			return nil, err
+		}
+		override.Action = OverrideAction(action)
+		override.CreatedAt = fromMicros(createdAt)
+		overrides = append(overrides, override)
+	}
+	return overrides, rows.Err()
+}
+
+func (s *SQLite) State(ctx context.Context, name string) ([]byte, error) {
+	var value []byte
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM state WHERE name = ?`, name).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("state %s: %w", name, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state %s: %w", name, err)
+	}
+	return value, nil
+}
+
+func (s *SQLite) SetState(ctx context.Context, name string, value []byte) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO state (name, value, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+		name, value, toMicros(time.Now()))
+	if err != nil {
+		return fmt.Errorf("failed to save state %s: %w", name, err)
+	}
+	return nil
+}
+
+func (s *SQLite) Journal(ctx context.Context) ([][]byte, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT entry FROM push_journal ORDER BY seq`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read push journal: %w", err)
+	}
+	defer rows.Close()
+
+	var entries [][]byte
+	for rows.Next() {
+		var entry []byte
+		if err := rows.Scan(&entry); err != nil {
+			return nil, fmt.Errorf("failed to read push journal: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *SQLite) AppendJournal(ctx context.Context, reset bool, entries ...[]byte) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if reset {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM push_journal`); err != nil {
+			return fmt.Errorf("failed to clear push journal: %w", err)
+		}
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO push_journal (entry) VALUES (?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, entry := range entries {
+		if _, err := stmt.ExecContext(ctx, entry); err != nil {
+			return fmt.Errorf("failed to write push journal: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
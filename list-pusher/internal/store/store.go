@@ -0,0 +1,202 @@
+// Package store is the persistent state shared by the list-pusher commands:
+// the source blocklists we track, who subscribes to them and when we saw it,
+// the listitem records we have published and manual overrides. It sits behind
+// the Store interface, with a SQLite implementation for local runs and a
+// Postgres one for shared deployments.
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// DefaultPath is the SQLite database used when LIST_PUSHER_DB is unset
+const DefaultPath = "list-pusher.db"
+
+// ErrNotFound is returned when a lookup matches nothing
+var ErrNotFound = errors.New("not found")
+
+// SourceList is a blocklist whose subscribers belong on our list. Name and
+// Description are copied from app.bsky.graph.getList, last at RefreshedAt.
+// Only the subscribers of enabled lists are wanted on our list.
+type SourceList struct {
+	URI         string
+	Owner       string
+	Name        string
+	Description string
+	Enabled     bool
+	Notes       string
+	AddedAt     time.Time
+	RefreshedAt *time.Time
+}
+
+// Subscription is a DID's subscription to one source list. FirstSeen and
+// LastSeen bound the times we have observed it; EndedAt is set once we see
+// them unsubscribe. RecordKey is their listblock's rkey, if known.
+type Subscription struct {
+	DID       string
+	ListURI   string
+	RecordKey string
+	FirstSeen time.Time
+	LastSeen  time.Time
+	EndedAt   *time.Time
+}
+
+// Active reports whether the subscription has not been seen to end
+func (s Subscription) Active() bool {
+	return s.EndedAt == nil
+}
+
+// The sources of subscription evidence. Evidence recorded before sources
+// were tracked is attributed to SourceLegacy.
+const (
+	SourceClearsky      = "clearsky"
+	SourceJetstream     = "jetstream"
+	SourceConstellation = "constellation"
+	SourceLegacy        = "legacy"
+)
+
+// Evidence is what one source has said about a DID's subscription to a list:
+// the window it saw them subscribed in, zero if never, and when it saw them
+// leave, if it did
+type Evidence struct {
+	DID       string
+	ListURI   string
+	Source    string
+	FirstSeen time.Time
+	LastSeen  time.Time
+	EndedAt   *time.Time
+}
+
+// ListItem is a listitem record we published on one of our lists
+type ListItem struct {
+	ListURI   string
+	DID       string
+	RecordKey string
+	CreatedAt time.Time
+}
+
+// OverrideAction is what a manual override does to a DID
+type OverrideAction string
+
+const (
+	// OverrideExclude keeps a DID off our list whatever the source lists say
+	OverrideExclude OverrideAction = "exclude"
+	// OverrideInclude keeps a DID on our list even if no source list has it
+	OverrideInclude OverrideAction = "include"
+)
+
+// Override is a manual decision about one DID
+type Override struct {
+	DID       string
+	Action    OverrideAction
+	Reason    string
+	CreatedAt time.Time
+}
+
+// Store is the list-pusher state
+type Store interface {
+	// AddSourceList starts tracking a source list, enabled whatever
+	// list.Enabled says. A list already tracked keeps its settings and takes
+	// any non-empty metadata given.
+	AddSourceList(ctx context.Context, list SourceList) error
+	// UpdateSourceList overwrites everything about a tracked list but its AddedAt
+	UpdateSourceList(ctx context.Context, list SourceList) error
+	// RemoveSourceList stops tracking a source list; its subscriptions are kept
+	RemoveSourceList(ctx context.Context, uri string) error
+	SourceList(ctx context.Context, uri string) (SourceList, error)
+	SourceLists(ctx context.Context) ([]SourceList, error)
+
+	// RecordSubscription notes that source saw sub.DID subscribed to
+	// sub.ListURI as of sub.LastSeen, widening the seen window and reopening
+	// the subscription if it ended no later than that
+	RecordSubscription(ctx context.Context, source string, sub Subscription) error
+	// EndSubscription notes that source saw the subscription with listblock
+	// rkey end at at, and returns it. The most recent evidence wins: a
+	// subscription seen after at, or already ended, is returned unchanged.
+	EndSubscription(ctx context.Context, source, did, rkey string, at time.Time) (Subscription, error)
+	// EndListSubscription is EndSubscription for sources that know the list but not the rkey
+	EndListSubscription(ctx context.Context, source, did, listURI string, at time.Time) (Subscription, error)
+	Subscriptions(ctx context.Context) ([]Subscription, error)
+	SubscriptionsOf(ctx context.Context, did string) ([]Subscription, error)
+	// SubscriptionsTo returns every subscription to listURI, ended or not
+	SubscriptionsTo(ctx context.Context, listURI string) ([]Subscription, error)
+
+	// Evidence returns what each source has said about each subscription
+	Evidence(ctx context.Context) ([]Evidence, error)
+	EvidenceOf(ctx context.Context, did string) ([]Evidence, error)
+
+	ListItems(ctx context.Context, listURI string) ([]ListItem, error)
+	PutListItem(ctx context.Context, item ListItem) error
+	DeleteListItem(ctx context.Context, listURI, rkey string) error
+	// ReplaceListItems makes the stored items for listURI exactly items, after
+	// reading the list back from the PDS
+	ReplaceListItems(ctx context.Context, listURI string, items []ListItem) error
+
+	// SetOverride records a manual decision. Repeating the same action for a
+	// DID keeps the original reason and date.
+	SetOverride(ctx context.Context, override Override) error
+	DeleteOverride(ctx context.Context, did string) error
+	Overrides(ctx context.Context) ([]Override, error)
+
+	// State returns a command's saved state, or ErrNotFound
+	State(ctx context.Context, name string) ([]byte, error)
+	SetState(ctx context.Context, name string, value []byte) error
+
+	// Journal returns the push journal's entries in the order they were written
+	Journal(ctx context.Context) ([][]byte, error)
+	// AppendJournal adds entries to the push journal, first emptying it if reset is set
+	AppendJournal(ctx context.Context, reset bool, entries ...[]byte) error
+
+	Close() error
+}
+
+// Open opens the store at dsn: a postgres:// URL for Postgres, otherwise a
+// SQLite database path. An empty dsn uses LIST_PUSHER_DB, then DefaultPath.
+func Open(ctx context.Context, dsn string) (Store, error) {
+	if dsn == "" {
+		dsn = os.Getenv("LIST_PUSHER_DB")
+	}
+	if dsn == "" {
+		dsn = DefaultPath
+	}
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return OpenPostgres(ctx, dsn)
+	}
+	return OpenSQLite(ctx, dsn)
+}
+
+// EnabledSourceLists returns the source lists whose subscribers we want
+func EnabledSourceLists(ctx context.Context, s Store) ([]SourceList, error) {
+	lists, err := s.SourceLists(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var enabled []SourceList
+	for _, list := range lists {
+		if list.Enabled {
+			enabled = append(enabled, list)
+		}
+	}
+	return enabled, nil
+}
+
+// listOwner returns the DID in a list AT-URI, or "" if it has none
+func listOwner(uri string) string {
+	aturi, err := syntax.ParseATURI(uri)
+	if err != nil {
+		return ""
+	}
+	did, err := aturi.Authority().AsDID()
+	if err != nil {
+		return ""
+	}
+	return did.String()
+}
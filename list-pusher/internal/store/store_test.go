@@ -0,0 +1,272 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const (
+	listA = "at://did:plc:owner/app.bsky.graph.list/3lbxfscjqno2d"
+	listB = "at://did:plc:owner/app.bsky.graph.list/3lbrgpl44zp2f"
+	ours  = "at://did:plc:us/app.bsky.graph.list/3labcdefghi2k"
+)
+
+var t0 = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// openStores returns a fresh SQLite store, and a Postgres one if
+// LIST_PUSHER_TEST_POSTGRES names a scratch database
+func openStores(t *testing.T) map[string]Store {
+	ctx := context.Background()
+	stores := make(map[string]Store)
+
+	sqlite, err := OpenSQLite(ctx, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+	stores["sqlite"] = sqlite
+
+	if url := os.Getenv("LIST_PUSHER_TEST_POSTGRES"); url != "" {
+		pg, err := OpenPostgres(ctx, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, table := range []string{"source_lists", "subscriptions", "evidence", "list_items", "overrides", "state", "push_journal"} {
+			if _, err := pg.pool.Exec(ctx, "TRUNCATE "+table); err != nil {
+				t.Fatal(err)
+			}
+		}
+		t.Cleanup(func() { pg.Close() })
+		stores["postgres"] = pg
+	}
+
+	return stores
+}
+
+func TestSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			must(t, s.AddSourceList(ctx, SourceList{URI: listA, Name: "A", AddedAt: t0}))
+			must(t, s.AddSourceList(ctx, SourceList{URI: listB, AddedAt: t0.Add(time.Hour)}))
+
+			// Seen twice, out of order: the window widens both ways
+			must(t, s.RecordSubscription(ctx, SourceJetstream, Subscription{DID: "did:plc:one", ListURI: listA, RecordKey: "rk1", LastSeen: t0.Add(2 * time.Hour)}))
+			must(t, s.RecordSubscription(ctx, SourceJetstream, Subscription{DID: "did:plc:one", ListURI: listA, LastSeen: t0}))
+			must(t, s.RecordSubscription(ctx, SourceJetstream, Subscription{DID: "did:plc:two", ListURI: listB, RecordKey: "rk2", LastSeen: t0}))
+			must(t, s.RecordSubscription(ctx, SourceJetstream, Subscription{DID: "did:plc:gone", ListURI: "at://did:plc:other/app.bsky.graph.list/3zzz", LastSeen: t0}))
+
+			ended, err := s.EndSubscription(ctx, SourceJetstream, "did:plc:two", "rk2", t0.Add(3*time.Hour))
+			must(t, err)
+			if ended.ListURI != listB || ended.Active() {
+				t.Errorf("unexpected ended subscription %+v", ended)
+			}
+
+			// Ending it again is harmless and keeps the first end time
+			again, err := s.EndSubscription(ctx, SourceJetstream, "did:plc:two", "rk2", t0.Add(4*time.Hour))
+			must(t, err)
+			if !again.EndedAt.Equal(t0.Add(3 * time.Hour)) {
+				t.Errorf("end time moved to %v", again.EndedAt)
+			}
+
+			if _, err := s.EndSubscription(ctx, SourceJetstream, "did:plc:two", "nope", t0); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound, got %v", err)
+			}
+
+			subs, err := s.Subscriptions(ctx)
+			must(t, err)
+			if len(subs) != 3 {
+				t.Fatalf("expected 3 subscriptions, got %+v", subs)
+			}
+			one := subs[1]
+			if one.DID != "did:plc:one" || one.RecordKey != "rk1" || !one.FirstSeen.Equal(t0) || !one.LastSeen.Equal(t0.Add(2*time.Hour)) {
+				t.Errorf("unexpected subscription %+v", one)
+			}
+
+			if two := subs[2]; two.DID != "did:plc:two" || two.Active() {
+				t.Errorf("unexpected subscription %+v", two)
+			}
+
+			// Seeing the subscription after it ended reopens it
+			must(t, s.RecordSubscription(ctx, SourceJetstream, Subscription{DID: "did:plc:two", ListURI: listB, LastSeen: t0.Add(5 * time.Hour)}))
+			reopened, err := s.SubscriptionsOf(ctx, "did:plc:two")
+			must(t, err)
+			if len(reopened) != 1 || !reopened[0].Active() || !reopened[0].LastSeen.Equal(t0.Add(5*time.Hour)) {
+				t.Errorf("unexpected reopened subscription %+v", reopened)
+			}
+
+			toA, err := s.SubscriptionsTo(ctx, listA)
+			must(t, err)
+			if len(toA) != 1 || toA[0].DID != "did:plc:one" {
+				t.Errorf("unexpected subscriptions to list A %+v", toA)
+			}
+		})
+	}
+}
+
+func TestEvidence(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			one := "did:plc:one"
+
+			// Clearsky saw them early, Jetstream later, and Constellation's
+			// older snapshot doesn't have them: the most recent evidence wins
+			must(t, s.RecordSubscription(ctx, SourceClearsky, Subscription{DID: one, ListURI: listA, FirstSeen: t0, LastSeen: t0.Add(time.Hour)}))
+			must(t, s.RecordSubscription(ctx, SourceJetstream, Subscription{DID: one, ListURI: listA, RecordKey: "rk1", LastSeen: t0.Add(3 * time.Hour)}))
+			sub, err := s.EndListSubscription(ctx, SourceConstellation, one, listA, t0.Add(2*time.Hour))
+			must(t, err)
+			if !sub.Active() {
+				t.Errorf("stale snapshot ended %+v", sub)
+			}
+
+			// A later departure does end it
+			sub, err = s.EndSubscription(ctx, SourceJetstream, one, "rk1", t0.Add(4*time.Hour))
+			must(t, err)
+			if sub.Active() {
+				t.Errorf("departure didn't end %+v", sub)
+			}
+
+			evidence, err := s.EvidenceOf(ctx, one)
+			must(t, err)
+			if len(evidence) != 3 {
+				t.Fatalf("expected evidence from 3 sources, got %+v", evidence)
+			}
+			clearsky, constellation, jetstream := evidence[0], evidence[1], evidence[2]
+			if clearsky.Source != SourceClearsky || !clearsky.FirstSeen.Equal(t0) || !clearsky.LastSeen.Equal(t0.Add(time.Hour)) || clearsky.EndedAt != nil {
+				t.Errorf("unexpected clearsky evidence %+v", clearsky)
+			}
+			if constellation.Source != SourceConstellation || !constellation.LastSeen.IsZero() || !constellation.EndedAt.Equal(t0.Add(2*time.Hour)) {
+				t.Errorf("unexpected constellation evidence %+v", constellation)
+			}
+			if jetstream.Source != SourceJetstream || !jetstream.LastSeen.Equal(t0.Add(3*time.Hour)) || !jetstream.EndedAt.Equal(t0.Add(4*time.Hour)) {
+				t.Errorf("unexpected jetstream evidence %+v", jetstream)
+			}
+
+			all, err := s.Evidence(ctx)
+			must(t, err)
+			if !reflect.DeepEqual(all, evidence) {
+				t.Errorf("got evidence %+v", all)
+			}
+		})
+	}
+}
+
+func TestSourceLists(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			must(t, s.AddSourceList(ctx, SourceList{URI: listA, Notes: "from the original file", AddedAt: t0}))
+			must(t, s.AddSourceList(ctx, SourceList{URI: listB, AddedAt: t0.Add(time.Hour)}))
+
+			list, err := s.SourceList(ctx, listA)
+			must(t, err)
+			if list.Owner != "did:plc:owner" || !list.Enabled || list.RefreshedAt != nil || list.Notes != "from the original file" {
+				t.Errorf("unexpected new source list %+v", list)
+			}
+
+			// Refreshing and disabling a list; adding it again changes neither
+			refreshed := t0.Add(2 * time.Hour)
+			list.Name, list.Description, list.Enabled, list.RefreshedAt = "Anti AI", "no AI here", false, &refreshed
+			must(t, s.UpdateSourceList(ctx, list))
+			must(t, s.AddSourceList(ctx, SourceList{URI: listA}))
+
+			lists, err := s.SourceLists(ctx)
+			must(t, err)
+			if len(lists) != 2 || !reflect.DeepEqual(lists[0], list) {
+				t.Fatalf("got source lists %+v", lists)
+			}
+
+			enabled, err := EnabledSourceLists(ctx, s)
+			must(t, err)
+			if len(enabled) != 1 || enabled[0].URI != listB {
+				t.Errorf("got enabled lists %+v", enabled)
+			}
+
+			if err := s.UpdateSourceList(ctx, SourceList{URI: "at://did:plc:none/app.bsky.graph.list/3xxx"}); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound, got %v", err)
+			}
+			if _, err := s.SourceList(ctx, "at://did:plc:none/app.bsky.graph.list/3xxx"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			must(t, s.AddSourceList(ctx, SourceList{URI: listA}))
+			must(t, s.RecordSubscription(ctx, SourceJetstream, Subscription{DID: "did:plc:one", ListURI: listA, LastSeen: t0}))
+			must(t, s.RecordSubscription(ctx, SourceJetstream, Subscription{DID: "did:plc:two", ListURI: listA, LastSeen: t0}))
+
+			must(t, s.SetOverride(ctx, Override{DID: "did:plc:two", Action: OverrideExclude, Reason: "first", CreatedAt: t0}))
+			must(t, s.SetOverride(ctx, Override{DID: "did:plc:two", Action: OverrideExclude, Reason: "second"}))
+			must(t, s.SetOverride(ctx, Override{DID: "did:plc:three", Action: OverrideInclude}))
+
+			overrides, err := s.Overrides(ctx)
+			must(t, err)
+			if len(overrides) != 2 || overrides[1].Reason != "first" || !overrides[1].CreatedAt.Equal(t0) {
+				t.Errorf("unexpected overrides %+v", overrides)
+			}
+
+			must(t, s.DeleteOverride(ctx, "did:plc:two"))
+			overrides, err = s.Overrides(ctx)
+			must(t, err)
+			if len(overrides) != 1 || overrides[0].DID != "did:plc:three" || overrides[0].Action != OverrideInclude {
+				t.Errorf("unexpected overrides after deleting one %+v", overrides)
+			}
+		})
+	}
+}
+
+func TestListItemsAndState(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range openStores(t) {
+		t.Run(name, func(t *testing.T) {
+			must(t, s.PutListItem(ctx, ListItem{ListURI: ours, DID: "did:plc:one", RecordKey: "a", CreatedAt: t0}))
+			must(t, s.PutListItem(ctx, ListItem{ListURI: ours, DID: "did:plc:two", RecordKey: "b", CreatedAt: t0.Add(time.Minute)}))
+			must(t, s.DeleteListItem(ctx, ours, "b"))
+
+			// Replacing keeps known creation times
+			must(t, s.ReplaceListItems(ctx, ours, []ListItem{
+				{DID: "did:plc:one", RecordKey: "a"},
+				{DID: "did:plc:three", RecordKey: "c", CreatedAt: t0.Add(time.Hour)},
+			}))
+
+			items, err := s.ListItems(ctx, ours)
+			must(t, err)
+			want := []ListItem{
+				{ListURI: ours, DID: "did:plc:one", RecordKey: "a", CreatedAt: t0},
+				{ListURI: ours, DID: "did:plc:three", RecordKey: "c", CreatedAt: t0.Add(time.Hour)},
+			}
+			if !reflect.DeepEqual(items, want) {
+				t.Errorf("got %+v\nwant %+v", items, want)
+			}
+
+			if _, err := s.State(ctx, "tailer"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound, got %v", err)
+			}
+			must(t, s.SetState(ctx, "tailer", []byte(`{"cursor":1}`)))
+			must(t, s.SetState(ctx, "tailer", []byte(`{"cursor":2}`)))
+			value, err := s.State(ctx, "tailer")
+			must(t, err)
+			if string(value) != `{"cursor":2}` {
+				t.Errorf("got state %s", value)
+			}
+		})
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,62 @@
+package tailer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"list-pusher/internal/store"
+)
+
+// stateName is the tailer's entry in the state store
+const stateName = "tail-listblocks"
+
+// Checkpoint is what the tailer has durably handled. Cursor is the time_us of
+// the last event whose effects are on the list, in the subscriptions table or
+// recorded here: Pending holds matching DIDs that have not been added yet and
+// Unlist the DIDs due to come off the list. Subscriptions are written as
+// events arrive, before the cursor moves past them, and replaying an event
+// against them is harmless.
+type Checkpoint struct {
+	Cursor  int64                `json:"cursor"`
+	Pending []string             `json:"pending"`
+	Unlist  map[string]time.Time `json:"unlist"`
+}
+
+// LoadCheckpoint reads the tailer's checkpoint from st. None is a fresh start.
+func LoadCheckpoint(ctx context.Context, st store.Store) (Checkpoint, error) {
+	checkpoint := Checkpoint{Unlist: make(map[string]time.Time)}
+
+	data, err := st.State(ctx, stateName)
+	if errors.Is(err, store.ErrNotFound) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return checkpoint, err
+	}
+
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("failed to parse checkpoint: %w", err)
+	}
+	if checkpoint.Unlist == nil {
+		checkpoint.Unlist = make(map[string]time.Time)
+	}
+
+	return checkpoint, nil
+}
+
+// Save writes the checkpoint to st
+func (c Checkpoint) Save(ctx context.Context, st store.Store) error {
+	if c.Pending == nil {
+		c.Pending = []string{}
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+
+	return st.SetState(ctx, stateName, data)
+}
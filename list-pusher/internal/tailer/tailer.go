@@ -0,0 +1,551 @@
+// Package tailer follows listblock records on Jetstream and keeps our list in
+// step with the source blocklists' subscribers: anyone who subscribes goes
+// straight onto our list, and anyone who unsubscribes from every source list
+// comes off it, instead of waiting for the next Constellation or clearsky scrape.
+package tailer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"list-pusher/internal/blocklist"
+	"list-pusher/internal/constellation"
+	"list-pusher/internal/jetstream"
+	"list-pusher/internal/policy"
+	"list-pusher/internal/store"
+)
+
+// Options controls the tailer; they map onto tail-listblocks' flags
+type Options struct {
+	Endpoint string
+
+	// Matching DIDs are pushed every FlushInterval, or sooner once FlushSize are waiting
+	FlushInterval time.Duration
+	FlushSize     int
+
+	// UnlistGrace is how long after unsubscribing from the last source list a
+	// DID stays on our list, in case they subscribe again
+	UnlistGrace time.Duration
+
+	// Backfill records every current subscriber from Constellation at
+	// BackfillURL in the state store, so their later unsubscribes are recognised
+	Backfill    bool
+	BackfillURL string
+}
+
+// lister is the part of the blocklist manager the tailer uses once it is set up
+type lister interface {
+	Store() store.Store
+	ApplyPolicy(dids []string) ([]string, []policy.Decision, error)
+	AddListItems(userDIDs []string, record blocklist.Outcome) (successful, failed int, err error)
+	RemoveListItems(recordKeys []string, record blocklist.Outcome) (successful, failed int, err error)
+}
+
+// Tailer keeps our list in step with the source blocklists' subscribers
+type Tailer struct {
+	manager *blocklist.BlueskyBlocklistManager
+	list    lister
+	opts    Options
+
+	sources map[string]bool
+	listed  map[string][]string // DID → record keys of its items on our list
+
+	pending    []string
+	pendingSet map[string]bool
+
+	// checkpoint holds the scheduled unlistings as they stand now, with the
+	// cursor as last saved; lastSeen is the newest event handled and dirty is
+	// set when anything needs saving
+	checkpoint Checkpoint
+	lastSeen   int64
+	dirty      bool
+
+	matched      int
+	added        int
+	unsubscribed int
+	removed      int
+}
+
+// New creates a tailer that writes through manager
+func New(manager *blocklist.BlueskyBlocklistManager, opts Options) *Tailer {
+	return &Tailer{
+		manager:    manager,
+		list:       manager,
+		opts:       opts,
+		sources:    make(map[string]bool),
+		listed:     make(map[string][]string),
+		pendingSet: make(map[string]bool),
+	}
+}
+
+// Run tails Jetstream until ctx is done, then pushes anything pending and
+// saves a final checkpoint
+func (t *Tailer) Run(ctx context.Context) error {
+	if err := t.setup(ctx); err != nil {
+		return err
+	}
+	defer t.manager.Close()
+
+	// Without a checkpoint, pin the start so early reconnects don't skip events
+	cursor := t.checkpoint.Cursor
+	if cursor == 0 {
+		cursor = time.Now().UnixMicro()
+	}
+
+	events := make(chan *jetstream.Event, 1024)
+	go jetstream.Stream(ctx, t.opts.Endpoint, []string{jetstream.ListBlockCollection}, cursor, events)
+
+	return t.tail(ctx, events)
+}
+
+// tail handles events until the channel is closed, pushing pending DIDs every
+// FlushInterval or once FlushSize are waiting, and flushes one last time at the end
+func (t *Tailer) tail(ctx context.Context, events <-chan *jetstream.Event) error {
+	ticker := time.NewTicker(t.opts.FlushInterval)
+	defer ticker.Stop()
+
+	fmt.Println("\nTailing listblocks...")
+	for {
+		// Once ctx is done the stream winds down, but the events still buffered
+		// are handled and flushed with a context that outlives it
+		if ctx.Err() != nil {
+			ctx = context.WithoutCancel(ctx)
+		}
+
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// The stream only ends when ctx is done
+				fmt.Println("\nShutting down...")
+				return t.flush(context.WithoutCancel(ctx))
+			}
+			if err := t.handle(ctx, event); err != nil {
+				return err
+			}
+			if len(t.pending) >= t.opts.FlushSize {
+				if err := t.flush(ctx); err != nil {
+					return err
+				}
+			}
+		case <-ticker.C:
+			if err := t.flush(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// setup logs in and loads the source lists, checkpoint and current list
+func (t *Tailer) setup(ctx context.Context) error {
+	if err := t.manager.LoadConfig(); err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if err := t.manager.OpenStore(); err != nil {
+		return err
+	}
+	st := t.manager.Store()
+
+	config := t.manager.Config()
+	fmt.Printf("Using handle: %s\n", config.Handle)
+	fmt.Printf("Using list: %s\n", config.ListURI)
+
+	sources, err := store.EnabledSourceLists(ctx, st)
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("no enabled source blocklists in the state store; add some with source-lists add")
+	}
+	for _, source := range sources {
+		t.sources[source.URI] = true
+	}
+	fmt.Printf("Watching %d source blocklists\n", len(sources))
+
+	checkpoint, err := LoadCheckpoint(ctx, st)
+	if err != nil {
+		return err
+	}
+	t.restore(checkpoint)
+	if checkpoint.Cursor > 0 {
+		fmt.Printf("Resuming from cursor %d (%s) with %d DIDs pending, %d due to be unlisted\n",
+			checkpoint.Cursor, time.UnixMicro(checkpoint.Cursor).UTC().Format(time.RFC3339), len(t.pending), len(checkpoint.Unlist))
+	} else {
+		fmt.Println("No checkpoint found, starting from live events")
+	}
+
+	if t.opts.Backfill {
+		if err := t.backfill(ctx, sources); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("\nConnecting to Bluesky...")
+	if err := t.manager.Authenticate(); err != nil {
+		return fmt.Errorf("authentication failed: %w", err)
+	}
+	fmt.Println("✓ Successfully authenticated")
+
+	if err := t.manager.LoadPolicy(); err != nil {
+		return err
+	}
+
+	fmt.Println("Fetching existing blocklist entries...")
+	items, err := t.manager.FetchListItems()
+	if err != nil {
+		return fmt.Errorf("failed to fetch existing blocklist: %w", err)
+	}
+	for _, item := range items {
+		t.listed[item.DID] = append(t.listed[item.DID], item.RecordKey)
+	}
+	fmt.Printf("List already contains %d DIDs\n", len(t.listed))
+
+	return nil
+}
+
+// restore picks up from checkpoint: events it covers are skipped and its
+// pending DIDs are queued again
+func (t *Tailer) restore(checkpoint Checkpoint) {
+	t.checkpoint = checkpoint
+	t.lastSeen = checkpoint.Cursor
+	for _, did := range checkpoint.Pending {
+		t.queue(did)
+	}
+}
+
+// backfill records every current subscriber of the source lists in the
+// state store, so we can recognise them unsubscribing later
+func (t *Tailer) backfill(ctx context.Context, sources []store.SourceList) error {
+	client := constellation.NewClient(t.opts.BackfillURL)
+	st := t.manager.Store()
+	now := time.Now()
+
+	for i, source := range sources {
+		fmt.Printf("Backfilling subscribers %d/%d: %s\n", i+1, len(sources), source.URI)
+		subscribers, err := client.ListSubscribers(ctx, source.URI)
+		if err != nil {
+			return fmt.Errorf("failed to backfill subscribers of %s: %w", source.URI, err)
+		}
+		for _, subscriber := range subscribers {
+			sub := store.Subscription{DID: subscriber.DID, ListURI: source.URI, RecordKey: recordKey(subscriber.RecordURI), LastSeen: now}
+			if err := st.RecordSubscription(ctx, store.SourceConstellation, sub); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("  %d subscribers\n", len(subscribers))
+	}
+
+	return nil
+}
+
+// handle processes one Jetstream event
+func (t *Tailer) handle(ctx context.Context, event *jetstream.Event) error {
+	// A resumed subscription replays from the checkpoint itself
+	if event.TimeUS <= t.checkpoint.Cursor {
+		return nil
+	}
+	t.lastSeen = event.TimeUS
+
+	commit := event.Commit
+	if event.Kind != jetstream.KindCommit || commit == nil || commit.Collection != jetstream.ListBlockCollection {
+		return nil
+	}
+	at := time.UnixMicro(event.TimeUS).UTC()
+
+	switch commit.Operation {
+	case jetstream.OpCreate, jetstream.OpUpdate:
+		var record jetstream.ListBlock
+		if err := json.Unmarshal(commit.Record, &record); err != nil {
+			fmt.Printf("Warning: unreadable listblock %s/%s: %v\n", event.DID, commit.RecordKey, err)
+			return nil
+		}
+
+		// An update may point the record at a different list
+		if commit.Operation == jetstream.OpUpdate {
+			if err := t.unsubscribe(ctx, event.DID, commit.RecordKey, at); err != nil {
+				return err
+			}
+		}
+		if t.sources[record.Subject] {
+			return t.subscribe(ctx, event.DID, commit.RecordKey, record.Subject, at)
+		}
+	case jetstream.OpDelete:
+		return t.unsubscribe(ctx, event.DID, commit.RecordKey, at)
+	}
+
+	return nil
+}
+
+// subscribe records that did subscribed to a source list and queues it for adding
+func (t *Tailer) subscribe(ctx context.Context, did, rkey, listURI string, at time.Time) error {
+	t.matched++
+	fmt.Printf("%s subscribed to %s\n", did, listURI)
+
+	sub := store.Subscription{DID: did, ListURI: listURI, RecordKey: rkey, LastSeen: at}
+	if err := t.list.Store().RecordSubscription(ctx, store.SourceJetstream, sub); err != nil {
+		return err
+	}
+
+	if _, scheduled := t.checkpoint.Unlist[did]; scheduled {
+		fmt.Printf("  resubscribed, no longer unlisting %s\n", did)
+		delete(t.checkpoint.Unlist, did)
+		t.dirty = true
+	}
+
+	t.queue(did)
+	return nil
+}
+
+// unsubscribe ends did's listblock rkey and, if that was its last tracked
+// subscription, schedules it to come off our list after the grace period
+func (t *Tailer) unsubscribe(ctx context.Context, did, rkey string, at time.Time) error {
+	ended, err := t.list.Store().EndSubscription(ctx, store.SourceJetstream, did, rkey, at)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// A delete older than the last sighting, say a replay after a backfill,
+	// leaves the subscription running
+	if ended.Active() {
+		return nil
+	}
+
+	fmt.Printf("%s unsubscribed from %s\n", did, ended.ListURI)
+	keep, err := t.keep(ctx, did)
+	if err != nil || keep {
+		return err
+	}
+
+	t.unsubscribed++
+	t.dequeue(did)
+	if len(t.listed[did]) == 0 {
+		return nil
+	}
+
+	// Count the grace period from when they unsubscribed, so replays agree
+	due := ended.EndedAt.Add(t.opts.UnlistGrace)
+	t.checkpoint.Unlist[did] = due
+	t.dirty = true
+	if t.opts.UnlistGrace > 0 {
+		fmt.Printf("  no longer subscribes to any source list, unlisting at %s\n", due.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// keep reports whether did stays on our list: it still subscribes to a
+// tracked source list, or the policy wants it there anyway, say through an
+// include override
+func (t *Tailer) keep(ctx context.Context, did string) (bool, error) {
+	subscribed, err := t.subscribed(ctx, did)
+	if err != nil || subscribed {
+		return subscribed, err
+	}
+	included, _, err := t.list.ApplyPolicy([]string{did})
+	if err != nil {
+		return false, err
+	}
+	return len(included) > 0, nil
+}
+
+// subscribed reports whether did still subscribes to any tracked source list
+func (t *Tailer) subscribed(ctx context.Context, did string) (bool, error) {
+	subs, err := t.list.Store().SubscriptionsOf(ctx, did)
+	if err != nil {
+		return false, err
+	}
+	for _, sub := range subs {
+		if sub.Active() && t.sources[sub.ListURI] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// queue adds did to the pending additions unless it is already listed or queued
+func (t *Tailer) queue(did string) {
+	if len(t.listed[did]) > 0 || t.pendingSet[did] {
+		return
+	}
+	t.pending = append(t.pending, did)
+	t.pendingSet[did] = true
+}
+
+// dequeue drops did from the pending additions
+func (t *Tailer) dequeue(did string) {
+	if !t.pendingSet[did] {
+		return
+	}
+	delete(t.pendingSet, did)
+	for i, pending := range t.pending {
+		if pending == did {
+			t.pending = append(t.pending[:i], t.pending[i+1:]...)
+			break
+		}
+	}
+}
+
+// flush pushes the pending DIDs to the list, removes those whose grace period
+// is over and checkpoints. Anything that fails is kept, and saved in the
+// checkpoint, to be tried again.
+func (t *Tailer) flush(ctx context.Context) error {
+	if err := t.addPending(); err != nil {
+		return err
+	}
+	if err := t.removeUnlisted(ctx); err != nil {
+		return err
+	}
+
+	if !t.dirty && t.lastSeen == t.checkpoint.Cursor {
+		return nil
+	}
+
+	t.checkpoint.Cursor = t.lastSeen
+	t.checkpoint.Pending = append([]string(nil), t.pending...)
+	if err := t.checkpoint.Save(ctx, t.list.Store()); err != nil {
+		return err
+	}
+	t.dirty = false
+
+	return nil
+}
+
+// addPending adds the pending DIDs to the list
+func (t *Tailer) addPending() error {
+	if len(t.pending) == 0 {
+		return nil
+	}
+
+	dids, rejected, err := t.list.ApplyPolicy(t.pending)
+	if err != nil {
+		return err
+	}
+	if len(rejected) > 0 {
+		fmt.Printf("Left off %d DIDs by policy\n", len(rejected))
+	}
+
+	var failed []string
+	_, _, err = t.list.AddListItems(dids, func(did, recordURI string, failure error) error {
+		if failure != nil {
+			failed = append(failed, did)
+			return nil
+		}
+		// An item added without a record URI can't be removed by record key
+		// until the list is fetched again, but still counts as listed
+		t.added++
+		t.listed[did] = append(t.listed[did], recordKey(recordURI))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	t.pending = failed
+	t.pendingSet = make(map[string]bool, len(failed))
+	for _, did := range failed {
+		t.pendingSet[did] = true
+	}
+	t.dirty = true
+
+	fmt.Printf("✓ %d subscribers matched, %d added so far, %d pending\n", t.matched, t.added, len(t.pending))
+	return nil
+}
+
+// removeUnlisted takes DIDs whose grace period is over off the list
+func (t *Tailer) removeUnlisted(ctx context.Context) error {
+	now := time.Now()
+
+	var dids []string
+	for did, due := range t.checkpoint.Unlist {
+		if now.Before(due) {
+			continue
+		}
+		// They may have resubscribed, gained an include override or already
+		// gone from the list
+		keep, err := t.keep(ctx, did)
+		if err != nil {
+			return err
+		}
+		if keep || len(t.listed[did]) == 0 {
+			delete(t.checkpoint.Unlist, did)
+			t.dirty = true
+			continue
+		}
+		dids = append(dids, did)
+	}
+	if len(dids) == 0 {
+		return nil
+	}
+	sort.Strings(dids)
+
+	owner := make(map[string]string)
+	var recordKeys []string
+	for _, did := range dids {
+		known := 0
+		for _, rkey := range t.listed[did] {
+			if rkey == "" {
+				continue
+			}
+			owner[rkey] = did
+			recordKeys = append(recordKeys, rkey)
+			known++
+		}
+		// Items added without a record URI can't be deleted from here
+		if known == 0 {
+			fmt.Printf("Warning: record key of %s's list item unknown, leaving it for publish-list --sync\n", did)
+			delete(t.listed, did)
+			delete(t.checkpoint.Unlist, did)
+			t.dirty = true
+		}
+	}
+	if len(recordKeys) == 0 {
+		return nil
+	}
+
+	_, _, err := t.list.RemoveListItems(recordKeys, func(rkey, _ string, failure error) error {
+		if failure != nil {
+			return nil
+		}
+		did := owner[rkey]
+		t.listed[did] = removeString(t.listed[did], rkey)
+		if len(t.listed[did]) == 0 {
+			delete(t.listed, did)
+			delete(t.checkpoint.Unlist, did)
+			t.removed++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	t.dirty = true
+
+	fmt.Printf("✓ %d unsubscribed from every source list, %d removed so far, %d awaiting removal\n", t.unsubscribed, t.removed, len(t.checkpoint.Unlist))
+	return nil
+}
+
+// removeString returns s without any occurrence of value
+func removeString(s []string, value string) []string {
+	var result []string
+	for _, item := range s {
+		if item != value {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// recordKey returns the record key of an AT-URI, or "" if it has none
+func recordKey(uri string) string {
+	aturi, err := syntax.ParseATURI(uri)
+	if err != nil {
+		return ""
+	}
+	return aturi.RecordKey().String()
+}
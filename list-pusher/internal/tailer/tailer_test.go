@@ -0,0 +1,359 @@
+package tailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"list-pusher/internal/blocklist"
+	"list-pusher/internal/jetstream"
+	"list-pusher/internal/policy"
+	"list-pusher/internal/store"
+)
+
+const (
+	testSource = "at://did:plc:2bij7yypmcuvwyz4gyqwtluy/app.bsky.graph.list/3lbxfscjqno2d"
+	otherList  = "at://did:plc:someone/app.bsky.graph.list/3lzzzzzzzzz2a"
+)
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeList stands in for the blocklist manager: it decides with the real
+// policy engine but keeps our list in memory
+type fakeList struct {
+	st      store.Store
+	engine  *policy.Engine
+	items   map[string]string // record key → DID
+	fail    map[string]bool
+	added   []string
+	removed []string
+}
+
+func newFakeList(st store.Store) *fakeList {
+	return &fakeList{
+		st:     st,
+		engine: policy.NewEngine(policy.Default(), st, nil),
+		items:  make(map[string]string),
+		fail:   make(map[string]bool),
+	}
+}
+
+func (l *fakeList) Store() store.Store {
+	return l.st
+}
+
+func (l *fakeList) ApplyPolicy(dids []string) ([]string, []policy.Decision, error) {
+	decisions, err := l.engine.Decide(context.Background(), dids)
+	if err != nil {
+		return nil, nil, err
+	}
+	var rejected []policy.Decision
+	for _, decision := range decisions {
+		if !decision.Include {
+			rejected = append(rejected, decision)
+		}
+	}
+	return policy.Included(decisions), rejected, nil
+}
+
+func (l *fakeList) AddListItems(dids []string, record blocklist.Outcome) (successful, failed int, err error) {
+	for _, did := range dids {
+		if l.fail[did] {
+			failed++
+			if err := record(did, "", errors.New("rate limited")); err != nil {
+				return successful, failed, err
+			}
+			continue
+		}
+		rkey := fmt.Sprintf("item%d", len(l.items)+len(l.removed))
+		l.items[rkey] = did
+		l.added = append(l.added, did)
+		successful++
+		if err := record(did, "at://did:plc:owner/app.bsky.graph.listitem/"+rkey, nil); err != nil {
+			return successful, failed, err
+		}
+	}
+	return successful, failed, nil
+}
+
+func (l *fakeList) RemoveListItems(recordKeys []string, record blocklist.Outcome) (successful, failed int, err error) {
+	for _, rkey := range recordKeys {
+		delete(l.items, rkey)
+		l.removed = append(l.removed, rkey)
+		successful++
+		if err := record(rkey, "", nil); err != nil {
+			return successful, failed, err
+		}
+	}
+	return successful, failed, nil
+}
+
+// newTestTailer creates a tailer watching testSource that writes to list,
+// as setup would leave it before restoring a checkpoint
+func newTestTailer(t *testing.T, list *fakeList, grace time.Duration) *Tailer {
+	tl := New(nil, Options{FlushInterval: time.Hour, FlushSize: 1000, UnlistGrace: grace})
+	tl.list = list
+	tl.sources[testSource] = true
+	for rkey, did := range list.items {
+		tl.listed[did] = append(tl.listed[did], rkey)
+	}
+	return tl
+}
+
+// openStore opens an in-memory store tracking testSource
+func openStore(t *testing.T) store.Store {
+	ctx := context.Background()
+	st, err := store.OpenSQLite(ctx, ":memory:")
+	must(t, err)
+	t.Cleanup(func() { st.Close() })
+	must(t, st.AddSourceList(ctx, store.SourceList{URI: testSource}))
+	return st
+}
+
+// listblock is a jetstream event for a listblock record; deletes carry no subject
+func listblock(did, operation, rkey, subject string, at time.Time) *jetstream.Event {
+	commit := &jetstream.Commit{Operation: operation, Collection: jetstream.ListBlockCollection, RecordKey: rkey}
+	if subject != "" {
+		commit.Record = []byte(fmt.Sprintf(`{"$type": "app.bsky.graph.listblock", "subject": %q}`, subject))
+	}
+	return &jetstream.Event{DID: did, TimeUS: at.UnixMicro(), Kind: jetstream.KindCommit, Commit: commit}
+}
+
+// tail feeds events to tl as a stream that then ends
+func tail(t *testing.T, tl *Tailer, events ...*jetstream.Event) {
+	t.Helper()
+	ch := make(chan *jetstream.Event, len(events))
+	for _, event := range events {
+		ch <- event
+	}
+	close(ch)
+	must(t, tl.tail(context.Background(), ch))
+}
+
+func TestCheckpointResume(t *testing.T) {
+	ctx := context.Background()
+	st := openStore(t)
+	list := newFakeList(st)
+	list.fail["did:plc:carol"] = true
+	base := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+
+	first := []*jetstream.Event{
+		listblock("did:plc:alice", jetstream.OpCreate, "rka", testSource, base),
+		listblock("did:plc:bob", jetstream.OpCreate, "rkb", otherList, base.Add(time.Second)),
+		listblock("did:plc:carol", jetstream.OpCreate, "rkc", testSource, base.Add(2*time.Second)),
+	}
+	tl := newTestTailer(t, list, 0)
+	checkpoint, err := LoadCheckpoint(ctx, st)
+	must(t, err)
+	tl.restore(checkpoint)
+	tail(t, tl, first...)
+
+	// carol's addition failed, so she is saved as pending
+	checkpoint, err = LoadCheckpoint(ctx, st)
+	must(t, err)
+	if checkpoint.Cursor != base.Add(2*time.Second).UnixMicro() || !reflect.DeepEqual(checkpoint.Pending, []string{"did:plc:carol"}) {
+		t.Fatalf("got checkpoint %+v", checkpoint)
+	}
+
+	// After a restart the stream replays from the checkpoint: those events
+	// are skipped, carol is retried and new subscribers are added
+	delete(list.fail, "did:plc:carol")
+	tl = newTestTailer(t, list, 0)
+	tl.restore(checkpoint)
+	tail(t, tl, append(first, listblock("did:plc:dave", jetstream.OpCreate, "rkd", testSource, base.Add(3*time.Second)))...)
+
+	if want := []string{"did:plc:alice", "did:plc:carol", "did:plc:dave"}; !reflect.DeepEqual(list.added, want) {
+		t.Errorf("got added %v, want %v", list.added, want)
+	}
+	if tl.matched != 1 {
+		t.Errorf("replayed events were handled again: %d matched", tl.matched)
+	}
+	checkpoint, err = LoadCheckpoint(ctx, st)
+	must(t, err)
+	if checkpoint.Cursor != base.Add(3*time.Second).UnixMicro() || len(checkpoint.Pending) != 0 {
+		t.Errorf("got checkpoint %+v", checkpoint)
+	}
+}
+
+func TestShutdownDrainsBufferedEvents(t *testing.T) {
+	st := openStore(t)
+	list := newFakeList(st)
+	base := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+
+	tl := newTestTailer(t, list, 0)
+	tl.restore(Checkpoint{Unlist: make(map[string]time.Time)})
+
+	// The stream was cancelled with events still waiting in the channel
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	events := make(chan *jetstream.Event, 2)
+	events <- listblock("did:plc:alice", jetstream.OpCreate, "rka", testSource, base)
+	events <- listblock("did:plc:bob", jetstream.OpCreate, "rkb", testSource, base.Add(time.Second))
+	close(events)
+	must(t, tl.tail(ctx, events))
+
+	if want := []string{"did:plc:alice", "did:plc:bob"}; !reflect.DeepEqual(list.added, want) {
+		t.Errorf("got added %v, want %v", list.added, want)
+	}
+	checkpoint, err := LoadCheckpoint(context.Background(), st)
+	must(t, err)
+	if checkpoint.Cursor != base.Add(time.Second).UnixMicro() {
+		t.Errorf("got checkpoint %+v", checkpoint)
+	}
+}
+
+func TestReplayDeleteAfterCreate(t *testing.T) {
+	ctx := context.Background()
+	st := openStore(t)
+	list := newFakeList(st)
+	list.items["rklisted"] = "did:plc:alice"
+	base := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+
+	events := []*jetstream.Event{
+		listblock("did:plc:alice", jetstream.OpCreate, "rka", testSource, base),
+		listblock("did:plc:alice", jetstream.OpDelete, "rka", "", base.Add(time.Second)),
+	}
+	tl := newTestTailer(t, list, 0)
+	tl.restore(Checkpoint{Unlist: make(map[string]time.Time)})
+	tail(t, tl, events...)
+	if !reflect.DeepEqual(list.removed, []string{"rklisted"}) {
+		t.Fatalf("got removed %v", list.removed)
+	}
+
+	// Crashing before the checkpoint was saved replays both events against a
+	// store that already has the subscription ended
+	tl = newTestTailer(t, list, 0)
+	tl.restore(Checkpoint{Unlist: make(map[string]time.Time)})
+	tail(t, tl, events...)
+
+	if len(list.added) != 0 || len(list.items) != 0 {
+		t.Errorf("replay put alice back on the list: added %v", list.added)
+	}
+	if len(tl.pending) != 0 || len(tl.checkpoint.Unlist) != 0 {
+		t.Errorf("replay left work behind: pending %v, unlist %v", tl.pending, tl.checkpoint.Unlist)
+	}
+	subs, err := st.SubscriptionsOf(ctx, "did:plc:alice")
+	must(t, err)
+	if len(subs) != 1 || subs[0].Active() || !subs[0].EndedAt.Equal(base.Add(time.Second)) {
+		t.Errorf("got subscriptions %+v", subs)
+	}
+}
+
+func TestStaleDelete(t *testing.T) {
+	ctx := context.Background()
+	st := openStore(t)
+	list := newFakeList(st)
+	list.items["rklisted"] = "did:plc:alice"
+	base := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+
+	// A scrape saw alice subscribed after the delete replayed below, to a
+	// list we no longer watch, so no tracked subscription keeps her listed
+	must(t, st.RecordSubscription(ctx, store.SourceClearsky,
+		store.Subscription{DID: "did:plc:alice", ListURI: otherList, RecordKey: "rka", LastSeen: base.Add(time.Minute)}))
+
+	tl := newTestTailer(t, list, 0)
+	tl.restore(Checkpoint{Unlist: make(map[string]time.Time)})
+	tail(t, tl, listblock("did:plc:alice", jetstream.OpDelete, "rka", "", base))
+
+	if len(list.removed) != 0 || len(tl.checkpoint.Unlist) != 0 || tl.unsubscribed != 0 {
+		t.Errorf("stale delete unlisted alice: removed %v, unlist %v", list.removed, tl.checkpoint.Unlist)
+	}
+	subs, err := st.SubscriptionsOf(ctx, "did:plc:alice")
+	must(t, err)
+	if len(subs) != 1 || !subs[0].Active() {
+		t.Errorf("got subscriptions %+v", subs)
+	}
+}
+
+func TestUnlistGrace(t *testing.T) {
+	tests := []struct {
+		name        string
+		ago         time.Duration
+		resubscribe bool
+		override    bool
+		wantRemoved []string
+		wantUnlist  bool
+	}{
+		{name: "resubscribed inside the grace period", ago: 10 * time.Minute, resubscribe: true},
+		{name: "kept by an include override", ago: 2 * time.Hour, override: true},
+		{name: "still inside the grace period", ago: 10 * time.Minute, wantUnlist: true},
+		{name: "grace period over", ago: 2 * time.Hour, wantRemoved: []string{"rklisted"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			st := openStore(t)
+			list := newFakeList(st)
+			list.items["rklisted"] = "did:plc:alice"
+			base := time.Now().Add(-tt.ago).Truncate(time.Microsecond)
+
+			events := []*jetstream.Event{
+				listblock("did:plc:alice", jetstream.OpCreate, "rka", testSource, base),
+				listblock("did:plc:alice", jetstream.OpDelete, "rka", "", base.Add(time.Minute)),
+			}
+			if tt.resubscribe {
+				events = append(events, listblock("did:plc:alice", jetstream.OpCreate, "rka2", testSource, base.Add(2*time.Minute)))
+			}
+
+			if tt.override {
+				must(t, st.SetOverride(ctx, store.Override{DID: "did:plc:alice", Action: store.OverrideInclude, Reason: "test"}))
+			}
+
+			tl := newTestTailer(t, list, time.Hour)
+			tl.restore(Checkpoint{Unlist: make(map[string]time.Time)})
+			tail(t, tl, events...)
+
+			if !reflect.DeepEqual(list.removed, tt.wantRemoved) {
+				t.Errorf("got removed %v, want %v", list.removed, tt.wantRemoved)
+			}
+			if len(list.added) != 0 {
+				t.Errorf("alice was already listed, got added %v", list.added)
+			}
+			checkpoint, err := LoadCheckpoint(ctx, st)
+			must(t, err)
+			due, scheduled := checkpoint.Unlist["did:plc:alice"]
+			if scheduled != tt.wantUnlist {
+				t.Errorf("got unlist %v, want scheduled %v", checkpoint.Unlist, tt.wantUnlist)
+			}
+			if scheduled && !due.Equal(base.Add(time.Minute+time.Hour)) {
+				t.Errorf("unlisting due at %s, want an hour after unsubscribing", due)
+			}
+		})
+	}
+}
+
+func TestOverrideDuringGrace(t *testing.T) {
+	ctx := context.Background()
+	st := openStore(t)
+	list := newFakeList(st)
+	list.items["rklisted"] = "did:plc:alice"
+	base := time.Now().Add(-10 * time.Minute).Truncate(time.Microsecond)
+
+	tl := newTestTailer(t, list, time.Hour)
+	tl.restore(Checkpoint{Unlist: make(map[string]time.Time)})
+	tail(t, tl,
+		listblock("did:plc:alice", jetstream.OpCreate, "rka", testSource, base),
+		listblock("did:plc:alice", jetstream.OpDelete, "rka", "", base.Add(time.Minute)),
+	)
+	if _, scheduled := tl.checkpoint.Unlist["did:plc:alice"]; !scheduled {
+		t.Fatal("alice should be due to come off the list")
+	}
+
+	// An include override made before the grace period runs out keeps them listed
+	must(t, st.SetOverride(ctx, store.Override{DID: "did:plc:alice", Action: store.OverrideInclude, Reason: "test"}))
+	tl.checkpoint.Unlist["did:plc:alice"] = time.Now().Add(-time.Second)
+	must(t, tl.flush(ctx))
+
+	if len(list.removed) != 0 || len(tl.checkpoint.Unlist) != 0 {
+		t.Errorf("got removed %v, unlist %v", list.removed, tl.checkpoint.Unlist)
+	}
+}